@@ -0,0 +1,64 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"crypto/tls"
+)
+
+// SetALPNProtoFunc registers the ProtoFunc of the ALPN protocol name,
+// which is selected instead of the one of ListenAndServe,
+// when the client negotiates the protocol in the TLS handshake.
+// NOTE:
+//  It should be called before listening;
+//  Only for the TLS listeners of the tcp, tcp4, tcp6, unix, unixpacket and mem network;
+//  The protocols are preferred in the registration order.
+func (p *peer) SetALPNProtoFunc(protocol string, protoFunc ProtoFunc) {
+	if len(protocol) == 0 || protoFunc == nil {
+		Fatalf("ALPN protocol name and ProtoFunc can not be empty")
+	}
+	if p.alpnProtoFuncs == nil {
+		p.alpnProtoFuncs = make(map[string]ProtoFunc)
+	}
+	if _, ok := p.alpnProtoFuncs[protocol]; !ok {
+		p.alpnProtocols = append(p.alpnProtocols, protocol)
+	}
+	p.alpnProtoFuncs[protocol] = protoFunc
+}
+
+// listenTLSConfig returns the TLS config of the listener,
+// which advertises the registered ALPN protocols before the configured ones.
+func (p *peer) listenTLSConfig() *tls.Config {
+	if p.tlsConfig == nil || len(p.alpnProtocols) == 0 {
+		return p.tlsConfig
+	}
+	tlsConfig := p.tlsConfig.Clone()
+	tlsConfig.NextProtos = append([]string(nil), p.alpnProtocols...)
+	for _, proto := range p.tlsConfig.NextProtos {
+		if _, ok := p.alpnProtoFuncs[proto]; !ok {
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, proto)
+		}
+	}
+	return tlsConfig
+}
+
+// alpnProtoFunc returns the ProtoFunc of the ALPN protocol negotiated by the handshaked connection.
+func (p *peer) alpnProtoFunc(conn *tls.Conn) (ProtoFunc, bool) {
+	if len(p.alpnProtoFuncs) == 0 {
+		return nil, false
+	}
+	protoFunc, ok := p.alpnProtoFuncs[conn.ConnectionState().NegotiatedProtocol]
+	return protoFunc, ok
+}
@@ -0,0 +1,44 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/proto/jsonproto"
+)
+
+func TestALPNProtoFunc(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.SetTLSConfig(tp.GenerateTLSConfigForServer())
+		srv.SetALPNProtoFunc("tp-json", jsonproto.NewJSONProtoFunc())
+		srv.RouteCallFunc(echo_call)
+		cli.SetTLSConfig(tp.GenerateTLSConfigForClient())
+	})
+	defer p.Close()
+
+	for _, c := range []struct {
+		nextProtos []string
+		protoFunc  []tp.ProtoFunc
+	}{
+		{[]string{"tp-json"}, []tp.ProtoFunc{jsonproto.NewJSONProtoFunc()}},
+		{nil, nil},
+	} {
+		// the server serves both by the proto negotiated in the handshake
+		cli := tp.NewPeer(tp.PeerConfig{Network: "mem"})
+		defer cli.Close()
+		tlsConfig := tp.GenerateTLSConfigForClient()
+		tlsConfig.NextProtos = c.nextProtos
+		cli.SetTLSConfig(tlsConfig)
+		sess, rerr := cli.Dial(p.addr, c.protoFunc...)
+		if rerr != nil {
+			t.Fatalf("%v: %v", c.nextProtos, rerr)
+		}
+		var arg, result = 10, 0
+		if rerr = sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+			t.Fatalf("%v: %v", c.nextProtos, rerr)
+		}
+		if result != arg {
+			t.Fatalf("%v: expect %d, got %d", c.nextProtos, arg, result)
+		}
+	}
+}
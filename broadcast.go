@@ -0,0 +1,123 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync"
+
+	"github.com/mylonly/teleport/codec"
+	"github.com/mylonly/teleport/socket"
+)
+
+// broadcastWorkers the maximum number of the sessions written at the same time by a broadcast.
+const broadcastWorkers = 256
+
+var rerrSessionNotFound = rerrConnClosed.Copy().SetReason("session not found")
+
+// Broadcast pushes the message to all the sessions, and returns the errors of the failed ones by session ID.
+// NOTE:
+//  The body is marshaled once, and written to the sessions concurrently;
+//  If the body fails to be marshaled, all the sessions fail with the error.
+func (p *peer) Broadcast(serviceMethod string, arg interface{}, setting ...MessageSetting) map[string]*Rerror {
+	var sessions []*session
+	p.sessHub.Range(func(sess *session) bool {
+		sessions = append(sessions, sess)
+		return true
+	})
+	return p.multicast(sessions, nil, serviceMethod, arg, setting)
+}
+
+// Multicast pushes the message to the sessions of the IDs, and returns the errors of the failed ones by session ID.
+// NOTE:
+//  The body is marshaled once, and written to the sessions concurrently;
+//  The sessions which are not found fail with CodeConnClosed.
+func (p *peer) Multicast(sessionIDs []string, serviceMethod string, arg interface{}, setting ...MessageSetting) map[string]*Rerror {
+	var (
+		sessions = make([]*session, 0, len(sessionIDs))
+		notFound []string
+	)
+	for _, id := range sessionIDs {
+		if sess, ok := p.sessHub.Get(id); ok {
+			sessions = append(sessions, sess)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	return p.multicast(sessions, notFound, serviceMethod, arg, setting)
+}
+
+func (p *peer) multicast(sessions []*session, notFound []string, serviceMethod string, arg interface{}, setting []MessageSetting) map[string]*Rerror {
+	var (
+		failed = make(map[string]*Rerror)
+		mu     sync.Mutex
+	)
+	for _, id := range notFound {
+		failed[id] = rerrSessionNotFound
+	}
+	if len(sessions) == 0 {
+		return failed
+	}
+
+	// marshal the body once, then it is written as is
+	tmp := socket.GetMessage(socket.WithBody(arg))
+	for _, fn := range setting {
+		if fn != nil {
+			fn(tmp)
+		}
+	}
+	bodyCodec := tmp.BodyCodec()
+	if bodyCodec == codec.NilCodecID {
+		bodyCodec = p.defaultBodyCodec
+		tmp.SetBodyCodec(bodyCodec)
+	}
+	body, err := tmp.MarshalBody()
+	socket.PutMessage(tmp)
+	if err != nil {
+		rerr := rerrBadMessage.Copy().SetReason(err.Error())
+		for _, sess := range sessions {
+			failed[sess.ID()] = rerr
+		}
+		return failed
+	}
+	setting = append(setting[:len(setting):len(setting)], WithBodyCodec(bodyCodec), WithBody(body))
+
+	var (
+		sessCh = make(chan *session)
+		wg     sync.WaitGroup
+	)
+	workers := broadcastWorkers
+	if len(sessions) < workers {
+		workers = len(sessions)
+	}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		AnywayGo(func() {
+			defer wg.Done()
+			for sess := range sessCh {
+				if rerr := sess.Push(serviceMethod, nil, setting...); rerr != nil {
+					mu.Lock()
+					failed[sess.ID()] = rerr
+					mu.Unlock()
+				}
+			}
+		})
+	}
+	for _, sess := range sessions {
+		sessCh <- sess
+	}
+	close(sessCh)
+	wg.Wait()
+	return failed
+}
@@ -0,0 +1,58 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+var hellos = make(chan string, 8)
+
+func hello_push(ctx tp.PushCtx, arg *string) *tp.Rerror {
+	hellos <- *arg
+	return nil
+}
+
+func TestBroadcast(t *testing.T) {
+	accepted := make(acceptedPlugin, 3)
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.PluginContainer().AppendRight(accepted)
+		cli.RoutePushFunc(hello_push)
+	})
+	defer p.Close()
+	for i := 0; i < 2; i++ {
+		if _, rerr := p.cli.Dial(p.addr); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		<-accepted
+	}
+	expect := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case <-hellos:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expect %d pushes, got %d", n, i)
+			}
+		}
+	}
+
+	if failed := p.srv.Broadcast("/hello/push", "hello"); len(failed) != 0 {
+		t.Fatalf("expect no failure, got %v", failed)
+	}
+	expect(3)
+
+	var ids []string
+	p.srv.RangeSession(func(sess tp.Session) bool {
+		ids = append(ids, sess.ID())
+		return len(ids) < 2
+	})
+	failed := p.srv.Multicast(append(ids, "unknown"), "/hello/push", "hello")
+	if len(failed) != 1 || failed["unknown"] == nil || failed["unknown"].Code != tp.CodeConnClosed {
+		t.Fatalf("expect the unknown session failed, got %v", failed)
+	}
+	expect(2)
+}
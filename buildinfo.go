@@ -0,0 +1,85 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"encoding/json"
+)
+
+// FrameworkVersion the version of the framework, which is sent in the build info.
+const FrameworkVersion = "v5"
+
+// BuildInfo the build metadata exchanged when the session is established,
+// e.g. for spotting the version skew of the fleet, or gating the features in plugins.
+type BuildInfo struct {
+	// Framework the version of the framework, filled by the sender
+	Framework string `json:"framework"`
+	// App the version of the application
+	App string `json:"app,omitempty"`
+	// GitSHA the git commit of the application build
+	GitSHA string `json:"git_sha,omitempty"`
+	// Extra the custom metadata
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// SetBuildInfo sets the build info which is sent to the remote peer by the HELLO control message,
+// when the session is established or redialed.
+// NOTE:
+//  It should be called before listening or dialing; if not called, no HELLO is sent;
+//  The HELLO is sent after the first messages of the connection announce the control messages, see FeatureControl,
+//  and never if the remote peer or the proto does not support them.
+func (p *peer) SetBuildInfo(info BuildInfo) {
+	info.Framework = FrameworkVersion
+	b, err := json.Marshal(&info)
+	if err != nil {
+		Errorf("invalid build info: %v", err)
+		return
+	}
+	p.buildInfo.Store(b)
+}
+
+// RemoteBuildInfo returns the build info of the remote peer.
+// NOTE:
+//  It is nil until the HELLO control message is read, or if the remote peer sends none,
+//  e.g. it does not accept the control messages;
+//  It is reset when the client redials.
+func (s *session) RemoteBuildInfo() *BuildInfo {
+	info, _ := s.remoteBuildInfo.Load().(*BuildInfo)
+	return info
+}
+
+// sendHello sends the build info to the remote peer, once it is negotiated to accept the control messages.
+// NOTE: It is sent asynchronously, since the connection may be synchronous, e.g. mem network.
+func (s *session) sendHello() {
+	b, ok := s.peer.buildInfo.Load().([]byte)
+	if !ok {
+		return
+	}
+	AnywayGo(func() {
+		if rerr := s.Control(TypeHello, WithBody(b)); rerr != nil {
+			Debugf("send hello to %s: %s", s.RemoteAddr().String(), rerr.String())
+		}
+	})
+}
+
+// readHello saves the build info of the remote peer.
+func (s *session) readHello(body []byte) {
+	info := new(BuildInfo)
+	if err := json.Unmarshal(body, info); err != nil {
+		Debugf("ignore bad hello from %s: %v", s.RemoteAddr().String(), err)
+		return
+	}
+	s.remoteBuildInfo.Store(info)
+}
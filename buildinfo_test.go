@@ -0,0 +1,126 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/socket"
+)
+
+func TestBuildInfo(t *testing.T) {
+	var (
+		path           string
+		srvCtl, cliCtl = make(controlRecorder, 16), make(controlRecorder, 16)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.SetBuildInfo(tp.BuildInfo{App: "1.2.0", GitSHA: "abc123"})
+		srv.PluginContainer().AppendRight(srvCtl)
+		cli.SetBuildInfo(tp.BuildInfo{App: "1.1.0", Extra: map[string]string{"region": "eu"}})
+		cli.PluginContainer().AppendRight(cliCtl)
+		path = srv.RouteCallFunc(echo_call)
+	})
+	defer peers.Close()
+	sess := peers.sess
+
+	// sent after the first messages negotiate the control messages
+	var arg = 1
+	if rerr := sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	cliCtl.wait(t, tp.TypeHello)
+	info := sess.RemoteBuildInfo()
+	if info == nil || info.Framework != tp.FrameworkVersion || info.App != "1.2.0" || info.GitSHA != "abc123" {
+		t.Fatalf("unexpected build info of the server: %+v", info)
+	}
+	srvCtl.wait(t, tp.TypeHello)
+	s, ok := peers.srv.GetSession(sess.ID())
+	if !ok {
+		t.Fatal("session not found")
+	}
+	if info = s.RemoteBuildInfo(); info == nil || info.App != "1.1.0" || info.Extra["region"] != "eu" {
+		t.Fatalf("unexpected build info of the client: %+v", info)
+	}
+}
+
+func TestBuildInfoNone(t *testing.T) {
+	var (
+		path   string
+		cliCtl = make(controlRecorder, 16)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.SetBuildInfo(tp.BuildInfo{App: "1.2.0"})
+		cli.PluginContainer().AppendRight(cliCtl)
+		path = srv.RouteCallFunc(echo_call)
+	})
+	defer peers.Close()
+	sess := peers.sess
+
+	var arg = 1
+	if rerr := sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	cliCtl.wait(t, tp.TypeHello)
+	if info := sess.RemoteBuildInfo(); info == nil || info.App != "1.2.0" {
+		t.Fatalf("unexpected build info of the server: %+v", info)
+	}
+	// the client sends none
+	s, ok := peers.srv.GetSession(sess.ID())
+	if !ok {
+		t.Fatal("session not found")
+	}
+	if info := s.RemoteBuildInfo(); info != nil {
+		t.Fatalf("expect no build info of the client, got %+v", info)
+	}
+}
+
+// pushProto mimics the proto which can not carry the control messages, e.g. sends them as PUSH.
+type pushProto struct{ tp.Proto }
+
+func (p pushProto) Pack(m tp.Message) error {
+	if tp.IsControlType(m.Mtype()) {
+		m.SetMtype(tp.TypePush)
+	}
+	return p.Proto.Pack(m)
+}
+
+func pushProtoFunc(rw tp.IOWithReadBuffer) tp.Proto {
+	return pushProto{socket.DefaultProtoFunc()(rw)}
+}
+
+func TestBuildInfoDowngrade(t *testing.T) {
+	for name, protoFunc := range map[string]tp.ProtoFunc{
+		"old peer":   oldProtoFunc,
+		"push proto": pushProtoFunc,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				path   string
+				cliCtl = make(controlRecorder, 16)
+			)
+			peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+				srv.SetBuildInfo(tp.BuildInfo{App: "1.2.0"})
+				cli.PluginContainer().AppendRight(cliCtl)
+				path = srv.RouteCallFunc(echo_call)
+			}, protoFunc)
+			defer peers.Close()
+
+			var arg = 1
+			if rerr := peers.sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+				t.Fatal(rerr)
+			}
+			if !peers.sess.IsDowngraded(tp.FeatureControl) {
+				t.Fatal("expect the control messages downgraded")
+			}
+			// never sent to the peers which do not accept the control messages
+			select {
+			case c := <-cliCtl:
+				t.Fatalf("expect no control message, got %s", tp.TypeText(c.mtype))
+			case <-time.After(100 * time.Millisecond):
+			}
+			if info := peers.sess.RemoteBuildInfo(); info != nil {
+				t.Fatalf("expect no build info of the server, got %+v", info)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"context"
+	"strconv"
+)
+
+// watchContext fails the CALL as soon as its context is done, instead of waiting for the reply.
+func (c *callCmd) watchContext(ctx context.Context) {
+	select {
+	case <-c.doneChan:
+	case <-ctx.Done():
+		c.abort(ctx.Err())
+	}
+}
+
+// abort fails the CALL locally, frees its sequence,
+// and notifies the remote peer to cancel the handler by the CANCEL control message.
+// NOTE: The CANCEL is sent only if the remote peer has been negotiated to accept the control messages,
+// otherwise the CALL is only canceled locally and its late reply is dropped, see FeatureControl.
+func (c *callCmd) abort(err error) {
+	c.mu.Lock()
+	if c.isDone() {
+		c.mu.Unlock()
+		return
+	}
+	c.rerr = rerrCallContext(err)
+	c.done()
+	c.mu.Unlock()
+	if !c.sess.acceptsControl() {
+		return
+	}
+	seq := strconv.FormatInt(int64(c.output.Seq()), 10)
+	if rerr := c.sess.Control(TypeCancel, WithSetMeta(MetaCancelSeq, seq)); rerr != nil {
+		Debugf("cancel call: %s, serviceMethod: %s, seq: %s, error: %s", c.sess.RemoteAddr().String(), c.output.ServiceMethod(), seq, rerr.String())
+	}
+}
+
+// rerrCallContext returns the error of the CALL whose context is done.
+func rerrCallContext(err error) *Rerror {
+	if err == context.DeadlineExceeded {
+		return rerrCallTimeout
+	}
+	return rerrCallCanceled.Copy().SetReason(err.Error())
+}
+
+// trackCancel makes the context of the CALL handler cancelable by the CANCEL of the caller.
+// NOTE: It is called in the read goroutine, so that the CANCEL read later always finds the CALL.
+func (c *handlerCtx) trackCancel(seq int32) {
+	ctx, cancel := context.WithCancel(c.Context())
+	c.setContext(ctx)
+	c.cancel = cancel
+	c.sess.callCancels.Store(seq, cancel)
+}
+
+// untrackCancel releases the context of the CALL handler,
+// unless it is retained by Detach.
+func (c *handlerCtx) untrackCancel() {
+	if c.cancel == nil {
+		return
+	}
+	c.sess.callCancels.Delete(c.input.Seq())
+	if !c.detached {
+		c.cancel()
+	}
+	c.cancel = nil
+}
+
+// cancelCall cancels the context of the CALL handler by the CANCEL of the caller.
+func (s *session) cancelCall(seq []byte) {
+	n, err := strconv.ParseInt(string(seq), 10, 32)
+	if err != nil {
+		Debugf("ignore bad cancel message: %s, seq: %q", s.RemoteAddr().String(), seq)
+		return
+	}
+	if cancel, ok := s.callCancels.Load(int32(n)); ok {
+		cancel.(context.CancelFunc)()
+	}
+}
@@ -0,0 +1,117 @@
+package tp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// cancelable returns the CALL handler which waits for its context until the timeout,
+// arg 0 is replied at once.
+func cancelable(timeout time.Duration, started chan<- struct{}, canceled chan<- error) func(tp.CallCtx, *int) (int, *tp.Rerror) {
+	return func(ctx tp.CallCtx, arg *int) (int, *tp.Rerror) {
+		if *arg == 0 {
+			return 0, nil
+		}
+		started <- struct{}{}
+		select {
+		case <-ctx.Context().Done():
+			canceled <- ctx.Context().Err()
+		case <-time.After(timeout):
+			canceled <- nil
+		}
+		return *arg, nil
+	}
+}
+
+func TestCallCancel(t *testing.T) {
+	var (
+		path     string
+		started  = make(chan struct{}, 1)
+		canceled = make(chan error, 1)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(cancelable(5*time.Second, started, canceled))
+	})
+	defer peers.Close()
+	sess := peers.sess
+
+	// negotiate the control messages by the first messages
+	var arg = 0
+	if rerr := sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if sess.IsDowngraded(tp.FeatureControl) {
+		t.Fatal("expect the control messages accepted")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	arg = 1
+	callCmd := sess.AsyncCall(path, &arg, new(int), make(chan tp.CallCmd, 1), tp.WithContext(ctx))
+	<-started
+	cancel()
+	select {
+	case <-callCmd.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expect the CALL aborted at once")
+	}
+	if rerr := callCmd.Rerror(); rerr == nil || rerr.Code != tp.CodeCanceled {
+		t.Fatalf("expect CodeCanceled, got %v", rerr)
+	}
+	select {
+	case err := <-canceled:
+		if err != context.Canceled {
+			t.Fatalf("expect the handler canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the handler canceled by CANCEL")
+	}
+
+	// the deadline exceeded
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rerr := sess.Call(path, &arg, new(int), tp.WithContext(ctx)).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeHandleTimeout {
+		t.Fatalf("expect CodeHandleTimeout, got %v", rerr)
+	}
+	<-started
+	<-canceled
+}
+
+func TestCallCancelLocal(t *testing.T) {
+	var (
+		path     string
+		started  = make(chan struct{}, 1)
+		canceled = make(chan error, 1)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(cancelable(200*time.Millisecond, started, canceled))
+	}, oldProtoFunc)
+	defer peers.Close()
+	sess := peers.sess
+
+	var arg = 0
+	if rerr := sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if !sess.IsDowngraded(tp.FeatureControl) {
+		t.Fatal("expect the control messages downgraded")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	arg = 1
+	callCmd := sess.AsyncCall(path, &arg, new(int), make(chan tp.CallCmd, 1), tp.WithContext(ctx))
+	<-started
+	cancel()
+	select {
+	case <-callCmd.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expect the CALL aborted locally at once")
+	}
+	if rerr := callCmd.Rerror(); rerr == nil || rerr.Code != tp.CodeCanceled {
+		t.Fatalf("expect CodeCanceled, got %v", rerr)
+	}
+	if err := <-canceled; err != nil {
+		t.Fatalf("expect no CANCEL sent, got the handler canceled: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Channel the logical sub-channel multiplexed over a session,
+// whose CALLs and PUSHes are handled in order by the remote peer,
+// independently of the other channels and the messages out of any channel.
+type Channel interface {
+	// Name returns the name of the channel.
+	Name() string
+	// Session returns the session which the channel is multiplexed over.
+	Session() Session
+	// Call sends a message over the channel, and receives reply.
+	// NOTE: It waits while the window of the channel is full.
+	Call(serviceMethod string, arg interface{}, result interface{}, setting ...MessageSetting) CallCmd
+	// AsyncCall sends a message over the channel, and receives reply asynchronously.
+	// NOTE: It waits while the window of the channel is full.
+	AsyncCall(serviceMethod string, arg interface{}, result interface{}, callCmdChan chan<- CallCmd, setting ...MessageSetting) CallCmd
+	// Push sends a message over the channel, but do not receives reply.
+	// NOTE: It waits while the window of the channel is full.
+	Push(serviceMethod string, arg interface{}, setting ...MessageSetting) *Rerror
+}
+
+// channel the sending side of a logical channel,
+// the window limits its CALLs waiting for the replies and PUSHes being written.
+type channel struct {
+	name   string
+	sess   *session
+	window chan struct{}
+}
+
+var _ Channel = new(channel)
+
+const defaultChannelWindow = 16
+
+// OpenChannel returns the logical channel of the name multiplexed over the session,
+// e.g. a bulk file sync on its own channel does not block the interactive CALLs on the others.
+// NOTE:
+// The channels of the same name share the window, see PeerConfig.ChannelWindow;
+// The remote peer handles the messages of each channel one by one, in the order of arrival.
+func (s *session) OpenChannel(name string) Channel {
+	if v, ok := s.channels.Load(name); ok {
+		return v.(*channel)
+	}
+	window := s.peer.channelWindow
+	if window <= 0 {
+		window = defaultChannelWindow
+	}
+	v, _ := s.channels.LoadOrStore(name, &channel{
+		name:   name,
+		sess:   s,
+		window: make(chan struct{}, window),
+	})
+	return v.(*channel)
+}
+
+func (c *channel) Name() string {
+	return c.name
+}
+
+func (c *channel) Session() Session {
+	return c.sess
+}
+
+// acquire waits for a place in the window, and returns false if the session is closed.
+func (c *channel) acquire() bool {
+	select {
+	case c.window <- struct{}{}:
+		return true
+	case <-c.sess.closeNotifyCh:
+		return false
+	}
+}
+
+func (c *channel) release() {
+	<-c.window
+}
+
+func (c *channel) settings(setting []MessageSetting) []MessageSetting {
+	return append(setting[:len(setting):len(setting)], WithAddMeta(MetaChannel, c.name))
+}
+
+func (c *channel) Call(serviceMethod string, arg interface{}, result interface{}, setting ...MessageSetting) CallCmd {
+	callCmd := c.AsyncCall(serviceMethod, arg, result, make(chan CallCmd, 1), setting...)
+	<-callCmd.Done()
+	return callCmd
+}
+
+func (c *channel) AsyncCall(serviceMethod string, arg interface{}, result interface{}, callCmdChan chan<- CallCmd, setting ...MessageSetting) CallCmd {
+	if !c.acquire() {
+		callCmd := NewFakeCallCmd(serviceMethod, arg, result, rerrConnClosed)
+		if callCmdChan != nil {
+			callCmdChan <- callCmd
+		}
+		return callCmd
+	}
+	callCmd := c.sess.AsyncCall(serviceMethod, arg, result, callCmdChan, c.settings(setting)...)
+	go func() {
+		<-callCmd.Done()
+		c.release()
+	}()
+	return callCmd
+}
+
+func (c *channel) Push(serviceMethod string, arg interface{}, setting ...MessageSetting) *Rerror {
+	if !c.acquire() {
+		return rerrConnClosed
+	}
+	defer c.release()
+	return c.sess.Push(serviceMethod, arg, c.settings(setting)...)
+}
+
+// channelQueue the receiving side of a logical channel,
+// which handles the messages one by one.
+type channelQueue struct {
+	name    string
+	msgs    list.List // *handlerCtx
+	running bool
+}
+
+// channelQueues the receiving sides of the logical channels of a session.
+type channelQueues struct {
+	queues map[string]*channelQueue
+	mu     sync.Mutex
+}
+
+// dispatchChannel queues the CALL or PUSH sent over a logical channel, and returns false if it is out of any channel.
+// NOTE: The CALL is refused with CodeTooManyRequests if the queue of the channel exceeds the window.
+func (p *peer) dispatchChannel(ctx *handlerCtx) bool {
+	if mtype := ctx.input.Mtype(); mtype != TypeCall && mtype != TypePush {
+		return false
+	}
+	name := ctx.input.Meta().Peek(MetaChannel)
+	if len(name) == 0 {
+		return false
+	}
+	window := p.channelWindow
+	if window <= 0 {
+		window = defaultChannelWindow
+	}
+	cq := &ctx.sess.chanQueues
+	cq.mu.Lock()
+	if cq.queues == nil {
+		cq.queues = make(map[string]*channelQueue)
+	}
+	q, ok := cq.queues[string(name)]
+	if !ok {
+		q = &channelQueue{name: string(name)}
+		cq.queues[q.name] = q
+	}
+	if q.msgs.Len() >= window && ctx.input.Mtype() == TypeCall && ctx.handleErr == nil {
+		cq.mu.Unlock()
+		// refused in the read goroutine, so that the excess CALLs spawn no goroutine
+		ctx.handleErr = rerrChannelOverloaded
+		ctx.handle()
+		p.putContext(ctx, true)
+		return true
+	}
+	q.msgs.PushBack(ctx)
+	if q.running {
+		cq.mu.Unlock()
+		return true
+	}
+	q.running = true
+	cq.mu.Unlock()
+	AnywayGo(func() {
+		for ctx := cq.next(q); ctx != nil; ctx = cq.next(q) {
+			ctx.handle()
+			p.putContext(ctx, true)
+		}
+	})
+	return true
+}
+
+// next returns the next message of the channel, or removes the drained channel.
+func (cq *channelQueues) next(q *channelQueue) *handlerCtx {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+	front := q.msgs.Front()
+	if front == nil {
+		q.running = false
+		delete(cq.queues, q.name)
+		return nil
+	}
+	return q.msgs.Remove(front).(*handlerCtx)
+}
@@ -0,0 +1,66 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestChannel(t *testing.T) {
+	var path string
+	// each CALL is released by one send
+	entered, release := make(chan int, 3), make(chan struct{})
+	p := newMemPeers(t, tp.PeerConfig{
+		ChannelWindow: 2,
+	}, tp.PeerConfig{
+		ChannelWindow: 2,
+	}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(blockingCall(entered, release))
+		srv.RouteCallFunc(echo_call)
+	})
+	defer p.Close()
+	bulk := p.sess.OpenChannel("bulk")
+	done := make(chan tp.CallCmd, 3)
+	var cmds []tp.CallCmd
+	for i := 0; i < 2; i++ {
+		arg := i
+		cmds = append(cmds, bulk.AsyncCall(path, &arg, new(int), done))
+	}
+	// the third waits for the window
+	sent := make(chan tp.CallCmd, 1)
+	go func() {
+		arg := 2
+		sent <- bulk.AsyncCall(path, &arg, new(int), done)
+	}()
+	if arg := <-entered; arg != 0 {
+		t.Fatalf("expect the CALL 0 handled first, got %d", arg)
+	}
+
+	// the interactive CALL is not blocked by the channel
+	var fast = 1
+	if rerr := p.sess.Call("/echo/call", &fast, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	select {
+	case <-sent:
+		t.Fatal("expect the third CALL waiting for the window")
+	default:
+	}
+
+	// handled in order, one by one
+	release <- struct{}{}
+	if arg := <-entered; arg != 1 {
+		t.Fatalf("expect the CALL 1 handled after the CALL 0, got %d", arg)
+	}
+	cmds = append(cmds, <-sent)
+	release <- struct{}{}
+	if arg := <-entered; arg != 2 {
+		t.Fatalf("expect the CALL 2 handled after the CALL 1, got %d", arg)
+	}
+	release <- struct{}{}
+	for i := 0; i < 3; i++ {
+		if cmd := <-done; cmd != cmds[i] || cmd.Rerror() != nil {
+			t.Fatalf("expect the CALL %d done in order: %v", i, cmd.Rerror())
+		}
+	}
+}
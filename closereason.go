@@ -0,0 +1,118 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/mylonly/teleport/socket"
+)
+
+// CloseKind the kind of the reason why the session is closed.
+type CloseKind string
+
+// The kinds of the close reasons.
+const (
+	// CloseNone the session is not closed yet.
+	CloseNone CloseKind = ""
+	// CloseActive the session is closed by Session.Close.
+	CloseActive CloseKind = "closed"
+	// CloseRemote the connection is closed by the remote peer.
+	CloseRemote CloseKind = "remote closed"
+	// CloseReadError the connection is broken when reading, e.g. reset by the network.
+	CloseReadError CloseKind = "read error"
+	// CloseWriteError the session is closed since it can not be written, e.g. the write queue is full or the frame is cut off.
+	CloseWriteError CloseKind = "write error"
+	// CloseAgeExpired the session age is expired.
+	CloseAgeExpired CloseKind = "age expired"
+	// CloseHeartbeatTimeout the remote peer is not heard within the heartbeat timeout.
+	CloseHeartbeatTimeout CloseKind = "heartbeat timeout"
+	// CloseHandshakeTimeout no valid message is read within the handshake timeout after accepting.
+	CloseHandshakeTimeout CloseKind = "handshake timeout"
+	// CloseIdleTimeout no message is exchanged within the idle timeout.
+	CloseIdleTimeout CloseKind = "idle timeout"
+	// CloseDrained the session is closed after Session.Drain or Peer.Drain.
+	CloseDrained CloseKind = "drained"
+	// CloseShutdown the session is closed by Peer.Close.
+	CloseShutdown CloseKind = "shutdown"
+	// CloseReplaced the session is replaced by another one with the same ID.
+	CloseReplaced CloseKind = "replaced"
+	// CloseRejected the session is rejected by the PostAccept or PostDial plugins.
+	CloseRejected CloseKind = "rejected"
+	// CloseProtocolError the remote peer violates the protocol, e.g. sends the message of an unsupported type.
+	CloseProtocolError CloseKind = "protocol error"
+	// CloseHandlerPanic the session is closed by PanicCloseSession after the handler panics.
+	CloseHandlerPanic CloseKind = "handler panic"
+)
+
+// errDrainTimeout the in-flight work is not finished when the drain timeout expires.
+var errDrainTimeout = errors.New("drain timeout")
+
+// CloseReason the reason why the session is closed.
+type CloseReason struct {
+	// Kind the kind of the reason, CloseNone if the session is not closed.
+	Kind CloseKind
+	// Err the last error before closing, e.g. the read error; may be nil.
+	Err error
+	// Time the time of closing.
+	Time time.Time
+}
+
+// String returns the kind and the error of the reason.
+func (r CloseReason) String() string {
+	if r.Err == nil {
+		return string(r.Kind)
+	}
+	return string(r.Kind) + ": " + r.Err.Error()
+}
+
+// CloseReason returns the reason why the session is closed last time.
+// NOTE: If the client session is redialed, it is the reason of the previous connection.
+func (s *session) CloseReason() CloseReason {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	return s.closeReason
+}
+
+// brokenWrite the connection closed since the frame written to it is cut off, and the write error.
+type brokenWrite struct {
+	conn net.Conn
+	err  error
+}
+
+// setCloseReasonLocked records the reason, s.statusLock should be locked.
+func (s *session) setCloseReasonLocked(kind CloseKind, err error) {
+	s.closeReason = CloseReason{Kind: kind, Err: err, Time: time.Now()}
+}
+
+// readCloseKind returns the kind of the reason by the read error.
+func (s *session) readCloseKind(err error) CloseKind {
+	switch {
+	case err == nil, err == io.EOF, err == socket.ErrProactivelyCloseSocket:
+		return CloseRemote
+	case err == ErrHeartbeatTimeout:
+		return CloseHeartbeatTimeout
+	}
+	if s.handshake.isExpired() {
+		return CloseHandshakeTimeout
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() && s.SessionAge() > 0 {
+		return CloseAgeExpired
+	}
+	return CloseReadError
+}
@@ -0,0 +1,89 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// closeReasonPlugin records the reasons of the closed sessions.
+type closeReasonPlugin chan tp.CloseReason
+
+func (closeReasonPlugin) Name() string {
+	return "close_reason"
+}
+
+func (p closeReasonPlugin) SessionClosed(_ tp.BaseSession, reason tp.CloseReason) *tp.Rerror {
+	p <- reason
+	return nil
+}
+
+// wait returns the next recorded reason.
+func (p closeReasonPlugin) wait(t *testing.T) tp.CloseReason {
+	t.Helper()
+	select {
+	case reason := <-p:
+		if reason.Time.IsZero() {
+			t.Fatalf("expect the time of closing, got %q", reason.String())
+		}
+		return reason
+	case <-time.After(3 * time.Second):
+		t.Fatal("expect the session closed")
+	}
+	return tp.CloseReason{}
+}
+
+// expectOnce waits for the reason of the kind, and checks that no more reason is recorded.
+func (p closeReasonPlugin) expectOnce(t *testing.T, kind tp.CloseKind) {
+	t.Helper()
+	if reason := p.wait(t); reason.Kind != kind {
+		t.Fatalf("expect %q, got %q", kind, reason.String())
+	}
+	select {
+	case reason := <-p:
+		t.Fatalf("expect the plugins executed once, got %q again", reason.String())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	var (
+		path                 string
+		srvClosed, cliClosed = make(closeReasonPlugin, 4), make(closeReasonPlugin, 4)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.PluginContainer().AppendRight(srvClosed)
+		cli.PluginContainer().AppendRight(cliClosed)
+		path = srv.RouteCallFunc(echo_call)
+	})
+	defer peers.Close()
+	sess := peers.sess
+
+	if kind := sess.CloseReason().Kind; kind != tp.CloseNone {
+		t.Fatalf("expect not closed, got %q", kind)
+	}
+	sess.Close()
+	cliClosed.expectOnce(t, tp.CloseActive)
+	srvClosed.expectOnce(t, tp.CloseRemote)
+	if kind := sess.CloseReason().Kind; kind != tp.CloseActive {
+		t.Fatalf("expect %q, got %q", tp.CloseActive, kind)
+	}
+
+	// drained by the server
+	sess, rerr := peers.cli.Dial(peers.addr)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	var arg = 1
+	if rerr = sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	s, ok := peers.srv.GetSession(sess.ID())
+	if !ok {
+		t.Fatal("session not found")
+	}
+	go s.Drain(time.Second, "maintenance")
+	srvClosed.expectOnce(t, tp.CloseDrained)
+	cliClosed.expectOnce(t, tp.CloseRemote)
+}
@@ -0,0 +1,85 @@
+// Copyright 2015-2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	// AnyTypeName the full name of google.protobuf.Any
+	AnyTypeName = "google.protobuf.Any"
+	// AnyTypeURLPrefix the default prefix of the type URL of PbAny
+	AnyTypeURLPrefix = "type.googleapis.com/"
+)
+
+// PbAny the protobuf message of the same wire format as google.protobuf.Any,
+// which carries an arbitrary serialized message along with the URL of its type.
+type PbAny struct {
+	TypeUrl string `protobuf:"bytes,1,opt,name=type_url,json=typeUrl,proto3" json:"type_url,omitempty"`
+	Value   []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *PbAny) Reset()         { *m = PbAny{} }
+func (m *PbAny) String() string { return proto.CompactTextString(m) }
+func (*PbAny) ProtoMessage()    {}
+
+// NewPbAny packs the protobuf message into PbAny.
+func NewPbAny(msg proto.Message) (*PbAny, error) {
+	value, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &PbAny{
+		TypeUrl: AnyTypeURLPrefix + proto.MessageName(msg),
+		Value:   value,
+	}, nil
+}
+
+// TypeName returns the full name of the message type, i.e. the type URL after the last '/'.
+func (m *PbAny) TypeName() string {
+	return m.TypeUrl[strings.LastIndex(m.TypeUrl, "/")+1:]
+}
+
+// UnmarshalTo parses the value into the message, whose type must be the one of the type URL.
+func (m *PbAny) UnmarshalTo(msg proto.Message) error {
+	if name := proto.MessageName(msg); name != m.TypeName() {
+		return fmt.Errorf("protobuf codec: mismatched message type: got %q, want %q", name, m.TypeName())
+	}
+	return proto.Unmarshal(m.Value, msg)
+}
+
+// Unpack creates the message of the type registered by the generated code, and parses the value into it.
+func (m *PbAny) Unpack() (proto.Message, error) {
+	msg, ok := NewProtoMessage(m.TypeName())
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: unregistered message type: %q", m.TypeName())
+	}
+	return msg, proto.Unmarshal(m.Value, msg)
+}
+
+// NewProtoMessage creates the message of the type name registered by the generated code.
+func NewProtoMessage(typeName string) (proto.Message, bool) {
+	t := proto.MessageType(typeName)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, false
+	}
+	msg, ok := reflect.New(t.Elem()).Interface().(proto.Message)
+	return msg, ok
+}
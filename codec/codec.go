@@ -18,6 +18,7 @@ package codec
 
 import (
 	"fmt"
+	"sort"
 )
 
 // Codec makes the body's Encoder and Decoder
@@ -81,6 +82,18 @@ func GetByName(codecName string) (Codec, error) {
 	return codec, nil
 }
 
+// List returns the registered codecs, in the order of the ids.
+func List() []Codec {
+	codecs := make([]Codec, 0, len(codecMap.idMap))
+	for _, codec := range codecMap.idMap {
+		codecs = append(codecs, codec)
+	}
+	sort.Slice(codecs, func(i, j int) bool {
+		return codecs[i].ID() < codecs[j].ID()
+	})
+	return codecs
+}
+
 // Marshal returns the encoding of v.
 func Marshal(codecID byte, v interface{}) ([]byte, error) {
 	codec, err := Get(codecID)
@@ -0,0 +1,94 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/mylonly/teleport/xfer"
+)
+
+// compressPoorRun the number of the consecutive incompressible messages
+// after which the compression filters of the session are disabled.
+const compressPoorRun = 8
+
+// defaultCompressPoorRatio the default ratio of the compressed size to the original size,
+// at or above which the message is incompressible.
+const defaultCompressPoorRatio = 0.95
+
+// prepareCompress observes the compression filters of the message to write,
+// or removes them if the compression of the session is disabled.
+func (s *session) prepareCompress(pipe *xfer.XferPipe) {
+	c := s.counters
+	if until := atomic.LoadInt64(&c.compressOffUntil); until > 0 {
+		if time.Now().UnixNano() < until {
+			n := pipe.Len()
+			pipe.Remove(xfer.IsCompress)
+			if pipe.Len() < n {
+				atomic.AddUint64(&c.compressSkipped, 1)
+			}
+			return
+		}
+		// the cooldown is over, try the compression again
+		atomic.CompareAndSwapInt64(&c.compressOffUntil, until, 0)
+	}
+	pipe.SetPackObserver(s.observeCompress)
+}
+
+// observeCompress counts the effectiveness of the compression filter, and disables the compression
+// of the session for the cooldown after compressPoorRun consecutive incompressible messages.
+func (s *session) observeCompress(filter xfer.XferFilter, srcLen, dstLen int, cost time.Duration) {
+	if !xfer.IsCompress(filter) {
+		return
+	}
+	c := s.counters
+	atomic.AddUint64(&c.compressIn, uint64(srcLen))
+	atomic.AddUint64(&c.compressOut, uint64(dstLen))
+	atomic.AddInt64(&c.compressCost, int64(cost))
+	cooldown := s.peer.compressCooldown
+	if cooldown <= 0 {
+		return
+	}
+	ratio := s.peer.compressPoorRatio
+	if ratio <= 0 {
+		ratio = defaultCompressPoorRatio
+	}
+	if float64(dstLen) < float64(srcLen)*ratio {
+		atomic.StoreInt64(&c.compressPoor, 0)
+		return
+	}
+	if atomic.AddInt64(&c.compressPoor, 1) < compressPoorRun {
+		return
+	}
+	atomic.StoreInt64(&c.compressPoor, 0)
+	atomic.StoreInt64(&c.compressOffUntil, time.Now().Add(cooldown).UnixNano())
+	Debugf("compression disabled for %s: %s, ratio: %d/%d", cooldown, s.RemoteAddr().String(), dstLen, srcLen)
+}
+
+// compressStats fills the compression statistics of the session.
+func (s *session) compressStats(stats *SessionStats) {
+	c := s.counters
+	stats.CompressIn = atomic.LoadUint64(&c.compressIn)
+	stats.CompressOut = atomic.LoadUint64(&c.compressOut)
+	stats.CompressTime = time.Duration(atomic.LoadInt64(&c.compressCost))
+	stats.CompressSkipped = atomic.LoadUint64(&c.compressSkipped)
+	if stats.CompressIn > 0 {
+		stats.CompressRatio = float64(stats.CompressOut) / float64(stats.CompressIn)
+	}
+	if until := atomic.LoadInt64(&c.compressOffUntil); until > time.Now().UnixNano() {
+		stats.CompressDisabledUntil = time.Unix(0, until)
+	}
+}
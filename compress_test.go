@@ -0,0 +1,64 @@
+package tp_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/xfer/gzip"
+)
+
+func TestCompressCooldown(t *testing.T) {
+	if !gzip.Is('g') {
+		gzip.Reg('g', "gzip", 5)
+	}
+	var path string
+	pushed := make(chan struct{}, 10)
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{
+		CompressCooldown: time.Minute,
+	}, func(srv, _ tp.Peer) {
+		path = srv.RoutePushFunc(func(ctx tp.PushCtx, arg *[]byte) *tp.Rerror {
+			pushed <- struct{}{}
+			return nil
+		})
+	})
+	defer p.Close()
+	push := func(body []byte) {
+		if rerr := p.sess.Push(path, body, tp.WithXferPipe('g')); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	push(bytes.Repeat([]byte("a"), 4096))
+	stats := p.sess.Stats()
+	if stats.CompressIn == 0 || stats.CompressRatio >= 0.5 || stats.CompressTime <= 0 {
+		t.Fatalf("expect the compression counted, got %+v", stats)
+	}
+	if !stats.CompressDisabledUntil.IsZero() {
+		t.Fatal("expect the compression enabled")
+	}
+	// the random data is incompressible
+	for i := 0; i < 8; i++ {
+		body := make([]byte, 4096)
+		rand.Read(body)
+		push(body)
+	}
+	stats = p.sess.Stats()
+	if stats.CompressDisabledUntil.IsZero() || stats.CompressSkipped != 0 {
+		t.Fatalf("expect the compression disabled, got %+v", stats)
+	}
+	compressIn := stats.CompressIn
+	push(bytes.Repeat([]byte("a"), 4096))
+	stats = p.sess.Stats()
+	if stats.CompressSkipped != 1 || stats.CompressIn != compressIn {
+		t.Fatalf("expect the compression skipped, got %+v", stats)
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case <-pushed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expect 10 pushes handled, got %d", i)
+		}
+	}
+}
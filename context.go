@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 
@@ -25,6 +26,7 @@ import (
 	"github.com/mylonly/teleport/codec"
 	"github.com/mylonly/teleport/socket"
 	"github.com/mylonly/teleport/utils"
+	"github.com/mylonly/teleport/xfer"
 )
 
 type (
@@ -72,6 +74,30 @@ type (
 		ServiceMethod() string
 		// ResetServiceMethod resets the input message service method.
 		ResetServiceMethod(string)
+		// Param returns the value of the path parameter of the route pattern, e.g. the id of /user/:id/orders.
+		// NOTE: It is empty if the route has no such parameter.
+		Param(name string) string
+		// Detach returns a long-lived copy of the context, which is safe to retain,
+		// e.g. used by the goroutines spawned by the handler.
+		Detach() DetachedCtx
+		// Timeline returns the timestamps of the processing phases of the input message so far,
+		// which are recorded only if PeerConfig.CountTime is true.
+		Timeline() Timeline
+	}
+	// DetachedCtx the read-only copy of the handler context,
+	// which stays valid after the handler returns.
+	DetachedCtx interface {
+		PreCtx
+		// Seq returns the input message sequence.
+		Seq() int32
+		// PeekMeta peeks the header metadata for the input message.
+		PeekMeta(key string) []byte
+		// VisitMeta calls f for each existing metadata.
+		VisitMeta(f func(key, value []byte))
+		// CopyMeta returns the input message metadata copy.
+		CopyMeta() *utils.Args
+		// ServiceMethod returns the input message service method.
+		ServiceMethod() string
 	}
 	// ReadCtx context method set for reading message.
 	ReadCtx interface {
@@ -82,6 +108,9 @@ type (
 		Rerror() *Rerror
 	}
 	// PushCtx context method set for handling the pushed message.
+	// NOTE:
+	//  The context is put back into the pool and reused after the handler returns,
+	//  so it must not be retained or used by other goroutines, use Detach instead.
 	// For example:
 	//  type HomePush struct{ PushCtx }
 	PushCtx interface {
@@ -90,6 +119,9 @@ type (
 		GetBodyCodec() byte
 	}
 	// CallCtx context method set for handling the called message.
+	// NOTE:
+	//  The context is put back into the pool and reused after the handler returns,
+	//  so it must not be retained or used by other goroutines, use Detach instead.
 	// For example:
 	//  type HomeCall struct{ CallCtx }
 	CallCtx interface {
@@ -111,6 +143,25 @@ type (
 		SetMeta(key, value string)
 		// AddXferPipe appends transfer filter pipe of reply message.
 		AddXferPipe(filterID ...byte)
+		// ReplyPart sends a part of the reply before the handler returns the last one,
+		// e.g. a page of the query results, which is received by Session.CallMulti.
+		// NOTE: It fails if the caller does not accept the multiple replies, e.g. by Session.Call.
+		ReplyPart(result interface{}) *Rerror
+		// SendStream sends a chunk of the reply before the handler returns, which is received by Session.CallStream.
+		// NOTE: It waits while the unread chunks fill the window of the caller.
+		SendStream(chunk interface{}) *Rerror
+	}
+	// StreamCtx context method set for handling the stream, see RouteStream.
+	// NOTE:
+	//  The context is put back into the pool and reused after the handler returns,
+	//  so it must not be retained or used by other goroutines, use Detach instead.
+	StreamCtx interface {
+		inputCtx
+		// Recv waits for the next message sent over the stream by the remote peer, and decodes it into arg;
+		// returns false after the remote peer closes its sending side, or if the stream is aborted.
+		Recv(arg interface{}) (ok bool, rerr *Rerror)
+		// Send sends a message over the stream to the remote peer, which is received by ClientStream.Next.
+		Send(result interface{}) *Rerror
 	}
 	// UnknownPushCtx context method set for handling the unknown pushed message.
 	UnknownPushCtx interface {
@@ -131,6 +182,15 @@ type (
 		InputBodyBytes() []byte
 		// Bind when the raw body binder is []byte type, now binds the input body to v.
 		Bind(v interface{}) (bodyCodec byte, err error)
+		// BodyType returns the type name of the input body declared by the sender, see WithBodyType.
+		BodyType() string
+		// BindAny packs the raw protobuf body into PbAny of the declared type,
+		// or unmarshals it if the declared type is google.protobuf.Any,
+		// e.g. for the gateway to forward it downstream.
+		BindAny() (*codec.PbAny, error)
+		// BindRegistered binds the raw body to a new value of the declared type,
+		// which is registered by RegBodyType or the generated protobuf code.
+		BindRegistered() (interface{}, error)
 		// SetBodyCodec sets the body codec for reply message.
 		SetBodyCodec(byte)
 		// AddMeta adds the header metadata 'key=value' for reply message.
@@ -151,10 +211,84 @@ var (
 	_ ReadCtx        = new(handlerCtx)
 	_ PushCtx        = new(handlerCtx)
 	_ CallCtx        = new(handlerCtx)
+	_ StreamCtx      = new(handlerCtx)
 	_ UnknownPushCtx = new(handlerCtx)
 	_ UnknownCallCtx = new(handlerCtx)
 )
 
+// detachedCtx the copy of handlerCtx, which is not pooled.
+type detachedCtx struct {
+	Logger
+	sess          *session
+	ip            string
+	realIP        string
+	swap          goutil.Map
+	context       context.Context
+	seq           int32
+	serviceMethod string
+	meta          *utils.Args
+}
+
+var _ DetachedCtx = new(detachedCtx)
+
+// Peer returns the peer.
+func (d *detachedCtx) Peer() Peer {
+	return d.sess.peer
+}
+
+// Session returns the session.
+func (d *detachedCtx) Session() Session {
+	return d.sess
+}
+
+// IP returns the remote addr.
+func (d *detachedCtx) IP() string {
+	return d.ip
+}
+
+// RealIP returns the the real remote addr when detaching.
+func (d *detachedCtx) RealIP() string {
+	return d.realIP
+}
+
+// Swap returns custom data swap of the context.
+func (d *detachedCtx) Swap() goutil.Map {
+	return d.swap
+}
+
+// Context carries a deadline, a cancelation signal, and other values across
+// API boundaries.
+func (d *detachedCtx) Context() context.Context {
+	return d.context
+}
+
+// Seq returns the input message sequence.
+func (d *detachedCtx) Seq() int32 {
+	return d.seq
+}
+
+// ServiceMethod returns the input message service method.
+func (d *detachedCtx) ServiceMethod() string {
+	return d.serviceMethod
+}
+
+// PeekMeta peeks the header metadata for the input message.
+func (d *detachedCtx) PeekMeta(key string) []byte {
+	return d.meta.Peek(key)
+}
+
+// VisitMeta calls f for each existing metadata.
+func (d *detachedCtx) VisitMeta(f func(key, value []byte)) {
+	d.meta.VisitAll(f)
+}
+
+// CopyMeta returns the input message metadata copy.
+func (d *detachedCtx) CopyMeta() *utils.Args {
+	dst := utils.AcquireArgs()
+	d.meta.CopyTo(dst)
+	return dst
+}
+
 // handlerCtx the underlying common instance of CallCtx and PushCtx.
 type handlerCtx struct {
 	sess            *session
@@ -169,6 +303,17 @@ type handlerCtx struct {
 	pluginContainer *PluginContainer
 	handleErr       *Rerror
 	context         context.Context
+	stagedBody      interface{}
+	rawBody         []byte
+	route           routeMatch          // only if the handler is routed by the pattern, see Param
+	unpackedLen     int                 // the size counted in the session unpack budget
+	sessLimiter     *concurrencyLimiter // the session handler limit the CALL is counted by
+	sessSlot        int8
+	cancel          context.CancelFunc // only for the CALL, see trackCancel
+	stream          *serverStream      // the stream opened by the CALL, or the one the PUSH is sent over
+	credit          *streamCredit      // the credits of the chunks of the reply, see SendStream
+	detached        bool
+	timeline        Timeline
 	next            *handlerCtx
 }
 
@@ -208,6 +353,15 @@ func (c *handlerCtx) clean() {
 	c.pluginContainer = nil
 	c.handleErr = nil
 	c.context = nil
+	c.detached = false
+	c.stagedBody = nil
+	c.route = routeMatch{}
+	c.timeline = Timeline{}
+	if cap(c.rawBody) > maxRetainedRawBody {
+		c.rawBody = nil
+	} else {
+		c.rawBody = c.rawBody[:0]
+	}
 	c.input.Reset(socket.WithNewBody(c.binding))
 	c.output.Reset()
 }
@@ -321,6 +475,26 @@ func (c *handlerCtx) Context() context.Context {
 	return c.context
 }
 
+// Detach returns a long-lived copy of the context, which is safe to retain,
+// e.g. used by the goroutines spawned by the handler.
+// NOTE: The copy does not follow the changes of the context after detaching.
+func (c *handlerCtx) Detach() DetachedCtx {
+	c.detached = true
+	d := &detachedCtx{
+		Logger:        c.sess,
+		sess:          c.sess,
+		ip:            c.IP(),
+		realIP:        c.RealIP(),
+		swap:          c.swap,
+		context:       c.Context(),
+		seq:           c.input.Seq(),
+		serviceMethod: c.input.ServiceMethod(),
+		meta:          new(utils.Args),
+	}
+	c.input.Meta().CopyTo(d.meta)
+	return d
+}
+
 // setContext sets the context for timeout.
 func (c *handlerCtx) setContext(ctx context.Context) {
 	c.context = ctx
@@ -329,18 +503,56 @@ func (c *handlerCtx) setContext(ctx context.Context) {
 // Be executed synchronously when reading message
 func (c *handlerCtx) binding(header Header) (body interface{}) {
 	c.start = c.sess.timeNow()
+	if c.sess.peer.countTime {
+		c.timeline.Read = c.start
+	}
 	c.pluginContainer = c.sess.peer.pluginContainer
 	switch header.Mtype() {
 	case TypeReply:
-		return c.bindReply(header)
+		body = c.bindReply(header)
 	case TypePush:
-		return c.bindPush(header)
+		body = c.bindPush(header)
+		if c.stream != nil {
+			// the messages of the stream are delivered in order by the read goroutine
+			return body
+		}
 	case TypeCall:
-		return c.bindCall(header)
+		body = c.bindCall(header)
 	default:
-		c.handleErr = rerrCodeMtypeNotAllowed
-		return nil
+		if IsControlType(header.Mtype()) {
+			body = c.bindControl(header)
+		} else {
+			c.handleErr = rerrCodeMtypeNotAllowed
+		}
+	}
+	return c.stageBody(body)
+}
+
+// stageBody defers the body decoding to the preprocessing workers, if enabled,
+// or until the body codec is sniffed.
+func (c *handlerCtx) stageBody(body interface{}) interface{} {
+	if body == nil || (c.sess.peer.preprocessor == nil && !c.sess.peer.sniffBodyCodec) {
+		return body
 	}
+	c.stagedBody = body
+	return &c.rawBody
+}
+
+// isStaged returns whether the body decoding is deferred.
+func (c *handlerCtx) isStaged() bool {
+	return c.stagedBody != nil
+}
+
+// decodeStagedBody decodes the staged raw body into the bound body.
+func (c *handlerCtx) decodeStagedBody() {
+	c.input.SetBody(c.stagedBody)
+	if c.sess.peer.sniffBodyCodec {
+		c.sniffBodyCodec()
+	}
+	if err := c.input.UnmarshalBody(c.rawBody); err != nil && c.handleErr == nil {
+		c.handleErr = rerrBadMessage.Copy().SetReason(err.Error())
+	}
+	c.markPhase(&c.timeline.Decoded)
 }
 
 const logFormatDisconnected = "disconnected due to unsupported message type: %d %s %s %q RECV(%s)"
@@ -367,17 +579,66 @@ func (c *handlerCtx) handle() {
 		return
 
 	default:
+		if IsControlType(c.input.Mtype()) {
+			// handles control message, never routed to user handlers
+			c.handleControl()
+			return
+		}
 	}
 E:
 	// if unsupported, disconnected.
 	rerrCodeMtypeNotAllowed.SetToMeta(c.output.Meta())
+	policy, _ := c.sess.peer.logPolicies.get(c.input.ServiceMethod())
 	Errorf(logFormatDisconnected,
 		c.input.Mtype(), c.IP(), c.input.ServiceMethod(), c.input.Seq(),
-		messageLogBytes(c.input, c.sess.peer.printDetail))
-	go c.sess.Close()
+		messageLogBytes(c.input, policy.PrintDetail))
+	go c.sess.close(CloseProtocolError, rerrCodeMtypeNotAllowed.ToError(), true)
+}
+
+func (c *handlerCtx) bindControl(header Header) interface{} {
+	c.input.SetBody(new([]byte))
+	return c.input.Body()
+}
+
+// handleControl hands the control message over to the plugins.
+func (c *handlerCtx) handleControl() {
+	defer func() {
+		if p := recover(); p != nil {
+			Errorf("panic:%v\n%s", p, goutil.PanicTrace(2))
+		}
+	}()
+	if c.handleErr != nil {
+		Debugf("ignore bad control message: %s %s %s", TypeText(c.input.Mtype()), c.IP(), c.handleErr.String())
+		return
+	}
+	switch c.input.Mtype() {
+	case TypeGoaway:
+		Infof("remote peer is going away: %s, reason: %s", c.IP(), c.PeekMeta(MetaGoawayReason))
+	case TypePing:
+		// always answered, even if the heartbeat of this side is disabled
+		c.sess.Control(TypePong)
+	case TypeHello:
+		c.sess.readHello(*c.input.Body().(*[]byte))
+	case TypeCancel:
+		c.sess.cancelCall(c.PeekMeta(MetaCancelSeq))
+	case TypeCredit:
+		c.sess.grantCredit(c.PeekMeta(MetaStreamSeq), c.PeekMeta(MetaCredit))
+	}
+	c.pluginContainer.postReadControl(c)
 }
 
 func (c *handlerCtx) bindPush(header Header) interface{} {
+	if err := decompressMeta(c.input.Meta()); err != nil {
+		c.handleErr = rerrBadMessage.Copy().SetReason(err.Error())
+		return nil
+	}
+	if err := c.sess.peer.metaLimit.check(c.input.Meta()); err != nil {
+		c.handleErr = rerrBadMessage.Copy().SetReason(err.Error())
+		return nil
+	}
+	if seq := c.input.Meta().Peek(MetaStreamSeq); len(seq) > 0 {
+		return c.bindStreamPush(seq)
+	}
 	c.handleErr = c.pluginContainer.postReadPushHeader(c)
 	if c.handleErr != nil {
 		return nil
@@ -389,17 +650,33 @@ func (c *handlerCtx) bindPush(header Header) interface{} {
 	}
 
 	var ok bool
-	c.handler, ok = c.sess.getPushHandler(header.ServiceMethod())
+	c.handler, ok = c.sess.getPushHandler(header.ServiceMethod(), GetVersion(c.input.Meta()))
 	if !ok {
 		c.handleErr = rerrNotFound
 		return nil
 	}
+	c.matchRoute(header.ServiceMethod())
+	c.handler = c.handler.selectBulk(c.input.Size())
+	if c.sess.peer.panicPolicies.isQuarantined(header.ServiceMethod()) {
+		c.handleErr = rerrRouteQuarantined
+		return nil
+	}
+	if c.handleErr = c.handler.checkSize(c.input.Size()); c.handleErr != nil {
+		return nil
+	}
+	if c.handleErr = c.handler.checkMeta(c.input.Meta()); c.handleErr != nil {
+		return nil
+	}
 
 	// reset plugin container
 	c.pluginContainer = c.handler.pluginContainer
 
-	c.arg = c.handler.NewArgValue()
-	c.input.SetBody(c.arg.Interface())
+	if c.handler.newArg != nil {
+		c.input.SetBody(c.handler.newArg())
+	} else {
+		c.arg = c.handler.NewArgValue()
+		c.input.SetBody(c.arg.Interface())
+	}
 	c.handleErr = c.pluginContainer.preReadPushBody(c)
 	if c.handleErr != nil {
 		return nil
@@ -418,18 +695,24 @@ func (c *handlerCtx) handlePush() {
 	defer func() {
 		if p := recover(); p != nil {
 			Errorf("panic:%v\n%s", p, goutil.PanicTrace(2))
+			c.onPanic()
 		}
 		c.cost = c.sess.timeSince(c.start)
-		c.sess.printAccessLog(c.RealIP(), c.cost, c.input, nil, typePushHandle)
+		c.sess.counters.countHandled(c.cost, c.sess.peer.countTime)
+		c.sess.printAccessLog(c.RealIP(), c.cost, c.input, nil, typePushHandle, c.handleErr != nil, &c.timeline)
 	}()
 
 	if c.handleErr == nil && c.handler != nil {
 		if c.pluginContainer.postReadPushBody(c) == nil {
-			if c.handler.isUnknown {
+			c.markPhase(&c.timeline.HandleStart)
+			if c.handler.isUnknown || c.handler.isBulk {
 				c.handler.unknownHandleFunc(c)
+			} else if c.handler.handleArg != nil {
+				c.handler.handleArg(c, c.input.Body())
 			} else {
 				c.handler.handleFunc(c, c.arg)
 			}
+			c.markPhase(&c.timeline.HandleEnd)
 		}
 	}
 	if c.handleErr != nil {
@@ -438,6 +721,20 @@ func (c *handlerCtx) handlePush() {
 }
 
 func (c *handlerCtx) bindCall(header Header) interface{} {
+	c.trackCancel(header.Seq())
+	if err := decompressMeta(c.input.Meta()); err != nil {
+		c.handleErr = rerrBadMessage.Copy().SetReason(err.Error())
+		return nil
+	}
+	if err := c.sess.peer.metaLimit.check(c.input.Meta()); err != nil {
+		c.handleErr = rerrBadMessage.Copy().SetReason(err.Error())
+		return nil
+	}
+	c.sess.negotiateRerrorCodec(c.input.Meta())
+	if c.sess.isDraining() {
+		c.handleErr = rerrServiceUnavailable.Copy().SetReason("session is draining")
+		return nil
+	}
 	c.handleErr = c.pluginContainer.postReadCallHeader(c)
 	if c.handleErr != nil {
 		return nil
@@ -449,17 +746,31 @@ func (c *handlerCtx) bindCall(header Header) interface{} {
 	}
 
 	var ok bool
-	c.handler, ok = c.sess.getCallHandler(header.ServiceMethod())
+	c.handler, ok = c.sess.lookupCallHandler(header.ServiceMethod(), GetVersion(c.input.Meta()))
 	if !ok {
 		c.handleErr = rerrNotFound
 		return nil
 	}
+	c.matchRoute(header.ServiceMethod())
+	c.handler = c.handler.selectBulk(c.input.Size())
+	if c.sess.peer.panicPolicies.isQuarantined(header.ServiceMethod()) {
+		c.handleErr = rerrRouteQuarantined
+		return nil
+	}
+	if c.handleErr = c.handler.checkSize(c.input.Size()); c.handleErr != nil {
+		return nil
+	}
+	if c.handleErr = c.handler.checkMeta(c.input.Meta()); c.handleErr != nil {
+		return nil
+	}
 
 	// reset plugin container
 	c.pluginContainer = c.handler.pluginContainer
 
 	if c.handler.isUnknown {
 		c.input.SetBody(new([]byte))
+	} else if c.handler.newArg != nil {
+		c.input.SetBody(c.handler.newArg())
 	} else {
 		c.arg = c.handler.NewArgValue()
 		c.input.SetBody(c.arg.Interface())
@@ -469,10 +780,36 @@ func (c *handlerCtx) bindCall(header Header) interface{} {
 	if c.handleErr != nil {
 		return nil
 	}
+	if c.handler.IsStream() {
+		if c.handleErr = c.openStream(); c.handleErr != nil {
+			return nil
+		}
+	}
 
 	return c.input.Body()
 }
 
+// negotiateReplyXfer picks the first supported transfer filter of the accept list for the reply.
+func (c *handlerCtx) negotiateReplyXfer(accept []byte) {
+	for _, id := range accept {
+		if _, err := xfer.Get(id); err == nil {
+			c.output.XferPipe().Append(id)
+			c.output.Meta().Set(MetaXfer, strconv.FormatUint(uint64(id), 10))
+			return
+		}
+	}
+}
+
+// onPanic applies the panic policy of the route, after the handler panics.
+func (c *handlerCtx) onPanic() {
+	if c.sess.peer.panicPolicies.onPanic(c.input.ServiceMethod()) == PanicCloseSession {
+		sess := c.sess
+		Warnf("close the session after the handler panics: %s, %s", sess.ID(), c.input.ServiceMethod())
+		// the session waits for the handler to return before closing
+		AnywayGo(func() { sess.close(CloseHandlerPanic, nil, true) })
+	}
+}
+
 // handleCall handles and replies call.
 func (c *handlerCtx) handleCall() {
 	var writed bool
@@ -485,18 +822,24 @@ func (c *handlerCtx) handleCall() {
 				}
 				c.writeReply(c.handleErr)
 			}
+			c.onPanic()
 		}
 		c.cost = c.sess.timeSince(c.start)
-		c.sess.printAccessLog(c.RealIP(), c.cost, c.input, c.output, typeCallHandle)
+		c.sess.counters.countHandled(c.cost, c.sess.peer.countTime)
+		c.sess.printAccessLog(c.RealIP(), c.cost, c.input, c.output, typeCallHandle, len(getRerrorBytes(c.output.Meta())) > 0, &c.timeline)
 	}()
 
 	c.output.SetMtype(TypeReply)
 	c.output.SetSeq(c.input.Seq())
 	c.output.SetServiceMethod(c.input.ServiceMethod())
-	c.output.XferPipe().AppendFrom(c.input.XferPipe())
+	if accept := GetAcceptXfer(c.input.Meta()); len(accept) > 0 {
+		c.negotiateReplyXfer(accept)
+	} else {
+		c.output.XferPipe().AppendFrom(c.input.XferPipe())
+	}
 
 	if age := c.sess.ContextAge(); age > 0 {
-		ctxTimout, _ := context.WithTimeout(c.input.Context(), age)
+		ctxTimout, _ := context.WithTimeout(c.Context(), age)
 		c.setContext(ctxTimout)
 		socket.WithContext(ctxTimout)(c.output)
 	}
@@ -509,16 +852,37 @@ func (c *handlerCtx) handleCall() {
 	if c.handleErr == nil {
 		c.handleErr = c.pluginContainer.postReadCallBody(c)
 		if c.handleErr == nil {
-			if c.handler.isUnknown {
+			c.handleErr = c.waitSessionSlot()
+		}
+		if c.handleErr == nil {
+			c.handleErr = c.handler.concurrency.acquire(c.Context())
+		}
+		if c.handleErr == nil {
+			defer c.handler.concurrency.release()
+			c.markPhase(&c.timeline.HandleStart)
+			if c.handler.isUnknown || c.handler.isBulk {
 				c.handler.unknownHandleFunc(c)
+			} else if c.handler.handleArg != nil {
+				c.handler.handleArg(c, c.input.Body())
 			} else {
 				c.handler.handleFunc(c, c.arg)
 			}
+			if c.handleErr == nil && len(c.handler.replyProcessors) > 0 {
+				c.processReply()
+			}
+			c.markPhase(&c.timeline.HandleEnd)
 		}
 	}
 
+	// the termination frame of the stream
+	if c.isStreamCall() {
+		c.endStream()
+	}
+
 	// reply call
 	c.setReplyBodyCodec(c.handleErr != nil)
+	c.setRetryAfter()
+	c.inheritPriority()
 	c.pluginContainer.preWriteReply(c)
 	rerr := c.writeReply(c.handleErr)
 	if rerr != nil {
@@ -561,13 +925,15 @@ func (c *handlerCtx) setReplyBodyCodec(hasError bool) {
 
 func (c *handlerCtx) writeReply(rerr *Rerror) *Rerror {
 	if rerr != nil {
-		rerr.SetToMeta(c.output.Meta())
+		rerr.SetToMetaWithCodec(c.output.Meta(), c.sess.RerrorCodec())
 		c.output.SetBody(nil)
 		c.output.SetBodyCodec(codec.NilCodecID)
 	}
 	serviceMethod := c.output.ServiceMethod()
 	c.output.SetServiceMethod("")
+	c.markPhase(&c.timeline.WriteStart)
 	_, rerr = c.sess.write(c.output)
+	c.markPhase(&c.timeline.Written)
 	c.output.SetServiceMethod(serviceMethod)
 	return rerr
 }
@@ -582,14 +948,29 @@ func (c *handlerCtx) bindReply(header Header) interface{} {
 
 	// unlock: handleReply
 	c.callCmd.mu.Lock()
+	if c.callCmd.isDone() {
+		// expired before the reply
+		c.callCmd.mu.Unlock()
+		c.callCmd = nil
+		return nil
+	}
 	c.input.SetServiceMethod(c.callCmd.output.ServiceMethod())
 	c.swap = c.callCmd.swap
 	c.callCmd.inputBodyCodec = c.GetBodyCodec()
+	metaErr := decompressMeta(c.input.Meta())
 	// if c.callCmd.inputMeta!=nil, means the callCmd is replyed.
 	c.callCmd.inputMeta = utils.AcquireArgs()
 	c.input.Meta().CopyTo(c.callCmd.inputMeta)
 	c.setContext(c.callCmd.output.Context())
-	c.input.SetBody(c.callCmd.result)
+	if c.callCmd.iter != nil {
+		c.input.SetBody(c.callCmd.iter.newResult())
+	} else {
+		c.input.SetBody(c.callCmd.result)
+	}
+	if metaErr != nil {
+		c.callCmd.rerr = rerrBadMessage.Copy().SetReason(metaErr.Error())
+		return nil
+	}
 
 	rerr := c.pluginContainer.postReadReplyHeader(c)
 	if rerr != nil {
@@ -617,11 +998,23 @@ func (c *handlerCtx) handleReply() {
 		if p := recover(); p != nil {
 			Errorf("panic:%v\n%s", p, goutil.PanicTrace(2))
 		}
+		if iter := c.callCmd.iter; iter != nil && c.callCmd.rerr == nil {
+			if isReplyPart(c.input.Meta()) {
+				iter.push(c.input.Body())
+				// the call goes on until the last reply
+				utils.ReleaseArgs(c.callCmd.inputMeta)
+				c.callCmd.inputMeta = nil
+				return
+			}
+			if !iter.lastEnds {
+				iter.push(c.input.Body())
+			}
+		}
 		c.callCmd.result = c.input.Body()
 		c.handleErr = c.callCmd.rerr
 		c.callCmd.done()
 		c.callCmd.cost = c.sess.timeSince(c.callCmd.start)
-		c.sess.printAccessLog(c.RealIP(), c.callCmd.cost, c.input, c.callCmd.output, typeCallLaunch)
+		c.sess.printAccessLog(c.RealIP(), c.callCmd.cost, c.input, c.callCmd.output, typeCallLaunch, c.callCmd.rerr != nil, nil)
 	}()
 	if c.callCmd.rerr != nil {
 		return
@@ -700,6 +1093,11 @@ type (
 		sess           *session
 		output         Message
 		result         interface{}
+		iter           *ReplyIter         // only for CallMulti
+		timer          *time.Timer        // only for PeerConfig.DefaultCallTimeout
+		timeoutCancel  context.CancelFunc // only for PeerConfig.DefaultCallTimeout, releases its context
+		peerSlot       *concurrencyLimiter
+		sessSlot       *concurrencyLimiter
 		rerr           *Rerror
 		inputBodyCodec byte
 		inputMeta      *utils.Args
@@ -821,6 +1219,9 @@ func (c *callCmd) CostTime() time.Duration {
 
 func (c *callCmd) done() {
 	c.sess.callCmdMap.Delete(c.output.Seq())
+	c.stopTimer()
+	c.releaseInflight()
+	c.iter.end()
 	c.callCmdChan <- c
 	close(c.doneChan)
 	// free count call-launch
@@ -829,13 +1230,48 @@ func (c *callCmd) done() {
 
 func (c *callCmd) cancel() {
 	c.sess.callCmdMap.Delete(c.output.Seq())
+	c.stopTimer()
+	c.releaseInflight()
 	c.rerr = rerrConnClosed
+	c.iter.end()
 	c.callCmdChan <- c
 	close(c.doneChan)
 	// free count call-launch
 	c.sess.graceCallCmdWaitGroup.Done()
 }
 
+// expire fails the call locally after PeerConfig.DefaultCallTimeout, and frees its sequence.
+func (c *callCmd) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isDone() || c.rerr != nil {
+		return
+	}
+	c.rerr = rerrCallTimeout
+	c.done()
+	Warnf("call timeout: %s, serviceMethod: %s, seq: %d", c.sess.RemoteAddr().String(), c.output.ServiceMethod(), c.output.Seq())
+}
+
+// stopTimer stops the timer and releases the context of PeerConfig.DefaultCallTimeout.
+func (c *callCmd) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	if c.timeoutCancel != nil {
+		c.timeoutCancel()
+	}
+}
+
+// isDone returns whether the call is completed, e.g. expired.
+func (c *callCmd) isDone() bool {
+	select {
+	case <-c.doneChan:
+		return true
+	default:
+		return false
+	}
+}
+
 // if callCmd.inputMeta!=nil, means the callCmd is replyed.
 func (c *callCmd) hasReply() bool {
 	return c.inputMeta != nil
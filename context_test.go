@@ -0,0 +1,111 @@
+package tp_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/xfer/gzip"
+)
+
+func TestAcceptXfer(t *testing.T) {
+	if !gzip.Is('g') {
+		gzip.Reg('g', "gzip", 5)
+	}
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.RouteCall(new(reverseCall))
+		cli.RouteCall(new(reverseCall))
+	})
+	defer p.Close()
+	var cases = []struct {
+		accept []byte
+		chosen string
+	}{
+		{[]byte{200, 'g'}, "103"},
+		{[]byte{200}, ""},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		var result string
+		callCmd := p.sess.Call("/reverse_call/reverse", "hello", &result, tp.WithAcceptXfer(c.accept...))
+		if rerr := callCmd.Rerror(); rerr != nil {
+			t.Fatalf("accept %v: %v", c.accept, rerr)
+		}
+		if chosen := string(callCmd.InputMeta().Peek(tp.MetaXfer)); chosen != c.chosen {
+			t.Fatalf("accept %v: expect chosen %q, got %q", c.accept, c.chosen, chosen)
+		}
+		if result != "hello" {
+			t.Fatalf("accept %v: expect hello, got %s", c.accept, result)
+		}
+	}
+}
+
+func TestDetach(t *testing.T) {
+	detached, reused := make(chan string, 1), make(chan struct{})
+	var path string
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(func(ctx tp.CallCtx, arg *int) (int, *tp.Rerror) {
+			d := ctx.Detach()
+			go func() {
+				<-reused
+				detached <- d.ServiceMethod() + "?k=" + string(d.PeekMeta("k")) + "&seq=" + strconv.Itoa(int(d.Seq()))
+			}()
+			return *arg, nil
+		})
+		srv.RouteCallFunc(echo_call)
+	})
+	defer p.Close()
+	cmd := p.sess.Call(path, 1, new(int), tp.WithSetMeta("k", "v"))
+	if rerr := cmd.Rerror(); rerr != nil {
+		t.Fatalf("%s: %v", path, rerr)
+	}
+	// the context has been reused by the following calls
+	for i := 0; i < 10; i++ {
+		p.sess.Call("/echo/call", 1, new(int), tp.WithSetMeta("k", "other"))
+	}
+	close(reused)
+	expect := path + "?k=v&seq=" + strconv.Itoa(int(cmd.Output().Seq()))
+	if got := <-detached; got != expect {
+		t.Fatalf("expect %s, got %s", expect, got)
+	}
+}
+
+func TestDefaultCallTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	var path string
+	entered, release := make(chan int, 3), make(chan struct{})
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{
+		DefaultCallTimeout: timeout,
+	}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(blockingCall(entered, release))
+	})
+	defer p.Close()
+	// the handler never replies before the release, so the timeout must fail locally
+	var arg, result = 1, 0
+	rerr := p.sess.Call(path, &arg, &result).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeHandleTimeout {
+		t.Fatalf("expect the call timeout, got %v", rerr)
+	}
+
+	// the explicit context is not limited
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	arg = 2
+	callCmd := p.sess.AsyncCall(path, &arg, &result, make(chan tp.CallCmd, 1), tp.WithContext(ctx))
+	// both CALLs are held by the handler past the default timeout
+	<-entered
+	<-entered
+	time.Sleep(2 * timeout)
+	close(release)
+	<-callCmd.Done()
+	if rerr = callCmd.Rerror(); rerr != nil || result != arg {
+		t.Fatalf("expect %d, got %d, %v", arg, result, rerr)
+	}
+	// the late reply of the expired call is dropped
+	arg = 3
+	if rerr = p.sess.Call(path, &arg, &result).Rerror(); rerr != nil || result != arg {
+		t.Fatalf("expect %d, got %d, %v", arg, result, rerr)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mylonly/teleport/socket"
+)
+
+// FeatureControl the feature name of the control messages, e.g. PING, CANCEL, HELLO and GOAWAY.
+// The first CALL, PUSH or REPLY written to each connection announces it in MetaFeatures,
+// if the proto keeps the types of the control messages;
+// the control messages are sent only after the remote peer announces it too,
+// and it is downgraded if the first message read from the connection does not announce it.
+const FeatureControl = "control"
+
+// announceFeatures sets MetaFeatures to the first non-control message written to the connection,
+// and returns whether it is set; the features are announced once it is written.
+// NOTE: It is called with the write lock, so that the announcing message is really the first one.
+func (s *session) announceFeatures(message Message) bool {
+	if IsControlType(message.Mtype()) || atomic.LoadInt32(&s.featuresSent) == 1 {
+		return false
+	}
+	if !carriesControl(s.GetProtoFunc()) {
+		atomic.StoreInt32(&s.featuresSent, 1)
+		return false
+	}
+	message.Meta().Set(MetaFeatures, FeatureControl)
+	return true
+}
+
+// readFeatures negotiates the control messages by the message read from the connection.
+func (s *session) readFeatures(input Message) {
+	if IsControlType(input.Mtype()) {
+		// only sent by the remote peer which has read the announcement
+		s.acceptControl()
+		return
+	}
+	if s.controlNegotiated() {
+		return
+	}
+	if bytes.Equal(input.Meta().Peek(MetaFeatures), featureControlBytes) && carriesControl(s.GetProtoFunc()) {
+		s.acceptControl()
+		return
+	}
+	s.Downgrade(FeatureControl, rerrControlUnsupported)
+}
+
+var featureControlBytes = []byte(FeatureControl)
+
+// acceptControl records that the remote peer accepts the control messages,
+// and starts the features depending on them.
+func (s *session) acceptControl() {
+	if s.IsDowngraded(FeatureControl) || !atomic.CompareAndSwapInt32(&s.controlAccepted, 0, 1) {
+		return
+	}
+	s.sendHello()
+}
+
+// acceptsControl returns whether the remote peer has been negotiated to accept the control messages.
+func (s *session) acceptsControl() bool {
+	return atomic.LoadInt32(&s.controlAccepted) == 1
+}
+
+// controlNegotiated returns whether it is known that the remote peer accepts the control messages or not.
+func (s *session) controlNegotiated() bool {
+	return s.acceptsControl() || s.IsDowngraded(FeatureControl)
+}
+
+// resetFeatures forgets the negotiated features, e.g. the remote peer may have been upgraded before redialing.
+func (s *session) resetFeatures() {
+	s.downgraded.Clear()
+	atomic.StoreInt32(&s.controlAccepted, 0)
+	atomic.StoreInt32(&s.featuresSent, 0)
+	s.remoteBuildInfo.Store((*BuildInfo)(nil))
+}
+
+// controlCarriers caches whether the protos keep the types of the control messages,
+// key: [3]interface{}{type, id, name} of the proto.
+var controlCarriers sync.Map
+
+// carriesControl returns whether the proto keeps the types of the control messages,
+// which is probed once per proto type and version by packing and unpacking a PING in memory.
+// NOTE: It is called with the write lock, so the panic of the proto is recovered as not keeping them.
+func carriesControl(protoFunc ProtoFunc) (ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			Debugf("probe the control messages of the proto: %v", p)
+			ok = false
+		}
+	}()
+	var buf bytes.Buffer
+	proto := protoFunc(&buf)
+	id, name := proto.Version()
+	key := [3]interface{}{reflect.TypeOf(proto), id, name}
+	if v, loaded := controlCarriers.Load(key); loaded {
+		return v.(bool)
+	}
+	defer func() {
+		controlCarriers.Store(key, ok)
+	}()
+	output := socket.GetMessage(socket.WithMtype(TypePing))
+	defer socket.PutMessage(output)
+	if proto.Pack(output) != nil {
+		return false
+	}
+	input := socket.GetMessage()
+	defer socket.PutMessage(input)
+	if proto.Unpack(input) != nil {
+		return false
+	}
+	return input.Mtype() == TypePing
+}
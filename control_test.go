@@ -0,0 +1,76 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/socket"
+)
+
+// oldProto mimics the proto of the remote peer which does not announce the features, e.g. an older version.
+type oldProto struct{ tp.Proto }
+
+func (p oldProto) Pack(m tp.Message) error {
+	m.Meta().Del(tp.MetaFeatures)
+	return p.Proto.Pack(m)
+}
+
+func oldProtoFunc(rw tp.IOWithReadBuffer) tp.Proto {
+	return oldProto{socket.DefaultProtoFunc()(rw)}
+}
+
+// featuresRecorder records the features announced by the CALLs read by the peer.
+type featuresRecorder chan string
+
+func (featuresRecorder) Name() string {
+	return "features_recorder"
+}
+
+func (r featuresRecorder) PostReadCallHeader(ctx tp.ReadCtx) *tp.Rerror {
+	r <- string(ctx.PeekMeta(tp.MetaFeatures))
+	return nil
+}
+
+func TestFeatureControl(t *testing.T) {
+	var (
+		path     string
+		features = make(featuresRecorder, 2)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.PluginContainer().AppendRight(features)
+		path = srv.RouteCallFunc(echo_call)
+	})
+	defer peers.Close()
+
+	for i := 0; i < 2; i++ {
+		if rerr := peers.sess.Call(path, &i, new(int)).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	// announced once per connection
+	if f := <-features; f != tp.FeatureControl {
+		t.Fatalf("expect the first CALL announces %q, got %q", tp.FeatureControl, f)
+	}
+	if f := <-features; f != "" {
+		t.Fatalf("expect the second CALL announces nothing, got %q", f)
+	}
+	if peers.sess.IsDowngraded(tp.FeatureControl) {
+		t.Fatal("expect the control messages accepted")
+	}
+}
+
+func TestFeatureControlDowngrade(t *testing.T) {
+	var path string
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(echo_call)
+	}, oldProtoFunc)
+	defer peers.Close()
+
+	var arg = 1
+	if rerr := peers.sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if !peers.sess.IsDowngraded(tp.FeatureControl) {
+		t.Fatal("expect the control messages downgraded")
+	}
+}
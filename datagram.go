@@ -0,0 +1,116 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/mylonly/teleport/socket"
+)
+
+// DatagramConn is the connection which also sends and receives the unreliable datagrams besides the stream,
+// e.g. the QUIC connection with the DATAGRAM frames (RFC 9221).
+// NOTE:
+//  The quic network does not implement it yet, since the vendored quic-go has no DATAGRAM frames;
+//  ReceiveDatagram must return an error after the connection is closed.
+type DatagramConn interface {
+	net.Conn
+	// SendDatagram sends the datagram, which may be lost.
+	SendDatagram(b []byte) error
+	// ReceiveDatagram blocks until a datagram is received.
+	ReceiveDatagram() ([]byte, error)
+}
+
+// PushUnreliable sends a message as an unreliable datagram, which may be lost, duplicated or reordered,
+// e.g. for the telemetry and the game-state updates.
+// NOTE:
+//  It falls back to Push if the connection does not support the datagrams, see DatagramConn;
+//  The message larger than the datagram limit of the connection fails;
+//  It is never re-sent after redialing.
+func (s *session) PushUnreliable(serviceMethod string, arg interface{}, setting ...MessageSetting) *Rerror {
+	if _, ok := s.getConn().(DatagramConn); !ok {
+		return s.Push(serviceMethod, arg, setting...)
+	}
+	return s.push(serviceMethod, arg, true, setting)
+}
+
+// writeDatagram packs the message by the initial proto of the session, and sends it as a datagram.
+// NOTE: The datagrams are independent of the stream, so they are not affected by UpgradeProto.
+func (s *session) writeDatagram(message Message) *Rerror {
+	conn, ok := s.getConn().(DatagramConn)
+	if !ok || s.getStatus() != statusOk {
+		return rerrConnClosed
+	}
+	if limit := s.WriteLimit(); limit > 0 {
+		socket.WithSizeLimit(limit)(message)
+	}
+	var buf bytes.Buffer
+	err := s.GetProtoFunc()(&buf).Pack(message)
+	if err == socket.ErrExceedMessageSizeLimit {
+		return rerrMessageTooLarge.Copy().SetReason(err.Error())
+	}
+	if err == nil {
+		err = conn.SendDatagram(buf.Bytes())
+	}
+	if err != nil {
+		return rerrWriteFailed.Copy().SetReason(err.Error())
+	}
+	s.counters.countOut(message)
+	s.touchActive(message)
+	return nil
+}
+
+// readDatagrams reads the PUSH messages sent by PushUnreliable, until the connection is closed.
+// The broken datagrams and the other types of messages are dropped.
+func (s *session) readDatagrams(conn DatagramConn) {
+	for s.goonRead() {
+		b, err := conn.ReceiveDatagram()
+		if err != nil {
+			return
+		}
+		var ctx = s.peer.getContext(s, false)
+		if s.peer.pluginContainer.preReadHeader(ctx) != nil {
+			s.peer.putContext(ctx, false)
+			continue
+		}
+		ctx.input.XferPipe().SetUnpackLimit(s.unpackLimit())
+		err = s.GetProtoFunc()(bytes.NewBuffer(b)).Unpack(ctx.input)
+		if err != nil || ctx.input.Mtype() != TypePush || !s.goonRead() {
+			if err != nil {
+				Debugf("read datagram: %s, error: %s", s.RemoteAddr().String(), err.Error())
+			}
+			s.peer.putContext(ctx, false)
+			continue
+		}
+		s.touchRead()
+		s.touchActive(ctx.input)
+		s.counters.countIn(ctx.input)
+		s.countUnpacked(ctx)
+		ctx.markPhase(&ctx.timeline.Unpacked)
+		if !ctx.isStaged() {
+			ctx.timeline.Decoded = ctx.timeline.Unpacked
+		}
+		s.graceCtxWaitGroup.Add(1)
+		if ctx.isStaged() {
+			if s.peer.preprocessor != nil {
+				s.peer.preprocessor.submit(ctx)
+				continue
+			}
+			ctx.decodeStagedBody()
+		}
+		s.peer.dispatch(ctx)
+	}
+}
@@ -0,0 +1,112 @@
+package tp_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// datagramConn the connection of net.Pipe which exchanges the datagrams by channels.
+type datagramConn struct {
+	net.Conn
+	in, out   chan []byte
+	sent      int32
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ tp.DatagramConn = new(datagramConn)
+
+func datagramPipe() (*datagramConn, *datagramConn) {
+	a, b := net.Pipe()
+	ab, ba := make(chan []byte, 10), make(chan []byte, 10)
+	return &datagramConn{Conn: a, in: ba, out: ab, closed: make(chan struct{})},
+		&datagramConn{Conn: b, in: ab, out: ba, closed: make(chan struct{})}
+}
+
+func (c *datagramConn) SendDatagram(b []byte) error {
+	atomic.AddInt32(&c.sent, 1)
+	c.out <- append([]byte(nil), b...)
+	return nil
+}
+
+func (c *datagramConn) ReceiveDatagram() ([]byte, error) {
+	select {
+	case b := <-c.in:
+		return b, nil
+	case <-c.closed:
+		return nil, io.EOF
+	}
+}
+
+func (c *datagramConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestPushUnreliable(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{})
+	defer srv.Close()
+	path := srv.RoutePushFunc((*telemetryPush).Position)
+	cli := tp.NewPeer(tp.PeerConfig{})
+	defer cli.Close()
+
+	cliConn, srvConn := datagramPipe()
+	if _, err := srv.ServeConn(srvConn); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := cli.ServeConn(cliConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rerr := sess.PushUnreliable(path, "1,2"); rerr != nil {
+		t.Fatal(rerr)
+	}
+	select {
+	case pos := <-telemetryCh:
+		if pos != "1,2" {
+			t.Fatalf("expect 1,2, got %s", pos)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the datagram to be handled")
+	}
+	if n := atomic.LoadInt32(&cliConn.sent); n != 1 {
+		t.Fatalf("expect 1 datagram sent, got %d", n)
+	}
+}
+
+func TestPushUnreliableFallback(t *testing.T) {
+	var path string
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.RoutePushFunc((*telemetryPush).Position)
+	})
+	defer p.Close()
+	// the mem connection has no datagrams, so it is pushed over the stream
+	if rerr := p.sess.PushUnreliable(path, "3,4"); rerr != nil {
+		t.Fatal(rerr)
+	}
+	select {
+	case pos := <-telemetryCh:
+		if pos != "3,4" {
+			t.Fatalf("expect 3,4, got %s", pos)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the push to be handled")
+	}
+}
+
+type telemetryPush struct {
+	tp.PushCtx
+}
+
+var telemetryCh = make(chan string, 10)
+
+func (t *telemetryPush) Position(arg *string) *tp.Rerror {
+	telemetryCh <- *arg
+	return nil
+}
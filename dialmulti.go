@@ -0,0 +1,129 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+)
+
+// dialTarget the address of one dialing attempt.
+type dialTarget struct {
+	addr      string
+	tlsConfig *tls.Config
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// splitDialAddrs splits the comma-separated dial addresses.
+func splitDialAddrs(addr string) []string {
+	if !strings.Contains(addr, ",") {
+		return []string{addr}
+	}
+	var addrs []string
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); len(a) > 0 {
+			addrs = append(addrs, a)
+		}
+	}
+	if len(addrs) == 0 {
+		return []string{addr}
+	}
+	return addrs
+}
+
+// isHostNetwork returns whether the addresses of the network have the resolvable hosts.
+func isHostNetwork(network string) bool {
+	switch network {
+	case "unix", "unixpacket", "mem":
+		return false
+	}
+	return true
+}
+
+// dialTargets connects with the targets, and keeps the first successful connection.
+// NOTE:
+//  Each attempt is limited by DialAttemptTimeout, or DefaultDialTimeout if not set;
+//  If DialRaceDelay is set, the next attempt starts when the previous one fails or is pending for the delay,
+//  e.g. the happy eyeballs, otherwise the targets are tried in order.
+func (p *peer) dialTargets(targets []dialTarget) (net.Conn, error) {
+	timeout := p.dialAttemptTimeout
+	if timeout <= 0 {
+		timeout = p.defaultDialTimeout
+	}
+	var err error
+	if p.dialRaceDelay <= 0 || len(targets) == 1 {
+		for _, t := range targets {
+			var conn net.Conn
+			conn, err = p.dialAddr(t.addr, t.tlsConfig, timeout)
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+	results := make(chan dialResult, len(targets))
+	var (
+		next    int
+		pending int
+		delay   <-chan time.Time
+	)
+	startNext := func() {
+		t := targets[next]
+		next++
+		pending++
+		go func() {
+			conn, err := p.dialAddr(t.addr, t.tlsConfig, timeout)
+			results <- dialResult{conn: conn, err: err}
+		}()
+		if next < len(targets) {
+			delay = time.After(p.dialRaceDelay)
+		} else {
+			delay = nil
+		}
+	}
+	startNext()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go closeLateConns(results, pending)
+				return r.conn, nil
+			}
+			err = r.err
+			if next < len(targets) {
+				startNext()
+			}
+		case <-delay:
+			startNext()
+		}
+	}
+	return nil, err
+}
+
+// closeLateConns closes the connections of the attempts that lose the race.
+func closeLateConns(results <-chan dialResult, pending int) {
+	for ; pending > 0; pending-- {
+		if r := <-results; r.err == nil {
+			r.conn.Close()
+		}
+	}
+}
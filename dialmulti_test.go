@@ -0,0 +1,54 @@
+package tp_test
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestDialAddrs(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(echo_call)
+	})
+	defer p.Close()
+	// the ports no one listens on
+	missing := ":" + strconv.Itoa(int(atomic.AddInt32(&memPort, 1)))
+	missing2 := ":" + strconv.Itoa(int(atomic.AddInt32(&memPort, 1)))
+
+	// the memory dialing of the missing listener hangs until the timeout
+	for _, c := range []struct {
+		cfg     tp.PeerConfig
+		maxCost time.Duration
+	}{
+		{tp.PeerConfig{Network: "mem", DialAttemptTimeout: 300 * time.Millisecond}, 2 * time.Second},
+		{tp.PeerConfig{Network: "mem", DialAttemptTimeout: 5 * time.Second, DialRaceDelay: 50 * time.Millisecond}, 2 * time.Second},
+	} {
+		cli := tp.NewPeer(c.cfg)
+		start := time.Now()
+		sess, rerr := cli.DialAddrs([]string{missing, p.addr})
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		cost := time.Since(start)
+		if cost > c.maxCost {
+			t.Fatalf("expect dialing within %v, cost %v", c.maxCost, cost)
+		}
+		var arg, result = 10, 0
+		if rerr = sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if result != arg {
+			t.Fatalf("expect %d, got %d", arg, result)
+		}
+		cli.Close()
+	}
+
+	cli := tp.NewPeer(tp.PeerConfig{Network: "mem", DialAttemptTimeout: 100 * time.Millisecond})
+	defer cli.Close()
+	if _, rerr := cli.Dial(missing + ", " + missing2); rerr == nil {
+		t.Fatal("expect dialing failed")
+	}
+}
@@ -0,0 +1,171 @@
+package tp_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// serveTestProxy serves a minimal SOCKS5 (with username/password) or HTTP CONNECT proxy.
+func serveTestProxy(t *testing.T, scheme string) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel := func(conn net.Conn, addr string) {
+		target, err := net.Dial("tcp", addr)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		go func() {
+			io.Copy(target, conn)
+			target.Close()
+		}()
+		io.Copy(conn, target)
+		conn.Close()
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if scheme == "http" {
+					req, err := http.ReadRequest(bufio.NewReader(conn))
+					if err != nil || req.Method != http.MethodConnect {
+						conn.Close()
+						return
+					}
+					conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+					tunnel(conn, req.Host)
+					return
+				}
+				buf := make([]byte, 512)
+				io.ReadFull(conn, buf[:4]) // 5, 2, 0, 2
+				conn.Write([]byte{5, 2})
+				io.ReadFull(conn, buf[:2])
+				user := make([]byte, buf[1])
+				io.ReadFull(conn, user)
+				io.ReadFull(conn, buf[:1])
+				pass := make([]byte, buf[0])
+				io.ReadFull(conn, pass)
+				if string(user) != "user" || string(pass) != "pass" {
+					conn.Write([]byte{1, 1})
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{1, 0})
+				io.ReadFull(conn, buf[:4]) // 5, 1, 0, 1
+				io.ReadFull(conn, buf[:6])
+				addr := net.JoinHostPort(net.IP(buf[:4]).String(), strconv.Itoa(int(buf[4])<<8|int(buf[5])))
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				tunnel(conn, addr)
+			}()
+		}
+	}()
+	return lis
+}
+
+func TestDialProxy(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{
+		LocalIP: "127.0.0.1",
+	})
+	defer srv.Close()
+	srv.RouteCallFunc(echo_call)
+	addr := listenAndServe(t, srv)
+
+	for _, c := range []struct {
+		scheme string
+		user   string
+	}{
+		{"socks5", "user:pass@"},
+		{"http", ""},
+	} {
+		lis := serveTestProxy(t, c.scheme)
+		defer lis.Close()
+		cli := tp.NewPeer(tp.PeerConfig{
+			DialProxy: c.scheme + "://" + c.user + lis.Addr().String(),
+		})
+		defer cli.Close()
+		sess, rerr := cli.Dial(addr)
+		if rerr != nil {
+			t.Fatalf("%s: %v", c.scheme, rerr)
+		}
+		var arg, result = 10, 0
+		if rerr = sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+			t.Fatalf("%s: %v", c.scheme, rerr)
+		}
+		if result != arg {
+			t.Fatalf("%s: expect %d, got %d", c.scheme, arg, result)
+		}
+		// the session comes from the proxy
+		var proxied bool
+		srv.RangeSession(func(s tp.Session) bool {
+			if s.RemoteAddr().String() == sess.LocalAddr().String() {
+				proxied = true
+			}
+			return true
+		})
+		if proxied {
+			t.Fatalf("%s: expect the session tunneled through the proxy", c.scheme)
+		}
+	}
+}
+
+func TestDialProxyFromEnvironment(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{
+		LocalIP: "127.0.0.1",
+	})
+	defer srv.Close()
+	srv.RouteCallFunc(echo_call)
+	addr := listenAndServe(t, srv)
+
+	lis := serveTestProxy(t, "http")
+	defer lis.Close()
+	for _, key := range []string{"HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy"} {
+		if v, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, v)
+		} else {
+			defer os.Unsetenv(key)
+		}
+		os.Unsetenv(key)
+	}
+	os.Setenv("HTTP_PROXY", lis.Addr().String())
+
+	cli := tp.NewPeer(tp.PeerConfig{DialProxy: tp.DialProxyFromEnvironment})
+	defer cli.Close()
+	proxied := func() bool {
+		sess, rerr := cli.Dial(addr)
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		defer sess.Close()
+		var arg = 1
+		if rerr = sess.Call("/echo/call", &arg, new(int)).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		direct := false
+		srv.RangeSession(func(s tp.Session) bool {
+			if s.RemoteAddr().String() == sess.LocalAddr().String() {
+				direct = true
+			}
+			return true
+		})
+		return !direct
+	}
+	if !proxied() {
+		t.Fatal("expect the session tunneled through the proxy of HTTP_PROXY")
+	}
+	os.Setenv("NO_PROXY", "localhost,127.0.0.0/8")
+	if proxied() {
+		t.Fatal("expect the session dialed directly by NO_PROXY")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// The address family preferences of dialing, see PeerConfig.DialFamily.
+const (
+	// DialFamilyIPv4 dials the IPv4 addresses first, and falls back to the IPv6 ones
+	DialFamilyIPv4 = "ipv4"
+	// DialFamilyIPv6 dials the IPv6 addresses first, and falls back to the IPv4 ones
+	DialFamilyIPv6 = "ipv6"
+	// DialFamilyIPv4Only dials the IPv4 addresses only
+	DialFamilyIPv4Only = "ipv4-only"
+	// DialFamilyIPv6Only dials the IPv6 addresses only
+	DialFamilyIPv6Only = "ipv6-only"
+)
+
+const (
+	dialFamilyAny int8 = iota
+	dialFamilyIPv4
+	dialFamilyIPv6
+	dialFamilyIPv4Only
+	dialFamilyIPv6Only
+)
+
+func parseDialFamily(family string) (int8, error) {
+	switch family {
+	case "":
+		return dialFamilyAny, nil
+	case DialFamilyIPv4:
+		return dialFamilyIPv4, nil
+	case DialFamilyIPv6:
+		return dialFamilyIPv6, nil
+	case DialFamilyIPv4Only:
+		return dialFamilyIPv4Only, nil
+	case DialFamilyIPv6Only:
+		return dialFamilyIPv6Only, nil
+	default:
+		return 0, fmt.Errorf("invalid dial family: %s, refer to the following: ipv4, ipv6, ipv4-only or ipv6-only", family)
+	}
+}
+
+// checkDualStack checks the address family configs,
+// and adds the IPv4 and IPv6 listen addresses if ListenDualStack is set.
+func (p *PeerConfig) checkDualStack() error {
+	var err error
+	if p.dialFamily, err = parseDialFamily(p.DialFamily); err != nil {
+		return err
+	}
+	p.dialSourceIPs = p.dialSourceIPs[:0]
+	for _, s := range p.DialSourceIPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("invalid dial source IP: %s", s)
+		}
+		p.dialSourceIPs = append(p.dialSourceIPs, ip)
+	}
+	if (p.dialFamily != dialFamilyAny || len(p.dialSourceIPs) > 0) && len(p.DialProxy) > 0 {
+		return fmt.Errorf("dial family and source IPs do not support the dial proxy: %s", p.DialProxy)
+	}
+	if !p.ListenDualStack || len(p.ListenAddrs) > 0 {
+		return nil
+	}
+	switch p.Network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("dual-stack listening does not support the network: %s, refer to the following: tcp, tcp4 or tcp6", p.Network)
+	}
+	if ip := net.ParseIP(p.LocalIP); ip == nil || !ip.IsUnspecified() {
+		return fmt.Errorf("dual-stack listening requires the unspecified local IP, got: %s", p.LocalIP)
+	}
+	port := strconv.FormatUint(uint64(p.ListenPort), 10)
+	p.listenURLs = append(p.listenURLs,
+		listenURL{network: "tcp4", addr: net.JoinHostPort("0.0.0.0", port)},
+		listenURL{network: "tcp6", addr: net.JoinHostPort("::", port)},
+	)
+	return nil
+}
+
+// addrIsIPv4 returns whether the IP of the host:port address is IPv4,
+// ok is false if the host is not an IP.
+func addrIsIPv4(addr string) (isIPv4, ok bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false, false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false, false
+	}
+	return ip.To4() != nil, true
+}
+
+// sortTargets orders the targets by the address family preference,
+// interleaving the families so that the racing falls back soon, e.g. the happy eyeballs;
+// or removes the other family if only one is allowed.
+// NOTE: The targets whose host is not an IP are kept in the preferred ones.
+func (p *peer) sortTargets(targets []dialTarget) []dialTarget {
+	if p.dialFamily == dialFamilyAny {
+		return targets
+	}
+	preferIPv4 := p.dialFamily == dialFamilyIPv4 || p.dialFamily == dialFamilyIPv4Only
+	var preferred, others []dialTarget
+	for _, t := range targets {
+		if isIPv4, ok := addrIsIPv4(t.addr); !ok || isIPv4 == preferIPv4 {
+			preferred = append(preferred, t)
+		} else {
+			others = append(others, t)
+		}
+	}
+	if p.dialFamily == dialFamilyIPv4Only || p.dialFamily == dialFamilyIPv6Only {
+		return preferred
+	}
+	sorted := make([]dialTarget, 0, len(targets))
+	for i := 0; i < len(preferred) || i < len(others); i++ {
+		if i < len(preferred) {
+			sorted = append(sorted, preferred[i])
+		}
+		if i < len(others) {
+			sorted = append(sorted, others[i])
+		}
+	}
+	return sorted
+}
+
+// dialLocalAddr returns the source address of dialing the address,
+// which is the one of DialSourceIPs of the same address family, or LocalIP if not set.
+// NOTE: If no source IP is of the same family, the system chooses.
+func (p *peer) dialLocalAddr(addr string) net.Addr {
+	if len(p.dialSourceIPs) == 0 || !isHostNetwork(p.network) {
+		return p.localAddr
+	}
+	isIPv4, ok := addrIsIPv4(addr)
+	if !ok {
+		return p.localAddr
+	}
+	for _, ip := range p.dialSourceIPs {
+		if (ip.To4() != nil) == isIPv4 {
+			return &net.TCPAddr{IP: ip}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package tp_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type dualStackResolver struct{}
+
+func (dualStackResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	return []string{"127.0.0.1", "::1"}, nil
+}
+
+func TestDualStack(t *testing.T) {
+	if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skip("IPv6 is not supported:", err)
+	} else {
+		l.Close()
+	}
+	// the port free on both of the address families
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	port := strconv.Itoa(listenPort)
+	srv := tp.NewPeer(tp.PeerConfig{ListenPort: uint16(listenPort), ListenDualStack: true})
+	defer srv.Close()
+	srv.RouteCallFunc(echo_call)
+	listeners := make(listened, 2)
+	srv.PluginContainer().AppendRight(listeners)
+	go srv.ListenAndServe()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-listeners:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the IPv4 and IPv6 listeners")
+		}
+	}
+
+	var clis []tp.Peer
+	defer func() {
+		for _, cli := range clis {
+			cli.Close()
+		}
+	}()
+	dial := func(cfg tp.PeerConfig, addr string) (tp.Session, *tp.Rerror) {
+		cli := tp.NewPeer(cfg)
+		clis = append(clis, cli)
+		cli.SetResolver(dualStackResolver{})
+		return cli.Dial(addr)
+	}
+	for _, addr := range []string{"127.0.0.1:" + port, "[::1]:" + port} {
+		sess, rerr := dial(tp.PeerConfig{}, addr)
+		if rerr != nil {
+			t.Fatalf("dial %s: %v", addr, rerr)
+		}
+		var arg = 1
+		if rerr = sess.Call("/echo/call", &arg, new(int)).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		sess.Close()
+	}
+
+	// the address family preference
+	for family, expect := range map[string]string{
+		tp.DialFamilyIPv4:     "127.0.0.1",
+		tp.DialFamilyIPv6:     "::1",
+		tp.DialFamilyIPv6Only: "::1",
+	} {
+		sess, rerr := dial(tp.PeerConfig{DialFamily: family}, "dual.test:"+port)
+		if rerr != nil {
+			t.Fatalf("dial by %s: %v", family, rerr)
+		}
+		if ip := sess.RemoteAddr().(*net.TCPAddr).IP.String(); ip != expect {
+			t.Fatalf("dial by %s: expect %s, got %s", family, expect, ip)
+		}
+		sess.Close()
+	}
+	if _, rerr := dial(tp.PeerConfig{DialFamily: tp.DialFamilyIPv4Only}, "[::1]:"+port); rerr == nil {
+		t.Fatal("expect no IPv4 address to dial")
+	}
+
+	// the source IP of the same address family
+	for _, addr := range []string{"127.0.0.1:" + port, "[::1]:" + port} {
+		sess, rerr := dial(tp.PeerConfig{DialSourceIPs: []string{"127.0.0.1", "::1"}}, addr)
+		if rerr != nil {
+			t.Fatalf("dial %s from the source IPs: %v", addr, rerr)
+		}
+		local, remote := sess.LocalAddr().(*net.TCPAddr).IP, sess.RemoteAddr().(*net.TCPAddr).IP
+		if !local.Equal(remote) {
+			t.Fatalf("expect the source IP %s, got %s", remote, local)
+		}
+		sess.Close()
+	}
+}
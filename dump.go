@@ -0,0 +1,194 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// StatsPlugin reports the statistics of the plugin in the state dump, see Peer.DumpState.
+	StatsPlugin interface {
+		Plugin
+		Stats() interface{}
+	}
+	// StateDump the snapshot of the peer state for the post-incident forensics.
+	StateDump struct {
+		Time              time.Time      `json:"time"`
+		PID               int            `json:"pid"`
+		Goroutines        int            `json:"goroutines"`
+		Config            PeerConfig     `json:"config"`
+		Listeners         []string       `json:"listeners"`
+		StageStats        StageStats     `json:"stage_stats"`
+		QuarantinedRoutes []string       `json:"quarantined_routes"`
+		Plugins           []PluginState  `json:"plugins"`
+		Sessions          []SessionState `json:"sessions"`
+	}
+	// PluginState the state of a plugin in the state dump.
+	PluginState struct {
+		Name  string      `json:"name"`
+		Stats interface{} `json:"stats,omitempty"`
+	}
+	// SessionState the state of a session in the state dump.
+	SessionState struct {
+		ID            string         `json:"id"`
+		LocalAddr     string         `json:"local_addr"`
+		RemoteAddr    string         `json:"remote_addr"`
+		Status        string         `json:"status"`
+		Draining      bool           `json:"draining"`
+		UnpackedBytes int64          `json:"unpacked_bytes"`
+		InflightCalls []InflightCall `json:"inflight_calls"`
+	}
+	// InflightCall a CALL waiting for the reply in the state dump.
+	InflightCall struct {
+		Seq           int32         `json:"seq"`
+		ServiceMethod string        `json:"service_method"`
+		Elapsed       time.Duration `json:"elapsed,omitempty"` // only counted if PeerConfig.CountTime
+	}
+)
+
+var statusTexts = map[int32]string{
+	statusOk:            "ok",
+	statusActiveClosing: "closing",
+	statusActiveClosed:  "closed",
+	statusPassiveClosed: "disconnected",
+}
+
+// stateDump returns the snapshot of the peer state.
+// NOTE: It does not stop the peer, so the snapshot is not atomic under load.
+func (p *peer) stateDump() *StateDump {
+	d := &StateDump{
+		Time:              time.Now(),
+		PID:               os.Getpid(),
+		Goroutines:        runtime.NumGoroutine(),
+		Config:            p.cfg,
+		StageStats:        p.StageStats(),
+		QuarantinedRoutes: p.QuarantinedRoutes(),
+	}
+	if p.dialProxy != nil && p.dialProxy.User != nil {
+		// never dump the password
+		u := *p.dialProxy
+		u.User = url.User(u.User.Username())
+		d.Config.DialProxy = u.String()
+	}
+	p.mu.Lock()
+	for lis := range p.listeners {
+		d.Listeners = append(d.Listeners, lis.Addr().String())
+	}
+	p.mu.Unlock()
+	sort.Strings(d.Listeners)
+	for _, plugin := range p.pluginContainer.GetAll() {
+		state := PluginState{Name: plugin.Name()}
+		if sp, ok := plugin.(StatsPlugin); ok {
+			state.Stats = sp.Stats()
+		}
+		d.Plugins = append(d.Plugins, state)
+	}
+	p.sessHub.Range(func(sess *session) bool {
+		d.Sessions = append(d.Sessions, sess.state(d.Time))
+		return true
+	})
+	sort.Slice(d.Sessions, func(i, j int) bool {
+		return d.Sessions[i].ID < d.Sessions[j].ID
+	})
+	return d
+}
+
+// DumpState writes the snapshot of the peer state in JSON to w.
+// NOTE: It does not stop the peer, so it is safe to run under load.
+func (p *peer) DumpState(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p.stateDump())
+}
+
+func (s *session) state(now time.Time) SessionState {
+	state := SessionState{
+		ID:            s.ID(),
+		LocalAddr:     s.LocalAddr().String(),
+		RemoteAddr:    s.RemoteAddr().String(),
+		Status:        statusTexts[s.getStatus()],
+		Draining:      s.isDraining(),
+		UnpackedBytes: atomic.LoadInt64(&s.unpackedBytes),
+	}
+	s.callCmdMap.Range(func(_, v interface{}) bool {
+		cmd := v.(*callCmd)
+		cmd.mu.Lock()
+		if !cmd.hasReply() {
+			call := InflightCall{
+				Seq:           cmd.output.Seq(),
+				ServiceMethod: cmd.output.ServiceMethod(),
+			}
+			if !cmd.start.IsZero() {
+				call.Elapsed = now.Sub(cmd.start)
+			}
+			state.InflightCalls = append(state.InflightCalls, call)
+		}
+		cmd.mu.Unlock()
+		return true
+	})
+	sort.Slice(state.InflightCalls, func(i, j int) bool {
+		return state.InflightCalls[i].Seq < state.InflightCalls[j].Seq
+	})
+	return state
+}
+
+// fatalDumpTimeout the maximum duration of dumping the states before exiting on the fatal error.
+const fatalDumpTimeout = 3 * time.Second
+
+// dumpStateOnFatal dumps the states of the peers with PeerConfig.StateDumpDir, before exiting on the fatal error.
+// NOTE: It gives up after fatalDumpTimeout, in case the fatal error happens while holding a lock.
+func dumpStateOnFatal() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() { recover() }()
+		peers.rwmu.RLock()
+		var list []*peer
+		for p := range peers.list {
+			if len(p.stateDumpDir) > 0 {
+				list = append(list, p)
+			}
+		}
+		peers.rwmu.RUnlock()
+		for i, p := range list {
+			name := filepath.Join(p.stateDumpDir, fmt.Sprintf("teleport-state-%d-%s-%d.json",
+				os.Getpid(), time.Now().Format("20060102T150405.000"), i))
+			f, err := os.Create(name)
+			if err != nil {
+				loggerOutput(ERROR, "dump state on fatal error: %s", err.Error())
+				continue
+			}
+			if err = p.DumpState(f); err != nil {
+				loggerOutput(ERROR, "dump state on fatal error: %s", err.Error())
+			}
+			f.Close()
+			loggerOutput(CRITICAL, "dumped state on fatal error: %s", name)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(fatalDumpTimeout):
+	}
+}
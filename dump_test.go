@@ -0,0 +1,54 @@
+package tp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestDumpState(t *testing.T) {
+	entered, release := make(chan int, 1), make(chan struct{})
+	var path string
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{
+		CountTime: true,
+	}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(blockingCall(entered, release))
+	})
+	defer p.Close()
+	var arg, result = 1, 0
+	callCmd := p.sess.AsyncCall(path, &arg, &result, make(chan tp.CallCmd, 1))
+	<-entered
+
+	var buf bytes.Buffer
+	if err := p.cli.DumpState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var dump tp.StateDump
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.Sessions) != 1 {
+		t.Fatalf("expect 1 session, got %d", len(dump.Sessions))
+	}
+	calls := dump.Sessions[0].InflightCalls
+	if len(calls) != 1 || calls[0].ServiceMethod != path || calls[0].Elapsed <= 0 {
+		t.Fatalf("expect the in-flight call, got %+v", calls)
+	}
+	close(release)
+	<-callCmd.Done()
+
+	buf.Reset()
+	if err := p.srv.DumpState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dump = tp.StateDump{}
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+	if ":"+strconv.Itoa(int(dump.Config.ListenPort)) != p.addr || len(dump.Listeners) != 1 || len(dump.Sessions) != 1 {
+		t.Fatalf("unexpected server state: %s", buf.String())
+	}
+}
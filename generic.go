@@ -0,0 +1,114 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package tp
+
+import (
+	"reflect"
+)
+
+// RouteCallG registers the CALL handler of the service method, whose argument and reply types are
+// checked at compile time, and whose argument is made without the reflection; returns the service method.
+// The router may be a Peer, *Router or *SubRouter, whose plugins are inherited.
+// NOTE: The service method is registered as it is, the prefix of the router is not added.
+func RouteCallG[Arg, Reply any](
+	router interface {
+		SubRoute(string, ...Plugin) *SubRouter
+	},
+	serviceMethod string,
+	fn func(ctx CallCtx, arg *Arg) (Reply, *Rerror),
+	plugin ...Plugin,
+) string {
+	maker := func(_ string, _ interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
+		return []*Handler{{
+			name:    serviceMethod,
+			argElem: reflect.TypeOf((*Arg)(nil)).Elem(),
+			reply:   reflect.TypeOf((*Reply)(nil)).Elem(),
+			newArg: func() interface{} {
+				return new(Arg)
+			},
+			handleArg: func(ctx *handlerCtx, arg interface{}) {
+				reply, rerr := fn(ctx, arg.(*Arg))
+				if rerr != nil {
+					ctx.handleErr = rerr
+					rerr.SetToMeta(ctx.output.Meta())
+				} else {
+					ctx.output.SetBody(reply)
+				}
+			},
+			pluginContainer: pluginContainer,
+		}}, nil
+	}
+	return router.SubRoute("").reg(pnCall, maker, nil, plugin)[0]
+}
+
+// RoutePushG registers the PUSH handler of the service method, whose argument type is
+// checked at compile time, and whose argument is made without the reflection; returns the service method.
+// The router may be a Peer, *Router or *SubRouter, whose plugins are inherited.
+// NOTE: The service method is registered as it is, the prefix of the router is not added.
+func RoutePushG[Arg any](
+	router interface {
+		SubRoute(string, ...Plugin) *SubRouter
+	},
+	serviceMethod string,
+	fn func(ctx PushCtx, arg *Arg) *Rerror,
+	plugin ...Plugin,
+) string {
+	maker := func(_ string, _ interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
+		return []*Handler{{
+			name:    serviceMethod,
+			argElem: reflect.TypeOf((*Arg)(nil)).Elem(),
+			newArg: func() interface{} {
+				return new(Arg)
+			},
+			handleArg: func(ctx *handlerCtx, arg interface{}) {
+				ctx.handleErr = fn(ctx, arg.(*Arg))
+			},
+			pluginContainer: pluginContainer,
+		}}, nil
+	}
+	return router.SubRoute("").reg(pnPush, maker, nil, plugin)[0]
+}
+
+// CallG sends the CALL by the caller and returns the reply, whose type is checked at compile time.
+// The caller may be a Session, *Pool or Channel.
+func CallG[Reply, Arg any](
+	caller interface {
+		Call(serviceMethod string, arg interface{}, result interface{}, setting ...MessageSetting) CallCmd
+	},
+	serviceMethod string,
+	arg *Arg,
+	setting ...MessageSetting,
+) (*Reply, *Rerror) {
+	reply := new(Reply)
+	if rerr := caller.Call(serviceMethod, arg, reply, setting...).Rerror(); rerr != nil {
+		return nil, rerr
+	}
+	return reply, nil
+}
+
+// ReplyProcessorG returns the reply processor of the typed reply body, which is checked at compile time.
+// NOTE: The reply bodies of the other types are passed through as they are.
+func ReplyProcessorG[Reply any](fn func(ctx CallCtx, reply Reply) (interface{}, *Rerror)) ReplyProcessor {
+	return ReplyProcessor(func(ctx CallCtx, reply interface{}) (interface{}, *Rerror) {
+		r, ok := reply.(Reply)
+		if !ok {
+			return reply, nil
+		}
+		return fn(ctx, r)
+	})
+}
@@ -0,0 +1,146 @@
+//go:build go1.18
+// +build go1.18
+
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type pushGArg struct {
+	A int
+	B string
+}
+
+func TestRoutePushG(t *testing.T) {
+	got := make(chan *pushGArg, 1)
+	var name string
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		name = tp.RoutePushG(srv, "Push.Test", func(ctx tp.PushCtx, arg *pushGArg) *tp.Rerror {
+			got <- arg
+			return nil
+		})
+	})
+	defer p.Close()
+	if name != "Push.Test" {
+		t.Fatalf("expect the service method Push.Test, got %s", name)
+	}
+	if rerr := p.sess.Push("Push.Test", &pushGArg{A: 1, B: "b"}); rerr != nil {
+		t.Fatal(rerr)
+	}
+	select {
+	case arg := <-got:
+		if arg.A != 1 || arg.B != "b" {
+			t.Fatalf("unexpected arg: %+v", arg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expect the push handled")
+	}
+}
+
+type ReplyGUser struct {
+	Name  string
+	Email string
+}
+
+func TestReplyProcessorG(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{Network: "mem", ListenPort: 9139})
+	defer srv.Close()
+	path := srv.RouteCallFunc(func(ctx tp.CallCtx, arg *string) (*ReplyGUser, *tp.Rerror) {
+		return &ReplyGUser{Name: *arg, Email: *arg + "@example.com"}, nil
+	},
+		tp.WithReplyProcessor(tp.ReplyProcessorG(func(ctx tp.CallCtx, reply *ReplyGUser) (interface{}, *tp.Rerror) {
+			switch string(ctx.PeekMeta("role")) {
+			case "admin":
+			case "guest":
+				return nil, tp.NewRerror(tp.CodeUnauthorized, tp.CodeText(tp.CodeUnauthorized), "")
+			default:
+				reply.Email = ""
+			}
+			return reply, nil
+		}), func(ctx tp.CallCtx, reply interface{}) (interface{}, *tp.Rerror) {
+			return map[string]interface{}{"data": reply}, nil
+		}),
+	)
+	go srv.ListenAndServe()
+
+	cli := tp.NewPeer(tp.PeerConfig{Network: "mem"})
+	defer cli.Close()
+	sess, rerr := cli.Dial(":9139")
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	var result struct {
+		Data ReplyGUser `json:"data"`
+	}
+	var arg = "henry"
+	if rerr = sess.Call(path, &arg, &result).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if result.Data.Name != "henry" || result.Data.Email != "" {
+		t.Fatalf("expect the email filtered in the envelope, got %+v", result)
+	}
+	if rerr = sess.Call(path, &arg, &result, tp.WithAddMeta("role", "admin")).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if result.Data.Email != "henry@example.com" {
+		t.Fatalf("expect the email kept for the admin, got %+v", result)
+	}
+	rerr = sess.Call(path, &arg, &result, tp.WithAddMeta("role", "guest")).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeUnauthorized {
+		t.Fatalf("expect CodeUnauthorized, got %v", rerr)
+	}
+}
+
+type callGArg struct {
+	A int
+	B int
+}
+
+type callGReply struct {
+	Sum int
+}
+
+func TestRouteCallG(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{Network: "mem", ListenPort: 9152})
+	defer srv.Close()
+	name := tp.RouteCallG(srv, "Home.Sum", func(ctx tp.CallCtx, arg *callGArg) (*callGReply, *tp.Rerror) {
+		if arg.A < 0 {
+			return nil, tp.NewRerror(tp.CodeBadMessage, tp.CodeText(tp.CodeBadMessage), "negative")
+		}
+		return &callGReply{Sum: arg.A + arg.B}, nil
+	}, tp.WithRequiredMeta("X-Tenant"))
+	if name != "Home.Sum" {
+		t.Fatalf("expect the service method Home.Sum, got %s", name)
+	}
+	if h, ok := srv.Router().Match(name); !ok || h.ArgElemType().Name() != "callGArg" || h.ReplyType().String() != "*tp_test.callGReply" {
+		t.Fatalf("unexpected handler: %v", h)
+	}
+	go srv.ListenAndServe()
+
+	cli := tp.NewPeer(tp.PeerConfig{Network: "mem"})
+	defer cli.Close()
+	sess, rerr := cli.Dial(":9152")
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	result, rerr := tp.CallG[callGReply](sess, name, &callGArg{A: 1, B: 2}, tp.WithSetMeta("X-Tenant", "t1"))
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if result.Sum != 3 {
+		t.Fatalf("expect 3, got %d", result.Sum)
+	}
+	result, rerr = tp.CallG[callGReply](sess, name, &callGArg{A: -1}, tp.WithSetMeta("X-Tenant", "t1"))
+	if rerr == nil || rerr.Reason != "negative" || result != nil {
+		t.Fatalf("expect the error of the handler, got %v, %v", result, rerr)
+	}
+	// the plugins are inherited
+	_, rerr = tp.CallG[callGReply](sess, name, &callGArg{A: 1, B: 2})
+	if rerr == nil || rerr.Code != tp.CodeBadMessage {
+		t.Fatalf("expect CodeBadMessage, got %v", rerr)
+	}
+}
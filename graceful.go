@@ -12,9 +12,12 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// +build !js
+
 package tp
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"net"
@@ -26,9 +29,10 @@ import (
 	"github.com/henrylee2cn/goutil/errors"
 	"github.com/henrylee2cn/goutil/graceful"
 	"github.com/henrylee2cn/goutil/graceful/inherit_net"
-	"github.com/mylonly/teleport/quic"
 )
 
+var _ graceful.LoggerWithFlusher = logger
+
 var peers = struct {
 	list map[*peer]struct{}
 	rwmu sync.RWMutex
@@ -108,7 +112,8 @@ func SetShutdown(timeout time.Duration, firstSweep, beforeExiting func() error)
 	}
 	FirstSweep = func() error {
 		setParentLaddrList()
-		return errors.Merge(firstSweep(), inherit_net.SetInherited(), quic.SetInherited())
+		setSystemdAddrs()
+		return errors.Merge(firstSweep(), inherit_net.SetInherited(), setQuicInherited())
 	}
 	BeforeExiting = func() error {
 		return errors.Merge(shutdown(), beforeExiting())
@@ -131,37 +136,89 @@ func Reboot(timeout ...time.Duration) {
 var testTLSConfig = GenerateTLSConfigForServer()
 
 // NewInheritedListener creates a new inherited listener.
-func NewInheritedListener(network, laddr string, tlsConfig *tls.Config) (lis net.Listener, err error) {
-	host, port, err := net.SplitHostPort(laddr)
-	if err != nil {
-		return nil, err
+// NOTE:
+//  The laddr of unix or unixpacket network is the socket path;
+//  The laddr of systemd network is the FileDescriptorName of the socket unit activated by systemd,
+//  or the index of the passed sockets, e.g. "0";
+//  The socket options are not used by quic network.
+func NewInheritedListener(network, laddr string, tlsConfig *tls.Config, sockOpts ...SocketOptions) (lis net.Listener, err error) {
+	initSystemdSockets()
+	if network == "systemd" {
+		network, laddr, err = lookupSystemdSocket(laddr)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if port == "0" {
-		laddr = popParentLaddr(network, host, laddr)
+	var host string
+	isUnix := network == "unix" || network == "unixpacket"
+	if !isUnix {
+		var port string
+		host, port, err = net.SplitHostPort(laddr)
+		if err != nil {
+			return nil, err
+		}
+		if port == "0" {
+			laddr = popParentLaddr(network, host, laddr)
+		}
 	}
 
 	if network == "quic" {
 		if tlsConfig == nil {
 			tlsConfig = testTLSConfig
 		}
-		lis, err = quic.InheritedListen(laddr, tlsConfig, nil)
+		lis, err = listenQuic(laddr, tlsConfig)
 
 	} else {
-		lis, err = inherit_net.Listen(network, laddr)
+		var opts SocketOptions
+		if len(sockOpts) > 0 {
+			opts = sockOpts[0]
+		}
+		lis, err = opts.listen(network, laddr)
 		if err == nil && tlsConfig != nil {
-			if len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil {
-				return nil, errors.New("tls: neither Certificates nor GetCertificate set in Config")
-			}
-			lis = tls.NewListener(lis, tlsConfig)
+			lis, err = newTLSListener(lis, tlsConfig)
 		}
 	}
 
-	if err == nil {
+	if err == nil && !isUnix {
 		pushParentLaddr(network, host, lis.Addr().String())
 	}
 	return
 }
 
+// inheritedFDsKey the environment variable of the listeners inherited from the parent process.
+const inheritedFDsKey = "LISTEN_FDS"
+
+// listen creates the inherited listener with the options.
+// NOTE:
+//  The inherited listening socket keeps the options set by the parent process;
+//  Several listeners may share the port with ReusePort, and only the first one is inherited by the reboot.
+func (o *SocketOptions) listen(network, laddr string) (net.Listener, error) {
+	var (
+		lis net.Listener
+		err error
+	)
+	if (o.ReusePort || o.Control != nil) && len(os.Getenv(inheritedFDsKey)) == 0 {
+		lc := &net.ListenConfig{Control: o.listenControl}
+		lis, err = lc.Listen(context.Background(), network, laddr)
+		if err != nil {
+			return nil, err
+		}
+		if err = inherit_net.Append(lis); err != nil && !o.ReusePort {
+			lis.Close()
+			return nil, err
+		}
+	} else {
+		lis, err = inherit_net.Listen(network, laddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.needTune() {
+		lis = &tunedListener{Listener: lis, opts: o}
+	}
+	return lis, nil
+}
+
 const parentLaddrsKey = "LISTEN_PARENT_ADDRS"
 
 var parentAddrList = make(map[string]map[string][]string, 2) // network:host:[host:port]
@@ -0,0 +1,114 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	gerrors "github.com/henrylee2cn/goutil/errors"
+)
+
+// NOTE: On js/wasm, the signals and the inherited listeners are unavailable,
+// so only the client role is supported, and the process is not rebooted gracefully.
+
+var peers = struct {
+	list map[*peer]struct{}
+	rwmu sync.RWMutex
+}{
+	list: make(map[*peer]struct{}),
+}
+
+func addPeer(p *peer) {
+	peers.rwmu.Lock()
+	peers.list[p] = struct{}{}
+	peers.rwmu.Unlock()
+}
+
+func deletePeer(p *peer) {
+	peers.rwmu.Lock()
+	delete(peers.list, p)
+	peers.rwmu.Unlock()
+}
+
+func shutdown() error {
+	peers.rwmu.RLock()
+	var list []*peer
+	for p := range peers.list {
+		list = append(list, p)
+	}
+	peers.rwmu.RUnlock()
+	var err error
+	for _, p := range list {
+		err = gerrors.Merge(err, p.Close())
+	}
+	return err
+}
+
+func init() {
+	SetShutdown(5*time.Second, nil, nil)
+}
+
+// GraceSignal does nothing on js/wasm.
+func GraceSignal() {}
+
+var (
+	// FirstSweep is first executed.
+	FirstSweep func() error
+	// BeforeExiting is executed before process exiting.
+	BeforeExiting func() error
+)
+
+// SetShutdown sets the function which is called after the process shutdown.
+// NOTE: The timeout is ignored on js/wasm.
+func SetShutdown(timeout time.Duration, firstSweep, beforeExiting func() error) {
+	if firstSweep == nil {
+		firstSweep = func() error { return nil }
+	}
+	if beforeExiting == nil {
+		beforeExiting = func() error { return nil }
+	}
+	FirstSweep = firstSweep
+	BeforeExiting = func() error {
+		return gerrors.Merge(shutdown(), beforeExiting())
+	}
+}
+
+// Shutdown closes all the frame process.
+// NOTE: The timeout is ignored on js/wasm.
+func Shutdown(timeout ...time.Duration) {
+	if err := gerrors.Merge(FirstSweep(), BeforeExiting()); err != nil {
+		Errorf("shutdown: %v", err)
+		FlushLogger()
+		os.Exit(1)
+	}
+	FlushLogger()
+	os.Exit(0)
+}
+
+// Reboot is not supported on js/wasm, shuts down instead.
+func Reboot(timeout ...time.Duration) {
+	Warnf("reboot is not supported on js/wasm, shut down instead")
+	Shutdown(timeout...)
+}
+
+// NewInheritedListener is not supported on js/wasm.
+func NewInheritedListener(network, laddr string, tlsConfig *tls.Config, sockOpts ...SocketOptions) (net.Listener, error) {
+	return nil, errors.New("listening is not supported on js/wasm")
+}
@@ -0,0 +1,61 @@
+package tp_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type shutdownPlugin chan struct{}
+
+func (shutdownPlugin) Name() string { return "shutdown" }
+
+func (s shutdownPlugin) PreShutdown(tp.Peer) error {
+	close(s)
+	return nil
+}
+
+func TestHandleSignals(t *testing.T) {
+	hook := make(shutdownPlugin)
+	entered, release := make(chan int, 1), make(chan struct{})
+	var path string
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{DefaultDialTimeout: time.Second}, func(srv, _ tp.Peer) {
+		srv.PluginContainer().AppendRight(hook)
+		path = srv.RouteCallFunc(blockingCall(entered, release))
+	})
+	defer p.Close()
+	done := p.srv.HandleSignals(5*time.Second, syscall.SIGHUP)
+
+	var result int
+	callCmd := p.sess.AsyncCall(path, 300, &result, make(chan tp.CallCmd, 1))
+	<-entered
+
+	proc, _ := os.FindProcess(os.Getpid())
+	if e := proc.Signal(syscall.SIGHUP); e != nil {
+		close(release)
+		t.Skipf("signal is not supported: %v", e)
+	}
+	select {
+	case <-hook:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for PreShutdown")
+	}
+	// the in-flight call is finished before the shutdown
+	close(release)
+	<-callCmd.Done()
+	if rerr := callCmd.Rerror(); rerr != nil || result != 300 {
+		t.Fatalf("in-flight call: expect 300, got %d, %v", result, rerr)
+	}
+	if shutdownErr := <-done; shutdownErr != nil {
+		t.Fatalf("shutdown: %v", shutdownErr)
+	}
+	if p.srv.CountSession() != 0 {
+		t.Fatalf("expect all the sessions to be closed")
+	}
+	if _, rerr := p.cli.Dial(p.addr); rerr == nil {
+		t.Fatalf("expect the listener to be closed")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// handshakeGuard closes the accepted connection if no valid message is read within the handshake timeout,
+// e.g. the slowloris clients which connect but never speak.
+type handshakeGuard struct {
+	timer   *time.Timer
+	expired int32
+}
+
+// guardHandshake starts the handshake timer of the accepted connection, nil if no limit.
+func (p *peer) guardHandshake(conn net.Conn) *handshakeGuard {
+	if p.handshakeTimeout <= 0 {
+		return nil
+	}
+	g := new(handshakeGuard)
+	g.timer = time.AfterFunc(p.handshakeTimeout, func() {
+		atomic.StoreInt32(&g.expired, 1)
+		atomic.AddUint64(&p.handshakeExpired, 1)
+		Warnf("handshake timeout, close the connection: %s", conn.RemoteAddr().String())
+		conn.Close()
+	})
+	return g
+}
+
+// done stops the handshake timer, it is safe to call on nil.
+func (g *handshakeGuard) done() {
+	if g != nil {
+		g.timer.Stop()
+	}
+}
+
+func (g *handshakeGuard) isExpired() bool {
+	return g != nil && atomic.LoadInt32(&g.expired) == 1
+}
@@ -0,0 +1,54 @@
+package tp_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestHandshakeTimeout(t *testing.T) {
+	closed := make(closeReasonPlugin, 2)
+	srv := tp.NewPeer(tp.PeerConfig{
+		LocalIP:          "127.0.0.1",
+		HandshakeTimeout: 200 * time.Millisecond,
+	}, closed)
+	defer srv.Close()
+	srv.RouteCallFunc(echo_call)
+	addr := listenAndServe(t, srv)
+
+	cli := tp.NewPeer(tp.PeerConfig{})
+	defer cli.Close()
+	sess, rerr := cli.Dial(addr)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	var arg, result = 10, 0
+	if rerr = sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+
+	// connects but never speaks
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect the silent connection closed, got %v", err)
+	}
+	if reason := closed.wait(t); reason.Kind != tp.CloseHandshakeTimeout {
+		t.Fatalf("expect %q, got %q", tp.CloseHandshakeTimeout, reason.String())
+	}
+	if n := srv.StageStats().HandshakeTimeouts; n != 1 {
+		t.Fatalf("expect 1 handshake timeout, got %d", n)
+	}
+
+	// the session which has spoken is not limited
+	if rerr = sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHeartbeatTimeout the reason of closing the connection,
+// on which nothing is read within PeerConfig.HeartbeatTimeout.
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+// heartbeat the keepalive of a connection of the session, which sends PING
+// when nothing is read within the interval, and closes the connection after the timeout.
+// NOTE:
+//  It never sets the read deadline, so the session age is not affected;
+//  Any message read keeps the connection alive, not only PONG;
+//  It is armed after the remote peer is negotiated to accept the control messages, see FeatureControl,
+//  so the peers which can not answer PING are never closed by it.
+type heartbeat struct {
+	sess     *session
+	conn     net.Conn
+	interval time.Duration
+	timeout  time.Duration
+	dead     int32
+	stopCh   chan struct{}
+}
+
+// startHeartbeat starts the keepalive of the connection, returns nil if it is disabled.
+func (s *session) startHeartbeat(conn net.Conn) *heartbeat {
+	s.touchRead()
+	if s.peer.heartbeatInterval <= 0 {
+		return nil
+	}
+	h := &heartbeat{
+		sess:     s,
+		conn:     conn,
+		interval: s.peer.heartbeatInterval,
+		timeout:  s.peer.heartbeatTimeout,
+		stopCh:   make(chan struct{}),
+	}
+	AnywayGo(h.run)
+	return h
+}
+
+func (h *heartbeat) run() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+		}
+		if !h.sess.acceptsControl() {
+			continue
+		}
+		idle := h.sess.readIdle()
+		if idle >= h.timeout {
+			atomic.StoreInt32(&h.dead, 1)
+			Warnf("heartbeat timeout, close the connection: %s, idle: %v", h.sess.RemoteAddr().String(), idle)
+			h.conn.Close()
+			return
+		}
+		// the busy connections need no PING
+		if idle >= h.interval/2 {
+			h.sess.Control(TypePing)
+		}
+	}
+}
+
+// stop stops the keepalive, and returns whether the connection is closed by it.
+func (h *heartbeat) stop() (dead bool) {
+	if h == nil {
+		return false
+	}
+	close(h.stopCh)
+	return atomic.LoadInt32(&h.dead) == 1
+}
+
+// touchRead records the time of the last read.
+func (s *session) touchRead() {
+	atomic.StoreInt64(&s.lastRead, time.Now().UnixNano())
+}
+
+// readIdle returns the duration since the last read.
+func (s *session) readIdle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastRead)))
+}
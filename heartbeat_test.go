@@ -0,0 +1,102 @@
+package tp_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/socket"
+)
+
+func TestHeartbeat(t *testing.T) {
+	var (
+		path           string
+		srvCtl, cliCtl = make(controlRecorder, 16), make(controlRecorder, 16)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{HeartbeatInterval: 20 * time.Millisecond}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.PluginContainer().AppendRight(srvCtl)
+		cli.PluginContainer().AppendRight(cliCtl)
+		path = srv.RouteCallFunc(echo_call)
+	})
+	defer peers.Close()
+
+	var arg = 1
+	if rerr := peers.sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	// answers PONG, even if the heartbeat of the client is disabled,
+	// so the session outlives the timeout
+	for i := 0; i < 5; i++ {
+		cliCtl.wait(t, tp.TypePing)
+		srvCtl.wait(t, tp.TypePong)
+	}
+	if n := peers.srv.CountSession(); n != 1 {
+		t.Fatalf("expect 1 session alive, got %d", n)
+	}
+	if rerr := peers.sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+}
+
+func TestHeartbeatTimeout(t *testing.T) {
+	closed := make(closeReasonPlugin, 1)
+	srv := tp.NewPeer(tp.PeerConfig{HeartbeatInterval: 20 * time.Millisecond}, closed)
+	defer srv.Close()
+	srvConn, cliConn := net.Pipe()
+	defer cliConn.Close()
+	if _, err := srv.ServeConn(srvConn); err != nil {
+		t.Fatal(err)
+	}
+
+	// the remote peer accepts the control messages, but never answers PING
+	m := socket.GetMessage(
+		socket.WithServiceMethod("/dead"),
+		socket.WithSetMeta(tp.MetaFeatures, tp.FeatureControl),
+		socket.WithBody([]byte{}),
+	)
+	m.SetMtype(tp.TypePush)
+	m.SetSeq(1)
+	if err := socket.NewSocket(cliConn).WriteMessage(m); err != nil {
+		t.Fatal(err)
+	}
+	socket.PutMessage(m)
+	go io.Copy(ioutil.Discard, cliConn)
+
+	if reason := closed.wait(t); reason.Kind != tp.CloseHeartbeatTimeout || reason.Err != tp.ErrHeartbeatTimeout {
+		t.Fatalf("expect %q, got %q", tp.CloseHeartbeatTimeout, reason.String())
+	}
+}
+
+func TestHeartbeatDowngrade(t *testing.T) {
+	var (
+		path   string
+		closed = make(closeReasonPlugin, 1)
+		cliCtl = make(controlRecorder, 16)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{HeartbeatInterval: 20 * time.Millisecond}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.PluginContainer().AppendRight(closed)
+		cli.PluginContainer().AppendRight(cliCtl)
+		path = srv.RouteCallFunc(echo_call)
+	}, oldProtoFunc)
+	defer peers.Close()
+
+	var arg = 1
+	if rerr := peers.sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	// the peer which can not answer PING is neither pinged nor closed,
+	// wait for several timeouts
+	select {
+	case c := <-cliCtl:
+		t.Fatalf("expect no control message, got %s", tp.TypeText(c.mtype))
+	case reason := <-closed:
+		t.Fatalf("expect the session alive, closed by %q", reason.String())
+	case <-time.After(200 * time.Millisecond):
+	}
+	if rerr := peers.sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+}
@@ -117,6 +117,49 @@ const (
 	MetaRealIP = "X-Real-IP"
 	// MetaAcceptBodyCodec the key of body codec that the sender wishes to accept
 	MetaAcceptBodyCodec = "X-Accept-Body-Codec"
+	// MetaAcceptXfer the key of transfer filters that the sender wishes to accept, in order of preference
+	MetaAcceptXfer = "X-Accept-Xfer"
+	// MetaXfer the key of transfer filter chosen by the replier from MetaAcceptXfer
+	MetaXfer = "X-Xfer"
+	// MetaAcceptRerrorCodec the key of Rerror codec that the sender wishes to accept
+	MetaAcceptRerrorCodec = "X-Accept-Rerror-Codec"
+	// MetaGoawayReason the key of reason carried by the GOAWAY control message
+	MetaGoawayReason = "X-Goaway-Reason"
+	// MetaMessageID the key of message ID generated by the sender, see PeerConfig.MessageID
+	MetaMessageID = "X-Message-ID"
+	// MetaUpgradeProto the key of protocol name carried by the UPGRADE and UPGRADE_ACK control messages
+	MetaUpgradeProto = "X-Upgrade-Proto"
+	// MetaGzip the key of the gzip-compressed metadata, see PeerConfig.MetaCompressThreshold
+	MetaGzip = "X-Meta-Gzip"
+	// MetaAcceptMultiReply the key of whether the caller accepts the parts of the reply, see Session.CallMulti
+	MetaAcceptMultiReply = "X-Accept-Multi-Reply"
+	// MetaReplyPart the key of the reply which is followed by more parts, see CallCtx.ReplyPart
+	MetaReplyPart = "X-Reply-Part"
+	// MetaBodyType the key of the type name of the body declared by the sender, see WithBodyType
+	MetaBodyType = "X-Body-Type"
+	// MetaRetryAfter the key of the milliseconds after which the rejected CALL may be retried, see PeerConfig.RetryAfter
+	MetaRetryAfter = "X-Retry-After"
+	// MetaChannel the key of the name of the logical channel which the CALL or PUSH is sent over, see Session.OpenChannel
+	MetaChannel = "X-Channel"
+	// MetaPriority the key of the priority of writing the message, see WithPriority
+	MetaPriority = "X-Priority"
+	// MetaCancelSeq the key of the sequence of the CALL canceled by the CANCEL control message
+	MetaCancelSeq = "X-Cancel-Seq"
+	// MetaFeatures the key of the framework features announced by the first message written to the connection, see FeatureControl
+	MetaFeatures = "X-Features"
+	// MetaVersion the key of the version of the handler which the message is sent to, see WithVersion
+	MetaVersion = "X-Version"
+	// MetaStream the key of whether the CALL opens a stream, see Session.OpenStream
+	MetaStream = "X-Stream"
+	// MetaStreamSeq the key of the sequence of the CALL which opened the stream that the PUSH or CREDIT is sent over
+	MetaStreamSeq = "X-Stream-Seq"
+	// MetaStreamEnd the key of the PUSH which closes the sending side of the stream, see ClientStream.CloseSend,
+	// or of the termination frame of the reply, see Session.CallStream
+	MetaStreamEnd = "X-Stream-End"
+	// MetaStreamWindow the key of the number of the chunks which the replier can send before they are read, see WithStreamWindow
+	MetaStreamWindow = "X-Stream-Window"
+	// MetaCredit the key of the number of the chunks granted by the CREDIT control message, see Session.CallStream
+	MetaCredit = "X-Credit"
 )
 
 // WithRerror sets the real IP to metadata.
@@ -157,6 +200,40 @@ func GetAcceptBodyCodec(meta *utils.Args) (byte, bool) {
 	return c, c != codec.NilCodecID
 }
 
+// WithAcceptXfer sets the transfer filters that the sender wishes to accept for the reply,
+// in order of preference.
+// NOTE:
+//  The replier picks the first one it supports, and records it in the reply metadata MetaXfer;
+//  If none is supported, the reply is not filtered.
+func WithAcceptXfer(filterID ...byte) MessageSetting {
+	if len(filterID) == 0 {
+		return WithNothing()
+	}
+	a := make([]string, len(filterID))
+	for i, id := range filterID {
+		a[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return socket.WithSetMeta(MetaAcceptXfer, strings.Join(a, ","))
+}
+
+// GetAcceptXfer gets the transfer filters that the sender wishes to accept, in order of preference.
+// NOTE: The invalid filter ids are ignored.
+func GetAcceptXfer(meta *utils.Args) []byte {
+	s := meta.Peek(MetaAcceptXfer)
+	if len(s) == 0 {
+		return nil
+	}
+	var ids []byte
+	for _, a := range strings.Split(goutil.BytesToString(s), ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(a), 10, 8)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, byte(id))
+	}
+	return ids
+}
+
 // WithNothing nothing to do.
 //  func WithNothing() MessageSetting
 var WithNothing = socket.WithNothing
@@ -0,0 +1,188 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IDGenerator generates the IDs of sessions and messages.
+// NOTE: NewID must be concurrent safe.
+type IDGenerator interface {
+	NewID() string
+}
+
+// IDGeneratorFunc the function type of IDGenerator
+type IDGeneratorFunc func() string
+
+// NewID generates a new ID.
+func (f IDGeneratorFunc) NewID() string {
+	return f()
+}
+
+// IDGeneratorMaker creates an IDGenerator by the parameter.
+// NOTE: The format of the parameter is defined by the maker.
+type IDGeneratorMaker func(param string) (IDGenerator, error)
+
+const (
+	// IDGeneratorUUIDv7 the name of the time-ordered UUID (version 7) generator
+	IDGeneratorUUIDv7 = "uuidv7"
+	// IDGeneratorSnowflake the name of the snowflake generator, the parameter is the node ID (0~1023)
+	IDGeneratorSnowflake = "snowflake"
+	// IDGeneratorSequential the name of the sequential generator, the parameter is the ID prefix
+	IDGeneratorSequential = "sequential"
+)
+
+var idGeneratorMap = struct {
+	m  map[string]IDGeneratorMaker
+	mu sync.RWMutex
+}{
+	m: make(map[string]IDGeneratorMaker),
+}
+
+func init() {
+	RegIDGenerator(IDGeneratorUUIDv7, func(string) (IDGenerator, error) {
+		return IDGeneratorFunc(NewUUIDv7), nil
+	})
+	RegIDGenerator(IDGeneratorSnowflake, func(param string) (IDGenerator, error) {
+		var node int64
+		if len(param) > 0 {
+			var err error
+			node, err = strconv.ParseInt(param, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid snowflake node id: %s", param)
+			}
+		}
+		return NewSnowflake(node)
+	})
+	RegIDGenerator(IDGeneratorSequential, func(prefix string) (IDGenerator, error) {
+		return NewSequential(prefix), nil
+	})
+}
+
+// RegIDGenerator registers the maker of IDGenerator.
+func RegIDGenerator(name string, maker IDGeneratorMaker) {
+	idGeneratorMap.mu.Lock()
+	defer idGeneratorMap.mu.Unlock()
+	if _, ok := idGeneratorMap.m[name]; ok {
+		panic("multi-register id generator: " + name)
+	}
+	idGeneratorMap.m[name] = maker
+}
+
+// NewIDGenerator creates an IDGenerator by the URI, format: 'name' or 'name:param'.
+// e.g. uuidv7, snowflake:12, sequential:node1-
+func NewIDGenerator(uri string) (IDGenerator, error) {
+	name, param := uri, ""
+	if i := strings.Index(uri, ":"); i >= 0 {
+		name, param = uri[:i], uri[i+1:]
+	}
+	idGeneratorMap.mu.RLock()
+	maker, ok := idGeneratorMap.m[name]
+	idGeneratorMap.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported id generator: %s", name)
+	}
+	return maker(param)
+}
+
+// NewUUIDv7 returns a time-ordered UUID of version 7, such as 01890a5d-ac96-774b-bcce-b302099a8057.
+func NewUUIDv7() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	u[0] = byte(ms >> 40)
+	u[1] = byte(ms >> 32)
+	u[2] = byte(ms >> 24)
+	u[3] = byte(ms >> 16)
+	u[4] = byte(ms >> 8)
+	u[5] = byte(ms)
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // variant RFC 4122
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// snowflakeEpoch the custom epoch of snowflake, 2018-01-01T00:00:00Z in milliseconds
+const snowflakeEpoch = 1514764800000
+
+// Snowflake generates 63-bit IDs composed of 41-bit milliseconds, 10-bit node ID and 12-bit sequence.
+type Snowflake struct {
+	node     int64
+	lastTime int64
+	seq      int64
+	mu       sync.Mutex
+}
+
+// NewSnowflake creates a snowflake generator.
+// NOTE: The node ID must be in the range of 0~1023, and unique in the cluster.
+func NewSnowflake(node int64) (*Snowflake, error) {
+	if node < 0 || node > 1023 {
+		return nil, fmt.Errorf("snowflake node id out of range 0~1023: %d", node)
+	}
+	return &Snowflake{node: node}, nil
+}
+
+// NewID generates a new ID.
+func (s *Snowflake) NewID() string {
+	s.mu.Lock()
+	now := time.Now().UnixNano()/int64(time.Millisecond) - snowflakeEpoch
+	if now <= s.lastTime {
+		// the same millisecond or the clock moved backwards
+		now = s.lastTime
+		s.seq = (s.seq + 1) & 0xfff
+		if s.seq == 0 {
+			now++
+		}
+	} else {
+		s.seq = 0
+	}
+	s.lastTime = now
+	id := now<<22 | s.node<<12 | s.seq
+	s.mu.Unlock()
+	return strconv.FormatInt(id, 10)
+}
+
+// Sequential generates IDs composed of the prefix and an increasing number.
+type Sequential struct {
+	prefix string
+	n      uint64
+}
+
+// NewSequential creates a sequential generator.
+func NewSequential(prefix string) *Sequential {
+	return &Sequential{prefix: prefix}
+}
+
+// NewID generates a new ID.
+func (s *Sequential) NewID() string {
+	return s.prefix + strconv.FormatUint(atomic.AddUint64(&s.n, 1), 10)
+}
@@ -0,0 +1,48 @@
+package tp_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type identCall struct {
+	tp.CallCtx
+}
+
+func (m *identCall) Get(*struct{}) (string, *tp.Rerror) {
+	return string(m.PeekMeta(tp.MetaMessageID)) + "|" + m.Session().ID(), nil
+}
+
+func TestIDGenerator(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{
+		IDGenerator: "sequential:srv-",
+	}, tp.PeerConfig{
+		IDGenerator: "snowflake:7",
+		MessageID:   true,
+	}, func(srv, _ tp.Peer) {
+		srv.RouteCall(new(identCall))
+	})
+	defer p.Close()
+	if _, err := strconv.ParseInt(p.sess.ID(), 10, 64); err != nil {
+		t.Fatalf("expect snowflake session id, got %s", p.sess.ID())
+	}
+	var lastMsgID string
+	for i := 0; i < 2; i++ {
+		var result string
+		rerr := p.sess.Call("/ident_call/get", nil, &result).Rerror()
+		if rerr != nil {
+			t.Fatalf("/ident_call/get: %v", rerr)
+		}
+		a := strings.SplitN(result, "|", 2)
+		if len(a[0]) == 0 || a[0] == lastMsgID {
+			t.Fatalf("expect a new message id, got %q", a[0])
+		}
+		lastMsgID = a[0]
+		if a[1] != "srv-1" {
+			t.Fatalf("expect session id srv-1, got %s", a[1])
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// IdleTimeout returns the duration after which the session is closed if no message is exchanged.
+func (s *session) IdleTimeout() time.Duration {
+	s.idleLock.Lock()
+	d := s.idleTimeout
+	s.idleLock.Unlock()
+	return d
+}
+
+// SetIdleTimeout sets the duration after which the session is closed if no message is exchanged,
+// which is reset by every message read or written, unlike the absolute session age.
+// NOTE:
+// If d<=0, never closed for idleness;
+// The control messages, e.g. the heartbeat PING, do not reset it.
+func (s *session) SetIdleTimeout(d time.Duration) {
+	s.idleLock.Lock()
+	defer s.idleLock.Unlock()
+	s.idleTimeout = d
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	if d > 0 {
+		s.idleTimer = time.AfterFunc(d-s.idle(), s.checkIdle)
+	}
+}
+
+// touchActive records the time of the last message exchanged, except the control messages.
+func (s *session) touchActive(msg Message) {
+	if !IsControlType(msg.Mtype()) {
+		atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+	}
+}
+
+func (s *session) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+}
+
+// checkIdle closes the session if it is idle, otherwise checks again when it would be.
+func (s *session) checkIdle() {
+	select {
+	case <-s.closeNotifyCh:
+		return
+	default:
+	}
+	s.idleLock.Lock()
+	d := s.idleTimeout
+	if d <= 0 || s.idleTimer == nil {
+		s.idleLock.Unlock()
+		return
+	}
+	idle := s.idle()
+	if idle < d {
+		s.idleTimer.Reset(d - idle)
+		s.idleLock.Unlock()
+		return
+	}
+	s.idleTimer = nil
+	s.idleLock.Unlock()
+	Infof("idle timeout, close the session: %s, idle: %v", s.RemoteAddr().String(), idle)
+	s.close(CloseIdleTimeout, nil, true)
+}
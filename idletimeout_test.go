@@ -0,0 +1,34 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	const idle = 300 * time.Millisecond
+	p := newMemPeers(t, tp.PeerConfig{
+		DefaultIdleTimeout: idle,
+	}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(echo_call)
+	})
+	defer p.Close()
+	// kept alive by the active exchanges, twice as long as the idle timeout in total
+	for i := 0; i < 4; i++ {
+		time.Sleep(idle / 2)
+		var arg = 1
+		if rerr := p.sess.Call("/echo/call", &arg, new(int)).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	if !p.sess.Health() {
+		t.Fatal("expect the active session alive")
+	}
+	select {
+	case <-p.sess.CloseNotify():
+	case <-time.After(5 * idle):
+		t.Fatal("expect the idle session closed")
+	}
+}
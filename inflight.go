@@ -0,0 +1,89 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync/atomic"
+)
+
+func newInflightLimiter(limit int) *concurrencyLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &concurrencyLimiter{slots: make(chan struct{}, limit)}
+}
+
+// SetInflightLimit sets the maximum number of the in-flight CALLs launched by the session,
+// the excess CALLs wait or fail with CodeTooManyCalls, see PeerConfig.InflightCallsWait.
+// It overrides PeerConfig.SessionInflightCalls.
+// NOTE:
+// If limit<=0, no limit;
+// The in-flight CALLs are counted by the previous limit.
+func (s *session) SetInflightLimit(limit int) {
+	s.inflightLimiter.Store(newInflightLimiter(limit))
+}
+
+// acquireInflight takes the in-flight slots of the peer and the session for the CALL.
+func (c *callCmd) acquireInflight() *Rerror {
+	if l := c.sess.peer.inflightLimiter; l != nil {
+		if rerr := c.waitInflight(l); rerr != nil {
+			return rerr
+		}
+		c.peerSlot = l
+	}
+	if l, _ := c.sess.inflightLimiter.Load().(*concurrencyLimiter); l != nil {
+		if rerr := c.waitInflight(l); rerr != nil {
+			c.releaseInflight()
+			return rerr
+		}
+		c.sessSlot = l
+	}
+	return nil
+}
+
+// waitInflight takes the in-flight slot of the limiter,
+// waits for it until the context of the CALL is done if PeerConfig.InflightCallsWait is set, or fails fast.
+func (c *callCmd) waitInflight(l *concurrencyLimiter) *Rerror {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if !c.sess.peer.inflightWait {
+		atomic.AddUint64(&l.shed, 1)
+		return rerrTooManyCalls
+	}
+	ctx := c.output.Context()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return rerrCallContext(ctx.Err())
+	case <-c.sess.closeNotifyCh:
+		return rerrConnClosed
+	}
+}
+
+// releaseInflight releases the in-flight slots of the CALL.
+func (c *callCmd) releaseInflight() {
+	if c.sessSlot != nil {
+		<-c.sessSlot.slots
+		c.sessSlot = nil
+	}
+	if c.peerSlot != nil {
+		<-c.peerSlot.slots
+		c.peerSlot = nil
+	}
+}
@@ -0,0 +1,81 @@
+package tp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestInflightLimit(t *testing.T) {
+	var path string
+	// each blocking CALL is released by one send
+	entered, release := make(chan int, 4), make(chan struct{})
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{
+		MaxInflightCalls: 2,
+	}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(blockingCall(entered, release))
+		srv.RouteCallFunc(echo_call)
+	})
+	defer p.Close()
+	block := func(sess tp.Session) tp.CallCmd {
+		var arg = 1
+		cmd := sess.AsyncCall(path, &arg, new(int), make(chan tp.CallCmd, 1))
+		<-entered
+		return cmd
+	}
+	echo := func(sess tp.Session) tp.CallCmd {
+		var arg = 1
+		cmd := sess.AsyncCall("/echo/call", &arg, new(int), make(chan tp.CallCmd, 1))
+		<-cmd.Done()
+		return cmd
+	}
+
+	// fail fast
+	cmd1, cmd2 := block(p.sess), block(p.sess)
+	if rerr := echo(p.sess).Rerror(); rerr == nil || rerr.Code != tp.CodeTooManyCalls {
+		t.Fatalf("expect CodeTooManyCalls, got %v", rerr)
+	}
+	release <- struct{}{}
+	release <- struct{}{}
+	<-cmd1.Done()
+	<-cmd2.Done()
+	if rerr := echo(p.sess).Rerror(); rerr != nil {
+		t.Fatalf("expect the slots released, got %v", rerr)
+	}
+
+	// the session limit overrides the default one, and the excess CALLs wait
+	cli := tp.NewPeer(tp.PeerConfig{Network: "mem", SessionInflightCalls: 4, InflightCallsWait: true})
+	defer cli.Close()
+	sess, rerr := cli.Dial(p.addr)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	sess.SetInflightLimit(1)
+	cmd1 = block(sess)
+	// sending the CALL waits for the in-flight slot
+	sent := make(chan tp.CallCmd, 1)
+	go func() {
+		sent <- echo(sess)
+	}()
+	select {
+	case cmd2 = <-sent:
+		t.Fatalf("expect the CALL waiting for the in-flight slot, got %v", cmd2.Rerror())
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	if rerr := (<-sent).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	<-cmd1.Done()
+	cmd1 = block(sess)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var arg = 0
+	if rerr := sess.Call("/echo/call", &arg, nil, tp.WithContext(ctx)).Rerror(); rerr == nil || rerr.Code != tp.CodeHandleTimeout {
+		t.Fatalf("expect CodeHandleTimeout while waiting, got %v", rerr)
+	}
+	release <- struct{}{}
+	<-cmd1.Done()
+}
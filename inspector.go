@@ -0,0 +1,49 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+// MessageInspector the low-level hook of the messages of the sessions,
+// e.g. for the protocol analyzers, anomaly detectors and WAF-style inspection modules.
+// NOTE:
+// The methods are called synchronously, so they should be fast;
+// The messages must not be modified or retained after the methods return.
+type MessageInspector interface {
+	// InspectInbound is called with each message read and decoded, before it is handled;
+	// if it returns an error, the CALL is refused with it, and the PUSH is dropped.
+	InspectInbound(sess Session, msg Message) *Rerror
+	// InspectOutbound is called with each message written, whose Size() is the packed size.
+	InspectOutbound(sess Session, msg Message)
+}
+
+// SetMessageInspector sets the hook of the messages read and written by the sessions.
+// NOTE: It should be called before dialing or serving.
+func (p *peer) SetMessageInspector(inspector MessageInspector) {
+	p.inspector = inspector
+}
+
+// inspectInbound passes the input message to the inspector, if any.
+func (c *handlerCtx) inspectInbound() {
+	inspector := c.sess.peer.inspector
+	if inspector == nil {
+		return
+	}
+	rerr := inspector.InspectInbound(c.sess, c.input)
+	if rerr != nil && c.handleErr == nil {
+		switch c.input.Mtype() {
+		case TypeCall, TypePush:
+			c.handleErr = rerr
+		}
+	}
+}
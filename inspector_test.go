@@ -0,0 +1,50 @@
+package tp_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type testInspector struct {
+	inbound, outbound int32
+}
+
+func (i *testInspector) InspectInbound(sess tp.Session, msg tp.Message) *tp.Rerror {
+	atomic.AddInt32(&i.inbound, 1)
+	if len(msg.Meta().Peek("evil")) > 0 {
+		return tp.NewRerror(tp.CodeUnauthorized, tp.CodeText(tp.CodeUnauthorized), "evil meta")
+	}
+	return nil
+}
+
+func (i *testInspector) InspectOutbound(sess tp.Session, msg tp.Message) {
+	if msg.Size() > 0 {
+		atomic.AddInt32(&i.outbound, 1)
+	}
+}
+
+func TestMessageInspector(t *testing.T) {
+	inspector, closed := new(testInspector), make(closeReasonPlugin, 1)
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.SetMessageInspector(inspector)
+		srv.RouteCallFunc(echo_call)
+		srv.PluginContainer().AppendRight(closed)
+	})
+	defer p.Close()
+	var arg = 1
+	if rerr := p.sess.Call("/echo/call", &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	rerr := p.sess.Call("/echo/call", &arg, new(int), tp.WithAddMeta("evil", "1")).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeUnauthorized {
+		t.Fatalf("expect the CALL refused by the inspector, got %v", rerr)
+	}
+	// the replies are inspected before the session is closed
+	p.sess.Close()
+	closed.wait(t)
+	if in, out := atomic.LoadInt32(&inspector.inbound), atomic.LoadInt32(&inspector.outbound); in < 2 || out < 2 {
+		t.Fatalf("expect the messages inspected, got %d inbound, %d outbound", in, out)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+// labelIndex the index of the sessions by the labels, e.g. "user" -> "42" -> sessions.
+type labelIndex map[string]map[string]map[*session]struct{}
+
+func (idx labelIndex) add(key, value string, sess *session) {
+	values, ok := idx[key]
+	if !ok {
+		values = make(map[string]map[*session]struct{})
+		idx[key] = values
+	}
+	sessions, ok := values[value]
+	if !ok {
+		sessions = make(map[*session]struct{})
+		values[value] = sessions
+	}
+	sessions[sess] = struct{}{}
+}
+
+func (idx labelIndex) remove(key, value string, sess *session) {
+	values := idx[key]
+	sessions := values[value]
+	delete(sessions, sess)
+	if len(sessions) == 0 {
+		delete(values, value)
+		if len(values) == 0 {
+			delete(idx, key)
+		}
+	}
+}
+
+// indexLabels indexes the labels of the session in the hub.
+func (sh *SessionHub) indexLabels(sess *session) {
+	sh.labelLock.Lock()
+	defer sh.labelLock.Unlock()
+	if sess.indexed {
+		return
+	}
+	sess.indexed = true
+	for k, v := range sess.labels {
+		sh.labels.add(k, v, sess)
+	}
+}
+
+// unindexLabels removes the labels of the session from the index of the hub.
+func (sh *SessionHub) unindexLabels(sess *session) {
+	sh.labelLock.Lock()
+	defer sh.labelLock.Unlock()
+	if !sess.indexed {
+		return
+	}
+	sess.indexed = false
+	for k, v := range sess.labels {
+		sh.labels.remove(k, v, sess)
+	}
+}
+
+// find returns the sessions which have all the labels of the selector.
+func (sh *SessionHub) find(selector map[string]string) []*session {
+	sh.labelLock.RLock()
+	defer sh.labelLock.RUnlock()
+	// range the smallest set of the sessions
+	var smallest map[*session]struct{}
+	for k, v := range selector {
+		sessions, ok := sh.labels[k][v]
+		if !ok {
+			return nil
+		}
+		if smallest == nil || len(sessions) < len(smallest) {
+			smallest = sessions
+		}
+	}
+	if smallest == nil {
+		return nil
+	}
+	list := make([]*session, 0, len(smallest))
+	for sess := range smallest {
+		matched := true
+		for k, v := range selector {
+			if val, ok := sess.labels[k]; !ok || val != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			list = append(list, sess)
+		}
+	}
+	return list
+}
+
+// SetLabel sets the label of the session, which is indexed for Peer.FindSessions,
+// e.g. SetLabel("user", "42").
+func (s *session) SetLabel(key, value string) {
+	hub := s.peer.sessHub
+	if s.setLabel(key, value) {
+		hub.syncSession(s)
+	}
+}
+
+// setLabel sets the label, and returns whether the indexed session is changed.
+func (s *session) setLabel(key, value string) bool {
+	hub := s.peer.sessHub
+	hub.labelLock.Lock()
+	defer hub.labelLock.Unlock()
+	if old, ok := s.labels[key]; ok {
+		if old == value {
+			return false
+		}
+		if s.indexed {
+			hub.labels.remove(key, old, s)
+		}
+	}
+	if s.labels == nil {
+		s.labels = make(map[string]string)
+	}
+	s.labels[key] = value
+	if s.indexed {
+		hub.labels.add(key, value, s)
+	}
+	return s.indexed
+}
+
+// DeleteLabel deletes the label of the session.
+func (s *session) DeleteLabel(key string) {
+	hub := s.peer.sessHub
+	hub.labelLock.Lock()
+	old, ok := s.labels[key]
+	if !ok {
+		hub.labelLock.Unlock()
+		return
+	}
+	delete(s.labels, key)
+	indexed := s.indexed
+	if indexed {
+		hub.labels.remove(key, old, s)
+	}
+	hub.labelLock.Unlock()
+	if indexed {
+		hub.syncSession(s)
+	}
+}
+
+// Label returns the label value of the session.
+func (s *session) Label(key string) (value string, ok bool) {
+	hub := s.peer.sessHub
+	hub.labelLock.RLock()
+	value, ok = s.labels[key]
+	hub.labelLock.RUnlock()
+	return
+}
+
+// Labels returns a copy of the labels of the session.
+func (s *session) Labels() map[string]string {
+	hub := s.peer.sessHub
+	hub.labelLock.RLock()
+	defer hub.labelLock.RUnlock()
+	labels := make(map[string]string, len(s.labels))
+	for k, v := range s.labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// FindSessions returns the sessions which have all the labels of the selector,
+// e.g. {"user": "42"} or {"region": "eu", "role": "admin"}.
+// NOTE: It looks up the index of the labels, instead of ranging all the sessions.
+func (p *peer) FindSessions(selector map[string]string) []Session {
+	list := p.sessHub.find(selector)
+	sessions := make([]Session, len(list))
+	for i, sess := range list {
+		sessions[i] = sess
+	}
+	return sessions
+}
@@ -0,0 +1,71 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func label_call(ctx tp.CallCtx, arg *map[string]string) (int, *tp.Rerror) {
+	for k, v := range *arg {
+		ctx.Session().SetLabel(k, v)
+	}
+	return len(*arg), nil
+}
+
+func TestSessionLabels(t *testing.T) {
+	closed := make(closeReasonPlugin, 3)
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(label_call)
+		srv.PluginContainer().AppendRight(closed)
+	})
+	defer p.Close()
+
+	var sessions []tp.Session
+	for i, labels := range []map[string]string{
+		{"user": "1", "region": "eu"},
+		{"user": "1", "region": "us"},
+		{"user": "2", "region": "eu"},
+	} {
+		sess := p.sess
+		if i > 0 {
+			var rerr *tp.Rerror
+			if sess, rerr = p.cli.Dial(p.addr); rerr != nil {
+				t.Fatal(rerr)
+			}
+		}
+		var n int
+		if rerr := sess.Call("/label/call", labels, &n).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		sessions = append(sessions, sess)
+	}
+
+	count := func(selector map[string]string, expect int) {
+		if n := len(p.srv.FindSessions(selector)); n != expect {
+			t.Fatalf("%v: expect %d sessions, got %d", selector, expect, n)
+		}
+	}
+	count(map[string]string{"user": "1"}, 2)
+	count(map[string]string{"region": "eu"}, 2)
+	count(map[string]string{"user": "1", "region": "eu"}, 1)
+	count(map[string]string{"user": "3"}, 0)
+	count(map[string]string{}, 0)
+
+	// the label is re-indexed
+	sess := p.srv.FindSessions(map[string]string{"user": "2"})[0]
+	sess.SetLabel("user", "1")
+	count(map[string]string{"user": "1"}, 3)
+	count(map[string]string{"user": "2"}, 0)
+	sess.DeleteLabel("region")
+	count(map[string]string{"region": "eu"}, 1)
+	if v, ok := sess.Label("user"); !ok || v != "1" {
+		t.Fatalf("expect label user=1, got %q", v)
+	}
+
+	// the closed session is removed from the index
+	sessions[0].Close()
+	closed.wait(t)
+	count(map[string]string{"user": "1"}, 2)
+	count(map[string]string{"region": "eu"}, 0)
+}
@@ -23,8 +23,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/henrylee2cn/goutil/graceful"
-
 	"github.com/henrylee2cn/goutil"
 	"github.com/mylonly/teleport/utils"
 	"github.com/mylonly/teleport/utils/color"
@@ -250,6 +248,7 @@ func Printf(format string, a ...interface{}) {
 // Fatalf is equivalent to l.Criticalf followed by a call to os.Exit(1).
 func Fatalf(format string, a ...interface{}) {
 	loggerOutput(CRITICAL, format, a...)
+	dumpStateOnFatal()
 	loggerOutputter.Flush()
 	os.Exit(1)
 }
@@ -301,9 +300,8 @@ func Tracef(format string, a ...interface{}) {
 type globalLogger struct{}
 
 var (
-	logger                            = new(globalLogger)
-	_      Logger                     = logger
-	_      graceful.LoggerWithFlusher = logger
+	logger        = new(globalLogger)
+	_      Logger = logger
 )
 
 func (globalLogger) Flush() error {
@@ -319,6 +317,7 @@ func (globalLogger) Printf(format string, a ...interface{}) {
 // Fatalf is equivalent to l.Criticalf followed by a call to os.Exit(1).
 func (globalLogger) Fatalf(format string, a ...interface{}) {
 	loggerOutput(CRITICAL, format, a...)
+	dumpStateOnFatal()
 	loggerOutputter.Flush()
 	os.Exit(1)
 }
@@ -376,6 +375,7 @@ func (s *session) Printf(format string, a ...interface{}) {
 // Fatalf is equivalent to l.Criticalf followed by a call to os.Exit(1).
 func (s *session) Fatalf(format string, a ...interface{}) {
 	loggerOutput(CRITICAL, format, a...)
+	dumpStateOnFatal()
 	loggerOutputter.Flush()
 	os.Exit(1)
 }
@@ -433,6 +433,7 @@ func (c *handlerCtx) Printf(format string, a ...interface{}) {
 // Fatalf is equivalent to l.Criticalf followed by a call to os.Exit(1).
 func (c *handlerCtx) Fatalf(format string, a ...interface{}) {
 	loggerOutput(CRITICAL, format, a...)
+	dumpStateOnFatal()
 	loggerOutputter.Flush()
 	os.Exit(1)
 }
@@ -490,6 +491,7 @@ func (c *callCmd) Printf(format string, a ...interface{}) {
 // Fatalf is equivalent to l.Criticalf followed by a call to os.Exit(1).
 func (c *callCmd) Fatalf(format string, a ...interface{}) {
 	loggerOutput(CRITICAL, format, a...)
+	dumpStateOnFatal()
 	loggerOutputter.Flush()
 	os.Exit(1)
 }
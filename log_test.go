@@ -2,6 +2,7 @@ package tp
 
 import (
 	"testing"
+	"time"
 )
 
 func TestLog(t *testing.T) {
@@ -14,3 +15,41 @@ func TestLog(t *testing.T) {
 	Debugf("test: %s", "Debugf()")
 	Tracef("test: %s", "Tracef()")
 }
+
+func TestLogPolicyTier(t *testing.T) {
+	var cases = []struct {
+		policy    LogPolicy
+		costTime  time.Duration
+		countTime bool
+		failed    bool
+		tier      logTier
+	}{
+		{LogPolicy{SampleRate: 0}, 0, false, true, logTierFailed},
+		{LogPolicy{SampleRate: 0}, time.Second, true, false, logTierSlow},
+		{LogPolicy{SampleRate: 0}, time.Second, false, false, logTierNone},
+		{LogPolicy{SampleRate: 0, SlowThreshold: 2 * time.Second}, time.Second, true, false, logTierNone},
+		{LogPolicy{SampleRate: 1}, time.Millisecond, true, false, logTierSampled},
+		{LogPolicy{SampleRate: 1}, 0, false, false, logTierSampled},
+	}
+	for i, c := range cases {
+		tier := c.policy.tier(c.costTime, 500*time.Millisecond, c.countTime, c.failed)
+		if tier != c.tier {
+			t.Fatalf("case %d: expect %d, got %d", i, c.tier, tier)
+		}
+	}
+	l := newLogPolicies(true)
+	if policy, ok := l.get("/other"); ok || !policy.PrintDetail {
+		t.Fatalf("expect no policy by default, got %+v", policy)
+	}
+	l.routes.Store("/quiet", LogPolicy{})
+	if policy, ok := l.get("/quiet"); !ok || policy.PrintDetail {
+		t.Fatalf("expect the route policy, got %+v", policy)
+	}
+	l.def.Store(LogPolicy{SampleRate: 1})
+	if policy, _ := l.get("/quiet"); policy.SampleRate != 0 {
+		t.Fatalf("expect the route override, got %+v", policy)
+	}
+	if policy, ok := l.get("/other"); !ok || policy.SampleRate != 1 {
+		t.Fatalf("expect the default policy, got %+v", policy)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/henrylee2cn/goutil"
+)
+
+// LogPolicy the policy of printing the access logs, which is opt-in by SetLogPolicy or SetRouteLogPolicy.
+// NOTE:
+//  Without the policy, the access logs are printed with INFO level, and the slow ones with WARNING level;
+//  With the policy, the failed requests are always printed with WARNING level;
+//  The slow requests are printed with WARNING level, only if PeerConfig.CountTime is enabled;
+//  The others are sampled by SampleRate, and printed with INFO level.
+type LogPolicy struct {
+	// SlowThreshold the cost time from which a request is slow;
+	// if <=0, use PeerConfig.SlowCometDuration.
+	SlowThreshold time.Duration
+	// SampleRate the fraction (0~1) of the successful and fast requests to be printed.
+	SampleRate float64
+	// PrintDetail whether to print the body and metadata.
+	PrintDetail bool
+}
+
+type logTier int8
+
+const (
+	logTierNone logTier = iota
+	logTierSampled
+	logTierSlow
+	logTierFailed
+)
+
+// logPolicies the default and per-route log policies of a peer.
+type logPolicies struct {
+	printDetail bool         // PeerConfig.PrintDetail, used without the policy
+	def         atomic.Value // LogPolicy, not stored until SetLogPolicy
+	routes      goutil.Map   // serviceMethod -> LogPolicy
+}
+
+func newLogPolicies(printDetail bool) *logPolicies {
+	return &logPolicies{
+		printDetail: printDetail,
+		routes:      goutil.AtomicMap(),
+	}
+}
+
+// get returns the policy of the service method, and whether it is set;
+// if not, the returned one only carries PeerConfig.PrintDetail.
+func (l *logPolicies) get(serviceMethod string) (LogPolicy, bool) {
+	if policy, ok := l.routes.Load(serviceMethod); ok {
+		return policy.(LogPolicy), true
+	}
+	if policy, ok := l.def.Load().(LogPolicy); ok {
+		return policy, true
+	}
+	return LogPolicy{PrintDetail: l.printDetail}, false
+}
+
+// tier classifies the request according to the policy.
+func (policy *LogPolicy) tier(costTime, defSlow time.Duration, countTime, failed bool) logTier {
+	if failed {
+		return logTierFailed
+	}
+	if countTime {
+		slow := policy.SlowThreshold
+		if slow <= 0 {
+			slow = defSlow
+		}
+		if costTime >= slow {
+			return logTierSlow
+		}
+	}
+	if policy.SampleRate >= 1 || (policy.SampleRate > 0 && rand.Float64() < policy.SampleRate) {
+		return logTierSampled
+	}
+	return logTierNone
+}
+
+// SetLogPolicy sets the default policy of printing the access logs.
+func (p *peer) SetLogPolicy(policy LogPolicy) {
+	p.logPolicies.def.Store(policy)
+}
+
+// SetRouteLogPolicy sets the policy of printing the access logs for the service method,
+// which overrides the default one.
+func (p *peer) SetRouteLogPolicy(serviceMethod string, policy LogPolicy) {
+	p.logPolicies.routes.Store(serviceMethod, policy)
+}
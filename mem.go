@@ -0,0 +1,183 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memDialWait the maximum duration for which dialing waits for the memory listener,
+// if the dial timeout is not set.
+const memDialWait = 3 * time.Second
+
+// memNet the in-process network, the listeners are identified by the port,
+// and the hosts are ignored.
+var memNet = struct {
+	listeners map[string]*memListener
+	changed   chan struct{} // closed and replaced when a listener is added
+	nextPort  int
+	nextConn  uint64
+	mu        sync.Mutex
+}{
+	listeners: make(map[string]*memListener),
+	changed:   make(chan struct{}),
+	nextPort:  1 << 16,
+}
+
+var errMemListenerClosed = errors.New("memory listener closed")
+
+type memAddr string
+
+func (memAddr) Network() string {
+	return "mem"
+}
+
+func (a memAddr) String() string {
+	return string(a)
+}
+
+// memConn the end of the in-process pipe.
+type memConn struct {
+	net.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (c *memConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *memConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// memListener accepts the in-process connections.
+type memListener struct {
+	port      string
+	addr      memAddr
+	connCh    chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// listenMem registers the in-process listener of the port of the address,
+// if the port is 0, chooses one.
+func listenMem(addr string) (*memListener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	memNet.mu.Lock()
+	defer memNet.mu.Unlock()
+	if port == "0" {
+		for {
+			port = strconv.Itoa(memNet.nextPort)
+			memNet.nextPort++
+			if _, ok := memNet.listeners[port]; !ok {
+				break
+			}
+		}
+	}
+	if _, ok := memNet.listeners[port]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "mem", Addr: memAddr(addr), Err: errors.New("address already in use")}
+	}
+	l := &memListener{
+		port:   port,
+		addr:   memAddr(net.JoinHostPort(host, port)),
+		connCh: make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	memNet.listeners[port] = l
+	close(memNet.changed)
+	memNet.changed = make(chan struct{})
+	return l, nil
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closed:
+		return nil, errMemListenerClosed
+	}
+}
+
+// Close unregisters the listener, the accepted connections are not affected.
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		memNet.mu.Lock()
+		if memNet.listeners[l.port] == l {
+			delete(memNet.listeners, l.port)
+		}
+		memNet.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return l.addr
+}
+
+// dialMem connects with the in-process listener of the port of the address,
+// and waits for the listener within the timeout, e.g. the server is starting.
+func dialMem(addr string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = memDialWait
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	refused := &net.OpError{Op: "dial", Net: "mem", Addr: memAddr(addr), Err: errors.New("connection refused")}
+	for {
+		memNet.mu.Lock()
+		l, ok := memNet.listeners[port]
+		changed := memNet.changed
+		if ok {
+			memNet.nextConn++
+		}
+		seq := memNet.nextConn
+		memNet.mu.Unlock()
+		if ok {
+			c, s := net.Pipe()
+			localAddr := memAddr(net.JoinHostPort("mem", strconv.FormatUint(seq, 10)))
+			select {
+			case l.connCh <- &memConn{Conn: s, localAddr: l.addr, remoteAddr: localAddr}:
+			case <-l.closed:
+				return nil, refused
+			case <-timer.C:
+				return nil, refused
+			}
+			var conn net.Conn = &memConn{Conn: c, localAddr: localAddr, remoteAddr: l.addr}
+			if tlsConfig != nil {
+				conn = tls.Client(conn, tlsConfig)
+			}
+			return conn, nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, refused
+		}
+	}
+}
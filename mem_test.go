@@ -0,0 +1,40 @@
+package tp_test
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/proto/jsonproto"
+)
+
+func TestMemNetwork(t *testing.T) {
+	// no sleep, dialing waits for the listener
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{DefaultDialTimeout: time.Second}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(echo_call)
+	}, jsonproto.NewJSONProtoFunc())
+	defer p.Close()
+	sess, rerr := p.cli.Dial(p.addr, jsonproto.NewJSONProtoFunc())
+	if rerr != nil {
+		t.Fatalf("dial: %v", rerr)
+	}
+	for _, sess := range []tp.Session{p.sess, sess} {
+		var arg, result = 10, 0
+		rerr = sess.Call("/echo/call", &arg, &result).Rerror()
+		if rerr != nil {
+			t.Fatalf("/echo/call: %v", rerr)
+		}
+		if result != arg {
+			t.Fatalf("expect %d, got %d", arg, result)
+		}
+	}
+	if n := p.srv.CountSession(); n != 2 {
+		t.Fatalf("expect 2 sessions, got %d", n)
+	}
+	unused := ":" + strconv.Itoa(int(atomic.AddInt32(&memPort, 1)))
+	if _, rerr := p.cli.Dial(unused); rerr == nil {
+		t.Fatal("expect dial failed without the listener")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/mylonly/teleport/socket"
+	"github.com/mylonly/teleport/utils"
+)
+
+var errMetaTooLarge = errors.New("gzip metadata: exceed the message size limit")
+
+// compressMeta replaces the metadata with the gzip-compressed one in MetaGzip,
+// if its encoded size exceeds the threshold and the compression pays off.
+// It returns the original metadata to restore after writing, or nil if not compressed.
+func compressMeta(meta *utils.Args, threshold int) *utils.Args {
+	if threshold <= 0 || meta.Has(MetaGzip) {
+		return nil
+	}
+	raw := meta.QueryString()
+	if len(raw) <= threshold {
+		return nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(raw); err != nil {
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		return nil
+	}
+	if base64.RawURLEncoding.EncodedLen(buf.Len()) >= len(raw) {
+		return nil
+	}
+	orig := utils.AcquireArgs()
+	meta.CopyTo(orig)
+	meta.Reset()
+	meta.Set(MetaGzip, base64.RawURLEncoding.EncodeToString(buf.Bytes()))
+	return orig
+}
+
+// restoreMeta restores the metadata compressed by compressMeta.
+func restoreMeta(meta *utils.Args, orig *utils.Args) {
+	orig.CopyTo(meta)
+	utils.ReleaseArgs(orig)
+}
+
+// decompressMeta expands the gzip-compressed metadata in MetaGzip, if any.
+// NOTE: The decompressed size is limited by the message size limit.
+func decompressMeta(meta *utils.Args) error {
+	v := meta.Peek(MetaGzip)
+	if len(v) == 0 {
+		return nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(string(v))
+	if err != nil {
+		return err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	limit := int64(socket.MessageSizeLimit())
+	raw, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(raw)) > limit {
+		return errMetaTooLarge
+	}
+	meta.Del(MetaGzip)
+	args := utils.AcquireArgs()
+	defer utils.ReleaseArgs(args)
+	args.ParseBytes(raw)
+	args.VisitAll(func(key, value []byte) {
+		meta.AddBytesKV(key, value)
+	})
+	return nil
+}
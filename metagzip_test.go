@@ -0,0 +1,41 @@
+package tp_test
+
+import (
+	"strings"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func meta_echo(ctx tp.CallCtx, arg *struct{}) (int, *tp.Rerror) {
+	if ctx.PeekMeta(tp.MetaGzip) != nil {
+		return 0, tp.NewRerror(tp.CodeBadMessage, tp.CodeText(tp.CodeBadMessage), "unexpected gzip metadata")
+	}
+	auth := ctx.PeekMeta("auth")
+	ctx.SetMeta("echo", string(auth))
+	return len(auth), nil
+}
+
+func TestMetaCompress(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{
+		MetaCompressThreshold: 1024,
+	}, tp.PeerConfig{
+		MetaCompressThreshold: 1024,
+	}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(meta_echo)
+	})
+	defer p.Close()
+	// longer than 65535, which is not supported by the raw proto uncompressed
+	auth := strings.Repeat("token=abc&", 10000)
+	var result int
+	callCmd := p.sess.Call("/meta/echo", nil, &result, tp.WithSetMeta("auth", auth))
+	if rerr := callCmd.Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if result != len(auth) {
+		t.Fatalf("expect %d, got %d", len(auth), result)
+	}
+	if echo := string(callCmd.InputMeta().Peek("echo")); echo != auth {
+		t.Fatalf("expect the echo metadata decompressed, got %d bytes", len(echo))
+	}
+}
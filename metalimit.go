@@ -0,0 +1,81 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"fmt"
+
+	"github.com/mylonly/teleport/utils"
+)
+
+// metaLimit the limits of the inbound metadata.
+type metaLimit struct {
+	maxPairs    int // if <=0, no limit
+	maxKeyLen   int // if <=0, no limit
+	maxValueLen int // if <=0, no limit
+	charset     *[256]bool
+}
+
+// newMetaLimit returns the limits of the inbound metadata, or nil if no limit is configured.
+func newMetaLimit(cfg *PeerConfig) *metaLimit {
+	if cfg.MaxMetaPairs <= 0 && cfg.MaxMetaKeyLen <= 0 && cfg.MaxMetaValueLen <= 0 && cfg.MetaKeyCharset == "" {
+		return nil
+	}
+	l := &metaLimit{
+		maxPairs:    cfg.MaxMetaPairs,
+		maxKeyLen:   cfg.MaxMetaKeyLen,
+		maxValueLen: cfg.MaxMetaValueLen,
+	}
+	if cfg.MetaKeyCharset != "" {
+		l.charset = new([256]bool)
+		for i := 0; i < len(cfg.MetaKeyCharset); i++ {
+			l.charset[cfg.MetaKeyCharset[i]] = true
+		}
+	}
+	return l
+}
+
+// check returns the error if the metadata exceeds the limits.
+func (l *metaLimit) check(meta *utils.Args) error {
+	if l == nil {
+		return nil
+	}
+	if l.maxPairs > 0 && meta.Len() > l.maxPairs {
+		return fmt.Errorf("too many meta pairs: %d > %d", meta.Len(), l.maxPairs)
+	}
+	var err error
+	meta.VisitAll(func(key, value []byte) {
+		if err != nil {
+			return
+		}
+		if l.maxKeyLen > 0 && len(key) > l.maxKeyLen {
+			err = fmt.Errorf("meta key too long: %d > %d", len(key), l.maxKeyLen)
+			return
+		}
+		if l.maxValueLen > 0 && len(value) > l.maxValueLen {
+			err = fmt.Errorf("meta value of %q too long: %d > %d", key, len(value), l.maxValueLen)
+			return
+		}
+		if l.charset != nil {
+			for _, b := range key {
+				if !l.charset[b] {
+					err = fmt.Errorf("invalid meta key character: %q", b)
+					return
+				}
+			}
+		}
+	})
+	return err
+}
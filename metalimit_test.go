@@ -0,0 +1,32 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestMetaLimit(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{
+		MaxMetaPairs:    2,
+		MaxMetaValueLen: 8,
+		MetaKeyCharset:  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_",
+	}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(echo_call)
+	})
+	defer p.Close()
+	var arg = 1
+	if rerr := p.sess.Call("/echo/call", &arg, new(int), tp.WithAddMeta("trace-id", "abc")).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	for _, setting := range [][]tp.MessageSetting{
+		{tp.WithAddMeta("a", "1"), tp.WithAddMeta("b", "2"), tp.WithAddMeta("c", "3")},
+		{tp.WithAddMeta("trace-id", "123456789")},
+		{tp.WithAddMeta("trace id", "1")},
+	} {
+		rerr := p.sess.Call("/echo/call", &arg, new(int), setting...).Rerror()
+		if rerr == nil || rerr.Code != tp.CodeBadMessage {
+			t.Fatalf("expect CodeBadMessage, got %v", rerr)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Package metrics exports the wire-level metrics of the socket communication protocols.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Error kinds
+const (
+	ErrKindEOF         = "eof"
+	ErrKindTimeout     = "timeout"
+	ErrKindClosed      = "closed"
+	ErrKindTooLarge    = "too_large"
+	ErrKindUnpackLimit = "unpack_limit" // the unpacked (e.g. decompressed) data exceeds the limit
+	ErrKindOther       = "other"
+)
+
+var errKinds = struct {
+	m  map[error]string
+	mu sync.RWMutex
+}{
+	m: map[error]string{
+		io.EOF:              ErrKindEOF,
+		io.ErrUnexpectedEOF: ErrKindEOF,
+	},
+}
+
+// RegErrorKind registers the kind of the sentinel error.
+func RegErrorKind(err error, kind string) {
+	errKinds.mu.Lock()
+	errKinds.m[err] = kind
+	errKinds.mu.Unlock()
+}
+
+// ErrorKind classifies the error.
+func ErrorKind(err error) string {
+	errKinds.mu.RLock()
+	kind, ok := errKinds.m[err]
+	errKinds.mu.RUnlock()
+	if ok {
+		return kind
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return ErrKindTimeout
+	}
+	if strings.Contains(err.Error(), "use of closed network connection") {
+		return ErrKindClosed
+	}
+	return ErrKindOther
+}
+
+// Direction statistics of one direction, pack or unpack
+type Direction struct {
+	Count       uint64
+	HeaderBytes uint64
+	BodyBytes   uint64
+	Duration    time.Duration
+	Errors      map[string]uint64
+}
+
+// ProtoStats the snapshot of the wire-level statistics of a proto
+type ProtoStats struct {
+	Proto  string
+	Pack   Direction
+	Unpack Direction
+}
+
+type direction struct {
+	count       uint64
+	headerBytes uint64
+	bodyBytes   uint64
+	nanos       int64
+	errors      sync.Map // kind -> *uint64
+}
+
+func (d *direction) observe(start time.Time, headerBytes, bodyBytes int, err error) {
+	if !start.IsZero() {
+		atomic.AddInt64(&d.nanos, int64(time.Since(start)))
+	}
+	if err != nil {
+		kind := ErrorKind(err)
+		n, ok := d.errors.Load(kind)
+		if !ok {
+			n, _ = d.errors.LoadOrStore(kind, new(uint64))
+		}
+		atomic.AddUint64(n.(*uint64), 1)
+		return
+	}
+	atomic.AddUint64(&d.count, 1)
+	atomic.AddUint64(&d.headerBytes, uint64(headerBytes))
+	atomic.AddUint64(&d.bodyBytes, uint64(bodyBytes))
+}
+
+func (d *direction) snapshot() Direction {
+	s := Direction{
+		Count:       atomic.LoadUint64(&d.count),
+		HeaderBytes: atomic.LoadUint64(&d.headerBytes),
+		BodyBytes:   atomic.LoadUint64(&d.bodyBytes),
+		Duration:    time.Duration(atomic.LoadInt64(&d.nanos)),
+		Errors:      make(map[string]uint64),
+	}
+	d.errors.Range(func(k, v interface{}) bool {
+		s.Errors[k.(string)] = atomic.LoadUint64(v.(*uint64))
+		return true
+	})
+	return s
+}
+
+// ProtoMetrics the wire-level metrics recorder of a proto
+type ProtoMetrics struct {
+	name   string
+	pack   direction
+	unpack direction
+}
+
+var protoMap = struct {
+	m  map[string]*ProtoMetrics
+	mu sync.Mutex
+}{
+	m: make(map[string]*ProtoMetrics),
+}
+
+// Proto returns the metrics recorder of the proto name, which is used as the label.
+func Proto(name string) *ProtoMetrics {
+	protoMap.mu.Lock()
+	defer protoMap.mu.Unlock()
+	p, ok := protoMap.m[name]
+	if !ok {
+		p = &ProtoMetrics{name: name}
+		protoMap.m[name] = p
+	}
+	return p
+}
+
+// ObservePack records a Pack.
+// NOTE: If start is zero, the duration is not counted.
+func (p *ProtoMetrics) ObservePack(start time.Time, headerBytes, bodyBytes int, err error) {
+	p.pack.observe(start, headerBytes, bodyBytes, err)
+}
+
+// ObserveUnpack records an Unpack.
+// NOTE:
+//  The start should be the time when the whole message is read from the connection,
+//  so that the time waiting for the next message is not counted;
+//  If start is zero, the duration is not counted.
+func (p *ProtoMetrics) ObserveUnpack(start time.Time, headerBytes, bodyBytes int, err error) {
+	p.unpack.observe(start, headerBytes, bodyBytes, err)
+}
+
+// Stats returns the snapshot of the statistics.
+func (p *ProtoMetrics) Stats() ProtoStats {
+	return ProtoStats{
+		Proto:  p.name,
+		Pack:   p.pack.snapshot(),
+		Unpack: p.unpack.snapshot(),
+	}
+}
+
+// Snapshot returns the statistics of all the protos, sorted by name.
+func Snapshot() []ProtoStats {
+	protoMap.mu.Lock()
+	all := make([]ProtoStats, 0, len(protoMap.m))
+	for _, p := range protoMap.m {
+		all = append(all, p.Stats())
+	}
+	protoMap.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Proto < all[j].Proto
+	})
+	return all
+}
+
+// WritePrometheus writes the statistics of all the protos in the Prometheus text format.
+func WritePrometheus(w io.Writer) error {
+	all := Snapshot()
+	var err error
+	write := func(format string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+	metric := func(name, typ, help string, value func(d Direction) float64) {
+		write("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, s := range all {
+			write("%s{proto=%q,op=\"pack\"} %v\n", name, s.Proto, value(s.Pack))
+			write("%s{proto=%q,op=\"unpack\"} %v\n", name, s.Proto, value(s.Unpack))
+		}
+	}
+	metric("teleport_proto_messages_total", "counter", "Number of messages packed or unpacked.",
+		func(d Direction) float64 { return float64(d.Count) })
+	metric("teleport_proto_header_bytes_total", "counter", "Bytes of the message headers.",
+		func(d Direction) float64 { return float64(d.HeaderBytes) })
+	metric("teleport_proto_body_bytes_total", "counter", "Bytes of the message bodies.",
+		func(d Direction) float64 { return float64(d.BodyBytes) })
+	metric("teleport_proto_duration_seconds_total", "counter", "Time spent packing or unpacking.",
+		func(d Direction) float64 { return d.Duration.Seconds() })
+	name := "teleport_proto_errors_total"
+	write("# HELP %s %s\n# TYPE %s counter\n", name, "Number of failed packs or unpacks by error kind.", name)
+	for _, s := range all {
+		for _, op := range []struct {
+			name string
+			d    Direction
+		}{{"pack", s.Pack}, {"unpack", s.Unpack}} {
+			kinds := make([]string, 0, len(op.d.Errors))
+			for kind := range op.d.Errors {
+				kinds = append(kinds, kind)
+			}
+			sort.Strings(kinds)
+			for _, kind := range kinds {
+				write("%s{proto=%q,op=%q,kind=%q} %d\n", name, s.Proto, op.name, kind, op.d.Errors[kind])
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package metrics_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/metrics"
+)
+
+type echo struct {
+	tp.CallCtx
+}
+
+func (e *echo) Say(arg *string) (string, *tp.Rerror) {
+	return *arg, nil
+}
+
+func TestRawProtoMetrics(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{ListenPort: 9078})
+	srv.RouteCall(new(echo))
+	go srv.ListenAndServe()
+	time.Sleep(time.Second)
+
+	cli := tp.NewPeer(tp.PeerConfig{})
+	defer cli.Close()
+	sess, rerr := cli.Dial(":9078")
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	var result string
+	rerr = sess.Call("/echo/say", "hello", &result).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+
+	var raw metrics.ProtoStats
+	for _, s := range metrics.Snapshot() {
+		if s.Proto == "raw" {
+			raw = s
+		}
+	}
+	if raw.Pack.Count < 2 || raw.Unpack.Count < 2 {
+		t.Fatalf("expect packs and unpacks, got %+v", raw)
+	}
+	if raw.Pack.HeaderBytes == 0 || raw.Pack.BodyBytes == 0 {
+		t.Fatalf("expect header and body bytes, got %+v", raw.Pack)
+	}
+
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `teleport_proto_messages_total{proto="raw",op="pack"}`) {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	p := metrics.Proto("test")
+	p.ObserveUnpack(time.Time{}, 0, 0, io.EOF)
+	p.ObserveUnpack(time.Time{}, 0, 0, errors.New("bad"))
+	p.ObservePack(time.Now(), 3, 4, nil)
+	s := p.Stats()
+	if s.Unpack.Errors[metrics.ErrKindEOF] != 1 || s.Unpack.Errors[metrics.ErrKindOther] != 1 {
+		t.Fatalf("unexpected errors: %v", s.Unpack.Errors)
+	}
+	if s.Pack.Count != 1 || s.Pack.HeaderBytes != 3 || s.Pack.BodyBytes != 4 {
+		t.Fatalf("unexpected pack: %+v", s.Pack)
+	}
+}
@@ -0,0 +1,297 @@
+// Package callcache is a client-side read-through cache of the CALL replies,
+// which are invalidated by the server pushes.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package callcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// InvalidateServiceMethod the reserved service method of the invalidation PUSH
+const InvalidateServiceMethod = "/callcache/invalidate"
+
+// Caller the backend which launches CALL, e.g. tp.Session or *multiclient.MultiClient
+type Caller interface {
+	AsyncCall(
+		serviceMethod string,
+		arg interface{},
+		result interface{},
+		callCmdChan chan<- tp.CallCmd,
+		setting ...tp.MessageSetting,
+	) tp.CallCmd
+}
+
+// Config cache config
+type Config struct {
+	// Routes the service methods whose replies are cached
+	Routes []string
+	// Size the maximum number of the cached replies, the least recently used one is evicted; default 1024
+	Size int
+	// TTL the maximum duration of a cached reply, in case an invalidation is lost; if <=0, no expiration
+	TTL time.Duration
+}
+
+// Invalidation the body of the invalidation PUSH
+type Invalidation struct {
+	// Route the service method whose cached replies are invalidated
+	Route string `json:"route"`
+	// Keys the argument keys of the invalidated replies, see ArgKey; if empty, the whole route
+	Keys []string `json:"keys,omitempty"`
+}
+
+// Cache the cache of the CALL replies.
+type Cache struct {
+	cfg     Config
+	routes  map[string]*routeGen
+	lru     *list.List // *entry, the front is the most recently used
+	entries map[string]*list.Element
+	mu      sync.Mutex
+}
+
+type routeGen struct {
+	gen  uint64 // increased by each invalidation of the whole route
+	keys map[string]uint64
+}
+
+type entry struct {
+	key    string // route + "\n" + arg key
+	reply  []byte // JSON
+	expire time.Time
+}
+
+var (
+	_ tp.PostNewPeerPlugin = new(Cache)
+	_ tp.PostDialPlugin    = new(Cache)
+)
+
+// New creates a cache.
+// NOTE:
+//  It should be registered as the plugin of the client peer to receive the invalidation pushes;
+//  Only one cache can be registered to a peer.
+func New(cfg Config) *Cache {
+	if cfg.Size <= 0 {
+		cfg.Size = 1024
+	}
+	c := &Cache{
+		cfg:     cfg,
+		routes:  make(map[string]*routeGen, len(cfg.Routes)),
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	for _, route := range cfg.Routes {
+		c.routes[route] = &routeGen{keys: make(map[string]uint64)}
+	}
+	return c
+}
+
+// Name returns name.
+func (c *Cache) Name() string {
+	return "callcache"
+}
+
+// PostNewPeer registers the invalidation handler.
+func (c *Cache) PostNewPeer(peer tp.EarlyPeer) error {
+	peer.SubRoute("/callcache").RoutePushFunc((*invalidatePush).invalidate)
+	return nil
+}
+
+// PostDial saves the cache for the invalidation handler.
+func (c *Cache) PostDial(sess tp.PreSession) *tp.Rerror {
+	sess.Swap().Store(swapKey, c)
+	return nil
+}
+
+// Client returns a client which caches the replies of the backend.
+func (c *Cache) Client(backend Caller) *Client {
+	return &Client{cache: c, backend: backend}
+}
+
+// Invalidate removes the cached replies of the route, by the argument keys;
+// if no key, removes all the replies of the route.
+func (c *Cache) Invalidate(route string, keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rg, ok := c.routes[route]
+	if !ok {
+		return
+	}
+	if len(keys) == 0 {
+		rg.gen++
+		for k, elem := range c.entries {
+			if strings.HasPrefix(k, route+"\n") {
+				c.lru.Remove(elem)
+				delete(c.entries, k)
+			}
+		}
+		return
+	}
+	for _, argKey := range keys {
+		rg.keys[argKey]++
+		k := route + "\n" + argKey
+		if elem, ok := c.entries[k]; ok {
+			c.lru.Remove(elem)
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Len returns the number of the cached replies.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// generation returns the generation of the reply, which is changed by the invalidations.
+func (c *Cache) generation(route, argKey string) (gen uint64, ok bool) {
+	rg, ok := c.routes[route]
+	if !ok {
+		return 0, false
+	}
+	return rg.gen + rg.keys[argKey], true
+}
+
+func (c *Cache) load(route, argKey string) ([]byte, bool) {
+	k := route + "\n" + argKey
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if !e.expire.IsZero() && time.Now().After(e.expire) {
+		c.lru.Remove(elem)
+		delete(c.entries, k)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return e.reply, true
+}
+
+// store caches the reply, unless the reply is invalidated during the CALL.
+func (c *Cache) store(route, argKey string, gen uint64, reply []byte) {
+	e := &entry{key: route + "\n" + argKey, reply: reply}
+	if c.cfg.TTL > 0 {
+		e.expire = time.Now().Add(c.cfg.TTL)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g, _ := c.generation(route, argKey); g != gen {
+		return
+	}
+	if elem, ok := c.entries[e.key]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.cfg.Size {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.entries, elem.Value.(*entry).key)
+	}
+}
+
+// Client the client which caches the replies of the designated routes.
+type Client struct {
+	cache   *Cache
+	backend Caller
+}
+
+// Call sends a message and receives reply, the reply of the designated route is cached by the argument.
+// NOTE:
+//  The result is decoded from the cached JSON on the hit, so it should be JSON-compatible;
+//  Only the successful replies are cached.
+func (c *Client) Call(serviceMethod string, arg interface{}, result interface{}, setting ...tp.MessageSetting) tp.CallCmd {
+	argKey, err := ArgKey(arg)
+	c.cache.mu.Lock()
+	gen, cacheable := c.cache.generation(serviceMethod, argKey)
+	c.cache.mu.Unlock()
+	if !cacheable || err != nil || result == nil {
+		callCmd := c.backend.AsyncCall(serviceMethod, arg, result, make(chan tp.CallCmd, 1), setting...)
+		<-callCmd.Done()
+		return callCmd
+	}
+	if reply, ok := c.cache.load(serviceMethod, argKey); ok {
+		if err = json.Unmarshal(reply, result); err == nil {
+			return tp.NewFakeCallCmd(serviceMethod, arg, result, nil)
+		}
+		tp.Warnf("callcache: decode %s: %v", serviceMethod, err)
+	}
+	callCmd := c.backend.AsyncCall(serviceMethod, arg, result, make(chan tp.CallCmd, 1), setting...)
+	<-callCmd.Done()
+	if callCmd.Rerror() != nil {
+		return callCmd
+	}
+	reply, err := json.Marshal(result)
+	if err != nil {
+		tp.Warnf("callcache: encode %s: %v", serviceMethod, err)
+		return callCmd
+	}
+	c.cache.store(serviceMethod, argKey, gen, reply)
+	return callCmd
+}
+
+// ArgKey returns the key of the CALL argument, which is the hash of its JSON.
+func ArgKey(arg interface{}) (string, error) {
+	b, err := json.Marshal(arg)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// Invalidate pushes the invalidation of the route to the client, by the CALL arguments;
+// if no argument, invalidates the whole route.
+// NOTE: It is used on the server side, e.g. broadcast by peer.RangeSession.
+func Invalidate(sess interface {
+	Push(serviceMethod string, arg interface{}, setting ...tp.MessageSetting) *tp.Rerror
+}, route string, args ...interface{}) *tp.Rerror {
+	inv := Invalidation{Route: route}
+	for _, arg := range args {
+		argKey, err := ArgKey(arg)
+		if err != nil {
+			return tp.NewRerror(tp.CodeBadMessage, tp.CodeText(tp.CodeBadMessage), err.Error())
+		}
+		inv.Keys = append(inv.Keys, argKey)
+	}
+	return sess.Push(InvalidateServiceMethod, &inv)
+}
+
+const swapKey = "callcache"
+
+type invalidatePush struct {
+	tp.PushCtx
+}
+
+func (i *invalidatePush) invalidate(inv *Invalidation) *tp.Rerror {
+	v, ok := i.Session().Swap().Load(swapKey)
+	if !ok {
+		return nil
+	}
+	v.(*Cache).Invalidate(inv.Route, inv.Keys...)
+	return nil
+}
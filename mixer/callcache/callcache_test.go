@@ -0,0 +1,85 @@
+package callcache_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/mixer/callcache"
+)
+
+var calls int32
+
+type Country struct {
+	tp.CallCtx
+}
+
+func (c *Country) Name(code *string) (string, *tp.Rerror) {
+	atomic.AddInt32(&calls, 1)
+	return "name of " + *code, nil
+}
+
+func (c *Country) Uncached(code *string) (string, *tp.Rerror) {
+	atomic.AddInt32(&calls, 1)
+	return *code, nil
+}
+
+func TestCallCache(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{ListenPort: 9085})
+	srv.RouteCall(new(Country))
+	go srv.ListenAndServe()
+	time.Sleep(500 * time.Millisecond)
+
+	cache := callcache.New(callcache.Config{
+		Routes: []string{"/country/name"},
+	})
+	sess, rerr := tp.NewPeer(tp.PeerConfig{}, cache).Dial(":9085")
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	cli := cache.Client(sess)
+
+	call := func(serviceMethod, code string, expectCalls int32) {
+		var result string
+		if rerr := cli.Call(serviceMethod, code, &result).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if n := atomic.LoadInt32(&calls); n != expectCalls {
+			t.Fatalf("%s(%s): expect %d calls, got %d", serviceMethod, code, expectCalls, n)
+		}
+		if serviceMethod == "/country/name" && result != "name of "+code {
+			t.Fatalf("expect %q, got %q", "name of "+code, result)
+		}
+	}
+	call("/country/name", "cn", 1)
+	call("/country/name", "cn", 1)
+	call("/country/name", "us", 2)
+	call("/country/uncached", "cn", 3)
+	call("/country/uncached", "cn", 4)
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("expect 2 cached replies, got %d", n)
+	}
+
+	// invalidate by the argument
+	srv.RangeSession(func(s tp.Session) bool {
+		if rerr := callcache.Invalidate(s, "/country/name", "cn"); rerr != nil {
+			t.Fatal(rerr)
+		}
+		return true
+	})
+	time.Sleep(100 * time.Millisecond)
+	call("/country/name", "cn", 5)
+	call("/country/name", "us", 5)
+
+	// invalidate the whole route
+	srv.RangeSession(func(s tp.Session) bool {
+		callcache.Invalidate(s, "/country/name")
+		return true
+	})
+	time.Sleep(100 * time.Millisecond)
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("expect 0 cached replies, got %d", n)
+	}
+	call("/country/name", "us", 6)
+}
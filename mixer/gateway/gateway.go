@@ -0,0 +1,134 @@
+// Package gateway forwards the HTTP requests to the CALLs, and streams the replies to the HTTP clients.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/codec"
+)
+
+// Caller the backend which launches the CALL whose reply is a stream, e.g. tp.Session
+type Caller interface {
+	CallStream(serviceMethod string, arg interface{}, newResult func() interface{}, setting ...tp.MessageSetting) *tp.ReplyIter
+}
+
+// NewHandler returns the HTTP handler which calls the service method with the JSON request body,
+// and streams the chunks of the reply to the HTTP client as they arrive, without buffering.
+// NOTE:
+//  The chunks are sent by the server-sent events if the client accepts text/event-stream,
+//  otherwise by the chunked encoding, one JSON per line;
+//  The next chunk is read after the previous one is flushed, so the slow HTTP client
+//  backpressures the replier by the window of the stream, see tp.WithStreamWindow;
+//  The CALL is canceled after the HTTP client goes away.
+func NewHandler(caller Caller, serviceMethod string, setting ...tp.MessageSetting) http.Handler {
+	return &handler{
+		caller:        caller,
+		serviceMethod: serviceMethod,
+		setting:       setting,
+	}
+}
+
+type handler struct {
+	caller        Caller
+	serviceMethod string
+	setting       []tp.MessageSetting
+}
+
+func newChunk() interface{} {
+	return new(json.RawMessage)
+}
+
+// ServeHTTP forwards the request, and streams the reply.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var arg json.RawMessage
+	if len(body) > 0 {
+		arg = body
+	}
+	setting := append(h.setting[:len(h.setting):len(h.setting)],
+		tp.WithBodyCodec(codec.ID_JSON),
+		tp.WithContext(r.Context()),
+	)
+	iter := h.caller.CallStream(h.serviceMethod, arg, newChunk, setting...)
+
+	// the status is decided by the first chunk
+	if !iter.Next() {
+		if rerr := iter.Rerror(); rerr != nil {
+			writeRerror(w, rerr)
+		}
+		return
+	}
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+	for more := true; more; more = iter.Next() {
+		chunk := *iter.Result().(*json.RawMessage)
+		if sse {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", chunk)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", chunk)
+		}
+		if err != nil {
+			// the client is gone, the CALL is canceled by the request context
+			return
+		}
+		flusher.Flush()
+	}
+	rerr := iter.Rerror()
+	if rerr == nil {
+		return
+	}
+	if sse {
+		b, _ := rerr.MarshalJSON()
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", b)
+		flusher.Flush()
+		return
+	}
+	// the chunked body is not terminated, so the client knows it is incomplete
+	panic(http.ErrAbortHandler)
+}
+
+// writeRerror writes the error of the CALL which fails before any chunk.
+func writeRerror(w http.ResponseWriter, rerr *tp.Rerror) {
+	status := int(rerr.Code)
+	if status < 400 || status > 599 {
+		status = http.StatusBadGateway
+	}
+	b, _ := rerr.MarshalJSON()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -0,0 +1,166 @@
+package gateway_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/mixer/gateway"
+)
+
+type Count struct {
+	N int
+}
+
+// counter returns the CALL handler which streams 0..N-1, or forever if N<=0,
+// and waits for the release after the first chunk.
+func counter(release <-chan struct{}, done chan<- *tp.Rerror) func(tp.CallCtx, *Count) (*int, *tp.Rerror) {
+	return func(ctx tp.CallCtx, arg *Count) (*int, *tp.Rerror) {
+		for i := 0; arg.N <= 0 || i < arg.N; i++ {
+			if i == 1 {
+				<-release
+			}
+			if rerr := ctx.SendStream(i); rerr != nil {
+				done <- rerr
+				return nil, rerr
+			}
+		}
+		done <- nil
+		return nil, nil
+	}
+}
+
+// backend the teleport server of the gateway.
+type backend struct {
+	release chan struct{}
+	done    chan *tp.Rerror
+	gateway *httptest.Server
+	srv     tp.Peer
+	cli     tp.Peer
+}
+
+func newBackend(t *testing.T, port uint16, setting ...tp.MessageSetting) *backend {
+	b := &backend{
+		release: make(chan struct{}),
+		done:    make(chan *tp.Rerror, 1),
+		srv:     tp.NewPeer(tp.PeerConfig{Network: "mem", ListenPort: port}),
+		cli:     tp.NewPeer(tp.PeerConfig{Network: "mem"}),
+	}
+	path := b.srv.RouteCallFunc(counter(b.release, b.done))
+	go b.srv.ListenAndServe()
+	sess, rerr := b.cli.Dial(":" + strconv.Itoa(int(port)))
+	if rerr != nil {
+		b.srv.Close()
+		t.Fatal(rerr)
+	}
+	b.gateway = httptest.NewServer(gateway.NewHandler(sess, path, setting...))
+	return b
+}
+
+func (b *backend) Close() {
+	b.gateway.Close()
+	b.cli.Close()
+	b.srv.Close()
+}
+
+func post(t *testing.T, url, body string, sse bool) *http.Response {
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sse {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHandler(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		sse  bool
+		line func(i int) string
+	}{
+		{"chunked", false, func(i int) string { return strconv.Itoa(i) + "\n" }},
+		{"sse", true, func(i int) string { return "data: " + strconv.Itoa(i) + "\n" }},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			port := uint16(9163)
+			if c.sse {
+				port = 9164
+			}
+			b := newBackend(t, port)
+			defer b.Close()
+			resp := post(t, b.gateway.URL, `{"N":3}`, c.sse)
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expect 200, got %d", resp.StatusCode)
+			}
+			r := bufio.NewReader(resp.Body)
+			readLine := func(want string) {
+				t.Helper()
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						t.Fatalf("expect %q, got %v", want, err)
+					}
+					if line == "\n" {
+						// the end of the event
+						continue
+					}
+					if line != want {
+						t.Fatalf("expect %q, got %q", want, line)
+					}
+					return
+				}
+			}
+			// the first chunk is received while the handler is still running
+			readLine(c.line(0))
+			close(b.release)
+			readLine(c.line(1))
+			readLine(c.line(2))
+			if rerr := <-b.done; rerr != nil {
+				t.Fatal(rerr)
+			}
+		})
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	b := newBackend(t, 9165)
+	defer b.Close()
+	// the arg can not be decoded
+	resp := post(t, b.gateway.URL, `"x"`, false)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expect 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerCancel(t *testing.T) {
+	b := newBackend(t, 9166, tp.WithStreamWindow(1))
+	defer b.Close()
+	close(b.release)
+	resp := post(t, b.gateway.URL, `{}`, false)
+	if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	// the endless CALL is canceled after the client goes away
+	resp.Body.Close()
+	select {
+	case rerr := <-b.done:
+		if rerr == nil {
+			t.Fatal("expect the handler canceled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the handler canceled after the client goes away")
+	}
+}
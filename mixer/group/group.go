@@ -0,0 +1,193 @@
+// Package group is a session group which pushes messages to a large number of sessions,
+// with a concurrency limit, weighted ordering and a partial-failure report.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package group
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// DefaultConcurrency the default maximum number of concurrent pushes
+const DefaultConcurrency = 1024
+
+// Group a set of sessions with weights
+type Group struct {
+	members map[string]*member
+	rwmu    sync.RWMutex
+}
+
+type member struct {
+	sess   tp.Session
+	weight int
+}
+
+// New creates a session group.
+func New() *Group {
+	return &Group{members: make(map[string]*member)}
+}
+
+// Add adds the session to the group, or updates its weight.
+// NOTE:
+//  The sessions with higher weight are pushed earlier, the default weight is 0;
+//  The session is keyed by its ID.
+func (g *Group) Add(sess tp.Session, weight ...int) {
+	m := &member{sess: sess}
+	if len(weight) > 0 {
+		m.weight = weight[0]
+	}
+	g.rwmu.Lock()
+	g.members[sess.ID()] = m
+	g.rwmu.Unlock()
+}
+
+// Remove removes the session from the group by the session ID.
+func (g *Group) Remove(sessID string) {
+	g.rwmu.Lock()
+	delete(g.members, sessID)
+	g.rwmu.Unlock()
+}
+
+// Len returns the number of the sessions in the group.
+func (g *Group) Len() int {
+	g.rwmu.RLock()
+	defer g.rwmu.RUnlock()
+	return len(g.members)
+}
+
+// snapshot returns the members ordered by weight descending.
+func (g *Group) snapshot() []*member {
+	g.rwmu.RLock()
+	members := make([]*member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, m)
+	}
+	g.rwmu.RUnlock()
+	sort.SliceStable(members, func(i, j int) bool {
+		return members[i].weight > members[j].weight
+	})
+	return members
+}
+
+// PushOptions the options of group push
+type PushOptions struct {
+	// Concurrency the maximum number of concurrent pushes; if <=0, use DefaultConcurrency.
+	Concurrency int
+	// RetryOnce whether to retry the failed pushes once, after all the sessions are pushed.
+	// NOTE: The sessions skipped as closed are not retried.
+	RetryOnce bool
+	// Setting the message settings of each push
+	Setting []tp.MessageSetting
+}
+
+// Report the result of group push
+type Report struct {
+	// Succeeded the IDs of the sessions pushed successfully
+	Succeeded []string
+	// Failed the errors of the sessions failed to be pushed, keyed by session ID
+	Failed map[string]*tp.Rerror
+	// SkippedClosed the IDs of the sessions skipped since the connection was closed
+	SkippedClosed []string
+	// Retried the number of the retried pushes
+	Retried int
+	mu      sync.Mutex
+}
+
+// Total returns the number of the sessions involved.
+func (r *Report) Total() int {
+	return len(r.Succeeded) + len(r.Failed) + len(r.SkippedClosed)
+}
+
+// FailedCodes returns the number of the failed pushes by error code.
+func (r *Report) FailedCodes() map[int32]int {
+	codes := make(map[int32]int, 4)
+	for _, rerr := range r.Failed {
+		codes[rerr.Code]++
+	}
+	return codes
+}
+
+// Push pushes the message to all the sessions in the group, and returns the report.
+// NOTE: The sessions added or removed during the push are not affected.
+func (g *Group) Push(serviceMethod string, arg interface{}, opt *PushOptions) *Report {
+	if opt == nil {
+		opt = new(PushOptions)
+	}
+	report := &Report{Failed: make(map[string]*tp.Rerror)}
+	members := g.snapshot()
+	failed := fanOut(members, serviceMethod, arg, opt, report)
+	if opt.RetryOnce && len(failed) > 0 {
+		report.Retried = len(failed)
+		for _, m := range failed {
+			delete(report.Failed, m.sess.ID())
+		}
+		fanOut(failed, serviceMethod, arg, opt, report)
+	}
+	return report
+}
+
+// fanOut pushes to the members concurrently, and returns the failed ones.
+func fanOut(members []*member, serviceMethod string, arg interface{}, opt *PushOptions, report *Report) []*member {
+	concurrency := opt.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	if concurrency > len(members) {
+		concurrency = len(members)
+	}
+	var (
+		next   int64 = -1
+		failed []*member
+		wg     sync.WaitGroup
+	)
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		tp.AnywayGo(func() {
+			defer wg.Done()
+			for {
+				idx := int(atomic.AddInt64(&next, 1))
+				if idx >= len(members) {
+					return
+				}
+				m := members[idx]
+				id := m.sess.ID()
+				if !m.sess.Health() {
+					report.mu.Lock()
+					report.SkippedClosed = append(report.SkippedClosed, id)
+					report.mu.Unlock()
+					continue
+				}
+				rerr := m.sess.Push(serviceMethod, arg, opt.Setting...)
+				report.mu.Lock()
+				switch {
+				case rerr == nil:
+					report.Succeeded = append(report.Succeeded, id)
+				case rerr.Code == tp.CodeConnClosed:
+					report.SkippedClosed = append(report.SkippedClosed, id)
+				default:
+					report.Failed[id] = rerr
+					failed = append(failed, m)
+				}
+				report.mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return failed
+}
@@ -0,0 +1,71 @@
+package group_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/mixer/group"
+)
+
+var received int32
+
+type notice struct {
+	tp.PushCtx
+}
+
+func (n *notice) Hello(arg *string) *tp.Rerror {
+	atomic.AddInt32(&received, 1)
+	return nil
+}
+
+func TestGroupPush(t *testing.T) {
+	g := group.New()
+	srv := tp.NewPeer(tp.PeerConfig{ListenPort: 9077}, &joinPlugin{g: g})
+	go srv.ListenAndServe()
+	time.Sleep(time.Second)
+
+	cli := tp.NewPeer(tp.PeerConfig{})
+	defer cli.Close()
+	cli.RoutePush(new(notice))
+	for i := 0; i < 10; i++ {
+		if _, rerr := cli.Dial(":9077"); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	if g.Len() != 10 {
+		t.Fatalf("expect 10 sessions, got %d", g.Len())
+	}
+	var closed string
+	srv.RangeSession(func(sess tp.Session) bool {
+		closed = sess.ID()
+		sess.Close()
+		return false
+	})
+
+	report := g.Push("/notice/hello", "hi", &group.PushOptions{Concurrency: 3, RetryOnce: true})
+	t.Logf("succeeded: %d, failed: %v, skipped: %v", len(report.Succeeded), report.Failed, report.SkippedClosed)
+	if report.Total() != 10 || len(report.Succeeded) != 9 || len(report.Failed) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(report.SkippedClosed) != 1 || report.SkippedClosed[0] != closed {
+		t.Fatalf("expect skipped %s, got %v", closed, report.SkippedClosed)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if n := atomic.LoadInt32(&received); n != 9 {
+		t.Fatalf("expect 9 received, got %d", n)
+	}
+}
+
+type joinPlugin struct {
+	g *group.Group
+}
+
+func (j *joinPlugin) Name() string { return "join" }
+
+func (j *joinPlugin) PostAccept(sess tp.PreSession) *tp.Rerror {
+	j.g.Add(sess.(tp.Session))
+	return nil
+}
@@ -0,0 +1,225 @@
+// Package hedge is a client which hedges the idempotent CALLs across multiple backends to reduce tail latency.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package hedge
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// Caller the backend which launches CALL, e.g. tp.Session or *multiclient.MultiClient
+type Caller interface {
+	AsyncCall(
+		serviceMethod string,
+		arg interface{},
+		result interface{},
+		callCmdChan chan<- tp.CallCmd,
+		setting ...tp.MessageSetting,
+	) tp.CallCmd
+}
+
+// Config hedging config
+type Config struct {
+	// Percentile the latency percentile used as the hedging delay, default 0.95
+	Percentile float64
+	// MinDelay the lower limit of the hedging delay, default 1ms
+	MinDelay time.Duration
+	// MaxDelay the upper limit of the hedging delay, default 1s;
+	// it is also used before enough latency samples are collected
+	MaxDelay time.Duration
+	// SampleSize the number of latency samples, default 1000
+	SampleSize int
+	// IsIdempotent reports whether the CALL is idempotent, only the idempotent CALLs are hedged;
+	// if nil, no CALL is hedged
+	IsIdempotent func(serviceMethod string) bool
+}
+
+// minSamples the number of samples required before using the percentile delay
+const minSamples = 20
+
+func (c *Config) check() {
+	if c.Percentile <= 0 || c.Percentile >= 1 {
+		c.Percentile = 0.95
+	}
+	if c.MinDelay <= 0 {
+		c.MinDelay = time.Millisecond
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = time.Second
+	}
+	if c.MaxDelay < c.MinDelay {
+		c.MaxDelay = c.MinDelay
+	}
+	if c.SampleSize < minSamples {
+		c.SampleSize = 1000
+	}
+}
+
+// Client hedging client
+type Client struct {
+	cfg      Config
+	backends []Caller
+	next     uint32
+	samples  []time.Duration
+	cursor   int
+	count    int
+	delay    int64
+	mu       sync.Mutex
+}
+
+// New creates a hedging client.
+// NOTE:
+//  If a CALL has not completed within the percentile-based delay,
+//  a duplicate is issued to the next backend, the first reply is taken,
+//  and the other one is canceled by its own context.
+func New(cfg Config, backends ...Caller) *Client {
+	cfg.check()
+	return &Client{
+		cfg:      cfg,
+		backends: backends,
+		samples:  make([]time.Duration, 0, cfg.SampleSize),
+		delay:    int64(cfg.MaxDelay),
+	}
+}
+
+// Delay returns the current hedging delay.
+func (c *Client) Delay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.delay))
+}
+
+// Call sends a message and receives reply, it is hedged if the CALL is idempotent.
+// NOTE: If the arg is []byte or *[]byte type, it can automatically fill in the body codec name.
+func (c *Client) Call(serviceMethod string, arg interface{}, result interface{}, setting ...tp.MessageSetting) tp.CallCmd {
+	n := len(c.backends)
+	if n == 0 {
+		return tp.NewFakeCallCmd(serviceMethod, arg, result, tp.NewRerror(tp.CodeDialFailed, tp.CodeText(tp.CodeDialFailed), "no backend"))
+	}
+	i := int(atomic.AddUint32(&c.next, 1) % uint32(n))
+	if n < 2 || c.cfg.IsIdempotent == nil || !c.cfg.IsIdempotent(serviceMethod) {
+		start := time.Now()
+		callCmd := c.backends[i].AsyncCall(serviceMethod, arg, result, make(chan tp.CallCmd, 1), setting...)
+		<-callCmd.Done()
+		c.record(callCmd, start)
+		return callCmd
+	}
+
+	// the competitors write to their own results, and are canceled by their own contexts
+	doneCh := make(chan tp.CallCmd, 2)
+	parent := contextOf(setting)
+	var cancels []context.CancelFunc
+	// cancel the loser once the first reply is taken
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	attempt := func(backend Caller) tp.CallCmd {
+		ctx, cancel := context.WithCancel(parent)
+		cancels = append(cancels, cancel)
+		return backend.AsyncCall(serviceMethod, arg, newResult(result), doneCh,
+			append(setting[:len(setting):len(setting)], tp.WithContext(ctx))...)
+	}
+	start := time.Now()
+	attempt(c.backends[i])
+	timer := time.NewTimer(c.Delay())
+	defer timer.Stop()
+	var winner tp.CallCmd
+	select {
+	case winner = <-doneCh:
+		c.record(winner, start)
+	case <-timer.C:
+		hedgeStart := time.Now()
+		hedged := attempt(c.backends[(i+1)%n])
+		winner = <-doneCh
+		if winner.Rerror() != nil {
+			// the other one may still succeed
+			winner = <-doneCh
+		}
+		if winner == hedged {
+			start = hedgeStart
+			tp.Debugf("hedge: the hedged reply of %s wins", serviceMethod)
+		}
+		c.record(winner, start)
+	}
+	if winner.Rerror() == nil && result != nil {
+		reply, _ := winner.Reply()
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(reply).Elem())
+	}
+	return &hedgedCallCmd{CallCmd: winner, result: result}
+}
+
+// contextOf returns the context set by tp.WithContext, or context.Background() if not set.
+func contextOf(setting []tp.MessageSetting) context.Context {
+	m := tp.GetMessage(setting...)
+	defer tp.PutMessage(m)
+	return m.Context()
+}
+
+func newResult(result interface{}) interface{} {
+	if result == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(result).Elem()).Interface()
+}
+
+func (c *Client) record(callCmd tp.CallCmd, start time.Time) {
+	if callCmd.Rerror() != nil {
+		return
+	}
+	cost := time.Since(start)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) < cap(c.samples) {
+		c.samples = append(c.samples, cost)
+	} else {
+		c.samples[c.cursor] = cost
+		c.cursor = (c.cursor + 1) % len(c.samples)
+	}
+	// refresh the delay per 10 samples
+	c.count++
+	if len(c.samples) < minSamples || c.count%10 != 0 {
+		return
+	}
+	sorted := append([]time.Duration(nil), c.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	delay := sorted[int(float64(len(sorted)-1)*c.cfg.Percentile)]
+	if delay < c.cfg.MinDelay {
+		delay = c.cfg.MinDelay
+	} else if delay > c.cfg.MaxDelay {
+		delay = c.cfg.MaxDelay
+	}
+	atomic.StoreInt64(&c.delay, int64(delay))
+}
+
+type hedgedCallCmd struct {
+	tp.CallCmd
+	result interface{}
+}
+
+// Reply returns the call reply.
+// NOTE:
+//  Inside, <-Done() is automatically called and blocked,
+//  until the call is completed!
+func (h *hedgedCallCmd) Reply() (interface{}, *tp.Rerror) {
+	<-h.Done()
+	return h.result, h.Rerror()
+}
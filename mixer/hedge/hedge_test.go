@@ -0,0 +1,114 @@
+package hedge_test
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/mixer/hedge"
+)
+
+type Echo struct {
+	tp.CallCtx
+}
+
+func (e *Echo) Do(arg *string) (string, *tp.Rerror) {
+	if e.Peer().(tp.Peer) == slowPeer {
+		select {
+		case <-e.Context().Done():
+			atomic.AddInt32(&slowCanceled, 1)
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+	return *arg, nil
+}
+
+var (
+	slowPeer     tp.Peer
+	slowCanceled int32
+)
+
+func dial(t *testing.T, port uint16, slow bool) tp.Session {
+	srv := tp.NewPeer(tp.PeerConfig{ListenPort: port})
+	if slow {
+		slowPeer = srv
+	}
+	srv.RouteCall(new(Echo))
+	go srv.ListenAndServe()
+	time.Sleep(500 * time.Millisecond)
+	sess, rerr := tp.NewPeer(tp.PeerConfig{}).Dial(":" + strconv.Itoa(int(port)))
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return sess
+}
+
+func TestHedge(t *testing.T) {
+	slow := dial(t, 9075, true)
+	fast := dial(t, 9076, false)
+
+	var idempotent bool
+	cli := hedge.New(hedge.Config{
+		MaxDelay:     50 * time.Millisecond,
+		IsIdempotent: func(string) bool { return idempotent },
+	}, slow, fast)
+
+	// hedged
+	idempotent = true
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		var result string
+		rerr := cli.Call("/echo/do", "hello", &result).Rerror()
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		if result != "hello" {
+			t.Fatalf("expect hello, got %s", result)
+		}
+		if cost := time.Since(start); cost > 200*time.Millisecond {
+			t.Fatalf("hedged call is too slow: %v", cost)
+		}
+	}
+
+	// not hedged
+	idempotent = false
+	var slowest time.Duration
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		var result string
+		if rerr := cli.Call("/echo/do", "hello", &result).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if cost := time.Since(start); cost > slowest {
+			slowest = cost
+		}
+	}
+	if slowest < 300*time.Millisecond {
+		t.Fatalf("expect a non-idempotent call to wait for the slow backend, got %v", slowest)
+	}
+}
+
+func TestHedgeCancel(t *testing.T) {
+	slow := dial(t, 9157, true)
+	fast := dial(t, 9158, false)
+	// negotiate the CANCEL with the slow backend
+	if rerr := slow.Call("/echo/do", "hello", new(string)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	cli := hedge.New(hedge.Config{
+		MaxDelay:     50 * time.Millisecond,
+		IsIdempotent: func(string) bool { return true },
+	}, slow, fast)
+	// one of them is sent to the slow backend first
+	for i := 0; i < 2; i++ {
+		if rerr := cli.Call("/echo/do", "hello", new(string)).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&slowCanceled) == 0 {
+		t.Fatal("expect the losing call canceled on the slow backend")
+	}
+}
@@ -74,7 +74,10 @@ func (j *jsonSubProto) Pack(m tp.Message) error {
 
 	b := goutil.StringToBytes(s)
 
-	m.SetSize(uint32(len(b)))
+	// set and check size
+	if err = m.SetSize(uint32(len(b))); err != nil {
+		return err
+	}
 
 	_, err = j.rw.Write(b)
 	return err
@@ -90,7 +93,9 @@ func (j *jsonSubProto) Unpack(m tp.Message) error {
 		return err
 	}
 
-	m.SetSize(uint32(len(b)))
+	if err = m.SetSize(uint32(len(b))); err != nil {
+		return err
+	}
 
 	s := goutil.BytesToString(b)
 
@@ -60,7 +60,10 @@ func (psp *pbSubProto) Pack(m tp.Message) error {
 		return err
 	}
 
-	m.SetSize(uint32(len(b)))
+	// set and check size
+	if err = m.SetSize(uint32(len(b))); err != nil {
+		return err
+	}
 
 	_, err = psp.rw.Write(b)
 	return err
@@ -76,7 +79,9 @@ func (psp *pbSubProto) Unpack(m tp.Message) error {
 		return err
 	}
 
-	m.SetSize(uint32(len(b)))
+	if err = m.SetSize(uint32(len(b))); err != nil {
+		return err
+	}
 
 	s := &pb.Payload{}
 	err = codec.ProtoUnmarshal(b, s)
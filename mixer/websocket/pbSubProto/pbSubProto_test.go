@@ -0,0 +1,67 @@
+package pbSubProto_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	ws "github.com/mylonly/teleport/mixer/websocket"
+	"github.com/mylonly/teleport/mixer/websocket/pbSubProto"
+)
+
+// listened signals that the server is listening.
+type listened chan struct{}
+
+func (listened) Name() string {
+	return "listened"
+}
+
+func (l listened) PostListen(net.Addr) error {
+	close(l)
+	return nil
+}
+
+// pingRecorder records the meta of the PING messages read by the peer.
+type pingRecorder chan string
+
+func (pingRecorder) Name() string {
+	return "ping_recorder"
+}
+
+func (r pingRecorder) PostReadControl(ctx tp.ReadCtx) *tp.Rerror {
+	if ctx.Input().Mtype() == tp.TypePing {
+		r <- string(ctx.PeekMeta("X-Test"))
+	}
+	return nil
+}
+
+func TestControl(t *testing.T) {
+	ready, pings := make(listened), make(pingRecorder, 1)
+	srv := ws.NewServer("/", tp.PeerConfig{ListenPort: 9162}, ready, pings)
+	defer srv.Close()
+	go srv.ListenAndServe(pbSubProto.NewPbSubProtoFunc())
+	<-ready
+
+	cli := ws.NewClient("/", tp.PeerConfig{})
+	defer cli.Close()
+	sess, rerr := cli.Dial(":9162", pbSubProto.NewPbSubProtoFunc())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	// negotiate the control messages by the first messages, no route is needed
+	if rerr = sess.Call("/unknown", nil, nil).Rerror(); rerr == nil || rerr.Code != tp.CodeNotFound {
+		t.Fatalf("expect CodeNotFound, got %v", rerr)
+	}
+	if rerr = sess.Control(tp.TypePing, tp.WithSetMeta("X-Test", "ping")); rerr != nil {
+		t.Fatal(rerr)
+	}
+	select {
+	case v := <-pings:
+		if v != "ping" {
+			t.Fatalf("expect the meta X-Test=ping, got X-Test=%s", v)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the PING is not read as a control message")
+	}
+}
@@ -29,9 +29,14 @@ var defaultProto = jsonSubProto.NewJSONSubProtoFunc()
 // NewWsProtoFunc wraps a protocol to a new websocket protocol.
 func NewWsProtoFunc(subProto ...tp.ProtoFunc) tp.ProtoFunc {
 	return func(rw tp.IOWithReadBuffer) socket.Proto {
-		conn, ok := rw.(socket.Socket).Raw().(*ws.Conn)
-		if !ok {
-			tp.Warnf("connection does not support websocket protocol")
+		var conn *ws.Conn
+		if s, ok := rw.(socket.Socket); ok {
+			if conn, ok = s.Raw().(*ws.Conn); !ok {
+				tp.Warnf("connection does not support websocket protocol")
+			}
+		}
+		// not a websocket connection, e.g. probed in memory, use the sub-protocol
+		if conn == nil {
 			if len(subProto) > 0 {
 				return subProto[0](rw)
 			}
@@ -10,6 +10,28 @@ const (
 	TypeAuthReply byte = 5
 )
 
+// Control message types, reserved for the framework-internal control messages.
+// NOTE:
+//  The range is [TypeControlMin, 255];
+//  Control messages are not routed to user handlers, but are visible to plugins;
+//  Unsupported control messages are ignored instead of disconnecting.
+const (
+	TypeControlMin byte = 0x80
+	TypePing       byte = 0x80
+	TypeCancel     byte = 0x81
+	TypeGoaway     byte = 0x82
+	TypeCredit     byte = 0x83
+	TypeUpgrade    byte = 0x84
+	TypeUpgradeAck byte = 0x85
+	TypePong       byte = 0x86
+	TypeHello      byte = 0x87
+)
+
+// IsControlType returns whether the message type is a framework-internal control type.
+func IsControlType(typ byte) bool {
+	return typ >= TypeControlMin
+}
+
 // TypeText returns the message type text.
 // If the type is undefined returns 'Undefined'.
 func TypeText(typ byte) string {
@@ -24,7 +46,26 @@ func TypeText(typ byte) string {
 		return "AUTH_CALL"
 	case TypeAuthReply:
 		return "AUTH_REPLY"
+	case TypePing:
+		return "PING"
+	case TypeCancel:
+		return "CANCEL"
+	case TypeGoaway:
+		return "GOAWAY"
+	case TypeCredit:
+		return "CREDIT"
+	case TypeUpgrade:
+		return "UPGRADE"
+	case TypeUpgradeAck:
+		return "UPGRADE_ACK"
+	case TypePong:
+		return "PONG"
+	case TypeHello:
+		return "HELLO"
 	default:
+		if IsControlType(typ) {
+			return "CONTROL"
+		}
 		return "Undefined"
 	}
 }
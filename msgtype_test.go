@@ -0,0 +1,127 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/proto/httproto"
+	"github.com/mylonly/teleport/proto/jsonproto"
+	"github.com/mylonly/teleport/proto/pbproto"
+	"github.com/mylonly/teleport/proto/rawproto"
+)
+
+type readControl struct {
+	mtype  byte
+	meta   string // the value of the X-Test meta
+	reason string // the reason of GOAWAY
+}
+
+// controlRecorder records the control messages read by the peer.
+type controlRecorder chan readControl
+
+func (controlRecorder) Name() string {
+	return "control_recorder"
+}
+
+func (r controlRecorder) PostReadControl(ctx tp.ReadCtx) *tp.Rerror {
+	r <- readControl{
+		mtype:  ctx.Input().Mtype(),
+		meta:   string(ctx.PeekMeta("X-Test")),
+		reason: string(ctx.PeekMeta(tp.MetaGoawayReason)),
+	}
+	return nil
+}
+
+// wait returns the first recorded control message of the mtype.
+func (r controlRecorder) wait(t *testing.T, mtype byte) readControl {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case c := <-r:
+			if c.mtype == mtype {
+				return c
+			}
+		case <-timeout:
+			t.Fatalf("no %s message is read", tp.TypeText(mtype))
+		}
+	}
+}
+
+func TestControl(t *testing.T) {
+	var (
+		path   string
+		srvCtl = make(controlRecorder, 16)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.PluginContainer().AppendRight(srvCtl)
+		path = srv.RouteCallFunc(echo_call)
+	})
+	defer peers.Close()
+	sess := peers.sess
+
+	rerr := sess.Control(tp.TypeCall)
+	if rerr == nil || rerr.Code != tp.CodeMtypeNotAllowed {
+		t.Fatalf("control with TypeCall: expect CodeMtypeNotAllowed, got %v", rerr)
+	}
+	// not sent before the negotiation
+	rerr = sess.Control(tp.TypePing)
+	if rerr == nil || rerr.Code != tp.CodeMtypeNotAllowed {
+		t.Fatalf("control before the negotiation: expect CodeMtypeNotAllowed, got %v", rerr)
+	}
+	var arg = 1
+	if rerr = sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if rerr = sess.Control(0xF0, tp.WithSetMeta("X-Test", "unknown")); rerr != nil {
+		t.Fatalf("control unknown: %v", rerr)
+	}
+	if c := srvCtl.wait(t, 0xF0); c.meta != "unknown" {
+		t.Fatalf("want the meta X-Test=unknown, have X-Test=%s", c.meta)
+	}
+	// the unknown control messages are ignored
+	if rerr = sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatalf("session should not be disconnected by control messages: %v", rerr)
+	}
+}
+
+func TestControlProtos(t *testing.T) {
+	protos := []struct {
+		name      string
+		protoFunc tp.ProtoFunc
+	}{
+		{"raw", rawproto.NewRawProtoFunc()},
+		{"json", jsonproto.NewJSONProtoFunc()},
+		{"pb", pbproto.NewPbProtoFunc()},
+		{"http", httproto.NewHTTProtoFunc()},
+	}
+	for _, p := range protos {
+		t.Run(p.name, func(t *testing.T) {
+			var (
+				path           string
+				srvCtl, cliCtl = make(controlRecorder, 16), make(controlRecorder, 16)
+			)
+			peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+				srv.PluginContainer().AppendRight(srvCtl)
+				cli.PluginContainer().AppendRight(cliCtl)
+				path = srv.RouteCallFunc(echo_call)
+			}, p.protoFunc)
+			defer peers.Close()
+
+			// negotiate the control messages by the first messages
+			var arg = 1
+			if rerr := peers.sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+				t.Fatal(rerr)
+			}
+			if rerr := peers.sess.Control(tp.TypePing, tp.WithSetMeta("X-Test", "ping")); rerr != nil {
+				t.Fatal(rerr)
+			}
+			if c := srvCtl.wait(t, tp.TypePing); c.meta != "ping" {
+				t.Fatalf("want the meta X-Test=ping, have X-Test=%s", c.meta)
+			}
+			// answered by the server
+			cliCtl.wait(t, tp.TypePong)
+		})
+	}
+}
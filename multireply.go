@@ -0,0 +1,147 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync"
+
+	"github.com/mylonly/teleport/socket"
+	"github.com/mylonly/teleport/utils"
+)
+
+// ReplyIter the iterator of the parts of the reply to a CALL, see Session.CallMulti.
+// For example:
+//  iter := sess.CallMulti("/user/list", arg, func() interface{} { return new([]User) })
+//  for iter.Next() {
+//  	users := iter.Result().(*[]User)
+//  }
+//  if rerr := iter.Rerror(); rerr != nil {
+//  	...
+//  }
+type ReplyIter struct {
+	cmd       *callCmd
+	newResult func() interface{}
+	parts     []interface{}
+	result    interface{}
+	ended     bool
+	lastEnds  bool       // the last reply only ends the iteration, without the result, see CallStream
+	flow      *replyFlow // only for CallStream
+	mu        sync.Mutex
+	cond      *sync.Cond
+}
+
+// CallMulti sends a message and receives the parts of the reply by the iterator,
+// which are sent by CallCtx.ReplyPart and ended by the reply returned by the handler.
+// NOTE:
+//  newResult creates the result which each part is decoded into;
+//  The parts are queued until read, so the reading is never blocked by the iterator.
+func (s *session) CallMulti(serviceMethod string, arg interface{}, newResult func() interface{}, setting ...MessageSetting) *ReplyIter {
+	iter := &ReplyIter{newResult: newResult}
+	iter.cond = sync.NewCond(&iter.mu)
+	iter.cmd = s.asyncCall(serviceMethod, arg, nil, make(chan CallCmd, 1), iter, setting...)
+	return iter
+}
+
+// Next waits for the next part of the reply, returns false after the last one or a failure.
+func (it *ReplyIter) Next() bool {
+	it.mu.Lock()
+	for len(it.parts) == 0 && !it.ended {
+		it.cond.Wait()
+	}
+	if len(it.parts) == 0 {
+		it.result = nil
+		it.mu.Unlock()
+		return false
+	}
+	it.result = it.parts[0]
+	it.parts[0] = nil
+	it.parts = it.parts[1:]
+	var credit int
+	if it.flow != nil && !it.ended {
+		credit = it.flow.onRead()
+	}
+	it.mu.Unlock()
+	if credit > 0 {
+		// the replier waits for the credits after the window is full
+		it.flow.grant(credit)
+	}
+	return true
+}
+
+// Result returns the current part of the reply, which is created by newResult.
+func (it *ReplyIter) Result() interface{} {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.result
+}
+
+// Rerror waits for the end of the call, and returns the call error.
+func (it *ReplyIter) Rerror() *Rerror {
+	return it.CallCmd().Rerror()
+}
+
+// CallCmd waits for the end of the call, and returns the command of it.
+func (it *ReplyIter) CallCmd() CallCmd {
+	<-it.cmd.Done()
+	return it.cmd
+}
+
+func (it *ReplyIter) push(result interface{}) {
+	it.mu.Lock()
+	it.parts = append(it.parts, result)
+	it.mu.Unlock()
+	it.cond.Signal()
+}
+
+func (it *ReplyIter) end() {
+	if it == nil {
+		return
+	}
+	it.mu.Lock()
+	it.ended = true
+	it.mu.Unlock()
+	it.cond.Broadcast()
+}
+
+// ReplyPart sends a part of the reply before the handler returns the last one,
+// e.g. a page of the query results, which is received by Session.CallMulti.
+// NOTE: It fails if the caller does not accept the multiple replies, e.g. by Session.Call.
+func (c *handlerCtx) ReplyPart(result interface{}) *Rerror {
+	if len(c.input.Meta().Peek(MetaAcceptMultiReply)) == 0 {
+		return rerrMultiReplyRefused
+	}
+	return c.writePart(result)
+}
+
+// writePart writes the part of the reply, see ReplyPart and StreamCtx.Send.
+func (c *handlerCtx) writePart(result interface{}) *Rerror {
+	output := socket.GetMessage(
+		socket.WithMtype(TypeReply),
+		socket.WithContext(c.output.Context()),
+		socket.WithBodyCodec(c.ReplyBodyCodec()),
+		socket.WithBody(result),
+	)
+	defer socket.PutMessage(output)
+	output.SetSeq(c.input.Seq())
+	output.Meta().Set(MetaReplyPart, "1")
+	output.XferPipe().AppendFrom(c.output.XferPipe())
+	_, rerr := c.sess.write(output)
+	return rerr
+}
+
+// isReplyPart returns whether the reply is followed by more parts.
+func isReplyPart(meta *utils.Args) bool {
+	return len(meta.Peek(MetaReplyPart)) > 0
+}
@@ -0,0 +1,51 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func pages_call(ctx tp.CallCtx, arg *int) ([]int, *tp.Rerror) {
+	for i := 0; i < *arg-1; i++ {
+		if rerr := ctx.ReplyPart([]int{i}); rerr != nil {
+			return nil, rerr
+		}
+	}
+	return []int{*arg - 1}, nil
+}
+
+func TestCallMulti(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(pages_call)
+	})
+	defer p.Close()
+	var arg = 100
+	iter := p.sess.CallMulti("/pages/call", &arg, func() interface{} { return new([]int) })
+	var got []int
+	for iter.Next() {
+		got = append(got, *iter.Result().(*[]int)...)
+	}
+	rerr := iter.Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if len(got) != arg {
+		t.Fatalf("expect %d parts, got %d", arg, len(got))
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("expect the parts in order, got %v", got)
+		}
+	}
+
+	// the multiple replies are refused by Call
+	var result []int
+	if rerr = p.sess.Call("/pages/call", &arg, &result).Rerror(); rerr == nil || rerr.Code != tp.CodeBadMessage {
+		t.Fatalf("expect the multiple replies refused, got %v", rerr)
+	}
+	arg = 1
+	if rerr = p.sess.Call("/pages/call", &arg, &result).Rerror(); rerr != nil || len(result) != 1 {
+		t.Fatalf("expect the single reply, got %v %v", result, rerr)
+	}
+}
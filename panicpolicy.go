@@ -0,0 +1,134 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync/atomic"
+
+	"github.com/henrylee2cn/goutil"
+)
+
+// PanicAction the action taken when a handler panics.
+type PanicAction int8
+
+const (
+	// PanicReply replies the CALL with CodeInternalServerError, and continues.
+	PanicReply PanicAction = iota
+	// PanicCloseSession replies the CALL with CodeInternalServerError, and closes the session.
+	PanicCloseSession
+	// PanicQuarantine replies the CALL with CodeInternalServerError, and disables the route
+	// after PanicPolicy.MaxPanics panics, which then replies CodeServiceUnavailable until released.
+	PanicQuarantine
+)
+
+// PanicPolicy the policy of handling the panics of a handler.
+type PanicPolicy struct {
+	// Action the action taken when the handler panics.
+	Action PanicAction
+	// MaxPanics the number of the panics after which the route is quarantined;
+	// only for PanicQuarantine; default 1.
+	MaxPanics int32
+}
+
+// panicPolicies the default and per-route panic policies of a peer,
+// and the states of the routes.
+type panicPolicies struct {
+	def         atomic.Value // PanicPolicy
+	routes      goutil.Map   // serviceMethod -> PanicPolicy
+	counts      goutil.Map   // serviceMethod -> *int32, the number of the panics
+	quarantined goutil.Map   // serviceMethod -> struct{}
+}
+
+func newPanicPolicies() *panicPolicies {
+	p := &panicPolicies{
+		routes:      goutil.AtomicMap(),
+		counts:      goutil.AtomicMap(),
+		quarantined: goutil.AtomicMap(),
+	}
+	p.def.Store(PanicPolicy{Action: PanicReply})
+	return p
+}
+
+func (p *panicPolicies) get(serviceMethod string) PanicPolicy {
+	if policy, ok := p.routes.Load(serviceMethod); ok {
+		return policy.(PanicPolicy)
+	}
+	return p.def.Load().(PanicPolicy)
+}
+
+// onPanic counts the panic of the route, and returns the action taken.
+func (p *panicPolicies) onPanic(serviceMethod string) PanicAction {
+	policy := p.get(serviceMethod)
+	if policy.Action != PanicQuarantine {
+		return policy.Action
+	}
+	v, _ := p.counts.LoadOrStore(serviceMethod, new(int32))
+	n := atomic.AddInt32(v.(*int32), 1)
+	max := policy.MaxPanics
+	if max <= 0 {
+		max = 1
+	}
+	if n >= max {
+		if _, loaded := p.quarantined.LoadOrStore(serviceMethod, struct{}{}); !loaded {
+			Errorf("route is quarantined after %d panics: %s", n, serviceMethod)
+		}
+	}
+	return policy.Action
+}
+
+func (p *panicPolicies) isQuarantined(serviceMethod string) bool {
+	if p.quarantined.Len() == 0 {
+		return false
+	}
+	_, ok := p.quarantined.Load(serviceMethod)
+	return ok
+}
+
+// release lifts the quarantine of the route, and resets the number of its panics.
+func (p *panicPolicies) release(serviceMethod string) {
+	p.quarantined.Delete(serviceMethod)
+	p.counts.Delete(serviceMethod)
+}
+
+// quarantinedRoutes returns the quarantined routes.
+func (p *panicPolicies) quarantinedRoutes() []string {
+	var routes []string
+	p.quarantined.Range(func(key, _ interface{}) bool {
+		routes = append(routes, key.(string))
+		return true
+	})
+	return routes
+}
+
+// SetPanicPolicy sets the default policy of handling the panics of the handlers.
+func (p *peer) SetPanicPolicy(policy PanicPolicy) {
+	p.panicPolicies.def.Store(policy)
+}
+
+// SetRoutePanicPolicy sets the policy of handling the panics of the handler for the service method,
+// which overrides the default one.
+func (p *peer) SetRoutePanicPolicy(serviceMethod string, policy PanicPolicy) {
+	p.panicPolicies.routes.Store(serviceMethod, policy)
+}
+
+// QuarantinedRoutes returns the service methods disabled by the PanicQuarantine policy.
+func (p *peer) QuarantinedRoutes() []string {
+	return p.panicPolicies.quarantinedRoutes()
+}
+
+// ReleaseRoute re-enables the service method disabled by the PanicQuarantine policy.
+func (p *peer) ReleaseRoute(serviceMethod string) {
+	p.panicPolicies.release(serviceMethod)
+}
@@ -0,0 +1,42 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestPanicPolicy(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(panic_call)
+		srv.RoutePushFunc(panic_push)
+		srv.SetRoutePanicPolicy("/panic/call", tp.PanicPolicy{Action: tp.PanicQuarantine, MaxPanics: 2})
+		srv.SetRoutePanicPolicy("/panic/push", tp.PanicPolicy{Action: tp.PanicCloseSession})
+	})
+	defer p.Close()
+	expect := func(code int32) {
+		t.Helper()
+		rerr := p.sess.Call("/panic/call", nil, nil).Rerror()
+		if rerr == nil || rerr.Code != code {
+			t.Fatalf("/panic/call: expect code %d, got %v", code, rerr)
+		}
+	}
+	expect(tp.CodeInternalServerError)
+	expect(tp.CodeInternalServerError)
+	expect(tp.CodeServiceUnavailable)
+	if routes := p.srv.QuarantinedRoutes(); len(routes) != 1 || routes[0] != "/panic/call" {
+		t.Fatalf("expect /panic/call quarantined, got %v", routes)
+	}
+	p.srv.ReleaseRoute("/panic/call")
+	expect(tp.CodeInternalServerError)
+
+	if rerr := p.sess.Push("/panic/push", nil); rerr != nil {
+		t.Fatalf("/panic/push: %v", rerr)
+	}
+	select {
+	case <-p.sess.CloseNotify():
+	case <-time.After(3 * time.Second):
+		t.Fatal("expect the session closed after /panic/push panics")
+	}
+}
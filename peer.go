@@ -18,18 +18,22 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
+	"net/url"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/mylonly/teleport/quic"
-
 	"github.com/henrylee2cn/goutil"
 	"github.com/henrylee2cn/goutil/coarsetime"
 	"github.com/henrylee2cn/goutil/errors"
 	"github.com/mylonly/teleport/codec"
+	"github.com/mylonly/teleport/store"
 )
 
 type (
@@ -37,20 +41,79 @@ type (
 	BasePeer interface {
 		// Close closes peer.
 		Close() (err error)
+		// Context returns the lifecycle context of the peer, which is canceled when the peer is closed or draining.
+		Context() context.Context
 		// CountSession returns the number of sessions.
 		CountSession() int
 		// GetSession gets the session by id.
 		GetSession(sessionID string) (Session, bool)
 		// RangeSession ranges all sessions. If fn returns false, stop traversing.
 		RangeSession(fn func(sess Session) bool)
+		// FindSessions returns the sessions which have all the labels of the selector,
+		// e.g. {"user": "42"} or {"region": "eu", "role": "admin"}.
+		// NOTE: It looks up the index of the labels, instead of ranging all the sessions.
+		FindSessions(selector map[string]string) []Session
+		// Broadcast pushes the message to all the sessions, and returns the errors of the failed ones by session ID.
+		// NOTE: The body is marshaled once, and written to the sessions concurrently.
+		Broadcast(serviceMethod string, arg interface{}, setting ...MessageSetting) map[string]*Rerror
+		// Multicast pushes the message to the sessions of the IDs, and returns the errors of the failed ones by session ID.
+		// NOTE: The body is marshaled once, and written to the sessions concurrently.
+		Multicast(sessionIDs []string, serviceMethod string, arg interface{}, setting ...MessageSetting) map[string]*Rerror
 		// SetTLSConfig sets the TLS config.
 		SetTLSConfig(tlsConfig *tls.Config)
 		// SetTLSConfigFromFile sets the TLS config from file.
+		// NOTE: The certificate is reloaded when the files change, without restart.
 		SetTLSConfigFromFile(tlsCertFile, tlsKeyFile string, insecureSkipVerifyForClient ...bool) error
+		// SetTLSCertificateLoader sets the loader of the certificate called on each TLS handshake,
+		// e.g. autocert.Manager.GetCertificate, so that the certificate can be renewed without restart.
+		// NOTE: It should be called before listening.
+		SetTLSCertificateLoader(loader func(*tls.ClientHelloInfo) (*tls.Certificate, error))
 		// TLSConfig returns the TLS config.
 		TLSConfig() *tls.Config
+		// SetResolver sets the resolver of the dial address host, e.g. a *CachedResolver.
+		// NOTE: If nil, the host is resolved by the system on each dialing.
+		SetResolver(resolver Resolver)
+		// SetSocketControl sets the hook which is called after creating the TCP socket
+		// and before binding or connecting it, like net.ListenConfig.Control.
+		SetSocketControl(control func(network, address string, c syscall.RawConn) error)
+		// SetBuildInfo sets the build info which is sent to the remote peer when the session is established.
+		// NOTE: It should be called before listening or dialing; if not called, no build info is sent.
+		SetBuildInfo(info BuildInfo)
 		// PluginContainer returns the global plugin container.
 		PluginContainer() *PluginContainer
+		// Store returns the key-value store for session-adjacent state,
+		// such as resume tokens, reliable-push journals and dedup caches.
+		Store() store.Store
+		// StageStats returns the statistics of the inbound message processing stages.
+		StageStats() StageStats
+		// DumpState writes the snapshot of the peer state in JSON to w,
+		// including the config, the sessions, the in-flight CALLs, the queues and the plugin stats.
+		// NOTE: It does not stop the peer, so it is safe to run under load.
+		DumpState(w io.Writer) error
+		// SetLogPolicy sets the default policy of printing the access logs.
+		SetLogPolicy(policy LogPolicy)
+		// SetRouteLogPolicy sets the policy of printing the access logs for the service method,
+		// which overrides the default one.
+		SetRouteLogPolicy(serviceMethod string, policy LogPolicy)
+		// SetPanicPolicy sets the default policy of handling the panics of the handlers.
+		SetPanicPolicy(policy PanicPolicy)
+		// SetRoutePanicPolicy sets the policy of handling the panics of the handler for the service method,
+		// which overrides the default one.
+		SetRoutePanicPolicy(serviceMethod string, policy PanicPolicy)
+		// QuarantinedRoutes returns the service methods disabled by the PanicQuarantine policy.
+		QuarantinedRoutes() []string
+		// ReleaseRoute re-enables the service method disabled by the PanicQuarantine policy.
+		ReleaseRoute(serviceMethod string)
+		// RouteConcurrencyStats returns the statistics of the concurrency limit of the CALL route,
+		// which is set by WithRouteConcurrency.
+		RouteConcurrencyStats(serviceMethod string) (RouteConcurrencyStats, bool)
+		// SetSessionSyncer sets the syncer of the state of the sessions,
+		// and the node name of the peer which is exported with the sessions.
+		// NOTE: It should be called before dialing or serving.
+		SetSessionSyncer(node string, syncer SessionSyncer)
+		// SetMessageInspector sets the hook of the messages read and written by the sessions.
+		// NOTE: It should be called before dialing or serving.
+		SetMessageInspector(inspector MessageInspector)
 	}
 	// EarlyPeer the communication peer that has just been created
 	EarlyPeer interface {
@@ -59,6 +122,8 @@ type (
 		Router() *Router
 		// SubRoute adds handler group.
 		SubRoute(pathPrefix string, plugin ...Plugin) *SubRouter
+		// SubRouteVersion adds the handler group of the version, see WithVersion.
+		SubRouteVersion(version string, plugin ...Plugin) *SubRouter
 		// RouteCall registers CALL handlers, and returns the paths.
 		RouteCall(ctrlStruct interface{}, plugin ...Plugin) []string
 		// RouteCallFunc registers CALL handler, and returns the path.
@@ -67,23 +132,52 @@ type (
 		RoutePush(ctrlStruct interface{}, plugin ...Plugin) []string
 		// RoutePushFunc registers PUSH handler, and returns the path.
 		RoutePushFunc(pushHandleFunc interface{}, plugin ...Plugin) string
+		// RouteStream registers the STREAM handler of the service method, and returns the path.
+		RouteStream(serviceMethod string, fn func(StreamCtx) *Rerror, plugin ...Plugin) string
+		// RouteCallBulk registers the bulk CALL handler of the service method,
+		// which is selected instead of the routed handler when the message size exceeds the threshold.
+		RouteCallBulk(serviceMethod string, threshold uint32, fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin)
+		// RoutePushBulk registers the bulk PUSH handler of the service method,
+		// which is selected instead of the routed handler when the message size exceeds the threshold.
+		RoutePushBulk(serviceMethod string, threshold uint32, fn func(UnknownPushCtx) *Rerror, plugin ...Plugin)
 		// SetUnknownCall sets the default handler, which is called when no handler for CALL is found.
 		SetUnknownCall(fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin)
 		// SetUnknownPush sets the default handler, which is called when no handler for PUSH is found.
 		SetUnknownPush(fn func(UnknownPushCtx) *Rerror, plugin ...Plugin)
+		// Unroute removes the CALL and PUSH handlers of the service method, and reports whether any is removed.
+		Unroute(serviceMethod string) bool
 	}
 	// Peer the communication peer which is server or client role
 	Peer interface {
 		EarlyPeer
 		// ListenAndServe turns on the listening service.
 		ListenAndServe(protoFunc ...ProtoFunc) error
+		// SetALPNProtoFunc registers the ProtoFunc of the ALPN protocol name,
+		// which is selected instead of the one of ListenAndServe,
+		// when the client negotiates the protocol in the TLS handshake.
+		// NOTE: It should be called before listening.
+		SetALPNProtoFunc(protocol string, protoFunc ProtoFunc)
 		// Dial connects with the peer of the destination address.
+		// NOTE: The address may be a comma-separated list, the first successful connection is kept.
 		Dial(addr string, protoFunc ...ProtoFunc) (Session, *Rerror)
+		// DialAddrs connects with the peer of any of the destination addresses,
+		// the first successful connection is kept.
+		DialAddrs(addrs []string, protoFunc ...ProtoFunc) (Session, *Rerror)
 		// ServeConn serves the connection and returns a session.
 		// NOTE:
 		//  Not support automatically redials after disconnection;
 		//  Execute the PostAcceptPlugin plugins.
 		ServeConn(conn net.Conn, protoFunc ...ProtoFunc) (Session, error)
+		// Drain stops accepting new connections, drains all the sessions with the timeout,
+		// and then closes the peer.
+		// NOTE:
+		//  Execute the PreShutdownPlugin plugins first;
+		//  The reason is sent to the sessions by GOAWAY, the default is "draining".
+		Drain(timeout time.Duration, reason ...string) error
+		// HandleSignals drains the peer when receiving one of the signals, the default are SIGINT and SIGTERM.
+		// The returned channel receives the result of the shutdown.
+		// NOTE: Do not use it together with GraceSignal.
+		HandleSignals(timeout time.Duration, sig ...os.Signal) <-chan error
 	}
 )
 
@@ -97,31 +191,84 @@ type peer struct {
 	router          *Router
 	pluginContainer *PluginContainer
 	sessHub         *SessionHub
+	store           store.Store
+	preprocessor    *preprocessor
+	scheduler       *scheduler // if nil, no limit on the running handlers
 	closeCh         chan struct{}
+	closeOnce       sync.Once
+	ctx             context.Context
+	cancelCtx       context.CancelFunc
 	// freeContext       *handlerCtx
 	// ctxLock           sync.Mutex
 	defaultSessionAge time.Duration // Default session max age, if less than or equal to 0, no time limit
+	idleTimeout       time.Duration // Default idle timeout of the sessions, if less than or equal to 0, no idle limit
 	defaultContextAge time.Duration // Default CALL or PUSH context max age, if less than or equal to 0, no time limit
+	callTimeout       time.Duration // Default maximum duration of a CALL launched without the context, if less than or equal to 0, no limit
+	retryAfter        time.Duration // the hint attached to the rejections, if less than or equal to 0, no hint
+	overloadRetries   int           // if <=0, no retry
+	pushWriteTimeout  time.Duration // Default maximum duration for writing a PUSH launched by the session, if less than or equal to 0, no limit
+	heartbeatInterval time.Duration // if <=0, no heartbeat
+	compressCooldown  time.Duration // if <=0, the compression filters are never disabled
+	handshakeTimeout  time.Duration // if <=0, no limit
+	heartbeatTimeout  time.Duration
+	handshakeExpired  uint64       // the number of the connections closed by the handshake timeout
+	buildInfo         atomic.Value // []byte, the JSON of the local build info
 	tlsConfig         *tls.Config
+	resolver          Resolver // nil means resolving by the system
 	slowCometDuration time.Duration
 	defaultBodyCodec  byte
-	printDetail       bool
+	rerrorCodec       byte
+	idGenerator       IDGenerator // nil means the address-derived session ID
+	msgIDGenerator    IDGenerator // nil means no message ID
+	logPolicies       *logPolicies
+	panicPolicies     *panicPolicies
+	metaLimit         *metaLimit
+	inspector         MessageInspector
+	msgUnpackLimit    int // if <=0, no limit
+	sessUnpackLimit   int // if <=0, no limit
+	metaGzipThreshold int // if <=0, never compress
+	writeMsgRate      int // if <=0, no limit
+	writeByteRate     int // if <=0, no limit
+	sessHandlerLimit  int // if <=0, no limit
+	sessHandlerQueue  int
+	inflightLimiter   *concurrencyLimiter // of the CALLs launched by all the sessions, nil means no limit
+	sessInflight      int
+	inflightWait      bool
+	channelWindow     int
+	writeQueueSize    int // if <=0, no limit
+	writeQueuePolicy  int8
+	compressPoorRatio float64
 	countTime         bool
+	sniffBodyCodec    bool
 	timeNow           func() time.Time
 	timeSince         func(time.Time) time.Duration
 	mu                sync.Mutex
+	cfg               PeerConfig // for the state dump
+	stateDumpDir      string     // if empty, no dump on the fatal error
 
-	network string
+	network  string
+	wsPath   string // only for ws and wss network
+	sockOpts SocketOptions
 
 	// only for client role
 	defaultDialTimeout time.Duration
+	dialAttemptTimeout time.Duration
+	dialRaceDelay      time.Duration // if <=0, the addresses are tried in order
 	redialInterval     time.Duration
 	redialTimes        int32
 	localAddr          net.Addr
+	dialProxy          *url.URL // nil means dialing directly
+	dialProxyEnv       bool     // whether the proxy is chosen by the environment variables
+	dialFamily         int8
+	dialSourceIPs      []net.IP
 
 	// only for server role
-	listenAddr string
-	listeners  map[net.Listener]struct{}
+	listenAddr     string
+	listenURLs     []listenURL
+	listeners      map[net.Listener]struct{}
+	proxyProtocol  bool
+	alpnProtoFuncs map[string]ProtoFunc
+	alpnProtocols  []string // in order of preference
 }
 
 // NewPeer creates a new peer.
@@ -139,18 +286,56 @@ func NewPeer(cfg PeerConfig, globalLeftPlugin ...Plugin) Peer {
 		pluginContainer:    pluginContainer,
 		sessHub:            newSessionHub(),
 		defaultSessionAge:  cfg.DefaultSessionAge,
+		idleTimeout:        cfg.DefaultIdleTimeout,
 		defaultContextAge:  cfg.DefaultContextAge,
+		callTimeout:        cfg.DefaultCallTimeout,
+		retryAfter:         cfg.RetryAfter,
+		overloadRetries:    cfg.OverloadRetries,
+		pushWriteTimeout:   cfg.DefaultPushWriteTimeout,
+		heartbeatInterval:  cfg.HeartbeatInterval,
+		heartbeatTimeout:   cfg.HeartbeatTimeout,
+		handshakeTimeout:   cfg.HandshakeTimeout,
+		metaGzipThreshold:  cfg.MetaCompressThreshold,
+		compressCooldown:   cfg.CompressCooldown,
+		compressPoorRatio:  cfg.CompressPoorRatio,
+		metaLimit:          newMetaLimit(&cfg),
+		writeMsgRate:       cfg.WriteMsgRate,
+		writeByteRate:      cfg.WriteByteRate,
+		sessHandlerLimit:   cfg.SessionHandlerLimit,
+		sessHandlerQueue:   cfg.SessionHandlerQueue,
+		sessInflight:       cfg.SessionInflightCalls,
+		inflightLimiter:    newInflightLimiter(cfg.MaxInflightCalls),
+		inflightWait:       cfg.InflightCallsWait,
+		channelWindow:      cfg.ChannelWindow,
+		writeQueueSize:     cfg.WriteQueueSize,
+		sniffBodyCodec:     cfg.SniffBodyCodec,
+		cfg:                cfg,
+		stateDumpDir:       cfg.StateDumpDir,
 		closeCh:            make(chan struct{}),
 		slowCometDuration:  cfg.slowCometDuration,
 		defaultDialTimeout: cfg.DefaultDialTimeout,
+		dialAttemptTimeout: cfg.DialAttemptTimeout,
+		dialRaceDelay:      cfg.DialRaceDelay,
 		redialInterval:     cfg.RedialInterval,
 		network:            cfg.Network,
+		wsPath:             cfg.WebsocketPath,
 		listenAddr:         cfg.listenAddrStr,
+		listenURLs:         cfg.listenURLs,
+		proxyProtocol:      cfg.ProxyProtocol,
+		sockOpts:           cfg.socketOptions(),
 		localAddr:          cfg.localAddr,
-		printDetail:        cfg.PrintDetail,
+		dialProxy:          cfg.dialProxy,
+		dialProxyEnv:       cfg.DialProxy == DialProxyFromEnvironment,
+		dialFamily:         cfg.dialFamily,
+		dialSourceIPs:      cfg.dialSourceIPs,
+		logPolicies:        newLogPolicies(cfg.PrintDetail),
+		panicPolicies:      newPanicPolicies(),
 		countTime:          cfg.CountTime,
 		redialTimes:        cfg.RedialTimes,
 		listeners:          make(map[net.Listener]struct{}),
+		preprocessor:       newPreprocessor(cfg.PreprocessWorkers, cfg.PreprocessQueue),
+		msgUnpackLimit:     cfg.MessageUnpackLimit,
+		sessUnpackLimit:    cfg.SessionUnpackLimit,
 	}
 
 	if c, err := codec.GetByName(cfg.DefaultBodyCodec); err != nil {
@@ -158,6 +343,39 @@ func NewPeer(cfg PeerConfig, globalLeftPlugin ...Plugin) Peer {
 	} else {
 		p.defaultBodyCodec = c.ID()
 	}
+	if s, err := newScheduler(cfg.HandlerWorkers, cfg.HandlerQueue, cfg.HandlerSchedule); err != nil {
+		Fatalf("%v", err)
+	} else {
+		p.scheduler = s
+	}
+	if policy, err := parseWriteQueuePolicy(cfg.WriteQueuePolicy); err != nil {
+		Fatalf("%v", err)
+	} else {
+		p.writeQueuePolicy = policy
+	}
+	if c, err := GetRerrorCodecByName(cfg.RerrorCodec); err != nil {
+		Fatalf("%v", err)
+	} else {
+		p.rerrorCodec = c.ID()
+	}
+	if len(cfg.IDGenerator) > 0 {
+		if g, err := NewIDGenerator(cfg.IDGenerator); err != nil {
+			Fatalf("%v", err)
+		} else {
+			p.idGenerator = g
+		}
+	}
+	if cfg.MessageID {
+		p.msgIDGenerator = p.idGenerator
+		if p.msgIDGenerator == nil {
+			p.msgIDGenerator = IDGeneratorFunc(NewUUIDv7)
+		}
+	}
+	if s, err := store.OpenURI(cfg.SessionStore); err != nil {
+		Fatalf("%v", err)
+	} else {
+		p.store = s
+	}
 	if p.countTime {
 		p.timeNow = time.Now
 		p.timeSince = time.Since
@@ -166,16 +384,36 @@ func NewPeer(cfg PeerConfig, globalLeftPlugin ...Plugin) Peer {
 		p.timeNow = func() time.Time { return t0 }
 		p.timeSince = func(time.Time) time.Duration { return 0 }
 	}
+	p.ctx, p.cancelCtx = context.WithCancel(context.Background())
 	addPeer(p)
 	p.pluginContainer.postNewPeer(p)
 	return p
 }
 
+// Context returns the lifecycle context of the peer, which is canceled when the peer is closed or draining.
+func (p *peer) Context() context.Context {
+	return p.ctx
+}
+
 // PluginContainer returns the global plugin container.
 func (p *peer) PluginContainer() *PluginContainer {
 	return p.pluginContainer
 }
 
+// Store returns the key-value store for session-adjacent state,
+// such as resume tokens, reliable-push journals and dedup caches.
+func (p *peer) Store() store.Store {
+	return p.store
+}
+
+// StageStats returns the statistics of the inbound message processing stages.
+func (p *peer) StageStats() StageStats {
+	stats := p.preprocessor.stats()
+	p.scheduler.stats(&stats)
+	stats.HandshakeTimeouts = atomic.LoadUint64(&p.handshakeExpired)
+	return stats
+}
+
 // TLSConfig returns the TLS config.
 func (p *peer) TLSConfig() *tls.Config {
 	return p.tlsConfig
@@ -186,11 +424,25 @@ func (p *peer) SetTLSConfig(tlsConfig *tls.Config) {
 	p.tlsConfig = tlsConfig
 }
 
+// SetResolver sets the resolver of the dial address host, e.g. a *CachedResolver.
+// NOTE: If nil, the host is resolved by the system on each dialing.
+func (p *peer) SetResolver(resolver Resolver) {
+	p.resolver = resolver
+}
+
 // SetTLSConfigFromFile sets the TLS config from file.
+// NOTE: The certificate is reloaded when the files change, without restart.
 func (p *peer) SetTLSConfigFromFile(tlsCertFile, tlsKeyFile string, insecureSkipVerifyForClient ...bool) error {
-	var err error
-	p.tlsConfig, err = NewTLSConfigFromFile(tlsCertFile, tlsKeyFile, insecureSkipVerifyForClient...)
-	return err
+	reloader, err := newCertReloader(tlsCertFile, tlsKeyFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig := newTLSConfig(*reloader.cert, insecureSkipVerifyForClient...)
+	tlsConfig.Certificates = nil
+	tlsConfig.GetCertificate = reloader.GetCertificate
+	tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	p.tlsConfig = tlsConfig
+	return nil
 }
 
 // GetSession gets the session by id.
@@ -212,27 +464,142 @@ func (p *peer) CountSession() int {
 }
 
 // Dial connects with the peer of the destination address.
+// NOTE: The address may be a comma-separated list, the first successful connection is kept.
 func (p *peer) Dial(addr string, protoFunc ...ProtoFunc) (Session, *Rerror) {
 	return p.newSessionForClient(func() (net.Conn, error) {
-		if p.network == "quic" {
-			ctx := context.Background()
-			if p.defaultDialTimeout > 0 {
-				ctx, _ = context.WithTimeout(ctx, p.defaultDialTimeout)
+		return p.dial(addr)
+	}, addr, protoFunc)
+}
+
+// DialAddrs connects with the peer of any of the destination addresses,
+// the first successful connection is kept.
+func (p *peer) DialAddrs(addrs []string, protoFunc ...ProtoFunc) (Session, *Rerror) {
+	return p.Dial(strings.Join(addrs, ","), protoFunc...)
+}
+
+// dial connects with the addresses, the hosts are resolved by the resolver if set.
+// NOTE: The resolved addresses are tried in order until one succeeds, or raced if DialRaceDelay is set.
+func (p *peer) dial(addr string) (net.Conn, error) {
+	addrs := splitDialAddrs(addr)
+	resolver := p.resolver
+	if resolver == nil && isHostNetwork(p.network) &&
+		(p.dialRaceDelay > 0 || p.dialFamily != dialFamilyAny || len(p.dialSourceIPs) > 0) {
+		// races the IPs of the host, or chooses them by the address family
+		resolver = net.DefaultResolver
+	}
+	if resolver == nil && len(addrs) == 1 {
+		return p.dialAddr(addrs[0], p.tlsConfig, p.defaultDialTimeout)
+	}
+	var (
+		targets []dialTarget
+		err     error
+	)
+	if resolver == nil {
+		for _, a := range addrs {
+			targets = append(targets, dialTarget{addr: a, tlsConfig: p.tlsConfig})
+		}
+	} else {
+		ctx := context.Background()
+		if p.defaultDialTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, p.defaultDialTimeout)
+			defer cancel()
+		}
+		for _, a := range addrs {
+			host, resolved, rerr := resolveDialAddrs(ctx, resolver, a)
+			if rerr == nil && len(resolved) == 0 {
+				rerr = &net.DNSError{Err: "no such host", Name: host}
+			}
+			if rerr != nil {
+				err = rerr
+				continue
 			}
-			if p.tlsConfig == nil {
-				return quic.DialAddrContext(ctx, addr, &tls.Config{InsecureSkipVerify: true}, nil)
+			tlsConfig := p.tlsConfig
+			if tlsConfig != nil && len(tlsConfig.ServerName) == 0 && net.ParseIP(host) == nil {
+				// verify the certificate by the host name, instead of the resolved IP
+				tlsConfig = tlsConfig.Clone()
+				tlsConfig.ServerName = host
+			}
+			for _, r := range resolved {
+				targets = append(targets, dialTarget{addr: r, tlsConfig: tlsConfig})
 			}
-			return quic.DialAddrContext(ctx, addr, p.tlsConfig, nil)
 		}
-		d := &net.Dialer{
-			LocalAddr: p.localAddr,
-			Timeout:   p.defaultDialTimeout,
+	}
+	if targets = p.sortTargets(targets); len(targets) == 0 {
+		if err == nil {
+			err = &net.AddrError{Err: "no address of the dial family", Addr: addr}
 		}
-		if p.tlsConfig != nil {
-			return tls.DialWithDialer(d, p.network, addr, p.tlsConfig)
+		return nil, err
+	}
+	return p.dialTargets(targets)
+}
+
+func (p *peer) dialAddr(addr string, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if p.network == "mem" {
+		return dialMem(addr, timeout, tlsConfig)
+	}
+	if p.network == "quic" {
+		ctx := context.Background()
+		if timeout > 0 {
+			ctx, _ = context.WithTimeout(ctx, timeout)
 		}
-		return d.Dial(p.network, addr)
-	}, addr, protoFunc)
+		return dialQuic(ctx, addr, tlsConfig)
+	}
+	d := &net.Dialer{
+		LocalAddr: p.dialLocalAddr(addr),
+		Timeout:   timeout,
+	}
+	if isWebsocketNetwork(p.network) {
+		return dialWebsocket(func(network, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+			return p.dialStream(d, network, addr, tlsConfig)
+		}, p.network, addr, p.wsPath, tlsConfig)
+	}
+	return p.dialStream(d, p.network, addr, tlsConfig)
+}
+
+// dialStream connects with the address through the proxy if set,
+// and then tunes the connection before the TLS handshake.
+func (p *peer) dialStream(d *net.Dialer, network, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	d.Control = p.sockOpts.Control
+	var (
+		conn     net.Conn
+		err      error
+		proxyURL = p.dialProxy
+	)
+	if p.dialProxyEnv {
+		if proxyURL, err = proxyFromEnvironment(addr, tlsConfig != nil); err != nil {
+			return nil, err
+		}
+	}
+	if proxyURL != nil {
+		conn, err = dialProxy(d, proxyURL, network, addr)
+	} else {
+		conn, err = d.Dial(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.sockOpts.tune(conn)
+	if tlsConfig == nil {
+		return conn, nil
+	}
+	if len(tlsConfig.ServerName) == 0 {
+		host, _, _ := net.SplitHostPort(addr)
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if d.Timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(d.Timeout))
+	}
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if d.Timeout > 0 {
+		tlsConn.SetDeadline(time.Time{})
+	}
+	return tlsConn, nil
 }
 
 type redialTimes int32
@@ -271,6 +638,8 @@ func (p *peer) newSessionForClient(dialFunc func() (net.Conn, error), addr strin
 		return nil, rerr
 	}
 	var sess = newSession(p, conn, protoFuncs)
+	// the sequences of the client role are odd
+	sess.seq = -1
 
 	// create redial func
 	if p.redialTimes != 0 {
@@ -301,9 +670,14 @@ func (p *peer) newSessionForClient(dialFunc func() (net.Conn, error), addr strin
 		}
 	}
 
-	sess.socket.SetID(sess.LocalAddr().String())
-	if rerr := p.pluginContainer.postDial(sess); rerr != nil {
-		sess.Close()
+	if p.idGenerator == nil {
+		sess.socket.SetID(sess.LocalAddr().String())
+	}
+	ctx, cancel := sess.hookContext(p.defaultDialTimeout)
+	rerr := p.pluginContainer.postDial(ctx, sess)
+	cancel()
+	if rerr != nil {
+		sess.close(CloseRejected, rerr.ToError(), true)
 		return nil, rerr
 	}
 	AnywayGo(sess.startReadAndHandle)
@@ -333,8 +707,14 @@ func (p *peer) renewSessionForClient(sess *session, dialFunc func() (net.Conn, e
 		sess.socket.SetID(oldID)
 	}
 	atomic.StoreInt32(&sess.status, statusOk)
-	if rerr := p.pluginContainer.postDial(sess); rerr != nil {
-		sess.Close()
+	// the remote peer may have been upgraded, renegotiate
+	sess.resetFeatures()
+	sess.SetWriteLimit(0)
+	ctx, cancel := sess.hookContext(p.defaultDialTimeout)
+	rerr := p.pluginContainer.postDial(ctx, sess)
+	cancel()
+	if rerr != nil {
+		sess.close(CloseRejected, rerr.ToError(), true)
 		return rerr.ToError()
 	}
 	AnywayGo(sess.startReadAndHandle)
@@ -349,14 +729,17 @@ func (p *peer) renewSessionForClient(sess *session, dialFunc func() (net.Conn, e
 func (p *peer) ServeConn(conn net.Conn, protoFunc ...ProtoFunc) (Session, error) {
 	network := conn.LocalAddr().Network()
 	if strings.Contains(network, "udp") {
-		if _, ok := conn.(*quic.Conn); !ok {
+		if !isQuicConn(conn) {
 			return nil, fmt.Errorf("invalid network: %s,\nrefer to the following: tcp, tcp4, tcp6, unix, unixpacket or quic", network)
 		}
 		network = "quic"
 	}
 	var sess = newSession(p, conn, protoFunc)
-	if rerr := p.pluginContainer.postAccept(sess); rerr != nil {
-		sess.Close()
+	ctx, cancel := sess.hookContext(0)
+	rerr := p.pluginContainer.postAccept(ctx, sess)
+	cancel()
+	if rerr != nil {
+		sess.close(CloseRejected, rerr.ToError(), true)
 		return nil, rerr.ToError()
 	}
 	Infof("serve ok (network:%s, addr:%s, id:%s)", network, sess.RemoteAddr().String(), sess.ID())
@@ -372,11 +755,15 @@ var ErrListenClosed = errors.New("listener is closed")
 // NOTE: The caller ensures that the listener supports graceful shutdown.
 func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
 	defer lis.Close()
+	p.mu.Lock()
 	p.listeners[lis] = struct{}{}
+	p.mu.Unlock()
 
 	network := lis.Addr().Network()
-	if _, ok := lis.(*quic.Listener); ok {
+	if isQuicListener(lis) {
 		network = "quic"
+	} else if wlis, ok := lis.(*wsListener); ok {
+		network = wlis.network
 	}
 	addr := lis.Addr().String()
 	Printf("listen and serve (network:%s, addr:%s)", network, addr)
@@ -414,6 +801,9 @@ func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
 		}
 		tempDelay = 0
 		AnywayGo(func() {
+			handshake := p.guardHandshake(conn)
+			defer handshake.done()
+			protoFunc := protoFunc
 			if c, ok := conn.(*tls.Conn); ok {
 				if p.defaultSessionAge > 0 {
 					c.SetReadDeadline(coarsetime.CeilingTimeNow().Add(p.defaultSessionAge))
@@ -425,10 +815,18 @@ func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
 					Errorf("TLS handshake error from %s: %s", c.RemoteAddr(), err.Error())
 					return
 				}
+				if pf, ok := p.alpnProtoFunc(c); ok {
+					protoFunc = []ProtoFunc{pf}
+				}
 			}
 			var sess = newSession(p, conn, protoFunc)
-			if rerr := p.pluginContainer.postAccept(sess); rerr != nil {
-				sess.Close()
+			sess.peerCred = readPeerCred(conn)
+			sess.handshake = handshake
+			ctx, cancel := sess.hookContext(p.handshakeTimeout)
+			rerr := p.pluginContainer.postAccept(ctx, sess)
+			cancel()
+			if rerr != nil {
+				sess.close(CloseRejected, rerr.ToError(), true)
 				return
 			}
 			Infof("accept ok (network:%s, addr:%s, id:%s)", network, sess.RemoteAddr().String(), sess.ID())
@@ -439,17 +837,110 @@ func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
 }
 
 // ListenAndServe turns on the listening service.
+// NOTE: If PeerConfig.ListenAddrs is set, serves all of them, and returns the first error.
 func (p *peer) ListenAndServe(protoFunc ...ProtoFunc) error {
+	if len(p.listenURLs) > 0 {
+		return p.listenAndServeAll(protoFunc...)
+	}
 	if len(p.listenAddr) == 0 {
 		Fatalf("listen address can not be empty")
 	}
-	lis, err := NewInheritedListener(p.network, p.listenAddr, p.tlsConfig)
+	lis, err := p.listen(listenURL{network: p.network, addr: p.listenAddr, wsPath: p.wsPath})
 	if err != nil {
 		Fatalf("%v", err)
 	}
 	return p.serveListener(lis, protoFunc...)
 }
 
+// listenAndServeAll serves the listeners of all the listen addresses,
+// which share the router and the sessions.
+func (p *peer) listenAndServeAll(protoFunc ...ProtoFunc) error {
+	listeners := make([]net.Listener, 0, len(p.listenURLs))
+	for _, u := range p.listenURLs {
+		lis, err := p.listen(u)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			Fatalf("%v", err)
+		}
+		listeners = append(listeners, lis)
+	}
+	errCh := make(chan error, len(listeners))
+	for _, lis := range listeners {
+		lis := lis
+		go func() {
+			errCh <- p.serveListener(lis, protoFunc...)
+		}()
+	}
+	err := <-errCh
+	// stop the others if one fails
+	for _, lis := range listeners {
+		lis.Close()
+	}
+	for i := 1; i < len(listeners); i++ {
+		<-errCh
+	}
+	return err
+}
+
+// listen creates the listener of the network.
+func (p *peer) listen(u listenURL) (net.Listener, error) {
+	switch u.network {
+	case "ws", "wss":
+		if u.network == "wss" && p.tlsConfig == nil {
+			return nil, errors.New("wss network requires the TLS config")
+		}
+		lis, err := p.listenStream("tcp", u.addr, p.tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return newWsListener(lis, u.network, u.wsPath), nil
+	case "mem":
+		lis, err := listenMem(u.addr)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig := p.listenTLSConfig(); tlsConfig != nil {
+			return tls.NewListener(lis, tlsConfig), nil
+		}
+		return lis, nil
+	case "quic":
+		return NewInheritedListener(u.network, u.addr, p.tlsConfig)
+	default:
+		return p.listenStream(u.network, u.addr, p.listenTLSConfig())
+	}
+}
+
+// listenStream creates the inherited stream listener,
+// which reads the PROXY headers under the TLS if PeerConfig.ProxyProtocol is enabled.
+func (p *peer) listenStream(network, addr string, tlsConfig *tls.Config) (net.Listener, error) {
+	if !p.proxyProtocol {
+		return NewInheritedListener(network, addr, tlsConfig, p.sockOpts)
+	}
+	lis, err := NewInheritedListener(network, addr, nil, p.sockOpts)
+	if err != nil {
+		return nil, err
+	}
+	plis := newProxyListener(lis, p.pluginContainer.postReadProxyHeader)
+	if tlsConfig == nil {
+		return plis, nil
+	}
+	tlis, err := newTLSListener(plis, tlsConfig)
+	if err != nil {
+		plis.Close()
+		return nil, err
+	}
+	return tlis, nil
+}
+
+func newTLSListener(lis net.Listener, tlsConfig *tls.Config) (net.Listener, error) {
+	if len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil {
+		return nil, errors.New("tls: neither Certificates nor GetCertificate set in Config")
+	}
+	return tls.NewListener(lis, tlsConfig), nil
+}
+
 // Close closes peer.
 func (p *peer) Close() (err error) {
 	defer func() {
@@ -457,12 +948,7 @@ func (p *peer) Close() (err error) {
 			err = errors.Errorf("panic:%v\n%s", p, goutil.PanicTrace(2))
 		}
 	}()
-	close(p.closeCh)
-	for lis := range p.listeners {
-		if _, ok := lis.(*quic.Listener); !ok {
-			lis.Close()
-		}
-	}
+	p.stopAccepting()
 	deletePeer(p)
 	var (
 		count int
@@ -471,9 +957,9 @@ func (p *peer) Close() (err error) {
 	p.sessHub.Range(func(sess *session) bool {
 		count++
 		if !Go(func() {
-			errCh <- sess.Close()
+			errCh <- sess.close(CloseShutdown, nil, true)
 		}) {
-			errCh <- sess.Close()
+			errCh <- sess.close(CloseShutdown, nil, true)
 		}
 		return true
 	})
@@ -481,12 +967,86 @@ func (p *peer) Close() (err error) {
 		err = errors.Merge(err, <-errCh)
 	}
 	close(errCh)
+	p.mu.Lock()
 	for lis := range p.listeners {
-		if qlis, ok := lis.(*quic.Listener); ok {
-			err = errors.Merge(err, qlis.Close())
+		if isQuicListener(lis) {
+			err = errors.Merge(err, lis.Close())
 		}
 	}
-	return err
+	p.mu.Unlock()
+	if p.preprocessor != nil {
+		p.preprocessor.stop()
+	}
+	return errors.Merge(err, p.store.Close())
+}
+
+// stopAccepting stops accepting new connections.
+func (p *peer) stopAccepting() {
+	p.closeOnce.Do(func() {
+		close(p.closeCh)
+		p.cancelCtx()
+		p.mu.Lock()
+		for lis := range p.listeners {
+			if !isQuicListener(lis) {
+				lis.Close()
+			}
+		}
+		p.mu.Unlock()
+	})
+}
+
+// Drain stops accepting new connections, drains all the sessions with the timeout,
+// and then closes the peer.
+// NOTE:
+//  Execute the PreShutdownPlugin plugins first;
+//  The reason is sent to the sessions by GOAWAY, the default is "draining".
+func (p *peer) Drain(timeout time.Duration, reason ...string) (err error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	p.pluginContainer.preShutdown(ctx, p)
+	p.stopAccepting()
+	var (
+		count int
+		errCh = make(chan error, p.sessHub.Len())
+	)
+	p.sessHub.Range(func(sess *session) bool {
+		count++
+		go func() {
+			errCh <- sess.Drain(timeout, reason...)
+		}()
+		return true
+	})
+	for i := 0; i < count; i++ {
+		err = errors.Merge(err, <-errCh)
+	}
+	return errors.Merge(err, p.Close())
+}
+
+// HandleSignals drains the peer when receiving one of the signals, the default are SIGINT and SIGTERM.
+// The returned channel receives the result of the shutdown.
+// NOTE: Do not use it together with GraceSignal.
+func (p *peer) HandleSignals(timeout time.Duration, sig ...os.Signal) <-chan error {
+	if len(sig) == 0 {
+		sig = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sig...)
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		defer signal.Stop(sigCh)
+		select {
+		case s := <-sigCh:
+			Printf("received signal %s, shutting down gracefully (timeout:%s)", s, timeout)
+			done <- p.Drain(timeout, "shutdown by signal "+s.String())
+		case <-p.closeCh:
+		}
+	}()
+	return done
 }
 
 var ctxPool = sync.Pool{
@@ -511,6 +1071,13 @@ func (p *peer) putContext(ctx *handlerCtx, withWg bool) {
 		// count get context
 		ctx.sess.graceCtxWaitGroup.Done()
 	}
+	if ctx.unpackedLen > 0 {
+		atomic.AddInt64(&ctx.sess.unpackedBytes, -int64(ctx.unpackedLen))
+		ctx.unpackedLen = 0
+	}
+	ctx.releaseSessionSlot()
+	ctx.untrackCancel()
+	ctx.closeStream()
 	ctxPool.Put(ctx)
 }
 
@@ -524,6 +1091,11 @@ func (p *peer) SubRoute(pathPrefix string, plugin ...Plugin) *SubRouter {
 	return p.router.SubRoute(pathPrefix, plugin...)
 }
 
+// SubRouteVersion adds the handler group of the version, see WithVersion.
+func (p *peer) SubRouteVersion(version string, plugin ...Plugin) *SubRouter {
+	return p.router.SubRouteVersion(version, plugin...)
+}
+
 // RouteCall registers CALL handlers, and returns the paths.
 func (p *peer) RouteCall(callCtrlStruct interface{}, plugin ...Plugin) []string {
 	return p.router.RouteCall(callCtrlStruct, plugin...)
@@ -544,6 +1116,23 @@ func (p *peer) RoutePushFunc(pushHandleFunc interface{}, plugin ...Plugin) strin
 	return p.router.RoutePushFunc(pushHandleFunc, plugin...)
 }
 
+// RouteStream registers the STREAM handler of the service method, and returns the path.
+func (p *peer) RouteStream(serviceMethod string, fn func(StreamCtx) *Rerror, plugin ...Plugin) string {
+	return p.router.RouteStream(serviceMethod, fn, plugin...)
+}
+
+// RouteCallBulk registers the bulk CALL handler of the service method,
+// which is selected instead of the routed handler when the message size exceeds the threshold.
+func (p *peer) RouteCallBulk(serviceMethod string, threshold uint32, fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin) {
+	p.router.RouteCallBulk(serviceMethod, threshold, fn, plugin...)
+}
+
+// RoutePushBulk registers the bulk PUSH handler of the service method,
+// which is selected instead of the routed handler when the message size exceeds the threshold.
+func (p *peer) RoutePushBulk(serviceMethod string, threshold uint32, fn func(UnknownPushCtx) *Rerror, plugin ...Plugin) {
+	p.router.RoutePushBulk(serviceMethod, threshold, fn, plugin...)
+}
+
 // SetUnknownCall sets the default handler,
 // which is called when no handler for CALL is found.
 func (p *peer) SetUnknownCall(fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin) {
@@ -556,12 +1145,17 @@ func (p *peer) SetUnknownPush(fn func(UnknownPushCtx) *Rerror, plugin ...Plugin)
 	p.router.SetUnknownPush(fn, plugin...)
 }
 
+// Unroute removes the CALL and PUSH handlers of the service method, and reports whether any is removed.
+func (p *peer) Unroute(serviceMethod string) bool {
+	return p.router.Unroute(serviceMethod)
+}
+
 // maybe useful
 
-func (p *peer) getCallHandler(uriPath string) (*Handler, bool) {
-	return p.router.subRouter.getCall(uriPath)
+func (p *peer) getCallHandler(uriPath, version string) (*Handler, bool) {
+	return p.router.subRouter.getCall(uriPath, version)
 }
 
-func (p *peer) getPushHandler(uriPath string) (*Handler, bool) {
-	return p.router.subRouter.getPush(uriPath)
+func (p *peer) getPushHandler(uriPath, version string) (*Handler, bool) {
+	return p.router.subRouter.getPush(uriPath, version)
 }
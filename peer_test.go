@@ -0,0 +1,68 @@
+package tp_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestListenAddrs(t *testing.T) {
+	sock := filepath.Join(os.TempDir(), "tp_test.sock")
+	os.Remove(sock)
+	l := make(listened, 3)
+	srv := tp.NewPeer(tp.PeerConfig{
+		ListenAddrs: []string{
+			"tcp://127.0.0.1:0",
+			"ws://127.0.0.2:0/rpc",
+			"unix://" + sock,
+		},
+	}, l)
+	defer srv.Close()
+	srv.RouteCallFunc(echo_call)
+	go srv.ListenAndServe()
+
+	// the listeners are told apart by the hosts
+	addrs := make(map[string]string, 3)
+	for i := 0; i < 3; i++ {
+		select {
+		case addr := <-l:
+			switch {
+			case addr.Network() == "unix":
+				addrs["unix"] = addr.String()
+			case strings.HasPrefix(addr.String(), "127.0.0.2:"):
+				addrs["ws"] = addr.String()
+			default:
+				addrs["tcp"] = addr.String()
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expect 3 listeners, got %v", addrs)
+		}
+	}
+	for _, c := range []struct {
+		cfg  tp.PeerConfig
+		addr string
+	}{
+		{tp.PeerConfig{}, addrs["tcp"]},
+		{tp.PeerConfig{Network: "ws", WebsocketPath: "/rpc"}, addrs["ws"]},
+		{tp.PeerConfig{Network: "unix"}, addrs["unix"]},
+	} {
+		cli := tp.NewPeer(c.cfg)
+		sess, rerr := cli.Dial(c.addr)
+		if rerr != nil {
+			t.Fatalf("dial %s: %v", c.addr, rerr)
+		}
+		var arg, result = 10, 0
+		rerr = sess.Call("/echo/call", &arg, &result).Rerror()
+		if rerr != nil {
+			t.Fatalf("%s: /echo/call: %v", c.addr, rerr)
+		}
+		if result != arg {
+			t.Fatalf("%s: expect %d, got %d", c.addr, arg, result)
+		}
+		cli.Close()
+	}
+}
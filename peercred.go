@@ -0,0 +1,33 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+// PeerCred the credentials of the process on the other end of the unix domain socket,
+// e.g. for authorizing the local clients without tokens.
+type PeerCred struct {
+	// UID the user ID of the process
+	UID uint32 `json:"uid"`
+	// GID the group ID of the process
+	GID uint32 `json:"gid"`
+	// PID the process ID
+	PID int32 `json:"pid"`
+}
+
+// PeerCred returns the credentials of the process on the other end of the unix domain socket,
+// which are captured when the connection is accepted.
+// NOTE: It is nil if the network is not unix or unixpacket, or the platform does not support it.
+func (s *session) PeerCred() *PeerCred {
+	return s.peerCred
+}
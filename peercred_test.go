@@ -0,0 +1,65 @@
+package tp_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type peerCredPlugin struct {
+	creds chan *tp.PeerCred
+}
+
+func (peerCredPlugin) Name() string {
+	return "peer_cred"
+}
+
+func (p peerCredPlugin) PostAccept(sess tp.PreSession) *tp.Rerror {
+	p.creds <- sess.PeerCred()
+	return nil
+}
+
+func TestPeerCred(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_PEERCRED is only supported on linux")
+	}
+	sock := filepath.Join(os.TempDir(), "tp_peer_cred_test.sock")
+	os.Remove(sock)
+	plugin := peerCredPlugin{creds: make(chan *tp.PeerCred, 1)}
+	srv := tp.NewPeer(tp.PeerConfig{
+		ListenAddrs: []string{"unix://" + sock},
+	}, plugin)
+	defer srv.Close()
+	srv.RouteCallFunc(echo_call)
+	listenAndServe(t, srv)
+
+	cli := tp.NewPeer(tp.PeerConfig{Network: "unix"})
+	defer cli.Close()
+	sess, rerr := cli.Dial(sock)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	var arg, result = 10, 0
+	if rerr = sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	cred := <-plugin.creds
+	if cred == nil {
+		t.Fatal("expect the peer credentials in PostAccept")
+	}
+	if cred.UID != uint32(os.Getuid()) || cred.GID != uint32(os.Getgid()) || cred.PID != int32(os.Getpid()) {
+		t.Fatalf("unexpected peer credentials: %+v", cred)
+	}
+	srv.RangeSession(func(s tp.Session) bool {
+		if got := s.PeerCred(); got == nil || *got != *cred {
+			t.Fatalf("expect the peer credentials on the session, got %+v", got)
+		}
+		return true
+	})
+	if sess.PeerCred() != nil {
+		t.Fatal("expect no peer credentials on the dialing session")
+	}
+}
@@ -15,6 +15,7 @@
 package tp
 
 import (
+	"context"
 	"fmt"
 	"net"
 
@@ -59,6 +60,43 @@ type (
 		Plugin
 		PostAccept(PreSession) *Rerror
 	}
+	// PostDialContextPlugin is the version of PostDialPlugin which receives the context,
+	// canceled when the dial timeout expires, the session is closed or the peer is going away.
+	// NOTE: If the plugin implements both, only PostDialContext is executed.
+	PostDialContextPlugin interface {
+		Plugin
+		PostDialContext(ctx context.Context, sess PreSession) *Rerror
+	}
+	// PostAcceptContextPlugin is the version of PostAcceptPlugin which receives the context,
+	// canceled when the handshake timeout expires, the session is closed or the peer is going away.
+	// NOTE: If the plugin implements both, only PostAcceptContext is executed.
+	PostAcceptContextPlugin interface {
+		Plugin
+		PostAcceptContext(ctx context.Context, sess PreSession) *Rerror
+	}
+	// PostReadProxyHeaderPlugin is executed after reading the PROXY protocol header of the accepted connection.
+	// NOTE: The header is nil if the connection has none; If returns error, the connection is rejected.
+	PostReadProxyHeaderPlugin interface {
+		Plugin
+		PostReadProxyHeader(conn net.Conn, header *ProxyHeader) error
+	}
+	// PostDowngradePlugin is executed after downgrading a feature which is unsupported by the remote peer.
+	PostDowngradePlugin interface {
+		Plugin
+		PostDowngrade(sess PreSession, feature string, reason *Rerror) *Rerror
+	}
+	// PreShutdownPlugin is executed before draining the sessions when the peer shuts down gracefully.
+	PreShutdownPlugin interface {
+		Plugin
+		PreShutdown(Peer) error
+	}
+	// PreShutdownContextPlugin is the version of PreShutdownPlugin which receives the context,
+	// canceled when the drain timeout expires.
+	// NOTE: If the plugin implements both, only PreShutdownContext is executed.
+	PreShutdownContextPlugin interface {
+		Plugin
+		PreShutdownContext(ctx context.Context, peer Peer) error
+	}
 	// PreWriteCallPlugin is executed before writing CALL message.
 	PreWriteCallPlugin interface {
 		Plugin
@@ -139,11 +177,29 @@ type (
 		Plugin
 		PostReadReplyBody(ReadCtx) *Rerror
 	}
+	// PostWriteControlPlugin is executed after successful writing CONTROL message.
+	PostWriteControlPlugin interface {
+		Plugin
+		PostWriteControl(WriteCtx) *Rerror
+	}
+	// PostReadControlPlugin is executed after reading CONTROL message.
+	// NOTE: The message body is *[]byte type.
+	PostReadControlPlugin interface {
+		Plugin
+		PostReadControl(ReadCtx) *Rerror
+	}
 	// PostDisconnectPlugin is executed after disconnection.
 	PostDisconnectPlugin interface {
 		Plugin
 		PostDisconnect(BaseSession) *Rerror
 	}
+	// SessionClosedPlugin is executed once after the connection of the session is closed actively or passively,
+	// e.g. the remote peer is gone or the heartbeat timeout, even if the client redials then.
+	// NOTE: The reason carries the kind and the last error, e.g. the read error, which may be nil.
+	SessionClosedPlugin interface {
+		Plugin
+		SessionClosed(sess BaseSession, reason CloseReason) *Rerror
+	}
 )
 
 // PluginContainer a plugin container
@@ -354,7 +410,7 @@ func (p *pluginSingleContainer) postListen(addr net.Addr) {
 }
 
 // PostDial executes the defined plugins after dialing.
-func (p *pluginSingleContainer) postDial(sess PreSession) (rerr *Rerror) {
+func (p *pluginSingleContainer) postDial(ctx context.Context, sess PreSession) (rerr *Rerror) {
 	var pluginName string
 	defer func() {
 		if p := recover(); p != nil {
@@ -363,7 +419,13 @@ func (p *pluginSingleContainer) postDial(sess PreSession) (rerr *Rerror) {
 		}
 	}()
 	for _, plugin := range p.plugins {
-		if _plugin, ok := plugin.(PostDialPlugin); ok {
+		if _plugin, ok := plugin.(PostDialContextPlugin); ok {
+			pluginName = plugin.Name()
+			if rerr = _plugin.PostDialContext(ctx, sess); rerr != nil {
+				Debugf("[PostDialContextPlugin:%s] network:%s, addr:%s, error:%s", pluginName, sess.RemoteAddr().Network(), sess.RemoteAddr().String(), rerr.String())
+				return rerr
+			}
+		} else if _plugin, ok := plugin.(PostDialPlugin); ok {
 			pluginName = plugin.Name()
 			if rerr = _plugin.PostDial(sess); rerr != nil {
 				Debugf("[PostDialPlugin:%s] network:%s, addr:%s, error:%s", pluginName, sess.RemoteAddr().Network(), sess.RemoteAddr().String(), rerr.String())
@@ -375,7 +437,7 @@ func (p *pluginSingleContainer) postDial(sess PreSession) (rerr *Rerror) {
 }
 
 // PostAccept executes the defined plugins after accepting connection.
-func (p *pluginSingleContainer) postAccept(sess PreSession) (rerr *Rerror) {
+func (p *pluginSingleContainer) postAccept(ctx context.Context, sess PreSession) (rerr *Rerror) {
 	var pluginName string
 	defer func() {
 		if p := recover(); p != nil {
@@ -384,7 +446,13 @@ func (p *pluginSingleContainer) postAccept(sess PreSession) (rerr *Rerror) {
 		}
 	}()
 	for _, plugin := range p.plugins {
-		if _plugin, ok := plugin.(PostAcceptPlugin); ok {
+		if _plugin, ok := plugin.(PostAcceptContextPlugin); ok {
+			pluginName = plugin.Name()
+			if rerr = _plugin.PostAcceptContext(ctx, sess); rerr != nil {
+				Debugf("[PostAcceptContextPlugin:%s] network:%s, addr:%s, error:%s", pluginName, sess.RemoteAddr().Network(), sess.RemoteAddr().String(), rerr.String())
+				return rerr
+			}
+		} else if _plugin, ok := plugin.(PostAcceptPlugin); ok {
 			pluginName = plugin.Name()
 			if rerr = _plugin.PostAccept(sess); rerr != nil {
 				Debugf("[PostAcceptPlugin:%s] network:%s, addr:%s, error:%s", pluginName, sess.RemoteAddr().Network(), sess.RemoteAddr().String(), rerr.String())
@@ -395,6 +463,58 @@ func (p *pluginSingleContainer) postAccept(sess PreSession) (rerr *Rerror) {
 	return nil
 }
 
+// PostReadProxyHeader executes the defined plugins after reading the PROXY protocol header of the accepted connection.
+func (p *pluginSingleContainer) postReadProxyHeader(conn net.Conn, header *ProxyHeader) (err error) {
+	var pluginName string
+	defer func() {
+		if p := recover(); p != nil {
+			Errorf("[PostReadProxyHeaderPlugin:%s] addr:%s, panic:%v\n%s", pluginName, conn.RemoteAddr().String(), p, goutil.PanicTrace(2))
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PostReadProxyHeaderPlugin); ok {
+			pluginName = plugin.Name()
+			if err = _plugin.PostReadProxyHeader(conn, header); err != nil {
+				Debugf("[PostReadProxyHeaderPlugin:%s] addr:%s, error:%s", pluginName, conn.RemoteAddr().String(), err.Error())
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PostDowngrade executes the defined plugins after downgrading a feature which is unsupported by the remote peer.
+func (p *pluginSingleContainer) postDowngrade(sess PreSession, feature string, reason *Rerror) *Rerror {
+	var rerr *Rerror
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PostDowngradePlugin); ok {
+			if rerr = _plugin.PostDowngrade(sess, feature, reason); rerr != nil {
+				Errorf("[PostDowngradePlugin:%s] %s", plugin.Name(), rerr.String())
+				return rerr
+			}
+		}
+	}
+	return nil
+}
+
+// PreShutdown executes the defined plugins before draining the sessions when the peer shuts down gracefully.
+// NOTE: The errors are only logged, and do not stop the shutdown.
+func (p *pluginSingleContainer) preShutdown(ctx context.Context, peer Peer) {
+	var err error
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PreShutdownContextPlugin); ok {
+			if err = _plugin.PreShutdownContext(ctx, peer); err != nil {
+				Errorf("[PreShutdownContextPlugin:%s] %s", plugin.Name(), err.Error())
+			}
+		} else if _plugin, ok := plugin.(PreShutdownPlugin); ok {
+			if err = _plugin.PreShutdown(peer); err != nil {
+				Errorf("[PreShutdownPlugin:%s] %s", plugin.Name(), err.Error())
+			}
+		}
+	}
+}
+
 // PreWriteCall executes the defined plugins before writing CALL message.
 func (p *pluginSingleContainer) preWriteCall(ctx WriteCtx) *Rerror {
 	var rerr *Rerror
@@ -477,6 +597,20 @@ func (p *pluginSingleContainer) postWritePush(ctx WriteCtx) *Rerror {
 	return nil
 }
 
+// PostWriteControl executes the defined plugins after successful writing CONTROL message.
+func (p *pluginSingleContainer) postWriteControl(ctx WriteCtx) *Rerror {
+	var rerr *Rerror
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PostWriteControlPlugin); ok {
+			if rerr = _plugin.PostWriteControl(ctx); rerr != nil {
+				Errorf("[PostWriteControlPlugin:%s] %s", plugin.Name(), rerr.String())
+				return rerr
+			}
+		}
+	}
+	return nil
+}
+
 // PreReadHeader executes the defined plugins before reading message header.
 func (p *pluginSingleContainer) preReadHeader(ctx PreCtx) error {
 	var err error
@@ -617,6 +751,20 @@ func (p *pluginSingleContainer) postReadReplyBody(ctx ReadCtx) *Rerror {
 	return nil
 }
 
+// PostReadControl executes the defined plugins after reading CONTROL message.
+func (p *pluginSingleContainer) postReadControl(ctx ReadCtx) *Rerror {
+	var rerr *Rerror
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PostReadControlPlugin); ok {
+			if rerr = _plugin.PostReadControl(ctx); rerr != nil {
+				Errorf("[PostReadControlPlugin:%s] %s", plugin.Name(), rerr.String())
+				return rerr
+			}
+		}
+	}
+	return nil
+}
+
 // PostDisconnect executes the defined plugins after disconnection.
 func (p *pluginSingleContainer) postDisconnect(sess BaseSession) *Rerror {
 	var rerr *Rerror
@@ -631,6 +779,20 @@ func (p *pluginSingleContainer) postDisconnect(sess BaseSession) *Rerror {
 	return nil
 }
 
+// SessionClosed executes the defined plugins after the connection of the session is closed.
+func (p *pluginSingleContainer) sessionClosed(sess BaseSession, reason CloseReason) *Rerror {
+	var rerr *Rerror
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(SessionClosedPlugin); ok {
+			if rerr = _plugin.SessionClosed(sess, reason); rerr != nil {
+				Errorf("[SessionClosedPlugin:%s] %s", plugin.Name(), rerr.String())
+				return rerr
+			}
+		}
+	}
+	return nil
+}
+
 func warnInvaildHandlerHooks(plugin []Plugin) {
 	for _, p := range plugin {
 		switch p.(type) {
@@ -642,6 +804,16 @@ func warnInvaildHandlerHooks(plugin []Plugin) {
 			Debugf("invalid PostDialPlugin in router: %s", p.Name())
 		case PostAcceptPlugin:
 			Debugf("invalid PostAcceptPlugin in router: %s", p.Name())
+		case PostDialContextPlugin:
+			Debugf("invalid PostDialContextPlugin in router: %s", p.Name())
+		case PostAcceptContextPlugin:
+			Debugf("invalid PostAcceptContextPlugin in router: %s", p.Name())
+		case PostDowngradePlugin:
+			Debugf("invalid PostDowngradePlugin in router: %s", p.Name())
+		case PreShutdownPlugin:
+			Debugf("invalid PreShutdownPlugin in router: %s", p.Name())
+		case PreShutdownContextPlugin:
+			Debugf("invalid PreShutdownContextPlugin in router: %s", p.Name())
 		case PreWriteCallPlugin:
 			Debugf("invalid PreWriteCallPlugin in router: %s", p.Name())
 		case PostWriteCallPlugin:
@@ -656,6 +828,12 @@ func warnInvaildHandlerHooks(plugin []Plugin) {
 			Debugf("invalid PostReadCallHeaderPlugin in router: %s", p.Name())
 		case PostReadPushHeaderPlugin:
 			Debugf("invalid PostReadPushHeaderPlugin in router: %s", p.Name())
+		case PostWriteControlPlugin:
+			Debugf("invalid PostWriteControlPlugin in router: %s", p.Name())
+		case PostReadControlPlugin:
+			Debugf("invalid PostReadControlPlugin in router: %s", p.Name())
+		case SessionClosedPlugin:
+			Debugf("invalid SessionClosedPlugin in router: %s", p.Name())
 		}
 	}
 }
@@ -0,0 +1,224 @@
+// Package affinity is a plugin for issuing session affinity tokens,
+// which route a returning client to the server instance holding its state.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package affinity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/henrylee2cn/goutil"
+	tp "github.com/mylonly/teleport"
+)
+
+const (
+	// BindServiceMethod the service method of the binding message
+	BindServiceMethod = "/affinity/bind"
+	// MetaToken the metadata key of the affinity token
+	MetaToken = "X-Affinity-Token"
+	// Feature the feature name used when downgrading
+	Feature = "affinity"
+)
+
+// ErrInvalidToken the token is malformed or not signed by the secret
+var ErrInvalidToken = errors.New("affinity: invalid token")
+
+// Affinity the plugin issuing the affinity tokens on the server side,
+// and presenting them again when redialing on the client side.
+type Affinity struct {
+	instance string
+	secret   []byte
+	tokens   goutil.Map // client side, dial address -> token
+}
+
+var (
+	_ tp.PostNewPeerPlugin = new(Affinity)
+	_ tp.PostDialPlugin    = new(Affinity)
+	_ tp.PostAcceptPlugin  = new(Affinity)
+)
+
+// NewAffinity creates the affinity plugin.
+// NOTE:
+//  The instance identifies the server instance, e.g. host:port, which the balancers route to;
+//  All the server instances behind the same balancer should share the secret;
+//  The client side only needs NewAffinity("", nil).
+func NewAffinity(instance string, secret []byte) *Affinity {
+	return &Affinity{
+		instance: instance,
+		secret:   secret,
+		tokens:   goutil.AtomicMap(),
+	}
+}
+
+// Name returns name.
+func (a *Affinity) Name() string {
+	return "affinity"
+}
+
+// PostNewPeer registers the binding handler.
+func (a *Affinity) PostNewPeer(peer tp.EarlyPeer) error {
+	peer.SubRoute("/affinity").RouteCallFunc((*bindCall).bind)
+	return nil
+}
+
+// PostDial presents the token received from the address last time, and saves the new one.
+// NOTE: If the remote peer does not support affinity, downgrade without token.
+func (a *Affinity) PostDial(sess tp.PreSession) *tp.Rerror {
+	addr := sess.RemoteAddr().String()
+	rerr := sess.Send(BindServiceMethod, nil, nil,
+		tp.WithMtype(tp.TypeCall),
+		tp.WithSetMeta(MetaToken, a.Token(addr)),
+	)
+	if rerr.HasError() {
+		return rerr
+	}
+	retMsg, rerr := sess.Receive(func(tp.Header) interface{} { return nil })
+	if rerr.HasError() {
+		if tp.IsMismatchRerror(rerr) {
+			sess.Downgrade(Feature, rerr)
+			return nil
+		}
+		return rerr
+	}
+	defer tp.PutMessage(retMsg)
+	token := string(retMsg.Meta().Peek(MetaToken))
+	if len(token) > 0 {
+		a.SetToken(addr, token)
+		sess.Swap().Store(swapKey, token)
+	}
+	return nil
+}
+
+// PostAccept saves the plugin for the binding handler.
+func (a *Affinity) PostAccept(sess tp.PreSession) *tp.Rerror {
+	sess.Swap().Store(swapKeyPlugin, a)
+	return nil
+}
+
+// Token returns the token received from the dial address, on the client side.
+func (a *Affinity) Token(addr string) string {
+	token, _ := a.tokens.Load(addr)
+	s, _ := token.(string)
+	return s
+}
+
+// SetToken sets the token presented to the dial address, on the client side,
+// e.g. restores the token persisted before the client restarts.
+func (a *Affinity) SetToken(addr, token string) {
+	a.tokens.Store(addr, token)
+}
+
+// Issue creates a token of the instance for the state key.
+func (a *Affinity) Issue(key string) string {
+	return Issue(a.secret, a.instance, key)
+}
+
+const (
+	swapKey       = "affinity_token"
+	swapKeyKey    = "affinity_key"
+	swapKeyPlugin = "affinity"
+)
+
+// SessionToken returns the affinity token of the session, empty if not bound.
+func SessionToken(sess interface{ Swap() goutil.Map }) string {
+	token, _ := sess.Swap().Load(swapKey)
+	s, _ := token.(string)
+	return s
+}
+
+// SessionKey returns the state key of the session on the server side,
+// which is kept when the client returns with the token, e.g. as the key of the peer store.
+func SessionKey(sess interface{ Swap() goutil.Map }) string {
+	key, _ := sess.Swap().Load(swapKeyKey)
+	s, _ := key.(string)
+	return s
+}
+
+// Issue creates a token of the instance for the state key, signed by the secret.
+// NOTE: The token format is base64(instance \n key).base64(signature).
+func Issue(secret []byte, instance, key string) string {
+	payload := instance + "\n" + key
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+}
+
+// Parse verifies the token by the secret, and returns the instance and the state key,
+// e.g. used by the balancers and the relays to choose the upstream.
+func Parse(secret []byte, token string) (instance, key string, err error) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return "", "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(sig, sign(secret, string(payload))) {
+		return "", "", ErrInvalidToken
+	}
+	a := strings.SplitN(string(payload), "\n", 2)
+	if len(a) != 2 {
+		return "", "", ErrInvalidToken
+	}
+	return a[0], a[1], nil
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)[:16]
+}
+
+type bindCall struct {
+	tp.CallCtx
+}
+
+// bind keeps the state key if the client returns with a valid token of this instance,
+// otherwise uses the session ID as the new key.
+func (b *bindCall) bind(_ *struct{}) (*struct{}, *tp.Rerror) {
+	sess := b.Session()
+	v, ok := sess.Swap().Load(swapKeyPlugin)
+	if !ok {
+		return nil, nil
+	}
+	a := v.(*Affinity)
+	if len(a.instance) == 0 {
+		// client side, does not issue tokens
+		return nil, nil
+	}
+	key := sess.ID()
+	if token := string(b.PeekMeta(MetaToken)); len(token) > 0 {
+		instance, oldKey, err := Parse(a.secret, token)
+		switch {
+		case err != nil:
+			tp.Warnf("affinity: %s, %s", b.IP(), err.Error())
+		case instance != a.instance:
+			tp.Infof("affinity: %s, the token belongs to instance %s", b.IP(), instance)
+		default:
+			key = oldKey
+		}
+	}
+	token := a.Issue(key)
+	sess.Swap().Store(swapKeyKey, key)
+	sess.Swap().Store(swapKey, token)
+	b.SetMeta(MetaToken, token)
+	return nil, nil
+}
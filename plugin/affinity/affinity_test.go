@@ -0,0 +1,66 @@
+package affinity_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/plugin/affinity"
+)
+
+type Home struct {
+	tp.CallCtx
+}
+
+func (h *Home) Key(*struct{}) (string, *tp.Rerror) {
+	return affinity.SessionKey(h.Session()), nil
+}
+
+func TestAffinity(t *testing.T) {
+	secret := []byte("secret")
+	// Server
+	srv := tp.NewPeer(
+		tp.PeerConfig{ListenPort: 9080},
+		affinity.NewAffinity("srv1", secret),
+	)
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe()
+	time.Sleep(1e9)
+
+	// Client
+	plugin := affinity.NewAffinity("", nil)
+	cli := tp.NewPeer(tp.PeerConfig{}, plugin)
+	var (
+		keys  [2]string
+		token string
+	)
+	for i := range keys {
+		sess, rerr := cli.Dial(":9080")
+		if rerr.HasError() {
+			t.Fatal(rerr)
+		}
+		token = affinity.SessionToken(sess)
+		if plugin.Token(sess.RemoteAddr().String()) != token {
+			t.Fatalf("expect the token to be saved for redialing")
+		}
+		instance, key, err := affinity.Parse(secret, token)
+		if err != nil || instance != "srv1" {
+			t.Fatalf("token %q: instance=%s, err=%v", token, instance, err)
+		}
+		rerr = sess.Call("/home/key", nil, &keys[i]).Rerror()
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		if keys[i] != key {
+			t.Fatalf("key: expect %s, got %s", key, keys[i])
+		}
+		sess.Close()
+	}
+	// the returning client keeps the state key
+	if keys[0] != keys[1] {
+		t.Fatalf("expect the same key, got %s and %s", keys[0], keys[1])
+	}
+	if _, _, err := affinity.Parse([]byte("other"), token); err != affinity.ErrInvalidToken {
+		t.Fatalf("expect ErrInvalidToken, got %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+// Package dashboard is an embeddable web dashboard plugin for operators.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+const (
+	// SubscribeServiceMethod the service method of subscribing to the stats by teleport
+	SubscribeServiceMethod = "/dashboard/subscribe"
+	// StatsServiceMethod the service method of the stats pushed to the subscribers
+	StatsServiceMethod = "/dashboard/stats"
+
+	startSwapKey    = "dashboard_start"
+	maxRecentErrors = 20
+	maxSessions     = 100
+)
+
+// NewDashboard creates a dashboard plugin, which serves the web page on the admin address.
+// NOTE:
+//  The stats are refreshed per interval, the default is 1s;
+//  The web page receives the stats in real time by server-sent events,
+//  and a teleport client receives them by calling SubscribeServiceMethod.
+func NewDashboard(adminAddr string, interval ...time.Duration) Dashboard {
+	d := &dashboard{
+		adminAddr:   adminAddr,
+		interval:    time.Second,
+		closeCh:     make(chan struct{}),
+		watchers:    make(map[chan []byte]struct{}),
+		subscribers: make(map[tp.BaseSession]tp.Session),
+	}
+	if len(interval) > 0 && interval[0] > 0 {
+		d.interval = interval[0]
+	}
+	d.snapshot.Store(&Stats{Time: time.Now()})
+	return d
+}
+
+type (
+	// Dashboard operator dashboard plugin
+	Dashboard interface {
+		tp.Plugin
+		// Addr returns the admin address of the dashboard.
+		Addr() net.Addr
+		// Snapshot returns the latest stats.
+		Snapshot() *Stats
+		// Close stops the dashboard.
+		Close() error
+	}
+	// Stats the stats of the peer
+	Stats struct {
+		Time         time.Time     `json:"time"`
+		SessionCount int           `json:"session_count"`
+		Sessions     []SessionInfo `json:"sessions"`
+		Routes       []RouteInfo   `json:"routes"`
+		Calls        uint64        `json:"calls"`
+		Pushes       uint64        `json:"pushes"`
+		Errors       uint64        `json:"errors"`
+		CallRate     float64       `json:"call_rate"`
+		PushRate     float64       `json:"push_rate"`
+		Latency      []Bucket      `json:"latency"`
+		RecentErrors []ErrorInfo   `json:"recent_errors"`
+	}
+	// SessionInfo the session information
+	SessionInfo struct {
+		ID         string `json:"id"`
+		RemoteAddr string `json:"remote_addr"`
+	}
+	// RouteInfo the route information
+	RouteInfo struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	// Bucket the latency histogram bucket
+	Bucket struct {
+		Le    string `json:"le"`
+		Count uint64 `json:"count"`
+	}
+	// ErrorInfo the failed request information
+	ErrorInfo struct {
+		Time          time.Time `json:"time"`
+		ServiceMethod string    `json:"service_method"`
+		RemoteAddr    string    `json:"remote_addr"`
+		Code          int32     `json:"code"`
+		Message       string    `json:"message"`
+	}
+)
+
+var (
+	_ tp.PostRegPlugin            = new(dashboard)
+	_ tp.PostNewPeerPlugin        = new(dashboard)
+	_ tp.PostReadCallHeaderPlugin = new(dashboard)
+	_ tp.PostReadPushHeaderPlugin = new(dashboard)
+	_ tp.PostWriteReplyPlugin     = new(dashboard)
+	_ tp.SessionClosedPlugin      = new(dashboard)
+)
+
+var latencyBounds = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+type dashboard struct {
+	adminAddr    string
+	interval     time.Duration
+	peer         tp.BasePeer
+	listener     net.Listener
+	closeCh      chan struct{}
+	closeOnce    sync.Once
+	calls        uint64
+	pushes       uint64
+	errors       uint64
+	latency      [8]uint64 // len(latencyBounds)+1
+	routes       []RouteInfo
+	recentErrors []ErrorInfo
+	snapshot     atomic.Value
+	watchers     map[chan []byte]struct{}
+	subscribers  map[tp.BaseSession]tp.Session // the sessions which subscribe to the stats by teleport
+	mu           sync.Mutex
+}
+
+// Name returns name.
+func (d *dashboard) Name() string {
+	return "dashboard"
+}
+
+// Addr returns the admin address of the dashboard.
+func (d *dashboard) Addr() net.Addr {
+	if d.listener == nil {
+		return nil
+	}
+	return d.listener.Addr()
+}
+
+// Snapshot returns the latest stats.
+func (d *dashboard) Snapshot() *Stats {
+	return d.snapshot.Load().(*Stats)
+}
+
+// Close stops the dashboard.
+func (d *dashboard) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		close(d.closeCh)
+		if d.listener != nil {
+			err = d.listener.Close()
+		}
+	})
+	return err
+}
+
+// PostReg collects the routes.
+func (d *dashboard) PostReg(h *tp.Handler) error {
+	typ := "CALL"
+	if h.IsPush() {
+		typ = "PUSH"
+	} else if h.IsStream() {
+		typ = "STREAM"
+	}
+	d.mu.Lock()
+	d.routes = append(d.routes, RouteInfo{Name: h.Name(), Type: typ})
+	d.mu.Unlock()
+	return nil
+}
+
+// PostNewPeer registers the subscription handler and starts the admin server.
+func (d *dashboard) PostNewPeer(peer tp.EarlyPeer) error {
+	d.peer = peer
+	peer.SubRoute("/dashboard").RouteCallFunc((*dashboardCall).subscribe)
+	lis, err := net.Listen("tcp", d.adminAddr)
+	if err != nil {
+		return err
+	}
+	d.listener = lis
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.serveIndex)
+	mux.HandleFunc("/stats", d.serveStats)
+	mux.HandleFunc("/events", d.serveEvents)
+	go http.Serve(lis, mux)
+	go d.loop()
+	tp.Infof("dashboard: serving on http://%s", lis.Addr().String())
+	return nil
+}
+
+// PostReadCallHeader counts the CALL.
+func (d *dashboard) PostReadCallHeader(ctx tp.ReadCtx) *tp.Rerror {
+	atomic.AddUint64(&d.calls, 1)
+	ctx.Swap().Store(startSwapKey, time.Now())
+	return nil
+}
+
+// PostReadPushHeader counts the PUSH.
+func (d *dashboard) PostReadPushHeader(ctx tp.ReadCtx) *tp.Rerror {
+	atomic.AddUint64(&d.pushes, 1)
+	return nil
+}
+
+// PostWriteReply records the latency, the error and the subscription.
+func (d *dashboard) PostWriteReply(ctx tp.WriteCtx) *tp.Rerror {
+	if v, ok := ctx.Swap().Load(startSwapKey); ok {
+		cost := time.Since(v.(time.Time))
+		i := 0
+		for ; i < len(latencyBounds) && cost > latencyBounds[i]; i++ {
+		}
+		atomic.AddUint64(&d.latency[i], 1)
+	}
+	if rerr := ctx.Rerror(); rerr != nil {
+		atomic.AddUint64(&d.errors, 1)
+		d.mu.Lock()
+		d.recentErrors = append(d.recentErrors, ErrorInfo{
+			Time:          time.Now(),
+			ServiceMethod: ctx.Output().ServiceMethod(),
+			RemoteAddr:    ctx.IP(),
+			Code:          rerr.Code,
+			Message:       rerr.Message,
+		})
+		if n := len(d.recentErrors); n > maxRecentErrors {
+			d.recentErrors = append(d.recentErrors[:0], d.recentErrors[n-maxRecentErrors:]...)
+		}
+		d.mu.Unlock()
+	} else if ctx.Output().ServiceMethod() == SubscribeServiceMethod {
+		d.mu.Lock()
+		d.subscribers[ctx.Session()] = ctx.Session()
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+// SessionClosed unsubscribes the closed session.
+func (d *dashboard) SessionClosed(sess tp.BaseSession, _ tp.CloseReason) *tp.Rerror {
+	d.mu.Lock()
+	delete(d.subscribers, sess)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *dashboard) loop() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closeCh:
+			return
+		case <-ticker.C:
+		}
+		stats := d.collect()
+		d.snapshot.Store(stats)
+		d.publish(stats)
+	}
+}
+
+func (d *dashboard) collect() *Stats {
+	last := d.Snapshot()
+	stats := &Stats{
+		Time:   time.Now(),
+		Calls:  atomic.LoadUint64(&d.calls),
+		Pushes: atomic.LoadUint64(&d.pushes),
+		Errors: atomic.LoadUint64(&d.errors),
+	}
+	if elapsed := stats.Time.Sub(last.Time).Seconds(); elapsed > 0 {
+		stats.CallRate = float64(stats.Calls-last.Calls) / elapsed
+		stats.PushRate = float64(stats.Pushes-last.Pushes) / elapsed
+	}
+	for i := range d.latency {
+		le := "+Inf"
+		if i < len(latencyBounds) {
+			le = latencyBounds[i].String()
+		}
+		stats.Latency = append(stats.Latency, Bucket{Le: le, Count: atomic.LoadUint64(&d.latency[i])})
+	}
+	stats.SessionCount = d.peer.CountSession()
+	d.peer.RangeSession(func(sess tp.Session) bool {
+		stats.Sessions = append(stats.Sessions, SessionInfo{
+			ID:         sess.ID(),
+			RemoteAddr: sess.RemoteAddr().String(),
+		})
+		return len(stats.Sessions) < maxSessions
+	})
+	d.mu.Lock()
+	stats.Routes = append(stats.Routes, d.routes...)
+	stats.RecentErrors = append(stats.RecentErrors, d.recentErrors...)
+	d.mu.Unlock()
+	return stats
+}
+
+func (d *dashboard) publish(stats *Stats) {
+	b, err := json.Marshal(stats)
+	if err != nil {
+		tp.Errorf("dashboard: %v", err)
+		return
+	}
+	d.mu.Lock()
+	for ch := range d.watchers {
+		select {
+		case ch <- b:
+		default:
+		}
+	}
+	subscribers := make([]tp.Session, 0, len(d.subscribers))
+	for _, sess := range d.subscribers {
+		subscribers = append(subscribers, sess)
+	}
+	d.mu.Unlock()
+	for _, sess := range subscribers {
+		sess.Push(StatsServiceMethod, b)
+	}
+}
+
+func (d *dashboard) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(indexHTML))
+}
+
+func (d *dashboard) serveStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.Snapshot())
+}
+
+func (d *dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	ch := make(chan []byte, 1)
+	d.mu.Lock()
+	d.watchers[ch] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.watchers, ch)
+		d.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-d.closeCh:
+			return
+		case <-r.Context().Done():
+			return
+		case b := <-ch:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+type dashboardCall struct {
+	tp.CallCtx
+}
+
+// subscribe subscribes the session to the stats, which is recorded by PostWriteReply.
+func (ctx *dashboardCall) subscribe(_ *struct{}) (*struct{}, *tp.Rerror) {
+	return nil, nil
+}
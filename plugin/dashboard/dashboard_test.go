@@ -0,0 +1,83 @@
+package dashboard_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/plugin/dashboard"
+)
+
+type Home struct {
+	tp.CallCtx
+}
+
+func (h *Home) Test(arg *string) (string, *tp.Rerror) {
+	if *arg == "error" {
+		return "", tp.NewRerror(500, "test error", "")
+	}
+	return *arg, nil
+}
+
+type statsPush struct {
+	tp.PushCtx
+}
+
+var statsCh = make(chan *dashboard.Stats, 10)
+
+func (s *statsPush) stats(stats *dashboard.Stats) *tp.Rerror {
+	statsCh <- stats
+	return nil
+}
+
+func TestDashboard(t *testing.T) {
+	// Server
+	dash := dashboard.NewDashboard("127.0.0.1:0", 100*time.Millisecond)
+	defer dash.Close()
+	srv := tp.NewPeer(tp.PeerConfig{Network: "mem", ListenPort: 9074}, dash)
+	defer srv.Close()
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe()
+
+	// Client
+	cli := tp.NewPeer(tp.PeerConfig{Network: "mem"})
+	defer cli.Close()
+	cli.SubRoute("/dashboard").RoutePushFunc((*statsPush).stats)
+	sess, rerr := cli.Dial(":9074")
+	if rerr.HasError() {
+		t.Fatal(rerr)
+	}
+	var result string
+	for _, arg := range []string{"ok", "ok", "error"} {
+		sess.Call("/home/test", arg, &result)
+	}
+	rerr = sess.Call(dashboard.SubscribeServiceMethod, nil, nil).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	var stats *dashboard.Stats
+	select {
+	case stats = <-statsCh:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the pushed stats")
+	}
+	if stats.SessionCount != 1 || stats.Calls < 3 || stats.Errors != 1 || len(stats.RecentErrors) != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	resp, err := http.Get("http://" + dash.Addr().String() + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var httpStats dashboard.Stats
+	if err = json.NewDecoder(resp.Body).Decode(&httpStats); err != nil {
+		t.Fatal(err)
+	}
+	if len(httpStats.Routes) == 0 {
+		t.Fatalf("unexpected stats: %+v", httpStats)
+	}
+	t.Logf("stats: %+v", httpStats)
+}
@@ -0,0 +1,58 @@
+package dashboard
+
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Teleport Dashboard</title>
+<style>
+body{font-family:-apple-system,Helvetica,Arial,sans-serif;margin:20px;color:#333}
+h1{font-size:20px}
+h2{font-size:16px;margin-top:24px}
+.cards{display:flex;gap:12px}
+.card{border:1px solid #ddd;border-radius:4px;padding:10px 16px;min-width:110px}
+.card b{display:block;font-size:22px}
+table{border-collapse:collapse;font-size:13px}
+td,th{border:1px solid #ddd;padding:4px 8px;text-align:left}
+.bar{background:#4a90d9;height:12px}
+</style>
+</head>
+<body>
+<h1>Teleport Dashboard <small id="time"></small></h1>
+<div class="cards">
+<div class="card">Sessions<b id="sessions">-</b></div>
+<div class="card">CALL/s<b id="call_rate">-</b></div>
+<div class="card">PUSH/s<b id="push_rate">-</b></div>
+<div class="card">Calls<b id="calls">-</b></div>
+<div class="card">Errors<b id="errors">-</b></div>
+</div>
+<h2>Latency</h2><table id="latency"></table>
+<h2>Recent Errors</h2><table id="recent_errors"></table>
+<h2>Routes</h2><table id="routes"></table>
+<h2>Sessions</h2><table id="session_list"></table>
+<script>
+function esc(s){return String(s).replace(/[&<>"]/g,function(c){return {'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;'}[c]})}
+function rows(id,head,list,fn){
+	var h='<tr>'+head.map(function(x){return '<th>'+x+'</th>'}).join('')+'</tr>';
+	(list||[]).forEach(function(x){h+='<tr>'+fn(x).map(function(y){return '<td>'+y+'</td>'}).join('')+'</tr>'});
+	document.getElementById(id).innerHTML=h;
+}
+function render(s){
+	document.getElementById('time').textContent=new Date(s.time).toLocaleTimeString();
+	document.getElementById('sessions').textContent=s.session_count;
+	document.getElementById('call_rate').textContent=s.call_rate.toFixed(1);
+	document.getElementById('push_rate').textContent=s.push_rate.toFixed(1);
+	document.getElementById('calls').textContent=s.calls;
+	document.getElementById('errors').textContent=s.errors;
+	var max=1;(s.latency||[]).forEach(function(b){if(b.count>max)max=b.count});
+	rows('latency',['&le;','count',''],s.latency,function(b){return [esc(b.le),b.count,'<div class="bar" style="width:'+(200*b.count/max)+'px"></div>']});
+	rows('recent_errors',['time','service method','remote','code','message'],(s.recent_errors||[]).slice().reverse(),function(e){return [new Date(e.time).toLocaleTimeString(),esc(e.service_method),esc(e.remote_addr),e.code,esc(e.message)]});
+	rows('routes',['type','name'],s.routes,function(r){return [r.type,esc(r.name)]});
+	rows('session_list',['id','remote addr'],s.sessions,function(x){return [esc(x.id),esc(x.remote_addr)]});
+}
+fetch('stats').then(function(r){return r.json()}).then(render);
+new EventSource('events').onmessage=function(e){render(JSON.parse(e.data))};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,460 @@
+// Package mirror is a plugin for mirroring the messages of a live session to an operator,
+// for debugging a single problematic client in production.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package mirror
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+const (
+	// AttachServiceMethod the service method of attaching to a session by the operator
+	AttachServiceMethod = "/mirror/attach"
+	// DetachServiceMethod the service method of detaching from a session by the operator
+	DetachServiceMethod = "/mirror/detach"
+	// MessageServiceMethod the service method of the mirrored messages pushed to the operator
+	MessageServiceMethod = "/mirror/message"
+
+	// Redacted the replacement of the redacted values
+	Redacted = "[REDACTED]"
+
+	// DirectionIn the message is received by the session
+	DirectionIn = "in"
+	// DirectionOut the message is sent by the session
+	DirectionOut = "out"
+
+	routePrefix = "/mirror/"
+	swapKey     = "mirror"
+)
+
+// Config mirror config
+type Config struct {
+	// MaxDuration the maximum duration of an attachment; default 5m
+	MaxDuration time.Duration
+	// QueueSize the maximum number of the mirrored messages waiting to be pushed per attachment,
+	// the others are dropped; default 256
+	QueueSize int
+	// MaxBodySize the maximum size of the mirrored body in JSON, the larger one is omitted; default 4KB
+	MaxBodySize int
+	// RedactMeta the metadata keys whose values are redacted
+	RedactMeta []string
+	// RedactFields the field names of the JSON bodies whose values are redacted, at any depth
+	RedactFields []string
+	// Authorize checks the operator calling AttachServiceMethod; if nil, any session is allowed
+	Authorize func(ctx tp.CallCtx) *tp.Rerror
+}
+
+// AttachArg the argument of AttachServiceMethod
+type AttachArg struct {
+	// SessionID the ID of the mirrored session
+	SessionID string `json:"session_id"`
+	// Duration the duration of the attachment; if <=0 or larger than Config.MaxDuration, Config.MaxDuration
+	Duration time.Duration `json:"duration"`
+}
+
+// AttachResult the result of AttachServiceMethod
+type AttachResult struct {
+	// Expire the time when the attachment ends
+	Expire time.Time `json:"expire"`
+}
+
+// DetachArg the argument of DetachServiceMethod
+type DetachArg struct {
+	// SessionID the ID of the mirrored session
+	SessionID string `json:"session_id"`
+}
+
+// Message the mirrored message pushed to MessageServiceMethod
+type Message struct {
+	SessionID     string            `json:"session_id"`
+	Time          time.Time         `json:"time"`
+	Direction     string            `json:"direction"`
+	Mtype         string            `json:"mtype"`
+	Seq           int32             `json:"seq"`
+	ServiceMethod string            `json:"service_method"`
+	Meta          map[string]string `json:"meta,omitempty"`
+	Body          json.RawMessage   `json:"body,omitempty"`
+	BodySize      int               `json:"body_size"`
+	BodyOmitted   bool              `json:"body_omitted,omitempty"` // too large or not JSON-compatible
+	Dropped       uint64            `json:"dropped,omitempty"`      // the number of the messages dropped before it
+}
+
+// NewMirror creates a plugin that mirrors the messages of the attached sessions.
+// NOTE:
+//  It should be registered as the plugin of the server peer;
+//  The operator attaches to a session by calling AttachServiceMethod, then the copies of
+//  its inbound and outbound messages are pushed to MessageServiceMethod of the operator session,
+//  until the attachment expires or either session is closed;
+//  The memory is bounded by Config.QueueSize and Config.MaxBodySize per attachment.
+func NewMirror(cfg Config) *Mirror {
+	if cfg.MaxDuration <= 0 {
+		cfg.MaxDuration = 5 * time.Minute
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 256
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = 4 << 10
+	}
+	m := &Mirror{
+		cfg:          cfg,
+		redactMeta:   make(map[string]bool, len(cfg.RedactMeta)),
+		redactFields: make(map[string]bool, len(cfg.RedactFields)),
+		attachments:  make(map[string]map[string]*attachment),
+	}
+	for _, k := range cfg.RedactMeta {
+		m.redactMeta[k] = true
+	}
+	for _, k := range cfg.RedactFields {
+		m.redactFields[k] = true
+	}
+	return m
+}
+
+// Mirror the plugin which mirrors the messages of the attached sessions.
+type Mirror struct {
+	cfg          Config
+	redactMeta   map[string]bool
+	redactFields map[string]bool
+	count        int32                             // the number of the attachments
+	attachments  map[string]map[string]*attachment // target session ID -> operator session ID -> attachment
+	mu           sync.RWMutex
+}
+
+type attachment struct {
+	target   string
+	operator tp.Session
+	queue    chan *Message
+	dropped  uint64
+	expire   time.Time
+	done     chan struct{}
+}
+
+var (
+	_ tp.PostNewPeerPlugin       = new(Mirror)
+	_ tp.PostAcceptPlugin        = new(Mirror)
+	_ tp.PostReadCallBodyPlugin  = new(Mirror)
+	_ tp.PostReadPushBodyPlugin  = new(Mirror)
+	_ tp.PostReadReplyBodyPlugin = new(Mirror)
+	_ tp.PostWriteCallPlugin     = new(Mirror)
+	_ tp.PostWriteReplyPlugin    = new(Mirror)
+	_ tp.PostWritePushPlugin     = new(Mirror)
+	_ tp.PostDisconnectPlugin    = new(Mirror)
+)
+
+// Name returns name.
+func (m *Mirror) Name() string {
+	return "mirror"
+}
+
+// PostNewPeer registers the attachment handlers.
+func (m *Mirror) PostNewPeer(peer tp.EarlyPeer) error {
+	group := peer.SubRoute("/mirror")
+	group.RouteCallFunc((*mirrorCall).attach)
+	group.RouteCallFunc((*mirrorCall).detach)
+	return nil
+}
+
+// PostAccept saves the mirror for the attachment handlers.
+func (m *Mirror) PostAccept(sess tp.PreSession) *tp.Rerror {
+	sess.Swap().Store(swapKey, m)
+	return nil
+}
+
+// PostReadCallBody mirrors the received CALL.
+func (m *Mirror) PostReadCallBody(ctx tp.ReadCtx) *tp.Rerror {
+	m.mirror(ctx.Session(), DirectionIn, ctx.Input())
+	return nil
+}
+
+// PostReadPushBody mirrors the received PUSH.
+func (m *Mirror) PostReadPushBody(ctx tp.ReadCtx) *tp.Rerror {
+	m.mirror(ctx.Session(), DirectionIn, ctx.Input())
+	return nil
+}
+
+// PostReadReplyBody mirrors the received REPLY.
+func (m *Mirror) PostReadReplyBody(ctx tp.ReadCtx) *tp.Rerror {
+	m.mirror(ctx.Session(), DirectionIn, ctx.Input())
+	return nil
+}
+
+// PostWriteCall mirrors the sent CALL.
+func (m *Mirror) PostWriteCall(ctx tp.WriteCtx) *tp.Rerror {
+	m.mirror(ctx.Session(), DirectionOut, ctx.Output())
+	return nil
+}
+
+// PostWriteReply mirrors the sent REPLY.
+func (m *Mirror) PostWriteReply(ctx tp.WriteCtx) *tp.Rerror {
+	m.mirror(ctx.Session(), DirectionOut, ctx.Output())
+	return nil
+}
+
+// PostWritePush mirrors the sent PUSH.
+func (m *Mirror) PostWritePush(ctx tp.WriteCtx) *tp.Rerror {
+	m.mirror(ctx.Session(), DirectionOut, ctx.Output())
+	return nil
+}
+
+// PostDisconnect ends the attachments of the session, either as the target or the operator.
+func (m *Mirror) PostDisconnect(sess tp.BaseSession) *tp.Rerror {
+	if atomic.LoadInt32(&m.count) == 0 {
+		return nil
+	}
+	id := sess.ID()
+	m.mu.Lock()
+	for _, a := range m.attachments[id] {
+		m.removeLocked(a)
+	}
+	for _, operators := range m.attachments {
+		if a, ok := operators[id]; ok {
+			m.removeLocked(a)
+		}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Attached returns the number of the attachments.
+func (m *Mirror) Attached() int {
+	return int(atomic.LoadInt32(&m.count))
+}
+
+type mirrorCall struct {
+	tp.CallCtx
+}
+
+func (ctx *mirrorCall) attach(arg *AttachArg) (*AttachResult, *tp.Rerror) {
+	v, ok := ctx.Session().Swap().Load(swapKey)
+	if !ok {
+		return nil, tp.NewRerror(tp.CodeNotFound, tp.CodeText(tp.CodeNotFound), "mirror: not enabled")
+	}
+	return v.(*Mirror).attach(ctx, arg)
+}
+
+func (ctx *mirrorCall) detach(arg *DetachArg) (*struct{}, *tp.Rerror) {
+	if v, ok := ctx.Session().Swap().Load(swapKey); ok {
+		v.(*Mirror).detach(ctx.Session().ID(), arg.SessionID)
+	}
+	return nil, nil
+}
+
+func (m *Mirror) attach(ctx tp.CallCtx, arg *AttachArg) (*AttachResult, *tp.Rerror) {
+	if m.cfg.Authorize != nil {
+		if rerr := m.cfg.Authorize(ctx); rerr != nil {
+			return nil, rerr
+		}
+	}
+	operator := ctx.Session()
+	if arg.SessionID == operator.ID() {
+		return nil, tp.NewRerror(tp.CodeBadMessage, tp.CodeText(tp.CodeBadMessage), "mirror: can not attach to the operator session itself")
+	}
+	if _, ok := ctx.Peer().GetSession(arg.SessionID); !ok {
+		return nil, tp.NewRerror(tp.CodeNotFound, tp.CodeText(tp.CodeNotFound), "mirror: session not found: "+arg.SessionID)
+	}
+	d := arg.Duration
+	if d <= 0 || d > m.cfg.MaxDuration {
+		d = m.cfg.MaxDuration
+	}
+	a := &attachment{
+		target:   arg.SessionID,
+		operator: operator,
+		queue:    make(chan *Message, m.cfg.QueueSize),
+		expire:   time.Now().Add(d),
+		done:     make(chan struct{}),
+	}
+	m.mu.Lock()
+	operators, ok := m.attachments[a.target]
+	if !ok {
+		operators = make(map[string]*attachment)
+		m.attachments[a.target] = operators
+	}
+	if old, ok := operators[operator.ID()]; ok {
+		// extends the attachment
+		close(old.done)
+	} else {
+		atomic.AddInt32(&m.count, 1)
+	}
+	operators[operator.ID()] = a
+	m.mu.Unlock()
+	tp.Infof("mirror: %s attached to %s until %s", operator.ID(), a.target, a.expire.Format(time.RFC3339))
+	go m.forward(a, d)
+	return &AttachResult{Expire: a.expire}, nil
+}
+
+func (m *Mirror) detach(operatorID, target string) {
+	m.mu.Lock()
+	if a, ok := m.attachments[target][operatorID]; ok {
+		m.removeLocked(a)
+	}
+	m.mu.Unlock()
+}
+
+// removeLocked ends the attachment, m.mu must be locked.
+func (m *Mirror) removeLocked(a *attachment) {
+	operators := m.attachments[a.target]
+	if operators[a.operator.ID()] != a {
+		return
+	}
+	delete(operators, a.operator.ID())
+	if len(operators) == 0 {
+		delete(m.attachments, a.target)
+	}
+	atomic.AddInt32(&m.count, -1)
+	close(a.done)
+	tp.Infof("mirror: %s detached from %s", a.operator.ID(), a.target)
+}
+
+// forward pushes the mirrored messages to the operator until the attachment ends.
+func (m *Mirror) forward(a *attachment, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-a.queue:
+			if rerr := a.operator.Push(MessageServiceMethod, msg); rerr != nil {
+				tp.Warnf("mirror: push to %s: %v", a.operator.ID(), rerr)
+			}
+		case <-timer.C:
+			m.mu.Lock()
+			m.removeLocked(a)
+			m.mu.Unlock()
+			return
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// mirror queues the copy of the message for the operators attached to the session.
+func (m *Mirror) mirror(sess tp.BaseSession, direction string, msg tp.Message) {
+	if atomic.LoadInt32(&m.count) == 0 || strings.HasPrefix(msg.ServiceMethod(), routePrefix) {
+		return
+	}
+	m.mu.RLock()
+	operators := m.attachments[sess.ID()]
+	if len(operators) == 0 {
+		m.mu.RUnlock()
+		return
+	}
+	attached := make([]*attachment, 0, len(operators))
+	for _, a := range operators {
+		attached = append(attached, a)
+	}
+	m.mu.RUnlock()
+
+	mirrored := m.copyMessage(sess.ID(), direction, msg)
+	for _, a := range attached {
+		c := *mirrored
+		c.Dropped = atomic.SwapUint64(&a.dropped, 0)
+		select {
+		case a.queue <- &c:
+		default:
+			atomic.AddUint64(&a.dropped, c.Dropped+1)
+		}
+	}
+}
+
+// copyMessage returns the redacted copy of the message.
+func (m *Mirror) copyMessage(sessionID, direction string, msg tp.Message) *Message {
+	mirrored := &Message{
+		SessionID:     sessionID,
+		Time:          time.Now(),
+		Direction:     direction,
+		Mtype:         tp.TypeText(msg.Mtype()),
+		Seq:           msg.Seq(),
+		ServiceMethod: msg.ServiceMethod(),
+	}
+	msg.Meta().VisitAll(func(key, value []byte) {
+		if mirrored.Meta == nil {
+			mirrored.Meta = make(map[string]string)
+		}
+		k := string(key)
+		if m.redactMeta[k] {
+			mirrored.Meta[k] = Redacted
+		} else {
+			mirrored.Meta[k] = string(value)
+		}
+	})
+	body, ok := m.encodeBody(msg.Body())
+	mirrored.BodySize = len(body)
+	if !ok || len(body) > m.cfg.MaxBodySize {
+		mirrored.BodyOmitted = true
+	} else {
+		mirrored.Body = body
+	}
+	return mirrored
+}
+
+// encodeBody encodes the body in JSON, and redacts the designated fields.
+func (m *Mirror) encodeBody(body interface{}) (json.RawMessage, bool) {
+	if body == nil {
+		return nil, true
+	}
+	var b []byte
+	switch v := body.(type) {
+	case []byte:
+		b = v
+	case *[]byte:
+		b = *v
+	default:
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return nil, false
+		}
+	}
+	if !json.Valid(b) {
+		return nil, false
+	}
+	if len(m.redactFields) == 0 {
+		return append(json.RawMessage(nil), b...), true
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, false
+	}
+	b, err := json.Marshal(m.redact(v))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+func (m *Mirror) redact(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			if m.redactFields[k] {
+				x[k] = Redacted
+			} else {
+				x[k] = m.redact(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = m.redact(e)
+		}
+	}
+	return v
+}
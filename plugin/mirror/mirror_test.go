@@ -0,0 +1,134 @@
+package mirror_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/plugin/mirror"
+)
+
+type Login struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+type Home struct {
+	tp.CallCtx
+}
+
+func (h *Home) Login(arg *Login) (string, *tp.Rerror) {
+	return "welcome " + arg.User, nil
+}
+
+type messagePush struct {
+	tp.PushCtx
+}
+
+var messageCh = make(chan *mirror.Message, 10)
+
+func (m *messagePush) message(msg *mirror.Message) *tp.Rerror {
+	messageCh <- msg
+	return nil
+}
+
+func TestMirror(t *testing.T) {
+	// Server
+	m := mirror.NewMirror(mirror.Config{
+		RedactMeta:   []string{"Authorization"},
+		RedactFields: []string{"password"},
+	})
+	srv := tp.NewPeer(tp.PeerConfig{ListenPort: 9063}, m)
+	defer srv.Close()
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe()
+	time.Sleep(500 * time.Millisecond)
+
+	// the problematic client
+	cli := tp.NewPeer(tp.PeerConfig{})
+	defer cli.Close()
+	sess, rerr := cli.Dial(":9063")
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	time.Sleep(100 * time.Millisecond)
+	var target string
+	srv.RangeSession(func(s tp.Session) bool {
+		target = s.ID()
+		return false
+	})
+
+	// the operator
+	op := tp.NewPeer(tp.PeerConfig{})
+	defer op.Close()
+	op.SubRoute("/mirror").RoutePushFunc((*messagePush).message)
+	opSess, rerr := op.Dial(":9063")
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	var result mirror.AttachResult
+	rerr = opSess.Call(mirror.AttachServiceMethod, &mirror.AttachArg{SessionID: target, Duration: time.Minute}, &result).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if m.Attached() != 1 || result.Expire.IsZero() {
+		t.Fatalf("expect 1 attachment, got %d, expire %v", m.Attached(), result.Expire)
+	}
+
+	var reply string
+	rerr = sess.Call("/home/login", &Login{User: "henry", Password: "secret"}, &reply,
+		tp.WithSetMeta("Authorization", "token"),
+	).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	// the pushes are handled concurrently by the operator
+	msgs := make(map[string]*mirror.Message)
+	for len(msgs) < 2 {
+		select {
+		case msg := <-messageCh:
+			if msg.SessionID != target || msg.ServiceMethod != "/home/login" {
+				t.Fatalf("unexpected mirrored message: %+v", msg)
+			}
+			msgs[msg.Direction+" "+msg.Mtype] = msg
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for the mirrored messages")
+		}
+	}
+	call, ok := msgs[mirror.DirectionIn+" CALL"]
+	if !ok {
+		t.Fatalf("expect the inbound CALL, got %v", msgs)
+	}
+	if call.Meta["Authorization"] != mirror.Redacted {
+		t.Fatalf("expect the redacted metadata, got %v", call.Meta)
+	}
+	var login Login
+	if err := json.Unmarshal(call.Body, &login); err != nil {
+		t.Fatal(err)
+	}
+	if login.User != "henry" || login.Password != mirror.Redacted {
+		t.Fatalf("expect the redacted body, got %s", call.Body)
+	}
+	replied, ok := msgs[mirror.DirectionOut+" REPLY"]
+	if !ok {
+		t.Fatalf("expect the outbound REPLY, got %v", msgs)
+	}
+	if string(replied.Body) != `"welcome henry"` {
+		t.Fatalf("unexpected reply body: %s", replied.Body)
+	}
+
+	rerr = opSess.Call(mirror.DetachServiceMethod, &mirror.DetachArg{SessionID: target}, nil).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if m.Attached() != 0 {
+		t.Fatalf("expect no attachment, got %d", m.Attached())
+	}
+	sess.Call("/home/login", &Login{User: "henry"}, &reply)
+	select {
+	case msg := <-messageCh:
+		t.Fatalf("unexpected mirrored message after detaching: %+v", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
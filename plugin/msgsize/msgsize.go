@@ -0,0 +1,141 @@
+// Package msgsize is a plugin for negotiating the maximum message size per session.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package msgsize
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/henrylee2cn/goutil"
+	tp "github.com/mylonly/teleport"
+)
+
+const (
+	// NegotiateServiceMethod the service method of the negotiation message
+	NegotiateServiceMethod = "/msgsize/negotiate"
+	// MetaReadLimit the metadata key of the maximum acceptable message size
+	MetaReadLimit = "X-Read-Limit"
+	// Feature the feature name used when downgrading
+	Feature = "msgsize"
+)
+
+// NewMsgSize creates a plugin that exchanges the maximum acceptable message size
+// with the remote peer during session setup, and limits the writing of the session.
+// NOTE:
+//  The readLimit is optional, the default is tp.GetReadLimit();
+//  Both the client and the server need to add the plugin.
+func NewMsgSize(readLimit ...uint32) tp.Plugin {
+	m := new(msgSize)
+	if len(readLimit) > 0 && readLimit[0] > 0 {
+		m.readLimit = readLimit[0]
+	}
+	return m
+}
+
+type msgSize struct {
+	readLimit uint32
+}
+
+var (
+	_ tp.PostNewPeerPlugin = new(msgSize)
+	_ tp.PostDialPlugin    = new(msgSize)
+	_ tp.PostAcceptPlugin  = new(msgSize)
+)
+
+// Name returns name.
+func (m *msgSize) Name() string {
+	return "msgsize"
+}
+
+func (m *msgSize) getReadLimit() uint32 {
+	if m.readLimit > 0 {
+		return m.readLimit
+	}
+	return tp.GetReadLimit()
+}
+
+// PostNewPeer registers the negotiation handler.
+func (m *msgSize) PostNewPeer(peer tp.EarlyPeer) error {
+	peer.SubRoute("/msgsize").RouteCallFunc((*negotiateCall).negotiate)
+	return nil
+}
+
+// PostDial sends the local read limit, and receives the remote read limit.
+// NOTE: If the remote peer does not support negotiation, downgrade to the global limit.
+func (m *msgSize) PostDial(sess tp.PreSession) *tp.Rerror {
+	rerr := sess.Send(NegotiateServiceMethod, nil, nil,
+		tp.WithMtype(tp.TypeCall),
+		tp.WithSetMeta(MetaReadLimit, strconv.FormatUint(uint64(m.getReadLimit()), 10)),
+	)
+	if rerr.HasError() {
+		return rerr
+	}
+	retMsg, rerr := sess.Receive(func(tp.Header) interface{} { return nil })
+	if rerr.HasError() {
+		if tp.IsMismatchRerror(rerr) {
+			sess.Downgrade(Feature, rerr)
+			return nil
+		}
+		return rerr
+	}
+	defer tp.PutMessage(retMsg)
+	return setWriteLimit(sess, retMsg.Meta().Peek(MetaReadLimit))
+}
+
+// PostAccept saves the local read limit for the negotiation handler.
+// NOTE: If the remote peer never negotiates, the global limit is used.
+func (m *msgSize) PostAccept(sess tp.PreSession) *tp.Rerror {
+	sess.Swap().Store(swapKey, m.getReadLimit())
+	return nil
+}
+
+const swapKey = "msgsize"
+
+type negotiateCall struct {
+	tp.CallCtx
+}
+
+func (ctx *negotiateCall) negotiate(_ *struct{}) (*struct{}, *tp.Rerror) {
+	rerr := setWriteLimit(ctx.Session(), ctx.PeekMeta(MetaReadLimit))
+	if rerr != nil {
+		return nil, rerr
+	}
+	readLimit, ok := ctx.Session().Swap().Load(swapKey)
+	if !ok {
+		readLimit = tp.GetReadLimit()
+	}
+	ctx.SetMeta(MetaReadLimit, strconv.FormatUint(uint64(readLimit.(uint32)), 10))
+	return nil, nil
+}
+
+type writeLimitSetter interface {
+	SetWriteLimit(limit uint32)
+}
+
+func setWriteLimit(sess writeLimitSetter, s []byte) *tp.Rerror {
+	if len(s) == 0 {
+		return nil
+	}
+	limit, err := strconv.ParseUint(goutil.BytesToString(s), 10, 32)
+	if err != nil {
+		return tp.NewRerror(tp.CodeBadMessage, tp.CodeText(tp.CodeBadMessage), "msgsize: invalid "+MetaReadLimit)
+	}
+	if limit < math.MaxUint32 {
+		sess.SetWriteLimit(uint32(limit))
+	}
+	return nil
+}
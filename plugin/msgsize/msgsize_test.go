@@ -0,0 +1,113 @@
+package msgsize_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/plugin/msgsize"
+)
+
+type Home struct {
+	tp.CallCtx
+}
+
+func (h *Home) Test(arg *string) (string, *tp.Rerror) {
+	return *arg, nil
+}
+
+func TestMsgSize(t *testing.T) {
+	// Server
+	srv := tp.NewPeer(
+		tp.PeerConfig{ListenPort: 9071},
+		msgsize.NewMsgSize(1024),
+	)
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe()
+	time.Sleep(1e9)
+
+	// Client
+	cli := tp.NewPeer(
+		tp.PeerConfig{},
+		msgsize.NewMsgSize(),
+	)
+	sess, rerr := cli.Dial(":9071")
+	if rerr.HasError() {
+		t.Fatal(rerr)
+	}
+	if sess.WriteLimit() != 1024 {
+		t.Fatalf("write limit: expect 1024, got %d", sess.WriteLimit())
+	}
+	var result string
+	rerr = sess.Call("/home/test", "small", &result).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	t.Logf("result:%s", result)
+	rerr = sess.Call("/home/test", strings.Repeat("a", 2048), &result).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeMessageTooLarge {
+		t.Fatalf("expect CodeMessageTooLarge, got %v", rerr)
+	}
+	t.Logf("large message rerror:%v", rerr)
+	// the session is still usable
+	rerr = sess.Call("/home/test", "small", &result).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+}
+
+type downgradeRecorder chan string
+
+func (downgradeRecorder) Name() string { return "downgrade-recorder" }
+
+func (d downgradeRecorder) PostDowngrade(_ tp.PreSession, feature string, _ *tp.Rerror) *tp.Rerror {
+	d <- feature
+	return nil
+}
+
+func TestDowngrade(t *testing.T) {
+	// Older server without msgsize
+	srv := tp.NewPeer(tp.PeerConfig{ListenPort: 9072})
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe()
+	time.Sleep(1e9)
+
+	// Newer client
+	recorder := make(downgradeRecorder, 1)
+	cli := tp.NewPeer(
+		tp.PeerConfig{},
+		msgsize.NewMsgSize(),
+		recorder,
+	)
+	sess, rerr := cli.Dial(":9072")
+	if rerr.HasError() {
+		t.Fatal(rerr)
+	}
+	if !sess.IsDowngraded(msgsize.Feature) || <-recorder != msgsize.Feature {
+		t.Fatal("expect msgsize to be downgraded")
+	}
+	var result string
+	rerr = sess.Call("/home/test", "downgraded", &result).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+
+	// Older client without msgsize
+	srv2 := tp.NewPeer(
+		tp.PeerConfig{ListenPort: 9073},
+		msgsize.NewMsgSize(1024),
+	)
+	srv2.RouteCall(new(Home))
+	go srv2.ListenAndServe()
+	time.Sleep(1e9)
+
+	sess, rerr = tp.NewPeer(tp.PeerConfig{}).Dial(":9073")
+	if rerr.HasError() {
+		t.Fatal(rerr)
+	}
+	rerr = sess.Call("/home/test", "downgraded", &result).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+}
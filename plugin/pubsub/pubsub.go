@@ -0,0 +1,372 @@
+// Package pubsub is a plugin for publishing the messages of the topics to the subscribed sessions,
+// whose subscriptions are resumed after the clients redial.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package pubsub
+
+import (
+	"strconv"
+	"sync"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/codec"
+)
+
+const (
+	// SubscribeServiceMethod the service method of subscribing to a topic
+	SubscribeServiceMethod = "/pubsub/subscribe"
+	// UnsubscribeServiceMethod the service method of unsubscribing from a topic
+	UnsubscribeServiceMethod = "/pubsub/unsubscribe"
+	// MessageServiceMethod the service method of the messages pushed to the subscribers
+	MessageServiceMethod = "/pubsub/message"
+
+	// MetaTopic the metadata key of the topic of the pushed message
+	MetaTopic = "X-Pubsub-Topic"
+	// MetaOffset the metadata key of the offset of the pushed message in its topic
+	MetaOffset = "X-Pubsub-Offset"
+
+	swapKey = "pubsub"
+)
+
+// SubscribeArg the argument of SubscribeServiceMethod
+type SubscribeArg struct {
+	Topic string `json:"topic"`
+	// Resume replays the retained messages whose offsets are larger than After
+	Resume bool   `json:"resume"`
+	After  uint64 `json:"after"`
+}
+
+// SubscribeResult the result of SubscribeServiceMethod
+type SubscribeResult struct {
+	// Offset the offset of the last message published to the topic
+	Offset uint64 `json:"offset"`
+}
+
+// UnsubscribeArg the argument of UnsubscribeServiceMethod
+type UnsubscribeArg struct {
+	Topic string `json:"topic"`
+}
+
+// NewBroker creates a plugin that publishes the messages to the subscribed sessions.
+// NOTE:
+//  It should be registered as the plugin of the server peer;
+//  The last retain messages of each topic are kept for replaying to the resumed subscriptions,
+//  the older ones are lost for the clients disconnected too long; default 64.
+func NewBroker(retain int) *Broker {
+	if retain <= 0 {
+		retain = 64
+	}
+	return &Broker{
+		retain: retain,
+		topics: make(map[string]*topic),
+	}
+}
+
+// Broker the plugin which publishes the messages to the subscribed sessions.
+type Broker struct {
+	retain int
+	topics map[string]*topic
+	mu     sync.Mutex
+}
+
+type topic struct {
+	name        string
+	offset      uint64
+	retained    []retained
+	subscribers map[tp.BaseSession]tp.Session
+	mu          sync.Mutex
+}
+
+type retained struct {
+	offset uint64
+	data   []byte
+}
+
+var (
+	_ tp.PostNewPeerPlugin    = new(Broker)
+	_ tp.PostAcceptPlugin     = new(Broker)
+	_ tp.SessionClosedPlugin  = new(Broker)
+	_ tp.PostNewPeerPlugin    = new(Subscriber)
+	_ tp.PostDialPlugin       = new(Subscriber)
+	_ tp.PostDisconnectPlugin = new(Subscriber)
+)
+
+// Name returns name.
+func (b *Broker) Name() string {
+	return "pubsub_broker"
+}
+
+// PostNewPeer registers the subscription handlers.
+func (b *Broker) PostNewPeer(peer tp.EarlyPeer) error {
+	group := peer.SubRoute("/pubsub")
+	group.RouteCallFunc((*brokerCall).subscribe)
+	group.RouteCallFunc((*brokerCall).unsubscribe)
+	return nil
+}
+
+// PostAccept saves the broker for the subscription handlers.
+func (b *Broker) PostAccept(sess tp.PreSession) *tp.Rerror {
+	sess.Swap().Store(swapKey, b)
+	return nil
+}
+
+// SessionClosed removes the subscriptions of the session.
+func (b *Broker) SessionClosed(sess tp.BaseSession, _ tp.CloseReason) *tp.Rerror {
+	b.mu.Lock()
+	topics := make([]*topic, 0, len(b.topics))
+	for _, t := range b.topics {
+		topics = append(topics, t)
+	}
+	b.mu.Unlock()
+	for _, t := range topics {
+		t.mu.Lock()
+		delete(t.subscribers, sess)
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// Publish publishes the message to the subscribers of the topic, and returns its offset.
+// NOTE:
+//  The messages of a topic are pushed in the order of their offsets.
+func (b *Broker) Publish(topicName string, data []byte) uint64 {
+	t := b.topic(topicName)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.offset++
+	t.retained = append(t.retained, retained{offset: t.offset, data: data})
+	if n := len(t.retained) - b.retain; n > 0 {
+		t.retained = append(t.retained[:0], t.retained[n:]...)
+	}
+	for _, sess := range t.subscribers {
+		t.push(sess, t.offset, data)
+	}
+	return t.offset
+}
+
+func (b *Broker) topic(name string) *topic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{
+			name:        name,
+			subscribers: make(map[tp.BaseSession]tp.Session),
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *Broker) subscribe(sess tp.Session, arg *SubscribeArg) *SubscribeResult {
+	t := b.topic(arg.Topic)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if arg.Resume {
+		for _, r := range t.retained {
+			if r.offset > arg.After {
+				t.push(sess, r.offset, r.data)
+			}
+		}
+	}
+	t.subscribers[sess] = sess
+	return &SubscribeResult{Offset: t.offset}
+}
+
+func (b *Broker) unsubscribe(sess tp.Session, topicName string) {
+	b.mu.Lock()
+	t, ok := b.topics[topicName]
+	b.mu.Unlock()
+	if ok {
+		t.mu.Lock()
+		delete(t.subscribers, sess)
+		t.mu.Unlock()
+	}
+}
+
+func (t *topic) push(sess tp.Session, offset uint64, data []byte) {
+	rerr := sess.Push(MessageServiceMethod, data,
+		tp.WithBodyCodec(codec.ID_PLAIN),
+		tp.WithSetMeta(MetaTopic, t.name),
+		tp.WithSetMeta(MetaOffset, strconv.FormatUint(offset, 10)),
+	)
+	if rerr != nil {
+		tp.Debugf("pubsub: push topic %s offset %d to %s: %v", t.name, offset, sess.ID(), rerr)
+	}
+}
+
+type brokerCall struct {
+	tp.CallCtx
+}
+
+func (ctx *brokerCall) subscribe(arg *SubscribeArg) (*SubscribeResult, *tp.Rerror) {
+	v, ok := ctx.Session().Swap().Load(swapKey)
+	if !ok {
+		return nil, tp.NewRerror(tp.CodeNotFound, tp.CodeText(tp.CodeNotFound), "pubsub: not enabled")
+	}
+	return v.(*Broker).subscribe(ctx.Session(), arg), nil
+}
+
+func (ctx *brokerCall) unsubscribe(arg *UnsubscribeArg) (*struct{}, *tp.Rerror) {
+	if v, ok := ctx.Session().Swap().Load(swapKey); ok {
+		v.(*Broker).unsubscribe(ctx.Session(), arg.Topic)
+	}
+	return nil, nil
+}
+
+// Handler handles the message of the topic received by the subscriber.
+// NOTE:
+//  Like the other PUSH handlers, it is called concurrently,
+//  so the messages may be handled out of the order of their offsets.
+type Handler func(topic string, offset uint64, data []byte)
+
+// NewSubscriber creates a plugin that receives the messages of the subscribed topics,
+// and resumes the subscriptions after redialing.
+// NOTE:
+//  It should be registered as the plugin of the client peer;
+//  After the session is redialed, it subscribes to the topics again and the broker replays
+//  the messages published while disconnected, as long as they are still retained.
+func NewSubscriber(handler Handler) *Subscriber {
+	return &Subscriber{
+		handler:  handler,
+		sessions: make(map[tp.BaseSession]map[string]uint64),
+	}
+}
+
+// Subscriber the plugin which receives the messages of the subscribed topics.
+type Subscriber struct {
+	handler  Handler
+	sessions map[tp.BaseSession]map[string]uint64 // session -> topic -> offset of the last received message
+	mu       sync.Mutex
+}
+
+// Name returns name.
+func (s *Subscriber) Name() string {
+	return "pubsub_subscriber"
+}
+
+// PostNewPeer registers the message handler.
+func (s *Subscriber) PostNewPeer(peer tp.EarlyPeer) error {
+	peer.SubRoute("/pubsub").RoutePushFunc((*subscriberPush).message)
+	return nil
+}
+
+// PostDial saves the subscriber for the message handler, and resumes the subscriptions after redialing.
+func (s *Subscriber) PostDial(sess tp.PreSession) *tp.Rerror {
+	sess.Swap().Store(swapKey, s)
+	session := sess.(tp.Session)
+	s.mu.Lock()
+	topics, ok := s.sessions[session]
+	var args []*SubscribeArg
+	if ok {
+		for name, offset := range topics {
+			args = append(args, &SubscribeArg{Topic: name, Resume: true, After: offset})
+		}
+	}
+	s.mu.Unlock()
+	if len(args) > 0 {
+		// the read loop has not started yet
+		go s.resubscribe(session, args)
+	}
+	return nil
+}
+
+// PostDisconnect forgets the subscriptions of the session, which is not redialed any more.
+func (s *Subscriber) PostDisconnect(sess tp.BaseSession) *tp.Rerror {
+	s.mu.Lock()
+	delete(s.sessions, sess)
+	s.mu.Unlock()
+	return nil
+}
+
+// Subscribe subscribes the session to the topic.
+func (s *Subscriber) Subscribe(sess tp.Session, topicName string) *tp.Rerror {
+	s.mu.Lock()
+	topics, ok := s.sessions[sess]
+	if !ok {
+		topics = make(map[string]uint64)
+		s.sessions[sess] = topics
+	}
+	_, subscribed := topics[topicName]
+	if !subscribed {
+		topics[topicName] = 0
+	}
+	s.mu.Unlock()
+	var result SubscribeResult
+	rerr := sess.Call(SubscribeServiceMethod, &SubscribeArg{Topic: topicName}, &result).Rerror()
+	if rerr != nil {
+		if !subscribed {
+			s.mu.Lock()
+			delete(topics, topicName)
+			s.mu.Unlock()
+		}
+		return rerr
+	}
+	s.received(sess, topicName, result.Offset)
+	return nil
+}
+
+// Unsubscribe unsubscribes the session from the topic.
+func (s *Subscriber) Unsubscribe(sess tp.Session, topicName string) *tp.Rerror {
+	s.mu.Lock()
+	if topics, ok := s.sessions[sess]; ok {
+		delete(topics, topicName)
+	}
+	s.mu.Unlock()
+	return sess.Call(UnsubscribeServiceMethod, &UnsubscribeArg{Topic: topicName}, nil).Rerror()
+}
+
+func (s *Subscriber) resubscribe(sess tp.Session, args []*SubscribeArg) {
+	for _, arg := range args {
+		rerr := sess.Call(SubscribeServiceMethod, arg, nil).Rerror()
+		if rerr != nil {
+			tp.Warnf("pubsub: resubscribe topic %s of %s: %v", arg.Topic, sess.ID(), rerr)
+		}
+	}
+}
+
+// received records the offset of the last received message of the subscribed topic.
+func (s *Subscriber) received(sess tp.BaseSession, topicName string, offset uint64) {
+	s.mu.Lock()
+	if topics, ok := s.sessions[sess]; ok {
+		if last, ok := topics[topicName]; ok && offset > last {
+			topics[topicName] = offset
+		}
+	}
+	s.mu.Unlock()
+}
+
+type subscriberPush struct {
+	tp.PushCtx
+}
+
+func (ctx *subscriberPush) message(arg *[]byte) *tp.Rerror {
+	v, ok := ctx.Session().Swap().Load(swapKey)
+	if !ok {
+		return nil
+	}
+	topicName := string(ctx.PeekMeta(MetaTopic))
+	offset, err := strconv.ParseUint(string(ctx.PeekMeta(MetaOffset)), 10, 64)
+	if err != nil {
+		return tp.NewRerror(tp.CodeBadMessage, tp.CodeText(tp.CodeBadMessage), "pubsub: invalid offset")
+	}
+	s := v.(*Subscriber)
+	s.received(ctx.Session(), topicName, offset)
+	if s.handler != nil {
+		s.handler(topicName, offset, *arg)
+	}
+	return nil
+}
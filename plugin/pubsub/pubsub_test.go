@@ -0,0 +1,102 @@
+package pubsub_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/plugin/pubsub"
+)
+
+type message struct {
+	topic  string
+	offset uint64
+	data   string
+}
+
+type closedPlugin chan struct{}
+
+func (c closedPlugin) Name() string {
+	return "closed"
+}
+
+func (c closedPlugin) SessionClosed(tp.BaseSession, tp.CloseReason) *tp.Rerror {
+	c <- struct{}{}
+	return nil
+}
+
+func TestResubscribe(t *testing.T) {
+	// Server
+	broker := pubsub.NewBroker(10)
+	closed := make(closedPlugin, 1)
+	srv := tp.NewPeer(tp.PeerConfig{Network: "mem", ListenPort: 9167}, broker, closed)
+	defer srv.Close()
+	go srv.ListenAndServe()
+
+	// Client
+	messageCh := make(chan message, 10)
+	subscriber := pubsub.NewSubscriber(func(topic string, offset uint64, data []byte) {
+		messageCh <- message{topic, offset, string(data)}
+	})
+	cli := tp.NewPeer(tp.PeerConfig{
+		Network:        "mem",
+		RedialTimes:    3,
+		RedialInterval: 200 * time.Millisecond,
+	}, subscriber)
+	defer cli.Close()
+	sess, rerr := cli.Dial(":9167")
+	if rerr.HasError() {
+		t.Fatal(rerr)
+	}
+	if rerr = subscriber.Subscribe(sess, "news"); rerr != nil {
+		t.Fatal(rerr)
+	}
+
+	// the messages may be handled out of order
+	expect := func(want ...message) {
+		t.Helper()
+		var got []message
+		for range want {
+			select {
+			case m := <-messageCh:
+				got = append(got, m)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expect %+v, got %+v", want, got)
+			}
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i].offset < got[j].offset })
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("expect %+v, got %+v", want, got)
+		}
+	}
+	broker.Publish("news", []byte("a"))
+	expect(message{"news", 1, "a"})
+
+	// the connection is broken, and the messages are published while the client is redialing
+	srv.RangeSession(func(s tp.Session) bool {
+		s.Close()
+		return false
+	})
+	<-closed
+	broker.Publish("news", []byte("b"))
+	broker.Publish("news", []byte("c"))
+	expect(message{"news", 2, "b"}, message{"news", 3, "c"})
+	if !sess.Health() {
+		t.Fatal("expect the session to be redialed")
+	}
+
+	broker.Publish("news", []byte("d"))
+	expect(message{"news", 4, "d"})
+
+	if rerr = subscriber.Unsubscribe(sess, "news"); rerr != nil {
+		t.Fatal(rerr)
+	}
+	broker.Publish("news", []byte("e"))
+	select {
+	case got := <-messageCh:
+		t.Fatalf("expect no message after unsubscribing, got %+v", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
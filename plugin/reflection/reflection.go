@@ -0,0 +1,170 @@
+// Package reflection is a plugin for discovering the service methods of the peer remotely,
+// e.g. by the generic CLI tools and the debuggers.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package reflection
+
+import (
+	"reflect"
+	"strings"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/codec"
+)
+
+// ListServiceMethod the service method of listing the service methods of the peer
+const ListServiceMethod = "/reflection/list"
+
+type (
+	// ListArgs the arguments of listing the service methods
+	ListArgs struct {
+		// Prefix lists only the service methods with the prefix; if empty, all
+		Prefix string `json:"prefix,omitempty"`
+	}
+	// ListReply the service methods of the peer
+	ListReply struct {
+		// Codecs the names of the body codecs supported by the peer
+		Codecs  []string `json:"codecs"`
+		Methods []Method `json:"methods"`
+	}
+	// Method the information of the service method
+	Method struct {
+		Name string `json:"name"`
+		// Type CALL, PUSH or STREAM
+		Type    string `json:"type"`
+		Version string `json:"version,omitempty"`
+		// Raw the handler gets the raw body bytes, e.g. the wildcard handler, which has no arg schema
+		Raw   bool    `json:"raw,omitempty"`
+		Arg   *Schema `json:"arg,omitempty"`
+		Reply *Schema `json:"reply,omitempty"`
+	}
+	// Schema the schema of the Go type of the body
+	Schema struct {
+		// Type the Go type name, e.g. *pkg.User
+		Type string `json:"type"`
+		// Kind the Go kind, e.g. struct, slice or string
+		Kind   string  `json:"kind"`
+		Fields []Field `json:"fields,omitempty"`
+		// Elem the schema of the element of the slice, array, pointer or map
+		Elem *Schema `json:"elem,omitempty"`
+		// Key the schema of the key of the map
+		Key *Schema `json:"key,omitempty"`
+	}
+	// Field the field of the struct
+	Field struct {
+		// Name the name in the body, e.g. by the json tag
+		Name   string  `json:"name"`
+		Schema *Schema `json:"schema"`
+	}
+)
+
+// NewReflection creates a plugin, which routes ListServiceMethod to list the service methods of the peer.
+// NOTE:
+//  It should be registered as the plugin of the peer;
+//  The routes registered or removed at runtime are listed at the time of the CALL.
+func NewReflection() tp.Plugin {
+	return new(reflection)
+}
+
+type reflection struct{}
+
+var _ tp.PostNewPeerPlugin = new(reflection)
+
+// Name returns name.
+func (r *reflection) Name() string {
+	return "reflection"
+}
+
+// PostNewPeer registers the list handler.
+func (r *reflection) PostNewPeer(peer tp.EarlyPeer) error {
+	peer.SubRoute("/reflection").RouteCallFunc((*listCall).list)
+	return nil
+}
+
+type listCall struct {
+	tp.CallCtx
+}
+
+func (l *listCall) list(args *ListArgs) (*ListReply, *tp.Rerror) {
+	reply := new(ListReply)
+	for _, c := range codec.List() {
+		reply.Codecs = append(reply.Codecs, c.Name())
+	}
+	for _, h := range l.Peer().Router().Handlers() {
+		if !strings.HasPrefix(h.Name(), args.Prefix) {
+			continue
+		}
+		m := Method{
+			Name:    h.Name(),
+			Type:    "PUSH",
+			Version: h.Version(),
+			Raw:     h.IsUnknown(),
+		}
+		if h.IsCall() {
+			m.Type = "CALL"
+		} else if h.IsStream() {
+			m.Type = "STREAM"
+			m.Raw = true
+		}
+		if !m.Raw {
+			m.Arg = NewSchema(h.ArgElemType())
+			if t := h.ReplyType(); t != nil {
+				m.Reply = NewSchema(t)
+			}
+		}
+		reply.Methods = append(reply.Methods, m)
+	}
+	return reply, nil
+}
+
+// NewSchema returns the schema of the Go type.
+func NewSchema(t reflect.Type) *Schema {
+	return newSchema(t, make(map[reflect.Type]bool))
+}
+
+// newSchema returns the schema of the type, and only the name of the struct being described, e.g. a tree node.
+func newSchema(t reflect.Type, describing map[reflect.Type]bool) *Schema {
+	s := &Schema{Type: t.String(), Kind: t.Kind().String()}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		s.Elem = newSchema(t.Elem(), describing)
+	case reflect.Map:
+		s.Key = newSchema(t.Key(), describing)
+		s.Elem = newSchema(t.Elem(), describing)
+	case reflect.Struct:
+		if describing[t] {
+			return s
+		}
+		describing[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if tag := field.Tag.Get("json"); tag != "" {
+				if tag = strings.Split(tag, ",")[0]; tag == "-" {
+					continue
+				} else if tag != "" {
+					name = tag
+				}
+			}
+			s.Fields = append(s.Fields, Field{Name: name, Schema: newSchema(field.Type, describing)})
+		}
+		delete(describing, t)
+	}
+	return s
+}
@@ -0,0 +1,82 @@
+package reflection_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/plugin/reflection"
+)
+
+type User struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Friends []*User  `json:"friends,omitempty"`
+	Secret  string   `json:"-"`
+	Tags    []string // no tag
+}
+
+type Home struct {
+	tp.CallCtx
+}
+
+func (h *Home) Get(id *int) (*User, *tp.Rerror) {
+	return &User{ID: *id}, nil
+}
+
+func TestReflection(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{Network: "mem", ListenPort: 9150}, reflection.NewReflection())
+	defer srv.Close()
+	srv.RouteCall(new(Home))
+	srv.SubRoute("/files").RouteCallWildcard("*path", func(tp.UnknownCallCtx) (interface{}, *tp.Rerror) {
+		return nil, nil
+	})
+	go srv.ListenAndServe()
+
+	cli := tp.NewPeer(tp.PeerConfig{Network: "mem"})
+	defer cli.Close()
+	sess, rerr := cli.Dial(":9150")
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	var reply reflection.ListReply
+	rerr = sess.Call(reflection.ListServiceMethod, &reflection.ListArgs{}, &reply).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if len(reply.Codecs) == 0 {
+		t.Fatal("expect the codecs")
+	}
+	var names []string
+	for _, m := range reply.Methods {
+		names = append(names, m.Name)
+	}
+	if len(names) != 3 || names[0] != "/files/*path" || names[1] != "/home/get" || names[2] != reflection.ListServiceMethod {
+		t.Fatalf("expect [/files/*path /home/get %s], got %v", reflection.ListServiceMethod, names)
+	}
+	if m := reply.Methods[0]; !m.Raw || m.Type != "CALL" || m.Arg != nil {
+		t.Fatalf("expect the raw CALL, got %+v", m)
+	}
+
+	rerr = sess.Call(reflection.ListServiceMethod, &reflection.ListArgs{Prefix: "/home"}, &reply).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if len(reply.Methods) != 1 {
+		t.Fatalf("expect 1 method, got %d", len(reply.Methods))
+	}
+	m := reply.Methods[0]
+	if m.Arg.Kind != "int" || m.Reply.Kind != "ptr" || m.Reply.Elem.Kind != "struct" {
+		t.Fatalf("unexpected schemas: %+v, %+v", m.Arg, m.Reply)
+	}
+	var fields []string
+	for _, f := range m.Reply.Elem.Fields {
+		fields = append(fields, f.Name)
+	}
+	if len(fields) != 4 || fields[0] != "id" || fields[1] != "name" || fields[2] != "friends" || fields[3] != "Tags" {
+		t.Fatalf("expect [id name friends Tags], got %v", fields)
+	}
+	// the recursive type is described once
+	if friend := m.Reply.Elem.Fields[2].Schema.Elem.Elem; friend.Type != "reflection_test.User" || len(friend.Fields) != 0 {
+		t.Fatalf("unexpected recursive schema: %+v", friend)
+	}
+}
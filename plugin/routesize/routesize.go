@@ -0,0 +1,298 @@
+// Package routesize is a plugin for tracking the request and reply sizes per route,
+// and alerting when the payload bloats.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package routesize
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// Direction of the message
+const (
+	Request = "request"
+	Reply   = "reply"
+)
+
+// Alert the event that the average size of a route exceeds the threshold in a window.
+type Alert struct {
+	Route     string
+	Direction string
+	// Baseline the average size of the previous windows, 0 if not available
+	Baseline float64
+	// Current the average size of the window
+	Current float64
+	// Reason e.g. "growth" or "max"
+	Reason string
+}
+
+// Config the config of the plugin
+type Config struct {
+	// Window the duration of a statistical window for alerting; default 1m.
+	Window time.Duration
+	// GrowthFactor alerts if the average size of a window is GrowthFactor times the baseline;
+	// if <=0, no growth alert.
+	GrowthFactor float64
+	// MaxAvgSize alerts if the average size of a window is bigger than it; if <=0, no limit.
+	MaxAvgSize float64
+	// OnAlert is called synchronously on the message path, should not block.
+	OnAlert func(Alert)
+}
+
+// SizeStats the size statistics of one direction of a route
+type SizeStats struct {
+	Count uint64
+	Min   uint32
+	Max   uint32
+	Avg   float64
+	// P99 the approximate 99th percentile, the upper bound of the power-of-two bucket
+	P99 uint32
+}
+
+// RouteStats the size statistics of a route
+type RouteStats struct {
+	Route   string
+	Request SizeStats
+	Reply   SizeStats
+}
+
+// RouteSize the plugin tracking the sizes per route
+type RouteSize struct {
+	cfg    Config
+	routes map[string]*route
+	mu     sync.RWMutex
+}
+
+var (
+	_ tp.PostReadCallBodyPlugin  = new(RouteSize)
+	_ tp.PostWriteReplyPlugin    = new(RouteSize)
+	_ tp.PostReadPushBodyPlugin  = new(RouteSize)
+	_ tp.PostWriteCallPlugin     = new(RouteSize)
+	_ tp.PostReadReplyBodyPlugin = new(RouteSize)
+	_ tp.PostWritePushPlugin     = new(RouteSize)
+)
+
+// NewRouteSize creates the plugin.
+// NOTE:
+//  The request is the CALL or PUSH, which is read on the server side or written on the client side;
+//  The reply is written on the server side or read on the client side, the error replies are not counted.
+func NewRouteSize(cfg Config) *RouteSize {
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	return &RouteSize{
+		cfg:    cfg,
+		routes: make(map[string]*route),
+	}
+}
+
+// Name returns name.
+func (r *RouteSize) Name() string {
+	return "routesize"
+}
+
+// PostReadCallBody counts the request size.
+func (r *RouteSize) PostReadCallBody(ctx tp.ReadCtx) *tp.Rerror {
+	r.observe(ctx.ServiceMethod(), Request, ctx.Input().Size())
+	return nil
+}
+
+// PostWriteReply counts the reply size.
+func (r *RouteSize) PostWriteReply(ctx tp.WriteCtx) *tp.Rerror {
+	if ctx.Rerror() == nil {
+		r.observe(ctx.Output().ServiceMethod(), Reply, ctx.Output().Size())
+	}
+	return nil
+}
+
+// PostReadPushBody counts the request size.
+func (r *RouteSize) PostReadPushBody(ctx tp.ReadCtx) *tp.Rerror {
+	r.observe(ctx.ServiceMethod(), Request, ctx.Input().Size())
+	return nil
+}
+
+// PostWriteCall counts the request size.
+func (r *RouteSize) PostWriteCall(ctx tp.WriteCtx) *tp.Rerror {
+	r.observe(ctx.Output().ServiceMethod(), Request, ctx.Output().Size())
+	return nil
+}
+
+// PostReadReplyBody counts the reply size.
+func (r *RouteSize) PostReadReplyBody(ctx tp.ReadCtx) *tp.Rerror {
+	r.observe(ctx.ServiceMethod(), Reply, ctx.Input().Size())
+	return nil
+}
+
+// PostWritePush counts the request size.
+func (r *RouteSize) PostWritePush(ctx tp.WriteCtx) *tp.Rerror {
+	r.observe(ctx.Output().ServiceMethod(), Request, ctx.Output().Size())
+	return nil
+}
+
+// Stats returns the size statistics of all the routes, sorted by route.
+func (r *RouteSize) Stats() []RouteStats {
+	r.mu.RLock()
+	all := make([]RouteStats, 0, len(r.routes))
+	for name, rt := range r.routes {
+		all = append(all, RouteStats{
+			Route:   name,
+			Request: rt.request.stats(),
+			Reply:   rt.reply.stats(),
+		})
+	}
+	r.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Route < all[j].Route
+	})
+	return all
+}
+
+func (r *RouteSize) observe(name, direction string, size uint32) {
+	if len(name) == 0 {
+		return
+	}
+	r.mu.RLock()
+	rt, ok := r.routes[name]
+	r.mu.RUnlock()
+	if !ok {
+		r.mu.Lock()
+		rt, ok = r.routes[name]
+		if !ok {
+			rt = new(route)
+			r.routes[name] = rt
+		}
+		r.mu.Unlock()
+	}
+	h := &rt.request
+	if direction == Reply {
+		h = &rt.reply
+	}
+	if alert, ok := h.observe(size, time.Now(), &r.cfg); ok && r.cfg.OnAlert != nil {
+		alert.Route = name
+		alert.Direction = direction
+		r.cfg.OnAlert(alert)
+	}
+}
+
+type route struct {
+	request histogram
+	reply   histogram
+}
+
+// histogram the size distribution with power-of-two buckets, and the windows for alerting.
+type histogram struct {
+	count   uint64
+	sum     uint64
+	min     uint32
+	max     uint32
+	buckets [33]uint64 // bucket i counts the sizes in (2^(i-1), 2^i]
+
+	windowStart time.Time
+	windowCount uint64
+	windowSum   uint64
+	baseline    float64 // the average size of the previous windows
+	mu          sync.Mutex
+}
+
+func bucketOf(size uint32) int {
+	if size <= 1 {
+		return 0
+	}
+	return bits.Len32(size - 1)
+}
+
+func (h *histogram) observe(size uint32, now time.Time, cfg *Config) (alert Alert, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 || size < h.min {
+		h.min = size
+	}
+	if size > h.max {
+		h.max = size
+	}
+	h.count++
+	h.sum += uint64(size)
+	h.buckets[bucketOf(size)]++
+
+	if h.windowStart.IsZero() {
+		h.windowStart = now
+	}
+	if now.Sub(h.windowStart) >= cfg.Window && h.windowCount > 0 {
+		alert, ok = h.rollWindow(cfg)
+		h.windowStart = now
+	}
+	h.windowCount++
+	h.windowSum += uint64(size)
+	return
+}
+
+// rollWindow closes the current window, and checks the thresholds.
+func (h *histogram) rollWindow(cfg *Config) (alert Alert, ok bool) {
+	current := float64(h.windowSum) / float64(h.windowCount)
+	alert = Alert{Baseline: h.baseline, Current: current}
+	switch {
+	case cfg.GrowthFactor > 0 && h.baseline > 0 && current >= h.baseline*cfg.GrowthFactor:
+		alert.Reason, ok = "growth", true
+	case cfg.MaxAvgSize > 0 && current > cfg.MaxAvgSize:
+		alert.Reason, ok = "max", true
+	}
+	if h.baseline == 0 {
+		h.baseline = current
+	} else if !ok {
+		// the bloated window does not raise the baseline
+		h.baseline = h.baseline*0.8 + current*0.2
+	}
+	h.windowCount = 0
+	h.windowSum = 0
+	return
+}
+
+func (h *histogram) stats() SizeStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := SizeStats{
+		Count: h.count,
+		Min:   h.min,
+		Max:   h.max,
+	}
+	if h.count == 0 {
+		return s
+	}
+	s.Avg = float64(h.sum) / float64(h.count)
+	rank := uint64(math.Ceil(float64(h.count) * 0.99))
+	var n uint64
+	for i, c := range h.buckets {
+		n += c
+		if n >= rank {
+			if i == 32 {
+				s.P99 = math.MaxUint32
+			} else {
+				s.P99 = 1 << uint(i)
+			}
+			break
+		}
+	}
+	if s.P99 > s.Max {
+		s.P99 = s.Max
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package routesize_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/plugin/routesize"
+)
+
+type Home struct {
+	tp.CallCtx
+}
+
+func (h *Home) Echo(arg *string) (string, *tp.Rerror) {
+	return *arg, nil
+}
+
+func TestRouteSize(t *testing.T) {
+	alerts := make(chan routesize.Alert, 10)
+	plugin := routesize.NewRouteSize(routesize.Config{
+		Window:       200 * time.Millisecond,
+		GrowthFactor: 10,
+		OnAlert: func(alert routesize.Alert) {
+			alerts <- alert
+		},
+	})
+	// Server
+	srv := tp.NewPeer(tp.PeerConfig{ListenPort: 9082}, plugin)
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe()
+	time.Sleep(1e9)
+
+	// Client
+	cli := tp.NewPeer(tp.PeerConfig{})
+	sess, rerr := cli.Dial(":9082")
+	if rerr.HasError() {
+		t.Fatal(rerr)
+	}
+	call := func(arg string) {
+		var result string
+		if rerr := sess.Call("/home/echo", arg, &result).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		call("small")
+	}
+	time.Sleep(300 * time.Millisecond)
+	// the payload bloats
+	for i := 0; i < 3; i++ {
+		call(strings.Repeat("a", 1024))
+	}
+	time.Sleep(300 * time.Millisecond)
+	call("small")
+
+	directions := map[string]bool{}
+	for len(directions) < 2 {
+		select {
+		case alert := <-alerts:
+			t.Logf("alert: %+v", alert)
+			if alert.Route != "/home/echo" || alert.Reason != "growth" {
+				t.Fatalf("unexpected alert: %+v", alert)
+			}
+			directions[alert.Direction] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expect growth alerts of both directions, got %v", directions)
+		}
+	}
+
+	stats := plugin.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("expect 1 route, got %d", len(stats))
+	}
+	s := stats[0].Request
+	if s.Count != 7 || s.Min >= s.Max || s.P99 != s.Max || s.Avg <= float64(s.Min) {
+		t.Fatalf("unexpected request stats: %+v", s)
+	}
+}
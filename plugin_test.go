@@ -0,0 +1,54 @@
+package tp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type acceptContextPlugin chan error
+
+func (acceptContextPlugin) Name() string {
+	return "accept_context"
+}
+
+func (p acceptContextPlugin) PostAcceptContext(ctx context.Context, sess tp.PreSession) *tp.Rerror {
+	// e.g. an auth lookup which respects the cancellation
+	<-ctx.Done()
+	p <- ctx.Err()
+	return tp.NewRerror(tp.CodeUnauthorized, tp.CodeText(tp.CodeUnauthorized), ctx.Err().Error())
+}
+
+func TestPluginContext(t *testing.T) {
+	accepted := make(acceptContextPlugin, 1)
+	p := newMemPeers(t, tp.PeerConfig{
+		HandshakeTimeout: 100 * time.Millisecond,
+	}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.PluginContainer().AppendRight(accepted)
+	})
+	defer p.Close()
+	select {
+	case err := <-accepted:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expect %v, got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the handshake plugin canceled by the handshake timeout")
+	}
+
+	// the session rejected by the server is closed
+	select {
+	case <-p.sess.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("expect the session context canceled")
+	}
+	if p.cli.Context().Err() != nil {
+		t.Fatal("expect the peer context alive")
+	}
+	p.cli.Close()
+	if p.cli.Context().Err() == nil {
+		t.Fatal("expect the peer context canceled")
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolConfig the config of the session pool.
+type PoolConfig struct {
+	// MaxSessions the maximum number of the sessions, default 4
+	MaxSessions int
+	// MinIdle the minimum number of the idle sessions kept dialed in advance, no more than MaxSessions
+	MinIdle int
+	// MaxIdle the maximum number of the idle sessions kept, the others are closed; default MaxSessions
+	MaxIdle int
+	// MaxLifetime the maximum duration of a session, after which it is closed once idle; if <=0, no limit
+	MaxLifetime time.Duration
+	// HealthCheckInterval the interval of checking the sessions and keeping MinIdle and MaxIdle, default 30s
+	HealthCheckInterval time.Duration
+	// HealthCheck reports whether the session is usable, e.g. by a CALL of the health route;
+	// if nil, Session.Health is used
+	HealthCheck func(Session) bool
+	// Resolver resolves the host of the address to the backends, which the sessions are spread across,
+	// and is looked up again by each health check; if nil, the address is dialed as is
+	Resolver Resolver
+	// RebalanceFraction the fraction of the sessions to the old backends which are closed once idle
+	// when new backends are resolved, so that they are redialed to the new ones; no more than 1, default 0
+	RebalanceFraction float64
+}
+
+func (c *PoolConfig) check() {
+	if c.MaxSessions <= 0 {
+		c.MaxSessions = 4
+	}
+	if c.MinIdle > c.MaxSessions {
+		c.MinIdle = c.MaxSessions
+	}
+	if c.MaxIdle <= 0 || c.MaxIdle > c.MaxSessions {
+		c.MaxIdle = c.MaxSessions
+	}
+	if c.MaxIdle < c.MinIdle {
+		c.MaxIdle = c.MinIdle
+	}
+	if c.HealthCheckInterval <= 0 {
+		c.HealthCheckInterval = 30 * time.Second
+	}
+	if c.RebalanceFraction > 1 {
+		c.RebalanceFraction = 1
+	}
+}
+
+// PoolStats the statistics of the session pool.
+type PoolStats struct {
+	// Sessions the number of the sessions
+	Sessions int
+	// Idle the number of the sessions without the CALLs or PUSHes in progress
+	Idle int
+	// InUse the number of the CALLs and PUSHes in progress
+	InUse int
+	// Dialed the number of the sessions dialed since the pool is created
+	Dialed uint64
+	// Closed the number of the sessions closed by the pool, e.g. unhealthy, expired or idle
+	Closed uint64
+	// Backends the number of the sessions per backend resolved by PoolConfig.Resolver
+	Backends map[string]int
+	// Rebalanced the number of the sessions retired for the new backends
+	Rebalanced uint64
+}
+
+// Pool maintains several sessions to an address, and balances the CALLs and PUSHes across them.
+type Pool struct {
+	peer       Peer
+	addr       string
+	protoFunc  []ProtoFunc
+	cfg        PoolConfig
+	sessions   []*pooledSession
+	backends   []string // the sorted addresses resolved by PoolConfig.Resolver
+	dialing    int
+	dialed     uint64
+	closed     uint64
+	rebalanced uint64
+	isClosed   bool
+	closeCh    chan struct{}
+	mu         sync.Mutex
+}
+
+type pooledSession struct {
+	sess     Session
+	backend  string // the address dialed
+	load     int32  // the number of the CALLs and PUSHes in progress
+	created  time.Time
+	lastUsed time.Time // guarded by Pool.mu
+	retired  bool      // not chosen any more, closed once idle; guarded by Pool.mu
+}
+
+// NewPool creates a pool of the sessions to the address.
+// NOTE:
+//  The sessions are dialed lazily when all the others are busy, and in advance up to PoolConfig.MinIdle;
+//  The least loaded session is chosen for each CALL or PUSH;
+//  If PoolConfig.Resolver is set, each session is dialed to the backend with the fewest sessions,
+//  and the TLS config of the peer should set the ServerName, since the backends are dialed by IP.
+func NewPool(peer Peer, addr string, cfg PoolConfig, protoFunc ...ProtoFunc) *Pool {
+	cfg.check()
+	p := &Pool{
+		peer:      peer,
+		addr:      addr,
+		protoFunc: protoFunc,
+		cfg:       cfg,
+		closeCh:   make(chan struct{}),
+	}
+	p.maintain()
+	go p.loop()
+	return p
+}
+
+// Addr returns the address.
+func (p *Pool) Addr() string {
+	return p.addr
+}
+
+// Peer returns the peer.
+func (p *Pool) Peer() Peer {
+	return p.peer
+}
+
+// Close closes the pool and all its sessions.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.isClosed {
+		p.mu.Unlock()
+		return
+	}
+	p.isClosed = true
+	close(p.closeCh)
+	sessions := p.sessions
+	p.sessions = nil
+	p.mu.Unlock()
+	for _, ps := range sessions {
+		ps.sess.Close()
+	}
+}
+
+// Stats returns the statistics of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := PoolStats{
+		Sessions:   len(p.sessions),
+		Dialed:     atomic.LoadUint64(&p.dialed),
+		Closed:     p.closed,
+		Rebalanced: p.rebalanced,
+	}
+	if len(p.backends) > 0 {
+		stats.Backends = make(map[string]int, len(p.backends))
+		for _, b := range p.backends {
+			stats.Backends[b] = 0
+		}
+	}
+	for _, ps := range p.sessions {
+		load := int(atomic.LoadInt32(&ps.load))
+		if load == 0 {
+			stats.Idle++
+		}
+		stats.InUse += load
+		if stats.Backends != nil {
+			stats.Backends[ps.backend]++
+		}
+	}
+	return stats
+}
+
+// AsyncCall sends a message and receives reply asynchronously, by the least loaded session.
+// NOTE: If the arg is []byte or *[]byte type, it can automatically fill in the body codec name.
+func (p *Pool) AsyncCall(
+	serviceMethod string,
+	arg interface{},
+	result interface{},
+	callCmdChan chan<- CallCmd,
+	setting ...MessageSetting,
+) CallCmd {
+	ps, rerr := p.acquire()
+	if rerr != nil {
+		callCmd := NewFakeCallCmd(serviceMethod, arg, result, rerr)
+		if callCmdChan != nil {
+			if cap(callCmdChan) == 0 {
+				Panicf("*Pool.AsyncCall(): callCmdChan channel is unbuffered")
+			}
+			callCmdChan <- callCmd
+		}
+		return callCmd
+	}
+	callCmd := ps.sess.AsyncCall(serviceMethod, arg, result, callCmdChan, setting...)
+	go func() {
+		<-callCmd.Done()
+		p.release(ps)
+	}()
+	return callCmd
+}
+
+// Call sends a message and receives reply, by the least loaded session.
+// NOTE: If the arg is []byte or *[]byte type, it can automatically fill in the body codec name.
+func (p *Pool) Call(serviceMethod string, arg interface{}, result interface{}, setting ...MessageSetting) CallCmd {
+	ps, rerr := p.acquire()
+	if rerr != nil {
+		return NewFakeCallCmd(serviceMethod, arg, result, rerr)
+	}
+	defer p.release(ps)
+	return ps.sess.Call(serviceMethod, arg, result, setting...)
+}
+
+// Push sends a message, but do not receives reply, by the least loaded session.
+// NOTE: If the arg is []byte or *[]byte type, it can automatically fill in the body codec name.
+func (p *Pool) Push(serviceMethod string, arg interface{}, setting ...MessageSetting) *Rerror {
+	ps, rerr := p.acquire()
+	if rerr != nil {
+		return rerr
+	}
+	defer p.release(ps)
+	return ps.sess.Push(serviceMethod, arg, setting...)
+}
+
+var rerrPoolClosed = NewRerror(CodeDialFailed, CodeText(CodeDialFailed), "session pool is closed")
+
+// acquire chooses the least loaded session, or dials a new one if all are busy and the pool is not full.
+func (p *Pool) acquire() (*pooledSession, *Rerror) {
+	p.mu.Lock()
+	if p.isClosed {
+		p.mu.Unlock()
+		return nil, rerrPoolClosed
+	}
+	var best *pooledSession
+	for _, ps := range p.sessions {
+		if ps.retired || !ps.sess.Health() {
+			continue
+		}
+		if best == nil || atomic.LoadInt32(&ps.load) < atomic.LoadInt32(&best.load) {
+			best = ps
+		}
+	}
+	if best != nil && (atomic.LoadInt32(&best.load) == 0 || len(p.sessions)+p.dialing >= p.cfg.MaxSessions) {
+		atomic.AddInt32(&best.load, 1)
+		p.mu.Unlock()
+		return best, nil
+	}
+	if best == nil && len(p.sessions)+p.dialing >= p.cfg.MaxSessions {
+		// all the sessions are unhealthy, and are replaced by the health check
+		p.mu.Unlock()
+		return nil, rerrConnClosed
+	}
+	p.dialing++
+	p.mu.Unlock()
+
+	ps, rerr := p.dial()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+	if rerr != nil {
+		if best != nil {
+			// fall back on the busy one
+			atomic.AddInt32(&best.load, 1)
+			return best, nil
+		}
+		return nil, rerr
+	}
+	if p.isClosed {
+		ps.sess.Close()
+		return nil, rerrPoolClosed
+	}
+	ps.load = 1
+	p.sessions = append(p.sessions, ps)
+	return ps, nil
+}
+
+func (p *Pool) dial() (*pooledSession, *Rerror) {
+	backend := p.pickBackend()
+	sess, rerr := p.peer.Dial(backend, p.protoFunc...)
+	if rerr != nil {
+		return nil, rerr
+	}
+	atomic.AddUint64(&p.dialed, 1)
+	now := time.Now()
+	return &pooledSession{sess: sess, backend: backend, created: now, lastUsed: now}, nil
+}
+
+// pickBackend returns the resolved backend with the fewest sessions, or the address if not resolved.
+func (p *Pool) pickBackend() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.backends) == 0 {
+		return p.addr
+	}
+	counts := make(map[string]int, len(p.backends))
+	for _, ps := range p.sessions {
+		if !ps.retired {
+			counts[ps.backend]++
+		}
+	}
+	best := p.backends[0]
+	for _, b := range p.backends[1:] {
+		if counts[b] < counts[best] {
+			best = b
+		}
+	}
+	return best
+}
+
+// resolve looks up the backends by PoolConfig.Resolver, retires the sessions to the removed backends,
+// and PoolConfig.RebalanceFraction of the others if new backends are added.
+func (p *Pool) resolve() {
+	if p.cfg.Resolver == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.HealthCheckInterval)
+	_, addrs, err := resolveDialAddrs(ctx, p.cfg.Resolver, p.addr)
+	cancel()
+	if err != nil || len(addrs) == 0 {
+		// keep the known backends
+		Warnf("session pool: resolve %s: %v", p.addr, err)
+		return
+	}
+	sort.Strings(addrs)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	known := make(map[string]bool, len(p.backends))
+	for _, b := range p.backends {
+		known[b] = true
+	}
+	current := make(map[string]bool, len(addrs))
+	var added bool
+	for _, a := range addrs {
+		current[a] = true
+		added = added || !known[a]
+	}
+	rebalance := added && len(p.backends) > 0 && p.cfg.RebalanceFraction > 0
+	p.backends = addrs
+
+	var n int
+	perBackend := make(map[string][]*pooledSession, len(addrs))
+	for _, ps := range p.sessions {
+		if ps.retired {
+			continue
+		}
+		if !current[ps.backend] {
+			ps.retired = true
+			continue
+		}
+		perBackend[ps.backend] = append(perBackend[ps.backend], ps)
+		n++
+	}
+	if !rebalance {
+		return
+	}
+	// retire from the most crowded backends
+	for n = int(math.Ceil(float64(n) * p.cfg.RebalanceFraction)); n > 0; n-- {
+		var crowded []*pooledSession
+		var backend string
+		for b, list := range perBackend {
+			if len(list) > len(crowded) {
+				crowded, backend = list, b
+			}
+		}
+		if len(crowded) == 0 {
+			break
+		}
+		crowded[len(crowded)-1].retired = true
+		perBackend[backend] = crowded[:len(crowded)-1]
+		p.rebalanced++
+	}
+}
+
+func (p *Pool) release(ps *pooledSession) {
+	p.mu.Lock()
+	ps.lastUsed = time.Now()
+	idle := atomic.AddInt32(&ps.load, -1) == 0
+	retired := idle && ps.retired && p.remove(ps)
+	p.mu.Unlock()
+	if retired {
+		ps.sess.Close()
+	}
+}
+
+// remove removes the session from the pool, p.mu should be locked.
+func (p *Pool) remove(ps *pooledSession) bool {
+	for i, s := range p.sessions {
+		if s == ps {
+			p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+			p.closed++
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh checks the sessions and resolves the backends now, instead of waiting for the health check,
+// e.g. when the service discovery reports the change of the backends.
+func (p *Pool) Refresh() {
+	p.maintain()
+}
+
+func (p *Pool) loop() {
+	ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		case <-ticker.C:
+			p.maintain()
+		}
+	}
+}
+
+// maintain closes the unhealthy, expired, rebalanced and excess idle sessions,
+// and then dials up to MinIdle idle sessions.
+func (p *Pool) maintain() {
+	p.resolve()
+	p.mu.Lock()
+	sessions := append([]*pooledSession(nil), p.sessions...)
+	p.mu.Unlock()
+
+	var toClose []*pooledSession
+	now := time.Now()
+	healthy := make(map[*pooledSession]bool, len(sessions))
+	for _, ps := range sessions {
+		healthy[ps] = ps.sess.Health() && (p.cfg.HealthCheck == nil || p.cfg.HealthCheck(ps.sess))
+	}
+
+	p.mu.Lock()
+	var idle []*pooledSession
+	for _, ps := range sessions {
+		expired := p.cfg.MaxLifetime > 0 && now.Sub(ps.created) >= p.cfg.MaxLifetime
+		if !healthy[ps] || expired {
+			ps.retired = true
+		}
+		if atomic.LoadInt32(&ps.load) != 0 {
+			continue
+		}
+		if ps.retired {
+			if p.remove(ps) {
+				toClose = append(toClose, ps)
+			}
+			continue
+		}
+		idle = append(idle, ps)
+	}
+	// close the least recently used idle sessions beyond MaxIdle
+	sort.Slice(idle, func(i, j int) bool { return idle[i].lastUsed.Before(idle[j].lastUsed) })
+	for len(idle) > p.cfg.MaxIdle {
+		if p.remove(idle[0]) {
+			toClose = append(toClose, idle[0])
+		}
+		idle = idle[1:]
+	}
+	need := p.cfg.MinIdle - len(idle)
+	if room := p.cfg.MaxSessions - len(p.sessions) - p.dialing; need > room {
+		need = room
+	}
+	if p.isClosed || need < 0 {
+		need = 0
+	}
+	p.dialing += need
+	p.mu.Unlock()
+
+	for _, ps := range toClose {
+		ps.sess.Close()
+	}
+	for ; need > 0; need-- {
+		ps, rerr := p.dial()
+		p.mu.Lock()
+		p.dialing--
+		if rerr == nil && !p.isClosed {
+			p.sessions = append(p.sessions, ps)
+			ps = nil
+		}
+		p.mu.Unlock()
+		if rerr != nil {
+			Warnf("session pool: dial %s: %s", p.addr, rerr.String())
+		} else if ps != nil {
+			ps.sess.Close()
+		}
+	}
+}
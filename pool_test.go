@@ -0,0 +1,185 @@
+package tp_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type poolCall struct {
+	tp.CallCtx
+}
+
+var (
+	poolStarted = make(chan struct{}, 10)
+	poolRelease chan struct{}
+)
+
+// Wait blocks until poolRelease is closed.
+func (p *poolCall) Wait(arg *int) (int, *tp.Rerror) {
+	poolStarted <- struct{}{}
+	<-poolRelease
+	return *arg, nil
+}
+
+type disconnectPlugin chan struct{}
+
+func (disconnectPlugin) Name() string {
+	return "disconnect"
+}
+
+func (d disconnectPlugin) PostDisconnect(tp.BaseSession) *tp.Rerror {
+	d <- struct{}{}
+	return nil
+}
+
+func TestPool(t *testing.T) {
+	poolRelease = make(chan struct{})
+	var path string
+	disconnected := make(disconnectPlugin, 10)
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		path = srv.RouteCallFunc((*poolCall).Wait)
+		cli.PluginContainer().AppendRight(disconnected)
+	})
+	defer p.Close()
+	pool := tp.NewPool(p.cli, p.addr, tp.PoolConfig{
+		MaxSessions:         3,
+		MinIdle:             2,
+		HealthCheckInterval: time.Hour,
+	})
+	defer pool.Close()
+	if stats := pool.Stats(); stats.Sessions != 2 || stats.Idle != 2 {
+		t.Fatalf("expect 2 idle sessions dialed in advance, got %+v", stats)
+	}
+
+	// grows up to MaxSessions while the sessions are busy
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var arg, result = 1, 0
+			if rerr := pool.Call(path, &arg, &result).Rerror(); rerr != nil {
+				t.Error(rerr)
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		<-poolStarted
+	}
+	if stats := pool.Stats(); stats.Sessions != 3 || stats.InUse != 5 {
+		t.Fatalf("expect 5 CALLs across 3 sessions, got %+v", stats)
+	}
+	close(poolRelease)
+	wg.Wait()
+	if stats := pool.Stats(); stats.InUse != 0 || stats.Dialed != 3 {
+		t.Fatalf("expect 3 sessions dialed and none in use, got %+v", stats)
+	}
+
+	// the broken sessions are replaced by the check
+	p.srv.RangeSession(func(s tp.Session) bool {
+		s.Close()
+		return true
+	})
+	// the 3 pooled sessions and the one dialed by newMemPeers
+	for i := 0; i < 4; i++ {
+		<-disconnected
+	}
+	pool.Refresh()
+	if stats := pool.Stats(); stats.Sessions != 2 || stats.Closed != 3 {
+		t.Fatalf("expect the broken sessions replaced by 2 idle ones, got %+v", stats)
+	}
+	var arg, result = 10, 0
+	if rerr := pool.Call(path, &arg, &result).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	<-poolStarted
+
+	pool.Close()
+	if rerr := pool.Push(path, &arg); rerr == nil {
+		t.Fatal("expect an error after the pool is closed")
+	}
+}
+
+// backendResolver resolves any host to the IPs.
+type backendResolver struct {
+	ips []string
+	mu  sync.Mutex
+}
+
+func (r *backendResolver) LookupHost(_ context.Context, _ string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ips...), nil
+}
+
+func (r *backendResolver) set(ips ...string) {
+	r.mu.Lock()
+	r.ips = ips
+	r.mu.Unlock()
+}
+
+// serveTCP serves the TCP listener of the address by the peer, and returns the listener.
+func serveTCP(t *testing.T, peer tp.Peer, addr string) net.Listener {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			peer.ServeConn(conn)
+		}
+	}()
+	return lis
+}
+
+func TestPoolRebalance(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{})
+	defer srv.Close()
+	lisA := serveTCP(t, srv, "127.0.0.1:0")
+	defer lisA.Close()
+	port := strconv.Itoa(lisA.Addr().(*net.TCPAddr).Port)
+	lisB := serveTCP(t, srv, "127.0.0.2:"+port)
+	defer lisB.Close()
+	backendA, backendB := "127.0.0.1:"+port, "127.0.0.2:"+port
+
+	cli := tp.NewPeer(tp.PeerConfig{})
+	defer cli.Close()
+	resolver := &backendResolver{ips: []string{"127.0.0.1"}}
+	pool := tp.NewPool(cli, "backend:"+port, tp.PoolConfig{
+		MaxSessions:         4,
+		MinIdle:             4,
+		HealthCheckInterval: time.Hour,
+		Resolver:            resolver,
+		RebalanceFraction:   0.5,
+	})
+	defer pool.Close()
+	if stats := pool.Stats(); stats.Backends[backendA] != 4 {
+		t.Fatalf("expect 4 sessions to %s, got %+v", backendA, stats)
+	}
+
+	// scale-out: half of the sessions are moved to the new backend
+	resolver.set("127.0.0.1", "127.0.0.2")
+	pool.Refresh()
+	stats := pool.Stats()
+	if stats.Backends[backendA] != 2 || stats.Backends[backendB] != 2 || stats.Rebalanced != 2 {
+		t.Fatalf("expect 2 sessions to each backend, got %+v", stats)
+	}
+
+	// scale-in: the sessions to the removed backend are moved
+	resolver.set("127.0.0.2")
+	pool.Refresh()
+	stats = pool.Stats()
+	if stats.Backends[backendB] != 4 || len(stats.Backends) != 1 || stats.Closed != 4 {
+		t.Fatalf("expect 4 sessions to %s, got %+v", backendB, stats)
+	}
+}
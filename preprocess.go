@@ -0,0 +1,118 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync"
+)
+
+// StageStats the statistics of the inbound message processing stages.
+type StageStats struct {
+	// PreprocessWorkers the number of body decoding workers, 0 means decoding in the read goroutine
+	PreprocessWorkers int
+	// PreprocessQueueLen the number of messages waiting to be decoded
+	PreprocessQueueLen int
+	// PreprocessQueueCap the capacity of the decoding queue
+	PreprocessQueueCap int
+	// HandlerWorkers the maximum number of the running handlers, 0 means no limit
+	HandlerWorkers int
+	// HandlerRunning the number of the running handlers, only counted if HandlerWorkers>0
+	HandlerRunning int
+	// HandlerQueueLen the number of messages waiting for the handlers
+	HandlerQueueLen int
+	// HandshakeTimeouts the number of the accepted connections closed since no valid message is read
+	// within PeerConfig.HandshakeTimeout
+	HandshakeTimeouts uint64
+}
+
+// maxRetainedRawBody the maximum capacity of the staged body buffer kept by a pooled context.
+const maxRetainedRawBody = 64 << 10
+
+// preprocessor decodes the message bodies with dedicated workers,
+// so that CPU-heavy decoding can not starve the handlers in the goroutine pool.
+type preprocessor struct {
+	workers int
+	queue   chan *handlerCtx
+	rwmu    sync.RWMutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func newPreprocessor(workers, queueSize int) *preprocessor {
+	if workers <= 0 {
+		return nil
+	}
+	if queueSize <= 0 {
+		queueSize = workers * 64
+	}
+	p := &preprocessor{
+		workers: workers,
+		queue:   make(chan *handlerCtx, queueSize),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *preprocessor) work() {
+	defer p.wg.Done()
+	for ctx := range p.queue {
+		p.process(ctx)
+	}
+}
+
+// submit puts the staged context into the queue.
+// NOTE: If the preprocessor has been stopped, process it synchronously.
+func (p *preprocessor) submit(ctx *handlerCtx) {
+	p.rwmu.RLock()
+	if p.stopped {
+		p.rwmu.RUnlock()
+		p.process(ctx)
+		return
+	}
+	p.queue <- ctx
+	p.rwmu.RUnlock()
+}
+
+func (p *preprocessor) process(ctx *handlerCtx) {
+	ctx.decodeStagedBody()
+	ctx.sess.peer.dispatch(ctx)
+}
+
+// stop stops the workers after the queued messages are processed.
+func (p *preprocessor) stop() {
+	p.rwmu.Lock()
+	if p.stopped {
+		p.rwmu.Unlock()
+		return
+	}
+	p.stopped = true
+	close(p.queue)
+	p.rwmu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *preprocessor) stats() StageStats {
+	if p == nil {
+		return StageStats{}
+	}
+	return StageStats{
+		PreprocessWorkers:  p.workers,
+		PreprocessQueueLen: len(p.queue),
+		PreprocessQueueCap: cap(p.queue),
+	}
+}
@@ -0,0 +1,39 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type stagedCall struct {
+	tp.CallCtx
+}
+
+func (s *stagedCall) Echo(arg *[]int) ([]int, *tp.Rerror) {
+	return *arg, nil
+}
+
+func TestPreprocess(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{PreprocessWorkers: 2}, tp.PeerConfig{PreprocessWorkers: 1}, func(srv, _ tp.Peer) {
+		srv.RouteCall(new(stagedCall))
+	})
+	defer p.Close()
+	if stats := p.srv.StageStats(); stats.PreprocessWorkers != 2 || stats.PreprocessQueueCap != 128 {
+		t.Fatalf("unexpected stage stats: %+v", stats)
+	}
+	for i := 0; i < 10; i++ {
+		var result []int
+		rerr := p.sess.Call("/staged_call/echo", []int{i, i + 1}, &result).Rerror()
+		if rerr != nil {
+			t.Fatalf("/staged_call/echo: %v", rerr)
+		}
+		if len(result) != 2 || result[0] != i || result[1] != i+1 {
+			t.Fatalf("/staged_call/echo: expect [%d %d], got %v", i, i+1, result)
+		}
+	}
+	rerr := p.sess.Call("/staged_call/echo", "bad", nil).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeBadMessage {
+		t.Fatalf("/staged_call/echo: expect CodeBadMessage, got %v", rerr)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+
+	"github.com/mylonly/teleport/socket"
+)
+
+// The priorities of writing the messages, the higher ones preempt the lower ones waiting for writing.
+const (
+	// PriorityLow e.g. the bulk pushes
+	PriorityLow = 1
+	// PriorityNormal the default
+	PriorityNormal = 2
+	// PriorityHigh e.g. the interactive CALLs and their replies
+	PriorityHigh = 3
+)
+
+// priorityControl the priority of the control messages, above any one of the users.
+const priorityControl = PriorityHigh + 1
+
+// WithPriority sets the priority of writing the message, which is one of PriorityLow, PriorityNormal and PriorityHigh;
+// the reply inherits the priority of the CALL, unless it is set by the handler.
+// NOTE: The control messages, e.g. the heartbeat PING, always take precedence.
+func WithPriority(priority int) MessageSetting {
+	return socket.WithSetMeta(MetaPriority, strconv.Itoa(priority))
+}
+
+// messagePriority returns the priority of writing the message.
+func messagePriority(message Message) int {
+	if IsControlType(message.Mtype()) {
+		return priorityControl
+	}
+	switch b := message.Meta().Peek(MetaPriority); string(b) {
+	case "1":
+		return PriorityLow
+	case "3":
+		return PriorityHigh
+	default:
+		return PriorityNormal
+	}
+}
+
+// inheritPriority sets the priority of the CALL to the reply, unless it is set by the handler.
+func (c *handlerCtx) inheritPriority() {
+	if p := c.input.Meta().Peek(MetaPriority); len(p) > 0 && len(c.output.Meta().Peek(MetaPriority)) == 0 {
+		c.output.Meta().SetBytesV(MetaPriority, p)
+	}
+}
+
+// priorityMutex the write lock of a session, which is handed over to the waiter of the highest priority,
+// in the order of arrival within the same priority.
+type priorityMutex struct {
+	mu      sync.Mutex
+	locked  bool
+	waiters [priorityControl + 1]list.List // *lockWaiter
+	seq     uint64
+	queue   writeQueue
+}
+
+type lockWaiter struct {
+	granted chan bool // false if dropped
+	seq     uint64
+}
+
+// Lock locks with the priority of the control messages.
+func (m *priorityMutex) Lock() {
+	m.lockPriority(priorityControl)
+}
+
+// lockPriority waits for the lock, and returns the error if the message is refused by the write queue policy.
+// NOTE: The control messages are never refused.
+func (m *priorityMutex) lockPriority(priority int) error {
+	m.mu.Lock()
+	if !m.locked {
+		m.locked = true
+		m.mu.Unlock()
+		return nil
+	}
+	if priority != priorityControl {
+		if err := m.queue.admit(m); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
+	m.seq++
+	w := &lockWaiter{granted: make(chan bool, 1), seq: m.seq}
+	m.waiters[priority].PushBack(w)
+	m.mu.Unlock()
+	if !<-w.granted {
+		return errWriteDropped
+	}
+	return nil
+}
+
+// Unlock hands the lock over to the waiter of the highest priority, if any.
+func (m *priorityMutex) Unlock() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p := len(m.waiters) - 1; p >= 0; p-- {
+		if front := m.waiters[p].Front(); front != nil {
+			m.waiters[p].Remove(front)
+			if p != priorityControl {
+				m.queue.waiting--
+			}
+			front.Value.(*lockWaiter).granted <- true
+			return
+		}
+	}
+	m.locked = false
+}
+
+// dropOldest refuses the oldest waiter except the control messages.
+// NOTE: The caller holds m.mu.
+func (m *priorityMutex) dropOldest() {
+	var oldest *list.Element
+	var level int
+	for p := 0; p < priorityControl; p++ {
+		if front := m.waiters[p].Front(); front != nil &&
+			(oldest == nil || front.Value.(*lockWaiter).seq < oldest.Value.(*lockWaiter).seq) {
+			oldest, level = front, p
+		}
+	}
+	if oldest != nil {
+		m.waiters[level].Remove(oldest)
+		m.queue.waiting--
+		oldest.Value.(*lockWaiter).granted <- false
+	}
+}
@@ -0,0 +1,89 @@
+package tp_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// gateLimiter the RateLimiter which holds the messages being written until the gate is opened,
+// so that the others wait for writing.
+type gateLimiter struct {
+	waiting chan struct{} // reports the message holding the write lock
+	open    chan struct{} // closed to open the gate
+}
+
+func newGateLimiter() *gateLimiter {
+	return &gateLimiter{waiting: make(chan struct{}, 16), open: make(chan struct{})}
+}
+
+func (g *gateLimiter) Wait(ctx context.Context, _ int) error {
+	g.waiting <- struct{}{}
+	select {
+	case <-g.open:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitWriteQueue waits until n messages are waiting for writing.
+func waitWriteQueue(t *testing.T, sess tp.Session, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for sess.Stats().WriteQueueLen != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d messages waiting for writing, got %d", n, sess.Stats().WriteQueueLen)
+		}
+		runtime.Gosched()
+	}
+}
+
+// writtenPushes reports the bodies of the PUSHes in the order of writing.
+type writtenPushes chan string
+
+func (writtenPushes) InspectInbound(tp.Session, tp.Message) *tp.Rerror {
+	return nil
+}
+
+func (w writtenPushes) InspectOutbound(_ tp.Session, msg tp.Message) {
+	if msg.Mtype() == tp.TypePush {
+		w <- fmt.Sprint(msg.Body())
+	}
+}
+
+func TestWritePriority(t *testing.T) {
+	written := make(writtenPushes, 8)
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.RoutePushFunc(notice_push)
+		cli.SetMessageInspector(written)
+	})
+	defer p.Close()
+	gate := newGateLimiter()
+	p.sess.SetWriteRateLimiter(gate, nil)
+	push := func(arg string, priority int) {
+		go p.sess.Push("/notice/push", arg, tp.WithPriority(priority))
+	}
+	push("low0", tp.PriorityLow)
+	<-gate.waiting
+	for i := 1; i <= 3; i++ {
+		push(fmt.Sprintf("low%d", i), tp.PriorityLow)
+		waitWriteQueue(t, p.sess, i)
+	}
+	push("high", tp.PriorityHigh)
+	waitWriteQueue(t, p.sess, 4)
+	close(gate.open)
+
+	var order []string
+	for i := 0; i < 5; i++ {
+		order = append(order, <-written)
+	}
+	if expect := []string{"low0", "high", "low1", "low2", "low3"}; !reflect.DeepEqual(order, expect) {
+		t.Fatalf("expect the high priority push preempts the waiting ones, got %v", order)
+	}
+}
@@ -25,10 +25,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/henrylee2cn/goutil"
 	tp "github.com/mylonly/teleport"
 	"github.com/mylonly/teleport/codec"
+	"github.com/mylonly/teleport/metrics"
 	"github.com/mylonly/teleport/utils"
 	"github.com/mylonly/teleport/xfer"
 	"github.com/mylonly/teleport/xfer/gzip"
@@ -112,15 +114,26 @@ func (h *httproto) Version() (byte, string) {
 	return h.id, h.name
 }
 
+var httpMetrics = metrics.Proto("http")
+
 // Pack writes the Message into the connection.
 // NOTE: Make sure to write only once or there will be package contamination!
 func (h *httproto) Pack(m tp.Message) (err error) {
+	var (
+		start              = time.Now()
+		headerLen, bodyLen int
+	)
+	defer func() {
+		httpMetrics.ObservePack(start, headerLen, bodyLen, err)
+	}()
+
 	// marshal body
 	m.SetBodyCodec(codec.ID_JSON)
 	bodyBytes, err := m.MarshalBody()
 	if err != nil {
 		return err
 	}
+	bodyLen = len(bodyBytes)
 
 	var header = make(http.Header, m.Meta().Len())
 
@@ -160,12 +173,20 @@ func (h *httproto) Pack(m tp.Message) (err error) {
 		err = h.packResponse(m, header, bb, bodyBytes)
 	// case tp.TypePush:
 	default:
-		return fmt.Errorf("unsupport message type: %d(%s)", m.Mtype(), tp.TypeText(m.Mtype()))
+		if !tp.IsControlType(m.Mtype()) {
+			return fmt.Errorf("unsupport message type: %d(%s)", m.Mtype(), tp.TypeText(m.Mtype()))
+		}
+		// sent as a request, the mtype is restored from the X-Mtype header
+		err = h.packRequest(m, header, bb, bodyBytes)
 	}
 	if err != nil {
 		return err
 	}
-	m.SetSize(uint32(bb.Len()))
+	err = m.SetSize(uint32(bb.Len()))
+	if err != nil {
+		return err
+	}
+	headerLen = bb.Len() - len(bodyBytes)
 	if h.printMessage {
 		tp.Printf("Send HTTP Message:\n%s", goutil.BytesToString(bb.B))
 	}
@@ -243,14 +264,22 @@ func (h *httproto) packResponse(m tp.Message, header http.Header, bb *utils.Byte
 var respPrefix = []byte("HTTP/")
 
 // Unpack reads bytes from the connection to the Message.
-func (h *httproto) Unpack(m tp.Message) error {
+func (h *httproto) Unpack(m tp.Message) (err error) {
+	var (
+		start              time.Time
+		headerLen, bodyLen int
+	)
+	defer func() {
+		httpMetrics.ObserveUnpack(start, headerLen, bodyLen, err)
+	}()
+
 	h.rMu.Lock()
 	defer h.rMu.Unlock()
 	bb := utils.AcquireByteBuffer()
 	defer utils.ReleaseByteBuffer(bb)
 	var size = 5
 	bb.ChangeLen(size)
-	_, err := io.ReadFull(h.rw, bb.B)
+	_, err = io.ReadFull(h.rw, bb.B)
 	if err != nil {
 		return err
 	}
@@ -279,10 +308,13 @@ func (h *httproto) Unpack(m tp.Message) error {
 			// TODO support
 			return errUnsupportHTTPCode
 		}
-		size, msg, err = h.unpack(m, bb)
+		size, headerLen, msg, err = h.unpack(m, bb)
 		if err != nil {
 			return err
 		}
+		start = time.Now()
+		headerLen += len(firstLine)
+		bodyLen = bb.Len()
 		if h.printMessage {
 			tp.Printf("Recv HTTP Message:\n%s\r\n%s",
 				goutil.BytesToString(firstLine), goutil.BytesToString(msg))
@@ -311,10 +343,13 @@ func (h *httproto) Unpack(m tp.Message) error {
 	if u.RawQuery != "" {
 		m.Meta().ParseBytes(goutil.StringToBytes(u.RawQuery))
 	}
-	size, msg, err = h.unpack(m, bb)
+	size, headerLen, msg, err = h.unpack(m, bb)
 	if err != nil {
 		return err
 	}
+	start = time.Now()
+	headerLen += len(firstLine)
+	bodyLen = bb.Len()
 	if h.printMessage {
 		tp.Printf("Recv HTTP Message:\n%s\r\n%s",
 			goutil.BytesToString(firstLine), goutil.BytesToString(msg))
@@ -344,13 +379,13 @@ var (
 	xMtypeStr = "X-Mtype"
 )
 
-func (h *httproto) unpack(m tp.Message, bb *utils.ByteBuffer) (size int, msg []byte, err error) {
+func (h *httproto) unpack(m tp.Message, bb *utils.ByteBuffer) (size, headerSize int, msg []byte, err error) {
 	var bodySize int
 	var a [][]byte
 	for i := 0; true; i++ {
 		err = h.readLine(bb)
 		if err != nil {
-			return 0, nil, err
+			return 0, 0, nil, err
 		}
 		if h.printMessage {
 			msg = append(msg, bb.B...)
@@ -364,7 +399,7 @@ func (h *httproto) unpack(m tp.Message, bb *utils.ByteBuffer) (size int, msg []b
 		// header
 		a = bytes.SplitN(bb.B, colonBytes, 2)
 		if len(a) != 2 {
-			return 0, nil, errBadHTTPMsg
+			return 0, 0, nil, errBadHTTPMsg
 		}
 		a[1] = bytes.TrimSpace(a[1])
 
@@ -378,7 +413,7 @@ func (h *httproto) unpack(m tp.Message, bb *utils.ByteBuffer) (size int, msg []b
 		if strings.EqualFold(contentLengthStr, key) {
 			bodySize, err = strconv.Atoi(goutil.BytesToString(a[1]))
 			if err != nil {
-				return 0, nil, errBadHTTPMsg
+				return 0, 0, nil, errBadHTTPMsg
 			}
 			size += bodySize
 			continue
@@ -387,7 +422,7 @@ func (h *httproto) unpack(m tp.Message, bb *utils.ByteBuffer) (size int, msg []b
 		if strings.EqualFold(xContentEncodingStr, key) {
 			zg, err := xfer.GetByName(goutil.BytesToString(a[1]))
 			if err != nil {
-				return 0, nil, err
+				return 0, 0, nil, err
 			}
 			m.XferPipe().Append(zg.ID())
 			continue
@@ -397,7 +432,7 @@ func (h *httproto) unpack(m tp.Message, bb *utils.ByteBuffer) (size int, msg []b
 			var seq int
 			seq, err = strconv.Atoi(goutil.BytesToString(a[1]))
 			if err != nil {
-				return 0, nil, errBadHTTPMsg
+				return 0, 0, nil, errBadHTTPMsg
 			}
 			m.SetSeq(int32(seq))
 			continue
@@ -407,7 +442,7 @@ func (h *httproto) unpack(m tp.Message, bb *utils.ByteBuffer) (size int, msg []b
 			var mtype int
 			mtype, err = strconv.Atoi(goutil.BytesToString(a[1]))
 			if err != nil {
-				return 0, nil, errBadHTTPMsg
+				return 0, 0, nil, errBadHTTPMsg
 			}
 			m.SetMtype(byte(mtype))
 			continue
@@ -425,20 +460,21 @@ func (h *httproto) unpack(m tp.Message, bb *utils.ByteBuffer) (size int, msg []b
 		//}
 		m.Meta().SetBytesKV(a[0], a[1])
 	}
+	headerSize = size - bodySize
 	if bodySize == 0 {
-		return size, msg, nil
+		return size, headerSize, msg, nil
 	}
 	bb.ChangeLen(bodySize)
 	_, err = io.ReadFull(h.rw, bb.B)
 	if err != nil {
-		return 0, nil, err
+		return 0, 0, nil, err
 	}
 	if h.printMessage {
 		msg = append(msg, bb.B...)
 		msg = append(msg, '\r', '\n')
 	}
 	bb.B, err = m.XferPipe().OnUnpack(bb.B)
-	return size, msg, err
+	return size, headerSize, msg, err
 }
 
 
@@ -22,11 +22,13 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/tidwall/gjson"
 
 	"github.com/henrylee2cn/goutil"
 	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/metrics"
 	"github.com/mylonly/teleport/utils"
 )
 
@@ -55,16 +57,27 @@ func (j *jsonproto) Version() (byte, string) {
 	return j.id, j.name
 }
 
+var jsonMetrics = metrics.Proto("json")
+
 const format = `{"seq":%d,"mtype":%d,"serviceMethod":%q,"meta":%q,"bodyCodec":%d,"body":"%s"}`
 
 // Pack writes the Message into the connection.
 // NOTE: Make sure to write only once or there will be package contamination!
-func (j *jsonproto) Pack(m tp.Message) error {
+func (j *jsonproto) Pack(m tp.Message) (err error) {
+	var (
+		start              = time.Now()
+		headerLen, bodyLen int
+	)
+	defer func() {
+		jsonMetrics.ObservePack(start, headerLen, bodyLen, err)
+	}()
+
 	// marshal body
 	bodyBytes, err := m.MarshalBody()
 	if err != nil {
 		return err
 	}
+	bodyBytes = bytes.Replace(bodyBytes, []byte{'"'}, []byte{'\\', '"'}, -1)
 
 	// marshal whole
 	var s = fmt.Sprintf(format,
@@ -73,8 +86,10 @@ func (j *jsonproto) Pack(m tp.Message) error {
 		m.ServiceMethod(),
 		m.Meta().QueryString(),
 		m.BodyCodec(),
-		bytes.Replace(bodyBytes, []byte{'"'}, []byte{'\\', '"'}, -1),
+		bodyBytes,
 	)
+	bodyLen = len(bodyBytes)
+	headerLen = len(s) - bodyLen
 
 	// do transfer pipe
 	b, err := m.XferPipe().OnPack(goutil.StringToBytes(s))
@@ -83,8 +98,11 @@ func (j *jsonproto) Pack(m tp.Message) error {
 	}
 	xferPipeLen := m.XferPipe().Len()
 
-	// set size
-	m.SetSize(uint32(1 + xferPipeLen + len(b)))
+	// set and check size
+	err = m.SetSize(uint32(1 + xferPipeLen + len(b)))
+	if err != nil {
+		return err
+	}
 
 	// pack
 	var all = make([]byte, m.Size()+4)
@@ -97,11 +115,19 @@ func (j *jsonproto) Pack(m tp.Message) error {
 }
 
 // Unpack reads bytes from the connection to the Message.
-func (j *jsonproto) Unpack(m tp.Message) error {
+func (j *jsonproto) Unpack(m tp.Message) (err error) {
+	var (
+		start              time.Time
+		headerLen, bodyLen int
+	)
+	defer func() {
+		jsonMetrics.ObserveUnpack(start, headerLen, bodyLen, err)
+	}()
+
 	j.rMu.Lock()
 	defer j.rMu.Unlock()
 	var size uint32
-	err := binary.Read(j.rw, binary.BigEndian, &size)
+	err = binary.Read(j.rw, binary.BigEndian, &size)
 	if err != nil {
 		return err
 	}
@@ -118,6 +144,7 @@ func (j *jsonproto) Unpack(m tp.Message) error {
 	if err != nil {
 		return err
 	}
+	start = time.Now()
 
 	// transfer pipe
 	var xferLen = bb.B[0]
@@ -147,6 +174,8 @@ func (j *jsonproto) Unpack(m tp.Message) error {
 	// read body
 	m.SetBodyCodec(byte(gjson.Get(s, "bodyCodec").Int()))
 	body := gjson.Get(s, "body").String()
+	bodyLen = len(body)
+	headerLen = len(s) - bodyLen
 	err = m.UnmarshalBody(goutil.StringToBytes(body))
 	return err
 }
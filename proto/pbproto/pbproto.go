@@ -20,9 +20,11 @@ import (
 	"encoding/binary"
 	"io"
 	"sync"
+	"time"
 
 	tp "github.com/mylonly/teleport"
 	"github.com/mylonly/teleport/codec"
+	"github.com/mylonly/teleport/metrics"
 	"github.com/mylonly/teleport/proto/pbproto/pb"
 	"github.com/mylonly/teleport/utils"
 )
@@ -50,9 +52,19 @@ func (pp *pbproto) Version() (byte, string) {
 	return pp.id, pp.name
 }
 
+var pbMetrics = metrics.Proto("protobuf")
+
 // Pack writes the Message into the connection.
 // NOTE: Make sure to write only once or there will be package contamination!
-func (pp *pbproto) Pack(m tp.Message) error {
+func (pp *pbproto) Pack(m tp.Message) (err error) {
+	var (
+		start              = time.Now()
+		headerLen, bodyLen int
+	)
+	defer func() {
+		pbMetrics.ObservePack(start, headerLen, bodyLen, err)
+	}()
+
 	// marshal body
 	bodyBytes, err := m.MarshalBody()
 	if err != nil {
@@ -70,6 +82,8 @@ func (pp *pbproto) Pack(m tp.Message) error {
 	if err != nil {
 		return err
 	}
+	bodyLen = len(bodyBytes)
+	headerLen = len(b) - bodyLen
 
 	// do transfer pipe
 	b, err = m.XferPipe().OnPack(b)
@@ -78,8 +92,11 @@ func (pp *pbproto) Pack(m tp.Message) error {
 	}
 	xferPipeLen := m.XferPipe().Len()
 
-	// set size
-	m.SetSize(uint32(1 + xferPipeLen + len(b)))
+	// set and check size
+	err = m.SetSize(uint32(1 + xferPipeLen + len(b)))
+	if err != nil {
+		return err
+	}
 
 	// pack
 	var all = make([]byte, m.Size()+4)
@@ -92,11 +109,19 @@ func (pp *pbproto) Pack(m tp.Message) error {
 }
 
 // Unpack reads bytes from the connection to the Message.
-func (pp *pbproto) Unpack(m tp.Message) error {
+func (pp *pbproto) Unpack(m tp.Message) (err error) {
+	var (
+		start              time.Time
+		headerLen, bodyLen int
+	)
+	defer func() {
+		pbMetrics.ObserveUnpack(start, headerLen, bodyLen, err)
+	}()
+
 	pp.rMu.Lock()
 	defer pp.rMu.Unlock()
 	var size uint32
-	err := binary.Read(pp.rw, binary.BigEndian, &size)
+	err = binary.Read(pp.rw, binary.BigEndian, &size)
 	if err != nil {
 		return err
 	}
@@ -114,6 +139,7 @@ func (pp *pbproto) Unpack(m tp.Message) error {
 	if err != nil {
 		return err
 	}
+	start = time.Now()
 
 	// transfer pipe
 	var xferLen = bb.B[0]
@@ -136,6 +162,8 @@ func (pp *pbproto) Unpack(m tp.Message) error {
 	if err != nil {
 		return err
 	}
+	bodyLen = len(s.Body)
+	headerLen = len(bb.B) - bodyLen
 
 	// read other
 	m.SetSeq(s.Seq)
@@ -31,3 +31,12 @@ import (
 func NewRawProtoFunc() tp.ProtoFunc {
 	return socket.RawProtoFunc
 }
+
+// NewRawTLVProtoFunc is creation function of the raw protocol with the binary TLV metadata,
+// which has no percent-encoding and supports the metadata longer than 65535.
+// NOTE:
+//  id:'R', name:"raw-tlv"
+//  It can be selected per session by Session.UpgradeProto.
+func NewRawTLVProtoFunc() tp.ProtoFunc {
+	return socket.RawTLVProtoFunc
+}
@@ -1,10 +1,12 @@
 package rawproto_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/proto/rawproto"
 	"github.com/mylonly/teleport/xfer/gzip"
 )
 
@@ -61,3 +63,43 @@ func (p *Push) Test(arg *map[string]string) *tp.Rerror {
 	tp.Infof("receive push(%s):\narg: %#v\n", p.IP(), arg)
 	return nil
 }
+
+func (h *Home) Meta(*struct{}) (string, *tp.Rerror) {
+	return string(h.PeekMeta("k")), nil
+}
+
+func TestRawTLVProto(t *testing.T) {
+	tp.RegUpgradeProto(rawproto.NewRawTLVProtoFunc())
+
+	// server
+	srv := tp.NewPeer(tp.PeerConfig{ListenPort: 9083})
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe()
+	time.Sleep(1e9)
+
+	// client
+	cli := tp.NewPeer(tp.PeerConfig{})
+	sess, err := cli.Dial(":9083")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", 70000)
+	var result string
+	rerr := sess.Call("/home/meta", nil, &result, tp.WithAddMeta("k", long)).Rerror()
+	if rerr == nil {
+		t.Fatal("expect the urlencoded metadata longer than 65535 to fail")
+	}
+	// select the TLV metadata for the session
+	if rerr = sess.UpgradeProto(rawproto.NewRawTLVProtoFunc()); rerr != nil {
+		t.Fatal(rerr)
+	}
+	for _, v := range []string{"a=b&c=%zz+ \x00", long} {
+		rerr = sess.Call("/home/meta", nil, &result, tp.WithAddMeta("k", v)).Rerror()
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		if result != v {
+			t.Fatalf("expect the metadata of length %d, got %d", len(v), len(result))
+		}
+	}
+}
@@ -2,10 +2,14 @@ package thriftproto
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
 	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/metrics"
 	"github.com/mylonly/teleport/proto/thriftproto/gen-go/payload"
 )
 
@@ -16,7 +20,8 @@ func init() {
 
 // NewTProtoFunc creates tp.ProtoFunc of Thrift protocol.
 // NOTE:
-//  If @factory is not provided, use the default binary protocol.
+//
+//	If @factory is not provided, use the default binary protocol.
 func NewTProtoFunc(factory ...thrift.TProtocolFactory) tp.ProtoFunc {
 	var fa thrift.TProtocolFactory
 	if len(factory) > 0 {
@@ -31,6 +36,8 @@ func NewTProtoFunc(factory ...thrift.TProtocolFactory) tp.ProtoFunc {
 			rw:   rw,
 		}
 		p.ioprot = fa.GetProtocol(p)
+		p.packBuf = thrift.NewTMemoryBuffer()
+		p.packProt = fa.GetProtocol(p.packBuf)
 		p.payloadPool = sync.Pool{
 			New: func() interface{} {
 				return payload.NewPayload()
@@ -45,10 +52,11 @@ type thriftproto struct {
 	name        string
 	rw          tp.IOWithReadBuffer
 	ioprot      thrift.TProtocol
+	packBuf     *thrift.TMemoryBuffer // the message is packed into it to check the size before writing
+	packProt    thrift.TProtocol
 	packLock    sync.Mutex
 	unpackLock  sync.Mutex
 	currReaded  int
-	currWrited  int
 	payloadPool sync.Pool
 }
 
@@ -57,14 +65,30 @@ func (t *thriftproto) Version() (byte, string) {
 	return t.id, t.name
 }
 
+var thriftMetrics = metrics.Proto("thrift")
+
+// headerLen returns the length of the fields except the body.
+func headerLen(serviceMethod string, pd *payload.Payload) int {
+	return len(serviceMethod) + len(pd.Meta) + len(pd.XferPipe)
+}
+
 // Pack writes the Message into the connection.
 // NOTE: Make sure to write only once or there will be package contamination!
-func (t *thriftproto) Pack(m tp.Message) error {
+func (t *thriftproto) Pack(m tp.Message) (err error) {
+	var (
+		start           = time.Now()
+		hdrLen, bodyLen int
+	)
+	defer func() {
+		thriftMetrics.ObservePack(start, hdrLen, bodyLen, err)
+	}()
+
 	// marshal body
 	bodyBytes, err := m.MarshalBody()
 	if err != nil {
 		return err
 	}
+	bodyLen = len(bodyBytes)
 
 	// do transfer pipe
 	bodyBytes, err = m.XferPipe().OnPack(bodyBytes)
@@ -76,6 +100,7 @@ func (t *thriftproto) Pack(m tp.Message) error {
 	*pd = payload.Payload{}
 	defer t.payloadPool.Put(pd)
 
+	pd.Meta = m.Meta().QueryString()
 	var typeID thrift.TMessageType
 	switch m.Mtype() {
 	case tp.TypeCall:
@@ -84,44 +109,63 @@ func (t *thriftproto) Pack(m tp.Message) error {
 		typeID = thrift.REPLY
 	case tp.TypePush:
 		typeID = thrift.ONEWAY
+	default:
+		if tp.IsControlType(m.Mtype()) {
+			// thrift has no such message type, so it is carried in the meta
+			typeID = thrift.ONEWAY
+			pd.Meta = appendMtype(pd.Meta, m.Mtype())
+		}
 	}
-	pd.Meta = m.Meta().QueryString()
 	pd.BodyCodec = int32(m.BodyCodec())
 	pd.XferPipe = m.XferPipe().IDs()
 	pd.Body = bodyBytes
+	hdrLen = headerLen(m.ServiceMethod(), pd)
 
 	t.packLock.Lock()
 	defer t.packLock.Unlock()
 
 	// pack
-	t.currWrited = 0
-	if err := t.ioprot.WriteMessageBegin(m.ServiceMethod(), typeID, m.Seq()); err != nil {
+	t.packBuf.Reset()
+	if err := t.packProt.WriteMessageBegin(m.ServiceMethod(), typeID, m.Seq()); err != nil {
 		return err
 	}
 
-	if err = pd.Write(t.ioprot); err != nil {
+	if err = pd.Write(t.packProt); err != nil {
 		return err
 	}
 
-	if err = t.ioprot.WriteMessageEnd(); err != nil {
+	if err = t.packProt.WriteMessageEnd(); err != nil {
 		return err
 	}
-	if err = t.ioprot.Flush(nil); err != nil {
+	if err = t.packProt.Flush(nil); err != nil {
 		return err
 	}
 
-	// set size
-	m.SetSize(uint32(t.currWrited))
+	// set and check size
+	if err = m.SetSize(uint32(t.packBuf.Len())); err != nil {
+		return err
+	}
 
-	return nil
+	_, err = t.rw.Write(t.packBuf.Bytes())
+	return err
 }
 
-func (t *thriftproto) Unpack(m tp.Message) error {
+func (t *thriftproto) Unpack(m tp.Message) (err error) {
+	var (
+		start           time.Time
+		hdrLen, bodyLen int
+	)
+	defer func() {
+		thriftMetrics.ObserveUnpack(start, hdrLen, bodyLen, err)
+	}()
+
 	pd, err := t.unpack(m)
 	if err != nil {
 		return err
 	}
 	defer t.payloadPool.Put(pd)
+	start = time.Now()
+	hdrLen = headerLen(m.ServiceMethod(), pd)
 
 	err = m.XferPipe().Append(pd.XferPipe...)
 	if err != nil {
@@ -131,25 +175,55 @@ func (t *thriftproto) Unpack(m tp.Message) error {
 	if err != nil {
 		return err
 	}
+	bodyLen = len(body)
 	m.Meta().ParseBytes(pd.Meta)
+	if err = restoreMtype(m); err != nil {
+		return err
+	}
 	m.SetBodyCodec(byte(pd.BodyCodec))
 	return m.UnmarshalBody(body)
 }
 
+// metaMtype is the reserved meta key which carries the control message type.
+const metaMtype = "X-Mtype"
+
+// appendMtype returns a copy of the meta with the message type appended.
+func appendMtype(meta []byte, mtype byte) []byte {
+	b := make([]byte, 0, len(meta)+len(metaMtype)+5)
+	if len(meta) > 0 {
+		b = append(append(b, meta...), '&')
+	}
+	b = append(append(b, metaMtype...), '=')
+	return strconv.AppendInt(b, int64(mtype), 10)
+}
+
+// restoreMtype restores the control message type carried by the meta of a ONEWAY message.
+func restoreMtype(m tp.Message) error {
+	if m.Mtype() != tp.TypePush {
+		return nil
+	}
+	v := m.Meta().Peek(metaMtype)
+	if len(v) == 0 {
+		return nil
+	}
+	mtype, err := strconv.Atoi(string(v))
+	if err != nil || !tp.IsControlType(byte(mtype)) {
+		return fmt.Errorf("thriftproto: bad %s meta: %q", metaMtype, v)
+	}
+	m.Meta().Del(metaMtype)
+	m.SetMtype(byte(mtype))
+	return nil
+}
+
 func (t *thriftproto) unpack(m tp.Message) (*payload.Payload, error) {
 	t.unpackLock.Lock()
 	defer t.unpackLock.Unlock()
-	t.currWrited = 0
+	t.currReaded = 0
 	rMethod, rTypeID, rSeqID, err := t.ioprot.ReadMessageBegin()
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.SetSize(uint32(t.currWrited))
-	if err != nil {
-		return nil, err
-	}
-
 	pd := t.payloadPool.Get().(*payload.Payload)
 	*pd = payload.Payload{}
 
@@ -175,6 +249,11 @@ func (t *thriftproto) unpack(m tp.Message) (*payload.Payload, error) {
 		t.payloadPool.Put(pd)
 		return nil, err
 	}
+	// currReaded is only touched under unpackLock
+	if err = m.SetSize(uint32(t.currReaded)); err != nil {
+		t.payloadPool.Put(pd)
+		return nil, err
+	}
 	return pd, nil
 }
 
@@ -200,12 +279,7 @@ func (t *thriftproto) Read(p []byte) (int, error) {
 }
 
 func (t *thriftproto) Write(p []byte) (int, error) {
-	n, err := t.rw.Write(p)
-	if err != nil {
-		return 0, err
-	}
-	t.currWrited += n
-	return n, err
+	return t.rw.Write(p)
 }
 
 // Flushing a memory buffer is a no-op
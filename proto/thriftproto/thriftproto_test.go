@@ -1,11 +1,13 @@
 package thriftproto_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	tp "github.com/mylonly/teleport"
 	"github.com/mylonly/teleport/proto/thriftproto"
+	"github.com/mylonly/teleport/xfer"
 	"github.com/mylonly/teleport/xfer/gzip"
 )
 
@@ -62,3 +64,124 @@ func (p *Push) Test(arg *map[string]string) *tp.Rerror {
 	tp.Infof("receive push(%s):\narg: %#v\n", p.IP(), arg)
 	return nil
 }
+
+func TestUnpackLimit(t *testing.T) {
+	if _, err := xfer.Get('g'); err != nil {
+		gzip.Reg('g', "gizp-5", 5)
+	}
+
+	// server
+	srv := tp.NewPeer(tp.PeerConfig{
+		ListenPort:         9079,
+		MessageUnpackLimit: 1 << 10,
+	})
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe(thriftproto.NewTProtoFunc())
+	time.Sleep(1e9)
+
+	// client
+	cli := tp.NewPeer(tp.PeerConfig{})
+	cli.RoutePush(new(Push))
+	sess, err := cli.Dial(":9079", thriftproto.NewTProtoFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result interface{}
+	rerr := sess.Call("Home.Test",
+		map[string]string{
+			"bomb": strings.Repeat("0", 1<<20),
+		},
+		&result,
+		tp.WithXferPipe('g'),
+	).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeMessageTooLarge {
+		t.Fatalf("want CodeMessageTooLarge, have %v", rerr)
+	}
+	// the session is still usable
+	rerr = sess.Call("Home.Test",
+		map[string]string{
+			"author": "henrylee2cn",
+		},
+		&result,
+		tp.WithXferPipe('g'),
+	).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+}
+
+func TestWriteLimit(t *testing.T) {
+	// server
+	srv := tp.NewPeer(tp.PeerConfig{Network: "mem", ListenPort: 9155})
+	defer srv.Close()
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe(thriftproto.NewTProtoFunc())
+
+	// client
+	cli := tp.NewPeer(tp.PeerConfig{Network: "mem"})
+	defer cli.Close()
+	sess, rerr := cli.Dial(":9155", thriftproto.NewTProtoFunc())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	sess.SetWriteLimit(1024)
+	var result interface{}
+	rerr = sess.Call("Home.Test", map[string]string{"a": strings.Repeat("a", 2048)}, &result).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeMessageTooLarge {
+		t.Fatalf("expect CodeMessageTooLarge, got %v", rerr)
+	}
+	// the oversized message is not written, so the session is still usable
+	rerr = sess.Call("Home.Test", map[string]string{"a": "small"}, &result).Rerror()
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+}
+
+// pingRecorder records the meta of the PING messages read by the peer.
+type pingRecorder chan string
+
+func (pingRecorder) Name() string {
+	return "ping_recorder"
+}
+
+func (r pingRecorder) PostReadControl(ctx tp.ReadCtx) *tp.Rerror {
+	if ctx.Input().Mtype() == tp.TypePing {
+		r <- string(ctx.Input().Meta().QueryString())
+	}
+	return nil
+}
+
+func TestControl(t *testing.T) {
+	// server
+	pings := make(pingRecorder, 1)
+	srv := tp.NewPeer(tp.PeerConfig{Network: "mem", ListenPort: 9160}, pings)
+	defer srv.Close()
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe(thriftproto.NewTProtoFunc())
+
+	// client
+	cli := tp.NewPeer(tp.PeerConfig{Network: "mem"})
+	defer cli.Close()
+	sess, rerr := cli.Dial(":9160", thriftproto.NewTProtoFunc())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	// negotiate the control messages by the first messages
+	var result interface{}
+	if rerr = sess.Call("Home.Test", map[string]string{}, &result).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	rerr = sess.Control(tp.TypePing, tp.WithSetMeta("X-Test", "ping"))
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	select {
+	case meta := <-pings:
+		// the carrier of the mtype is removed
+		if meta != "X-Test=ping" {
+			t.Fatalf("expect the meta X-Test=ping, got %s", meta)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the PING is not read as a control message")
+	}
+}
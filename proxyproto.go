@@ -0,0 +1,275 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyHeader the PROXY protocol header sent by the load balancer,
+// which carries the addresses of the original connection.
+type ProxyHeader struct {
+	// Version the protocol version, 1 or 2.
+	Version int
+	// Local is true for the v2 LOCAL command or the v1 UNKNOWN protocol,
+	// e.g. the health checks of the load balancer, and the addresses of the connection are not changed.
+	Local bool
+	// SourceAddr the address of the client.
+	SourceAddr net.Addr
+	// DestAddr the address which the client connected to.
+	DestAddr net.Addr
+}
+
+// proxyHeaderTimeout the maximum duration for reading the PROXY header,
+// if nothing is read within it, the connection is regarded as having no header.
+const proxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV1Prefix  = []byte("PROXY ")
+	proxyV2Sig     = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	errProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+// proxyConn the connection whose addresses are reported by the PROXY header.
+type proxyConn struct {
+	net.Conn
+	r          *bufio.Reader
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// readProxyHeader reads the PROXY header of the connection, the header is nil if there is none.
+func readProxyHeader(conn net.Conn) (*proxyConn, *ProxyHeader, error) {
+	c := &proxyConn{
+		Conn:       conn,
+		r:          bufio.NewReader(conn),
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: conn.RemoteAddr(),
+	}
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	b, err := c.r.Peek(1)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return c, nil, nil
+		}
+		return nil, nil, err
+	}
+	var header *ProxyHeader
+	switch b[0] {
+	case proxyV1Prefix[0]:
+		if b, err = c.r.Peek(len(proxyV1Prefix)); err == nil && bytes.Equal(b, proxyV1Prefix) {
+			header, err = readProxyV1(c.r)
+		}
+	case proxyV2Sig[0]:
+		if b, err = c.r.Peek(len(proxyV2Sig)); err == nil && bytes.Equal(b, proxyV2Sig) {
+			header, err = readProxyV2(c.r)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if header != nil && !header.Local {
+		c.remoteAddr = header.SourceAddr
+		c.localAddr = header.DestAddr
+	}
+	return c, header, nil
+}
+
+// readProxyV1 reads the header in the human-readable format,
+// e.g. "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (*ProxyHeader, error) {
+	const maxLen = 107
+	var line []byte
+	for len(line) <= maxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	header := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		header.Local = true
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return nil, errProxyHeader
+	}
+	header.SourceAddr = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	header.DestAddr = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return header, nil
+}
+
+// readProxyV2 reads the header in the binary format, the TLVs are skipped.
+func readProxyV2(r *bufio.Reader) (*ProxyHeader, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	verCmd, famProto := fixed[12], fixed[13]
+	if verCmd>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	data := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	header := &ProxyHeader{Version: 2}
+	switch verCmd & 0xf {
+	case 0: // LOCAL
+		header.Local = true
+		return header, nil
+	case 1: // PROXY
+	default:
+		return nil, errProxyHeader
+	}
+	switch famProto >> 4 {
+	case 1: // AF_INET
+		if len(data) < 12 {
+			return nil, errProxyHeader
+		}
+		header.SourceAddr = &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(binary.BigEndian.Uint16(data[8:]))}
+		header.DestAddr = &net.TCPAddr{IP: net.IP(data[4:8]), Port: int(binary.BigEndian.Uint16(data[10:]))}
+	case 2: // AF_INET6
+		if len(data) < 36 {
+			return nil, errProxyHeader
+		}
+		header.SourceAddr = &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(binary.BigEndian.Uint16(data[32:]))}
+		header.DestAddr = &net.TCPAddr{IP: net.IP(data[16:32]), Port: int(binary.BigEndian.Uint16(data[34:]))}
+	case 3: // AF_UNIX
+		if len(data) < 216 {
+			return nil, errProxyHeader
+		}
+		header.SourceAddr = &net.UnixAddr{Name: string(bytes.TrimRight(data[:108], "\x00")), Net: "unix"}
+		header.DestAddr = &net.UnixAddr{Name: string(bytes.TrimRight(data[108:216], "\x00")), Net: "unix"}
+	default: // AF_UNSPEC
+		header.Local = true
+	}
+	return header, nil
+}
+
+// proxyListener reads the PROXY headers of the accepted connections,
+// so that a slow connection does not block accepting the others.
+type proxyListener struct {
+	net.Listener
+	check     func(net.Conn, *ProxyHeader) error
+	connCh    chan net.Conn
+	errCh     chan error
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newProxyListener(lis net.Listener, check func(net.Conn, *ProxyHeader) error) *proxyListener {
+	l := &proxyListener{
+		Listener: lis,
+		check:    check,
+		connCh:   make(chan net.Conn),
+		errCh:    make(chan error),
+		closed:   make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *proxyListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errCh <- err:
+			case <-l.closed:
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyListener) handshake(conn net.Conn) {
+	c, header, err := readProxyHeader(conn)
+	if err == nil {
+		err = l.check(c, header)
+	}
+	if err != nil {
+		Debugf("reject connection from %s: %s", conn.RemoteAddr(), err.Error())
+		conn.Close()
+		return
+	}
+	select {
+	case l.connCh <- c:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case err := <-l.errCh:
+		return nil, err
+	case <-l.closed:
+		return nil, fmt.Errorf("accept %s: %v", l.Addr(), ErrListenClosed)
+	}
+}
+
+func (l *proxyListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.Listener.Close()
+	})
+	return err
+}
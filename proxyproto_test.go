@@ -0,0 +1,75 @@
+package tp_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func proxy_addr(ctx tp.CallCtx, arg *struct{}) (string, *tp.Rerror) {
+	return ctx.Session().RemoteAddr().String(), nil
+}
+
+type requireProxyHeader struct{}
+
+func (requireProxyHeader) Name() string {
+	return "require_proxy_header"
+}
+
+func (requireProxyHeader) PostReadProxyHeader(conn net.Conn, header *tp.ProxyHeader) error {
+	if header == nil {
+		return errors.New("missing PROXY header")
+	}
+	return nil
+}
+
+func TestProxyProtocol(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{
+		LocalIP:       "127.0.0.1",
+		ProxyProtocol: true,
+	}, requireProxyHeader{})
+	defer srv.Close()
+	srv.RouteCallFunc(proxy_addr)
+	addr := listenAndServe(t, srv)
+
+	v2 := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c")
+	v2 = append(v2, 5, 6, 7, 8, 127, 0, 0, 1, 0x03, 0x8e, 0x23, 0xa1) // 5.6.7.8:910 -> 127.0.0.1:9121
+	cli := tp.NewPeer(tp.PeerConfig{})
+	defer cli.Close()
+	for _, c := range []struct {
+		header []byte
+		addr   string
+	}{
+		{[]byte("PROXY TCP4 1.2.3.4 127.0.0.1 5678 9105\r\n"), "1.2.3.4:5678"},
+		{v2, "5.6.7.8:910"},
+		{nil, ""},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write(c.header); err != nil {
+			t.Fatal(err)
+		}
+		sess, err := cli.ServeConn(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var remoteAddr string
+		rerr := sess.Call("/proxy/addr", struct{}{}, &remoteAddr).Rerror()
+		if c.header == nil {
+			if rerr == nil {
+				t.Fatal("expect the connection without the header rejected")
+			}
+			continue
+		}
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		if remoteAddr != c.addr {
+			t.Fatalf("expect remote addr %s, got %s", c.addr, remoteAddr)
+		}
+	}
+}
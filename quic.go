@@ -0,0 +1,54 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !js,!noquic
+
+package tp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/mylonly/teleport/quic"
+)
+
+// dialQuic connects with the address by the quic network,
+// without verifying the server certificate if the TLS config is nil.
+func dialQuic(ctx context.Context, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig == nil {
+		return quic.DialAddrContext(ctx, addr, &tls.Config{InsecureSkipVerify: true}, nil)
+	}
+	return quic.DialAddrContext(ctx, addr, tlsConfig, nil)
+}
+
+func isQuicConn(conn net.Conn) bool {
+	_, ok := conn.(*quic.Conn)
+	return ok
+}
+
+func isQuicListener(lis net.Listener) bool {
+	_, ok := lis.(*quic.Listener)
+	return ok
+}
+
+// listenQuic announces on the local address by the quic network,
+// or inherits the listener from the parent process.
+func listenQuic(laddr string, tlsConfig *tls.Config) (net.Listener, error) {
+	return quic.InheritedListen(laddr, tlsConfig, nil)
+}
+
+func setQuicInherited() error {
+	return quic.SetInherited()
+}
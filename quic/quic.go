@@ -3,6 +3,7 @@ package quic
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"net"
 	"time"
 
@@ -166,6 +167,11 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.sess.RemoteAddr()
 }
 
+// PeerCertificates returns the certificate chain presented by the remote peer.
+func (c *Conn) PeerCertificates() []*x509.Certificate {
+	return c.sess.ConnectionState().PeerCertificates
+}
+
 // SetDeadline sets the read and write deadlines associated
 // with the connection. It is equivalent to calling both
 // SetReadDeadline and SetWriteDeadline.
@@ -0,0 +1,78 @@
+package quic_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/quic"
+)
+
+// countingCache counts the lookups and the stores of the TLS sessions.
+type countingCache struct {
+	tls.ClientSessionCache
+	used int32
+}
+
+func (c *countingCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	atomic.AddInt32(&c.used, 1)
+	return c.ClientSessionCache.Get(sessionKey)
+}
+
+func (c *countingCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	atomic.AddInt32(&c.used, 1)
+	c.ClientSessionCache.Put(sessionKey, cs)
+}
+
+// TestNoSessionResumption pins that the vendored quic-go never resumes the TLS sessions,
+// so the reconnection can not skip the handshake round trip by 0-RTT:
+// it does not pass tls.Config.ClientSessionCache to qtls (internal/handshake/crypto_setup.go),
+// and drops the 0-RTT packets (session.go).
+// If it fails after upgrading quic-go, 0-RTT can be supported by DialAddrContext.
+func TestNoSessionResumption(t *testing.T) {
+	lis, err := quic.ListenAddr("127.0.0.1:0", tp.GenerateTLSConfigForServer(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	cache := &countingCache{ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, err := quic.DialAddrContext(ctx, lis.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			ClientSessionCache: cache,
+		}, nil)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 4)
+		if _, err = conn.Write([]byte("ping")); err == nil {
+			_, err = io.ReadFull(conn, b)
+		}
+		conn.Close()
+		if err != nil || string(b) != "ping" {
+			t.Fatalf("expect the echo ping, got %q, error: %v", b, err)
+		}
+	}
+	if used := atomic.LoadInt32(&cache.used); used != 0 {
+		t.Fatalf("expect the session cache unused, got %d lookups and stores", used)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build js noquic
+
+package tp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// errQuicUnsupported the quic network is excluded on js/wasm, or by the noquic build tag,
+// e.g. the pure client build which should not pull quic-go in.
+var errQuicUnsupported = errors.New("quic network is not supported in this build")
+
+func dialQuic(ctx context.Context, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	return nil, errQuicUnsupported
+}
+
+func isQuicConn(conn net.Conn) bool {
+	return false
+}
+
+func isQuicListener(lis net.Listener) bool {
+	return false
+}
+
+func listenQuic(laddr string, tlsConfig *tls.Config) (net.Listener, error) {
+	return nil, errQuicUnsupported
+}
+
+func setQuicInherited() error {
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate of the events, e.g. the messages or bytes written to a session.
+type RateLimiter interface {
+	// Wait blocks until n events are allowed, or returns the error when the context is done.
+	Wait(ctx context.Context, n int) error
+}
+
+// tokenBucket the token bucket RateLimiter.
+type tokenBucket struct {
+	rate   float64 // the tokens refilled per second
+	burst  float64
+	tokens float64 // negative means the debt
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// NewTokenBucket creates a token bucket RateLimiter,
+// which is refilled by rate tokens per second up to burst tokens.
+// NOTE: The event larger than the burst is allowed by overdrawing, and the following ones wait for the debt.
+func NewTokenBucket(rate float64, burst int) RateLimiter {
+	if rate <= 0 {
+		Fatalf("invalid token bucket rate: %v", rate)
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until n events are allowed, or returns the error when the context is done.
+func (b *tokenBucket) Wait(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give back the reserved tokens
+		b.mu.Lock()
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// SetWriteRateLimiter sets the limiters of the messages and bytes written to the session,
+// which override the ones of PeerConfig.WriteMsgRate and PeerConfig.WriteByteRate.
+// NOTE: If the limiter is nil, no limit; the control messages are never limited.
+func (s *session) SetWriteRateLimiter(msgLimiter, byteLimiter RateLimiter) {
+	s.writeLock.Lock()
+	s.msgLimiter = msgLimiter
+	s.byteLimiter = byteLimiter
+	s.unchargedBytes = 0
+	s.writeLock.Unlock()
+}
+
+// initWriteRate creates the default write limiters of the session.
+func (s *session) initWriteRate() {
+	if rate := s.peer.writeMsgRate; rate > 0 {
+		s.msgLimiter = NewTokenBucket(float64(rate), rate)
+	}
+	if rate := s.peer.writeByteRate; rate > 0 {
+		s.byteLimiter = NewTokenBucket(float64(rate), rate)
+	}
+}
+
+// waitWriteRate waits for the write limiters, before writing the message.
+// NOTE:
+// The size of the message is unknown until it is packed,
+// so it is charged before the next writing;
+// Must be called with the write lock held.
+func (s *session) waitWriteRate(ctx context.Context) error {
+	if s.msgLimiter != nil {
+		if err := s.msgLimiter.Wait(ctx, 1); err != nil {
+			return err
+		}
+	}
+	if s.byteLimiter != nil && s.unchargedBytes > 0 {
+		if err := s.byteLimiter.Wait(ctx, int(s.unchargedBytes)); err != nil {
+			return err
+		}
+		s.unchargedBytes = 0
+	}
+	return nil
+}
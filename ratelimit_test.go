@@ -0,0 +1,53 @@
+package tp_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestWriteRateLimit(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{
+		WriteMsgRate: 50,
+	}, func(srv, _ tp.Peer) {
+		srv.RoutePushFunc(notice_push)
+	})
+	defer p.Close()
+	// 10 messages over the burst
+	start := time.Now()
+	for i := 0; i < 60; i++ {
+		if rerr := p.sess.Push("/notice/push", "rate"); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	if cost := time.Since(start); cost < 150*time.Millisecond {
+		t.Fatalf("expect the messages limited, cost %v", cost)
+	}
+
+	// limited by the bytes
+	p.sess.SetWriteRateLimiter(nil, tp.NewTokenBucket(10000, 10000))
+	body := strings.Repeat("x", 5000)
+	start = time.Now()
+	for i := 0; i < 4; i++ {
+		if rerr := p.sess.Push("/notice/push", body); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	if cost := time.Since(start); cost < 400*time.Millisecond {
+		t.Fatalf("expect the bytes limited, cost %v", cost)
+	}
+	// the waiting is limited by the context
+	p.sess.SetWriteRateLimiter(tp.NewTokenBucket(1, 1), nil)
+	p.sess.Push("/notice/push", "rate")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if rerr := p.sess.Push("/notice/push", "rate", tp.WithContext(ctx)); rerr == nil {
+		t.Fatal("expect the write failure after the context is done")
+	}
+	if !p.sess.Health() {
+		t.Fatal("expect the session alive")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+// ReplyProcessor transforms the reply body of the CALL handler before marshalling,
+// e.g. filtering the fields by the permissions of the caller, wrapping it in an envelope, or localizing it;
+// it may return a body of another type, or an error which is replied instead.
+type ReplyProcessor func(ctx CallCtx, reply interface{}) (interface{}, *Rerror)
+
+// WithReplyProcessor returns the plugin which applies the processors in order to the reply bodies
+// of the CALL handlers of each route it is registered with.
+// NOTE:
+// The processors are skipped if the handler returns an error;
+// All the processors of a route should be passed in one call, since the plugin can not be repeated;
+// See ReplyProcessorG for the processor of the typed reply.
+func WithReplyProcessor(processor ...ReplyProcessor) Plugin {
+	return &replyProcessors{processors: processor}
+}
+
+type replyProcessors struct {
+	processors []ReplyProcessor
+}
+
+var _ PostRegPlugin = new(replyProcessors)
+
+func (r *replyProcessors) Name() string {
+	return "reply-processor"
+}
+
+// PostReg appends the processors to the handler.
+func (r *replyProcessors) PostReg(h *Handler) error {
+	if h.IsCall() {
+		h.replyProcessors = append(h.replyProcessors, r.processors...)
+	}
+	return nil
+}
+
+// processReply applies the reply processors of the route to the reply body.
+func (c *handlerCtx) processReply() {
+	body := c.output.Body()
+	for _, fn := range c.handler.replyProcessors {
+		var rerr *Rerror
+		if body, rerr = fn(c, body); rerr != nil {
+			c.handleErr = rerr
+			return
+		}
+	}
+	c.output.SetBody(body)
+}
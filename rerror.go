@@ -23,18 +23,22 @@ const (
 	CodeConnClosed          = 102
 	CodeWriteFailed         = 104
 	CodeDialFailed          = 105
+	CodeCanceled            = 106 // the CALL is canceled by its context
+	CodeTooManyCalls        = 107 // the in-flight CALLs of the caller exceed the limit
 	CodeBadMessage          = 400
 	CodeUnauthorized        = 401
 	CodeNotFound            = 404
 	CodeMtypeNotAllowed     = 405
 	CodeHandleTimeout       = 408
+	CodeConflict            = 409
+	CodeMessageTooLarge     = 413
+	CodeTooManyRequests     = 429 // e.g. the session handler limit is exceeded
 	CodeInternalServerError = 500
 	CodeBadGateway          = 502
+	CodeServiceUnavailable  = 503 // retryable, e.g. the session is draining
 
-	// CodeConflict                      = 409
 	// CodeUnsupportedTx                 = 410
 	// CodeUnsupportedCodecType          = 415
-	// CodeServiceUnavailable            = 503
 	// CodeGatewayTimeout                = 504
 	// CodeVariantAlsoNegotiates         = 506
 	// CodeInsufficientStorage           = 507
@@ -57,6 +61,10 @@ func CodeText(rerrCode int32) string {
 		return "Dial Failed"
 	case CodeConnClosed:
 		return "Connection Closed"
+	case CodeCanceled:
+		return "Canceled"
+	case CodeTooManyCalls:
+		return "Too Many Calls"
 	case CodeWriteFailed:
 		return "Write Failed"
 	case CodeNotFound:
@@ -65,10 +73,18 @@ func CodeText(rerrCode int32) string {
 		return "Handle Timeout"
 	case CodeMtypeNotAllowed:
 		return "Message Type Not Allowed"
+	case CodeConflict:
+		return "Conflict"
+	case CodeMessageTooLarge:
+		return "Message Too Large"
+	case CodeTooManyRequests:
+		return "Too Many Requests"
 	case CodeInternalServerError:
 		return "Internal Server Error"
 	case CodeBadGateway:
 		return "Bad Gateway"
+	case CodeServiceUnavailable:
+		return "Service Unavailable"
 	case CodeUnknownError:
 		fallthrough
 	default:
@@ -86,7 +102,19 @@ var (
 	rerrNotFound            = NewRerror(CodeNotFound, CodeText(CodeNotFound), "")
 	rerrCodeMtypeNotAllowed = NewRerror(CodeMtypeNotAllowed, CodeText(CodeMtypeNotAllowed), "")
 	rerrHandleTimeout       = NewRerror(CodeHandleTimeout, CodeText(CodeHandleTimeout), "")
+	rerrCallTimeout         = NewRerror(CodeHandleTimeout, CodeText(CodeHandleTimeout), "call timeout")
+	rerrCallCanceled        = NewRerror(CodeCanceled, CodeText(CodeCanceled), "")
+	rerrTooManyCalls        = NewRerror(CodeTooManyCalls, CodeText(CodeTooManyCalls), "in-flight call limit exceeded")
+	rerrConflict            = NewRerror(CodeConflict, CodeText(CodeConflict), "")
+	rerrMessageTooLarge     = NewRerror(CodeMessageTooLarge, CodeText(CodeMessageTooLarge), "")
 	rerrInternalServerError = NewRerror(CodeInternalServerError, CodeText(CodeInternalServerError), "")
+	rerrServiceUnavailable  = NewRerror(CodeServiceUnavailable, CodeText(CodeServiceUnavailable), "")
+	rerrRouteQuarantined    = NewRerror(CodeServiceUnavailable, CodeText(CodeServiceUnavailable), "route is quarantined")
+	rerrRouteOverloaded     = NewRerror(CodeServiceUnavailable, CodeText(CodeServiceUnavailable), "route concurrency limit exceeded")
+	rerrSessionOverloaded   = NewRerror(CodeTooManyRequests, CodeText(CodeTooManyRequests), "session handler limit exceeded")
+	rerrChannelOverloaded   = NewRerror(CodeTooManyRequests, CodeText(CodeTooManyRequests), "channel window exceeded")
+	rerrControlUnsupported  = NewRerror(CodeMtypeNotAllowed, CodeText(CodeMtypeNotAllowed), "the remote peer does not accept the control messages")
+	rerrMultiReplyRefused   = NewRerror(CodeBadMessage, CodeText(CodeBadMessage), "the caller does not accept the multiple replies")
 )
 
 // IsConnRerror determines whether the error is a connection error
@@ -100,6 +128,18 @@ func IsConnRerror(rerr *Rerror) bool {
 	return false
 }
 
+// IsMismatchRerror determines whether the error means that the remote peer
+// does not support the handshake feature, e.g. an older peer.
+func IsMismatchRerror(rerr *Rerror) bool {
+	if rerr == nil {
+		return false
+	}
+	if rerr.Code == CodeNotFound || rerr.Code == CodeMtypeNotAllowed {
+		return true
+	}
+	return false
+}
+
 type (
 	// Rerror error only for reply message
 	Rerror struct {
@@ -138,7 +178,11 @@ func NewRerrorFromMeta(meta *utils.Args) *Rerror {
 		return nil
 	}
 	r := new(Rerror)
-	r.UnmarshalJSON(b)
+	if err := unmarshalRerror(b, r); err != nil {
+		r.Code = CodeBadMessage
+		r.Message = CodeText(CodeBadMessage)
+		r.Reason = err.Error()
+	}
 	return r
 }
 
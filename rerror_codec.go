@@ -0,0 +1,160 @@
+package tp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/henrylee2cn/goutil"
+	"github.com/mylonly/teleport/utils"
+)
+
+// RerrorCodec the codec of Rerror wire representation in the 'X-Reply-Error' metadata.
+// NOTE:
+//  The encoded bytes must start with the codec id, so that the receiver can detect the codec;
+//  The id '{' is reserved for the JSON codec, which is compatible with the old versions.
+type RerrorCodec interface {
+	// ID returns the codec id.
+	ID() byte
+	// Name returns the codec name.
+	Name() string
+	// Marshal returns the encoding of rerr.
+	Marshal(rerr *Rerror) []byte
+	// Unmarshal parses the encoded data and stores the result in rerr.
+	Unmarshal(data []byte, rerr *Rerror) error
+}
+
+// Built-in Rerror codec ids
+const (
+	RerrorCodecJSON   byte = '{'
+	RerrorCodecBinary byte = 1
+)
+
+var rerrorCodecMap = struct {
+	idMap   map[byte]RerrorCodec
+	nameMap map[string]RerrorCodec
+}{
+	idMap:   make(map[byte]RerrorCodec),
+	nameMap: make(map[string]RerrorCodec),
+}
+
+func init() {
+	RegRerrorCodec(new(jsonRerrorCodec))
+	RegRerrorCodec(new(binaryRerrorCodec))
+}
+
+// RegRerrorCodec registers Rerror codec.
+func RegRerrorCodec(c RerrorCodec) {
+	if _, ok := rerrorCodecMap.idMap[c.ID()]; ok {
+		panic(fmt.Sprintf("multi-register rerror codec id: %d", c.ID()))
+	}
+	if _, ok := rerrorCodecMap.nameMap[c.Name()]; ok {
+		panic("multi-register rerror codec name: " + c.Name())
+	}
+	rerrorCodecMap.idMap[c.ID()] = c
+	rerrorCodecMap.nameMap[c.Name()] = c
+}
+
+// GetRerrorCodec returns Rerror codec by id.
+func GetRerrorCodec(id byte) (RerrorCodec, error) {
+	c, ok := rerrorCodecMap.idMap[id]
+	if !ok {
+		return nil, fmt.Errorf("unsupported rerror codec id: %d", id)
+	}
+	return c, nil
+}
+
+// GetRerrorCodecByName returns Rerror codec by name.
+func GetRerrorCodecByName(name string) (RerrorCodec, error) {
+	c, ok := rerrorCodecMap.nameMap[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported rerror codec name: %s", name)
+	}
+	return c, nil
+}
+
+// SetToMetaWithCodec sets self to 'X-Reply-Error' metadata with the specified codec.
+// NOTE: If the codec is not found, use the JSON codec.
+func (r *Rerror) SetToMetaWithCodec(meta *utils.Args, codecID byte) {
+	c, err := GetRerrorCodec(codecID)
+	if err != nil {
+		r.SetToMeta(meta)
+		return
+	}
+	b := c.Marshal(r)
+	if len(b) == 0 {
+		return
+	}
+	meta.Set(MetaRerror, goutil.BytesToString(b))
+}
+
+func unmarshalRerror(b []byte, r *Rerror) error {
+	c, err := GetRerrorCodec(b[0])
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(b, r)
+}
+
+type jsonRerrorCodec struct{}
+
+func (jsonRerrorCodec) ID() byte     { return RerrorCodecJSON }
+func (jsonRerrorCodec) Name() string { return "json" }
+
+func (jsonRerrorCodec) Marshal(r *Rerror) []byte {
+	b, _ := r.MarshalJSON()
+	return b
+}
+
+func (jsonRerrorCodec) Unmarshal(b []byte, r *Rerror) error {
+	return r.UnmarshalJSON(b)
+}
+
+// binaryRerrorCodec the compact binary codec:
+//  {1 byte codec id}{varint code}{uvarint message length}{message}{uvarint reason length}{reason}
+type binaryRerrorCodec struct{}
+
+func (binaryRerrorCodec) ID() byte     { return RerrorCodecBinary }
+func (binaryRerrorCodec) Name() string { return "binary" }
+
+func (binaryRerrorCodec) Marshal(r *Rerror) []byte {
+	if r == nil {
+		return nil
+	}
+	b := make([]byte, 1, 1+binary.MaxVarintLen32+2*binary.MaxVarintLen64+len(r.Message)+len(r.Reason))
+	b[0] = RerrorCodecBinary
+	var tmp [binary.MaxVarintLen64]byte
+	b = append(b, tmp[:binary.PutVarint(tmp[:], int64(r.Code))]...)
+	b = append(b, tmp[:binary.PutUvarint(tmp[:], uint64(len(r.Message)))]...)
+	b = append(b, r.Message...)
+	b = append(b, tmp[:binary.PutUvarint(tmp[:], uint64(len(r.Reason)))]...)
+	b = append(b, r.Reason...)
+	return b
+}
+
+var errBadBinaryRerror = errors.New("bad binary rerror")
+
+func (binaryRerrorCodec) Unmarshal(b []byte, r *Rerror) error {
+	if len(b) == 0 || b[0] != RerrorCodecBinary {
+		return errBadBinaryRerror
+	}
+	b = b[1:]
+	code, n := binary.Varint(b)
+	if n <= 0 {
+		return errBadBinaryRerror
+	}
+	b = b[n:]
+	var s [2]string
+	for i := range s {
+		size, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < size {
+			return errBadBinaryRerror
+		}
+		s[i] = string(b[n : n+int(size)])
+		b = b[n+int(size):]
+	}
+	r.Code = int32(code)
+	r.Message = s[0]
+	r.Reason = s[1]
+	return nil
+}
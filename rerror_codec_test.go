@@ -0,0 +1,22 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestRerrorCodecNegotiation(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{RerrorCodec: "binary"}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(panic_call)
+	})
+	defer p.Close()
+	callCmd := p.sess.Call("/panic/call", nil, nil)
+	rerr := callCmd.Rerror()
+	if rerr == nil || rerr.Code != tp.CodeInternalServerError {
+		t.Fatalf("/panic/call: expect CodeInternalServerError, got %v", rerr)
+	}
+	if b := callCmd.InputMeta().Peek(tp.MetaRerror); len(b) == 0 || b[0] != tp.RerrorCodecBinary {
+		t.Fatalf("expect binary rerror, got %q", b)
+	}
+}
@@ -37,3 +37,25 @@ func TestRerror(t *testing.T) {
 	newRerr = ToRerror(errors.New("text error"))
 	t.Logf("test ToRerror 3: %s", newRerr)
 }
+
+func TestRerrorCodec(t *testing.T) {
+	rerr := NewRerror(-1234, "msg", `"bala...bala..."`)
+	for _, id := range []byte{RerrorCodecJSON, RerrorCodecBinary} {
+		meta := new(utils.Args)
+		rerr.SetToMetaWithCodec(meta, id)
+		b := meta.Peek(MetaRerror)
+		if b[0] != id {
+			t.Fatalf("codec %d: expect the first byte is the codec id, got %q", id, b)
+		}
+		newRerr := NewRerrorFromMeta(meta)
+		if *newRerr != *rerr {
+			t.Fatalf("codec %d: expect %v, got %v", id, rerr, newRerr)
+		}
+		t.Logf("codec %d: %d bytes", id, len(b))
+	}
+	meta := new(utils.Args)
+	meta.Set(MetaRerror, "\x7fbad")
+	if newRerr := NewRerrorFromMeta(meta); newRerr.Code != CodeBadMessage {
+		t.Fatalf("unknown codec: expect CodeBadMessage, got %v", newRerr)
+	}
+}
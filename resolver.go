@@ -0,0 +1,212 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver resolves the host of the dial address to IP addresses.
+// NOTE: *net.Resolver is a Resolver.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+// ResolverCacheConfig the config of CachedResolver
+type ResolverCacheConfig struct {
+	// Size the maximum number of the cached hosts, the least recently used one is evicted; default 1024.
+	Size int
+	// TTL the duration after which a resolution is refreshed asynchronously; default 30s.
+	TTL time.Duration
+	// MaxStale the duration after TTL in which the stale resolution is still used,
+	// e.g. while the DNS is failing; default 5m.
+	MaxStale time.Duration
+	// NegativeTTL the duration in which a failed resolution is cached; default 5s.
+	NegativeTTL time.Duration
+}
+
+// CachedResolver the Resolver with an LRU+TTL cache, asynchronous refresh and negative-result caching.
+type CachedResolver struct {
+	upstream Resolver
+	cfg      ResolverCacheConfig
+	lru      *list.List // *resolverEntry, the front is the most recently used
+	entries  map[string]*list.Element
+	mu       sync.Mutex
+}
+
+type resolverEntry struct {
+	host       string
+	addrs      []string
+	err        error
+	expire     time.Time // the time to refresh
+	pinned     bool
+	refreshing bool
+}
+
+var _ Resolver = new(CachedResolver)
+
+// NewCachedResolver creates a CachedResolver.
+// NOTE: If upstream is nil, use net.DefaultResolver.
+func NewCachedResolver(upstream Resolver, cfg ResolverCacheConfig) *CachedResolver {
+	if upstream == nil {
+		upstream = net.DefaultResolver
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = 1024
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 30 * time.Second
+	}
+	if cfg.MaxStale <= 0 {
+		cfg.MaxStale = 5 * time.Minute
+	}
+	if cfg.NegativeTTL <= 0 {
+		cfg.NegativeTTL = 5 * time.Second
+	}
+	return &CachedResolver{
+		upstream: upstream,
+		cfg:      cfg,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// LookupHost returns the cached addresses of the host,
+// and refreshes them asynchronously after TTL.
+func (r *CachedResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	now := time.Now()
+	r.mu.Lock()
+	if elem, ok := r.entries[host]; ok {
+		r.lru.MoveToFront(elem)
+		e := elem.Value.(*resolverEntry)
+		switch {
+		case e.pinned || now.Before(e.expire):
+			addrs, err := e.addrs, e.err
+			r.mu.Unlock()
+			return addrs, err
+		case e.err == nil && now.Before(e.expire.Add(r.cfg.MaxStale)):
+			if !e.refreshing {
+				e.refreshing = true
+				go r.refresh(host)
+			}
+			addrs := e.addrs
+			r.mu.Unlock()
+			return addrs, nil
+		}
+	}
+	r.mu.Unlock()
+	addrs, err := r.upstream.LookupHost(ctx, host)
+	r.store(host, addrs, err, false)
+	return addrs, err
+}
+
+func (r *CachedResolver) refresh(host string) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.TTL)
+	defer cancel()
+	addrs, err := r.upstream.LookupHost(ctx, host)
+	if err != nil {
+		// keep serving the stale addresses until MaxStale
+		Warnf("refresh dns: %s: %v", host, err)
+		r.mu.Lock()
+		if elem, ok := r.entries[host]; ok {
+			elem.Value.(*resolverEntry).refreshing = false
+		}
+		r.mu.Unlock()
+		return
+	}
+	r.store(host, addrs, nil, false)
+}
+
+func (r *CachedResolver) store(host string, addrs []string, err error, pinned bool) {
+	ttl := r.cfg.TTL
+	if err != nil {
+		ttl = r.cfg.NegativeTTL
+	}
+	e := &resolverEntry{
+		host:   host,
+		addrs:  addrs,
+		err:    err,
+		expire: time.Now().Add(ttl),
+		pinned: pinned,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elem, ok := r.entries[host]; ok {
+		if elem.Value.(*resolverEntry).pinned && !pinned {
+			// never overwrite the pinned resolution
+			return
+		}
+		elem.Value = e
+		r.lru.MoveToFront(elem)
+		return
+	}
+	r.entries[host] = r.lru.PushFront(e)
+	for r.lru.Len() > r.cfg.Size {
+		elem := r.lru.Back()
+		r.lru.Remove(elem)
+		delete(r.entries, elem.Value.(*resolverEntry).host)
+	}
+}
+
+// Pin fixes the addresses of the host, which are never expired or refreshed until Unpin,
+// e.g. during a DNS incident.
+func (r *CachedResolver) Pin(host string, addrs ...string) {
+	r.store(host, addrs, nil, true)
+}
+
+// Unpin removes the pinned addresses of the host, the next lookup resolves it again.
+func (r *CachedResolver) Unpin(host string) {
+	r.Forget(host)
+}
+
+// Forget removes the cached resolution of the host.
+func (r *CachedResolver) Forget(host string) {
+	r.mu.Lock()
+	if elem, ok := r.entries[host]; ok {
+		r.lru.Remove(elem)
+		delete(r.entries, host)
+	}
+	r.mu.Unlock()
+}
+
+// Len returns the number of the cached hosts.
+func (r *CachedResolver) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lru.Len()
+}
+
+// resolveDialAddrs resolves the dial address to the IP addresses with the same port.
+// NOTE: If the host is empty or an IP, returns the address itself.
+func resolveDialAddrs(ctx context.Context, resolver Resolver, addr string) (host string, addrs []string, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || len(host) == 0 || net.ParseIP(host) != nil {
+		return host, []string{addr}, nil
+	}
+	ips, err := resolver.LookupHost(ctx, host)
+	if err != nil {
+		return host, nil, err
+	}
+	addrs = make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	return host, addrs, nil
+}
@@ -0,0 +1,88 @@
+package tp_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// fakeResolver resolves the hosts except bad.test to 127.0.0.1, and reports the lookups.
+type fakeResolver chan string
+
+func (f fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	f <- host
+	if host == "bad.test" {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	return []string{"127.0.0.1"}, nil
+}
+
+// expect checks the hosts looked up since the last check.
+func (f fakeResolver) expect(t *testing.T, hosts ...string) {
+	t.Helper()
+	for _, host := range hosts {
+		select {
+		case got := <-f:
+			if got != host {
+				t.Fatalf("expect the lookup of %s, got %s", host, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expect the lookup of %s", host)
+		}
+	}
+	select {
+	case got := <-f:
+		t.Fatalf("expect no more lookup, got %s", got)
+	default:
+	}
+}
+
+func TestCachedResolver(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	upstream := make(fakeResolver, 10)
+	resolver := tp.NewCachedResolver(upstream, tp.ResolverCacheConfig{
+		Size: 2,
+		TTL:  ttl,
+	})
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, nil)
+	defer p.Close()
+	p.cli.SetResolver(resolver)
+	for i := 0; i < 2; i++ {
+		sess, rerr := p.cli.Dial("svc.test" + p.addr)
+		if rerr != nil {
+			t.Fatalf("dial: %v", rerr)
+		}
+		sess.Close()
+	}
+	upstream.expect(t, "svc.test")
+	// negative-result caching
+	for i := 0; i < 2; i++ {
+		if _, rerr := p.cli.Dial("bad.test" + p.addr); rerr == nil {
+			t.Fatal("expect dial failed")
+		}
+	}
+	upstream.expect(t, "bad.test")
+	// asynchronous refresh after TTL, serving the stale addresses
+	time.Sleep(ttl)
+	if addrs, err := resolver.LookupHost(context.Background(), "svc.test"); err != nil || len(addrs) != 1 {
+		t.Fatalf("expect stale addresses, got %v, %v", addrs, err)
+	}
+	upstream.expect(t, "svc.test")
+	// pinning
+	resolver.Pin("bad.test", "127.0.0.1")
+	sess, rerr := p.cli.Dial("bad.test" + p.addr)
+	if rerr != nil {
+		t.Fatalf("dial pinned: %v", rerr)
+	}
+	sess.Close()
+	upstream.expect(t)
+	// LRU eviction
+	resolver.LookupHost(context.Background(), "other.test")
+	upstream.expect(t, "other.test")
+	if n := resolver.Len(); n != 2 {
+		t.Fatalf("expect 2 cached hosts, got %d", n)
+	}
+}
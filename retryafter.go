@@ -0,0 +1,82 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/henrylee2cn/goutil"
+	"github.com/mylonly/teleport/utils"
+)
+
+// WithRetryAfter sets the hint after which the rejected CALL may be retried,
+// e.g. set to the reply by the handler which returns CodeServiceUnavailable.
+func WithRetryAfter(d time.Duration) MessageSetting {
+	return WithSetMeta(MetaRetryAfter, strconv.FormatInt(int64(d/time.Millisecond), 10))
+}
+
+// GetRetryAfter gets the hint after which the rejected CALL may be retried,
+// e.g. from CallCmd.InputMeta().
+func GetRetryAfter(meta *utils.Args) (time.Duration, bool) {
+	if meta == nil {
+		return 0, false
+	}
+	s := meta.Peek(MetaRetryAfter)
+	if len(s) == 0 {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(goutil.BytesToString(s), 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// setRetryAfter attaches the retry-after hint of the peer to the overload rejection, if not set by the handler.
+func (c *handlerCtx) setRetryAfter() {
+	d := c.sess.peer.retryAfter
+	if d <= 0 || c.handleErr == nil || c.handleErr.Code != CodeServiceUnavailable {
+		return
+	}
+	if len(c.output.Meta().Peek(MetaRetryAfter)) == 0 {
+		WithRetryAfter(d)(c.output)
+	}
+}
+
+// waitRetryAfter waits for the retry-after hint of the rejected CALL,
+// and returns false if it should not be retried, e.g. the context would be done first.
+func waitRetryAfter(callCmd CallCmd) bool {
+	rerr := callCmd.Rerror()
+	if rerr == nil || rerr.Code != CodeServiceUnavailable {
+		return false
+	}
+	d, ok := GetRetryAfter(callCmd.InputMeta())
+	if !ok {
+		return false
+	}
+	ctx := callCmd.Context()
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+		return false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,72 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// rejectedReplies reports the error replies read by the client.
+type rejectedReplies chan struct{}
+
+func (r rejectedReplies) InspectInbound(_ tp.Session, msg tp.Message) *tp.Rerror {
+	if msg.Mtype() == tp.TypeReply && tp.NewRerrorFromMeta(msg.Meta()) != nil {
+		r <- struct{}{}
+	}
+	return nil
+}
+
+func (rejectedReplies) InspectOutbound(tp.Session, tp.Message) {}
+
+func TestRetryAfter(t *testing.T) {
+	const retryAfter = 100 * time.Millisecond
+	var path string
+	rejected := make(rejectedReplies, 4)
+	// each CALL is released by one send
+	entered, release := make(chan int, 4), make(chan struct{})
+	p := newMemPeers(t, tp.PeerConfig{
+		RetryAfter: retryAfter,
+	}, tp.PeerConfig{
+		OverloadRetries: 3,
+	}, func(srv, cli tp.Peer) {
+		path = srv.RouteCallFunc(blockingCall(entered, release), tp.WithRouteConcurrency(1, 0))
+		cli.SetMessageInspector(rejected)
+	})
+	defer p.Close()
+	var arg, arg2, result = 1, 2, 0
+	busy := p.sess.AsyncCall(path, &arg, new(int), make(chan tp.CallCmd, 1))
+	<-entered
+
+	// rejected with the hint, and retried after the busy one
+	start := time.Now()
+	done := make(chan tp.CallCmd, 1)
+	go func() {
+		done <- p.sess.Call(path, &arg2, &result)
+	}()
+	<-rejected
+	release <- struct{}{}
+	if _, rerr := busy.Reply(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	<-entered
+	release <- struct{}{}
+	if rerr := (<-done).Rerror(); rerr != nil || result != arg2 {
+		t.Fatalf("expect %d, got %d, %v", arg2, result, rerr)
+	}
+	if cost := time.Since(start); cost < retryAfter {
+		t.Fatalf("expect waiting for the hint, cost %v", cost)
+	}
+
+	// the hint is exposed to the caller without retry
+	busy = p.sess.AsyncCall(path, &arg, new(int), make(chan tp.CallCmd, 1))
+	<-entered
+	callCmd := p.sess.AsyncCall(path, &arg2, &result, make(chan tp.CallCmd, 1))
+	<-callCmd.Done()
+	d, ok := tp.GetRetryAfter(callCmd.InputMeta())
+	if rerr := callCmd.Rerror(); rerr == nil || rerr.Code != tp.CodeServiceUnavailable || !ok || d != retryAfter {
+		t.Fatalf("expect the retry-after hint, got %v, %v, %v", d, ok, rerr)
+	}
+	release <- struct{}{}
+	busy.Reply()
+}
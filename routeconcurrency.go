@@ -0,0 +1,130 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// RouteConcurrencyStats the statistics of the route concurrency limit.
+type RouteConcurrencyStats struct {
+	// Limit the maximum number of the running handlers
+	Limit int
+	// Queue the capacity of the CALLs waiting for the handlers
+	Queue int
+	// Running the number of the running handlers
+	Running int
+	// Queued the number of the CALLs waiting for the handlers
+	Queued int
+	// Shed the total number of the CALLs refused since the queue is full
+	Shed uint64
+}
+
+// concurrencyLimiter limits the running handlers of a route.
+type concurrencyLimiter struct {
+	slots  chan struct{}
+	queue  int32
+	queued int32
+	shed   uint64
+}
+
+// WithRouteConcurrency returns the plugin which limits the number of the running handlers of each route
+// it is registered with, and queues at most queue CALLs for them;
+// the excess CALLs are refused with the retryable CodeServiceUnavailable.
+// NOTE:
+// Only for the CALL handlers, the PUSH handlers are not limited;
+// If queue<=0, the excess CALLs are refused without waiting;
+// The waiting CALL fails with CodeHandleTimeout when its context is done.
+func WithRouteConcurrency(limit, queue int) Plugin {
+	if limit <= 0 {
+		Fatalf("invalid route concurrency limit: %d", limit)
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &routeConcurrency{limit: limit, queue: queue}
+}
+
+type routeConcurrency struct {
+	limit, queue int
+}
+
+var _ PostRegPlugin = new(routeConcurrency)
+
+func (r *routeConcurrency) Name() string {
+	return "route-concurrency"
+}
+
+// PostReg creates the limiter of the handler.
+func (r *routeConcurrency) PostReg(h *Handler) error {
+	if h.IsCall() {
+		h.concurrency = &concurrencyLimiter{
+			slots: make(chan struct{}, r.limit),
+			queue: int32(r.queue),
+		}
+	}
+	return nil
+}
+
+// acquire waits for a slot to run the handler.
+func (l *concurrencyLimiter) acquire(ctx context.Context) *Rerror {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if atomic.AddInt32(&l.queued, 1) > l.queue {
+		atomic.AddInt32(&l.queued, -1)
+		atomic.AddUint64(&l.shed, 1)
+		return rerrRouteOverloaded
+	}
+	defer atomic.AddInt32(&l.queued, -1)
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return rerrHandleTimeout.Copy().SetReason("waiting for the route concurrency: " + ctx.Err().Error())
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+func (l *concurrencyLimiter) stats() RouteConcurrencyStats {
+	return RouteConcurrencyStats{
+		Limit:   cap(l.slots),
+		Queue:   int(l.queue),
+		Running: len(l.slots),
+		Queued:  int(atomic.LoadInt32(&l.queued)),
+		Shed:    atomic.LoadUint64(&l.shed),
+	}
+}
+
+// RouteConcurrencyStats returns the statistics of the concurrency limit of the CALL route,
+// which is set by WithRouteConcurrency.
+func (p *peer) RouteConcurrencyStats(serviceMethod string) (RouteConcurrencyStats, bool) {
+	h, ok := p.router.subRouter.getCall(serviceMethod, "")
+	if !ok || h.name != serviceMethod || h.concurrency == nil {
+		return RouteConcurrencyStats{}, false
+	}
+	return h.concurrency.stats(), true
+}
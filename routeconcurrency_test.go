@@ -0,0 +1,45 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestRouteConcurrency(t *testing.T) {
+	var path string
+	entered, release := make(chan int, 3), make(chan struct{})
+	closed := make(closeReasonPlugin, 1)
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(blockingCall(entered, release), tp.WithRouteConcurrency(1, 1))
+		srv.PluginContainer().AppendRight(closed)
+	})
+	defer p.Close()
+	done := make(chan tp.CallCmd, 3)
+	for i := 0; i < 3; i++ {
+		arg := i
+		p.sess.AsyncCall(path, &arg, new(int), done)
+	}
+	// one CALL runs, one is queued, and the last one is shed at once
+	<-entered
+	cmd := <-done
+	if rerr := cmd.Rerror(); rerr == nil || rerr.Code != tp.CodeServiceUnavailable {
+		t.Fatalf("expect the call shed, got %v", rerr)
+	}
+	stats, ok := p.srv.RouteConcurrencyStats(path)
+	if !ok || stats.Limit != 1 || stats.Running != 1 || stats.Queued != 1 || stats.Shed != 1 {
+		t.Fatalf("unexpected stats: %+v, %v", stats, ok)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if rerr := (<-done).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	// the session is closed after its handlers return, which release the slots
+	p.sess.Close()
+	closed.wait(t)
+	if stats, _ = p.srv.RouteConcurrencyStats(path); stats.Running != 0 || stats.Queued != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
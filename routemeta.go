@@ -0,0 +1,70 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"github.com/henrylee2cn/goutil"
+	"github.com/mylonly/teleport/utils"
+)
+
+// MetaValidator validates the value of the metadata of the message,
+// and the error is replied to the CALL, e.g. CodeUnauthorized for the invalid token.
+type MetaValidator func(value string) *Rerror
+
+// WithRequiredMeta returns the plugin which requires the metadata key of the messages of each route
+// it is registered with, e.g. the auth token or the tenant ID, and validates the value by the validators in order;
+// the messages missing it are refused with CodeBadMessage before the body is unmarshalled.
+// NOTE:
+// The plugins of the different keys can be registered together, but not the ones of the same key;
+// The bulk handler is selected before the metadata is checked, so it should be registered with the plugin too.
+func WithRequiredMeta(key string, validator ...MetaValidator) Plugin {
+	if key == "" {
+		Fatalf("invalid required meta key: empty")
+	}
+	return &requiredMeta{key: key, validators: validator}
+}
+
+type requiredMeta struct {
+	key        string
+	validators []MetaValidator
+}
+
+var _ PostRegPlugin = new(requiredMeta)
+
+func (r *requiredMeta) Name() string {
+	return "required-meta(" + r.key + ")"
+}
+
+// PostReg appends the requirement to the handler.
+func (r *requiredMeta) PostReg(h *Handler) error {
+	h.requiredMeta = append(h.requiredMeta, r)
+	return nil
+}
+
+// checkMeta refuses the message which misses the required metadata of the route, or fails the validation.
+func (h *Handler) checkMeta(meta *utils.Args) *Rerror {
+	for _, r := range h.requiredMeta {
+		value := meta.Peek(r.key)
+		if len(value) == 0 {
+			return rerrBadMessage.Copy().SetReason("missing metadata: " + r.key)
+		}
+		for _, fn := range r.validators {
+			if rerr := fn(goutil.BytesToString(value)); rerr != nil {
+				return rerr
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestRequiredMeta(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.SubRoute("/tenant",
+			tp.WithRequiredMeta("X-Tenant"),
+			tp.WithRequiredMeta("X-Token", func(value string) *tp.Rerror {
+				if value != "secret" {
+					return tp.NewRerror(tp.CodeUnauthorized, tp.CodeText(tp.CodeUnauthorized), "invalid token")
+				}
+				return nil
+			}),
+		).RouteCallFunc(hotloaded)
+		srv.SubRoute("/open").RouteCallFunc(hotloaded)
+	})
+	defer p.Close()
+	for _, c := range []struct {
+		serviceMethod string
+		setting       []tp.MessageSetting
+		expectCode    int32
+	}{
+		{"/tenant/hotloaded", nil, tp.CodeBadMessage},
+		{"/tenant/hotloaded", []tp.MessageSetting{tp.WithSetMeta("X-Tenant", "t1")}, tp.CodeBadMessage},
+		{"/tenant/hotloaded", []tp.MessageSetting{tp.WithSetMeta("X-Tenant", "t1"), tp.WithSetMeta("X-Token", "guess")}, tp.CodeUnauthorized},
+		{"/tenant/hotloaded", []tp.MessageSetting{tp.WithSetMeta("X-Tenant", "t1"), tp.WithSetMeta("X-Token", "secret")}, tp.CodeNoError},
+		{"/open/hotloaded", nil, tp.CodeNoError},
+	} {
+		var result string
+		rerr := p.sess.Call(c.serviceMethod, "x", &result, c.setting...).Rerror()
+		if c.expectCode == tp.CodeNoError {
+			if rerr != nil || result != "x" {
+				t.Fatalf("%s: expect x, got %q, %v", c.serviceMethod, result, rerr)
+			}
+			continue
+		}
+		if rerr == nil || rerr.Code != c.expectCode {
+			t.Fatalf("%s: expect code %d, got %v", c.serviceMethod, c.expectCode, rerr)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"path"
+	"strings"
+)
+
+// routePattern the route whose service method has the path parameters, e.g. /user/:id/orders,
+// which matches the service methods of the same number of the segments, e.g. /user/42/orders;
+// or the catch-all parameter as the last segment, e.g. /files/*path, which matches the whole subtree.
+type routePattern struct {
+	segments []string // the static segments, or the parameters starting with ':' or '*'
+	statics  int      // the number of the static segments, the pattern with more is tried first
+	catchAll bool     // tried after the others with the same number of the static segments
+	shape    string   // without the parameter names, the patterns of the same shape are conflicting
+	handler  *Handler
+}
+
+// routeMatch the route pattern matched by the service method of the input message.
+type routeMatch struct {
+	pattern *routePattern
+	path    string
+}
+
+// isRoutePattern returns whether the service method has the path parameters.
+func isRoutePattern(serviceMethod string) bool {
+	return strings.Contains(serviceMethod, "/:") || strings.Contains(serviceMethod, "/*")
+}
+
+func newRoutePattern(h *Handler) *routePattern {
+	p := &routePattern{handler: h}
+	for rest := h.name; len(rest) > 0; {
+		var seg string
+		seg, rest = nextSegment(rest)
+		switch segmentKind(seg) {
+		case segmentCatchAll:
+			p.catchAll = true
+			p.shape += "/*"
+		case segmentParam:
+			p.shape += "/:"
+		default:
+			p.statics++
+			p.shape += "/" + seg
+		}
+		p.segments = append(p.segments, seg)
+	}
+	return p
+}
+
+// The kinds of the segments of the pattern, in the order of the precedence.
+const (
+	segmentStatic = iota
+	segmentParam
+	segmentCatchAll
+)
+
+func segmentKind(seg string) int {
+	switch {
+	case strings.HasPrefix(seg, "*"):
+		return segmentCatchAll
+	case strings.HasPrefix(seg, ":"):
+		return segmentParam
+	}
+	return segmentStatic
+}
+
+// before reports whether the pattern is tried before the other one, which is deterministic:
+// the pattern with more static segments first, and then the one without the catch-all parameter,
+// and then the one whose first different segment is more specific, e.g. /a/b/:y before /a/:x/c.
+func (p *routePattern) before(q *routePattern) bool {
+	if p.rank() != q.rank() {
+		return p.rank() > q.rank()
+	}
+	for i := 0; i < len(p.segments) && i < len(q.segments); i++ {
+		if a, b := segmentKind(p.segments[i]), segmentKind(q.segments[i]); a != b {
+			return a < b
+		}
+	}
+	// not overlapping
+	return p.shape < q.shape
+}
+
+// rank returns the priority of the pattern.
+func (p *routePattern) rank() int {
+	if p.catchAll {
+		return p.statics * 2
+	}
+	return p.statics*2 + 1
+}
+
+// nextSegment returns the first segment of the path, and the rest.
+func nextSegment(path string) (seg, rest string) {
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i:]
+	}
+	return path, ""
+}
+
+func (p *routePattern) match(path string) bool {
+	for _, want := range p.segments {
+		if want[0] == '*' {
+			return len(strings.TrimPrefix(path, "/")) > 0
+		}
+		if len(path) == 0 {
+			return false
+		}
+		var seg string
+		seg, path = nextSegment(path)
+		if want[0] == ':' {
+			if len(seg) == 0 {
+				return false
+			}
+		} else if seg != want {
+			return false
+		}
+	}
+	return len(path) == 0
+}
+
+// param returns the value of the parameter in the path matched by the pattern.
+func (p *routePattern) param(path, name string) string {
+	for _, want := range p.segments {
+		if want[0] == '*' {
+			if want[1:] == name {
+				return strings.TrimPrefix(path, "/")
+			}
+			return ""
+		}
+		var seg string
+		seg, path = nextSegment(path)
+		if want[0] == ':' && want[1:] == name {
+			return seg
+		}
+	}
+	return ""
+}
+
+// addPattern registers the handler whose service method has the path parameters.
+func (r *SubRouter) addPattern(routerTypeName string, h *Handler) {
+	patterns := r.callPatterns
+	if routerTypeName != pnCall {
+		patterns = r.pushPatterns
+	}
+	h.pattern = newRoutePattern(h)
+	i := len(*patterns)
+	for i > 0 && h.pattern.before((*patterns)[i-1]) {
+		i--
+	}
+	*patterns = append(*patterns, nil)
+	copy((*patterns)[i+1:], (*patterns)[i:])
+	(*patterns)[i] = h.pattern
+}
+
+// conflictPattern returns the registered pattern of the same shape as the service method, if any,
+// e.g. /user/:id and /user/:uid, one of which would never be matched.
+func (r *SubRouter) conflictPattern(routerTypeName, serviceMethod string) (string, bool) {
+	patterns := r.callPatterns
+	if routerTypeName != pnCall {
+		patterns = r.pushPatterns
+	}
+	shape := newRoutePattern(&Handler{name: serviceMethod}).shape
+	for _, p := range *patterns {
+		if p.shape == shape {
+			return p.handler.name, true
+		}
+	}
+	return "", false
+}
+
+// removePattern removes the pattern of the handler.
+func removePattern(patterns *[]*routePattern, h *Handler) {
+	for i, p := range *patterns {
+		if p == h.pattern {
+			*patterns = append((*patterns)[:i], (*patterns)[i+1:]...)
+			return
+		}
+	}
+}
+
+// RouteCallWildcard registers the CALL handler of the pattern, which gets the raw body bytes,
+// e.g. /files/*path for a gateway receiving the whole subtree.
+func (r *Router) RouteCallWildcard(pattern string, fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin) string {
+	return r.subRouter.RouteCallWildcard(pattern, fn, plugin...)
+}
+
+// RouteCallWildcard registers the CALL handler of the pattern under the prefix of the router,
+// which gets the raw body bytes, e.g. *path under /files for a gateway receiving the whole subtree.
+// NOTE:
+//  The catch-all parameter should be the last segment, and matches one or more segments, see CallCtx.Param;
+//  Unlike SetUnknownCall, the plugins of the router are kept.
+func (r *SubRouter) RouteCallWildcard(pattern string, fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin) string {
+	pluginContainer := r.pluginContainer.cloneAndAppendMiddle(plugin...)
+	warnInvaildHandlerHooks(plugin)
+	h := newUnknownCallHandler(fn, pluginContainer)
+	return r.regWildcard(pnCall, h, pattern)
+}
+
+// RoutePushWildcard registers the PUSH handler of the pattern, which gets the raw body bytes,
+// e.g. /files/*path for a gateway receiving the whole subtree.
+func (r *Router) RoutePushWildcard(pattern string, fn func(UnknownPushCtx) *Rerror, plugin ...Plugin) string {
+	return r.subRouter.RoutePushWildcard(pattern, fn, plugin...)
+}
+
+// RoutePushWildcard registers the PUSH handler of the pattern under the prefix of the router,
+// which gets the raw body bytes, e.g. *path under /files for a gateway receiving the whole subtree.
+// NOTE:
+//  The catch-all parameter should be the last segment, and matches one or more segments, see PushCtx.Param;
+//  Unlike SetUnknownPush, the plugins of the router are kept.
+func (r *SubRouter) RoutePushWildcard(pattern string, fn func(UnknownPushCtx) *Rerror, plugin ...Plugin) string {
+	pluginContainer := r.pluginContainer.cloneAndAppendMiddle(plugin...)
+	warnInvaildHandlerHooks(plugin)
+	h := newUnknownPushHandler(fn, pluginContainer)
+	return r.regWildcard(pnPush, h, pattern)
+}
+
+func (r *SubRouter) regWildcard(routerTypeName string, h *Handler, pattern string) string {
+	h.name = path.Join("/", r.prefix, pattern)
+	if r.version != "" {
+		Fatalf("the route with the path parameters can not be versioned: %s(%s)", h.name, r.version)
+	}
+	if i := strings.Index(h.name, "/*"); i >= 0 && strings.Contains(h.name[i+1:], "/") {
+		Fatalf("invalid wildcard route: %s, the catch-all parameter should be the last segment", h.name)
+	}
+	if !isRoutePattern(h.name) {
+		Fatalf("invalid wildcard route: %s, no path parameter", h.name)
+	}
+	hadHandlers := r.callHandlers
+	if routerTypeName != pnCall {
+		hadHandlers = r.pushHandlers
+	}
+	r.mu.Lock()
+	if _, ok := hadHandlers[h.name]; ok {
+		r.mu.Unlock()
+		Fatalf("there is a handler conflict: %s", h.name)
+	}
+	if had, ok := r.conflictPattern(routerTypeName, h.name); ok {
+		r.mu.Unlock()
+		Fatalf("there is a handler conflict: %s and %s", h.name, had)
+	}
+	// the handler is set up before it is visible to the running sessions
+	h.routerTypeName = routerTypeName
+	h.pluginContainer.postReg(h)
+	hadHandlers[h.name] = h
+	r.addPattern(routerTypeName, h)
+	r.mu.Unlock()
+	Printf("register %s handler: %s", routerTypeName, h.name)
+	return h.name
+}
+
+// matchPattern returns the handler of the first pattern matched by the service method.
+func matchPattern(patterns []*routePattern, serviceMethod string) (*Handler, bool) {
+	for _, p := range patterns {
+		if p.match(serviceMethod) {
+			return p.handler, true
+		}
+	}
+	return nil, false
+}
+
+// Param returns the value of the path parameter of the route pattern, e.g. the id of /user/:id/orders.
+// NOTE: It is empty if the route has no such parameter.
+func (c *handlerCtx) Param(name string) string {
+	if c.route.pattern == nil {
+		return ""
+	}
+	return c.route.pattern.param(c.route.path, name)
+}
+
+// matchRoute saves the service method for Param, if the handler is routed by the pattern.
+func (c *handlerCtx) matchRoute(serviceMethod string) {
+	if c.handler.pattern != nil {
+		c.route = routeMatch{pattern: c.handler.pattern, path: serviceMethod}
+	}
+}
@@ -0,0 +1,145 @@
+package tp_test
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func orders(ctx tp.CallCtx, arg *int) (string, *tp.Rerror) {
+	return ctx.Param("id") + ":" + ctx.ServiceMethod(), nil
+}
+
+func TestRoutePattern(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		if path := srv.SubRoute("/user/:id").RouteCallFunc(orders); path != "/user/:id/orders" {
+			t.Fatalf("expect /user/:id/orders, got %s", path)
+		}
+		srv.SubRoute("/user/me").RouteCallFunc(orders)
+	})
+	defer p.Close()
+	for serviceMethod, expect := range map[string]string{
+		"/user/42/orders": "42:/user/42/orders",
+		"/user/me/orders": ":/user/me/orders", // the static route first
+	} {
+		var result string
+		if rerr := p.sess.Call(serviceMethod, new(int), &result).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if result != expect {
+			t.Fatalf("%s: expect %q, got %q", serviceMethod, expect, result)
+		}
+	}
+	for _, serviceMethod := range []string{"/user/42/orders/1", "/user//orders", "/user/42"} {
+		if rerr := p.sess.Call(serviceMethod, new(int), nil).Rerror(); rerr == nil || rerr.Code != tp.CodeNotFound {
+			t.Fatalf("%s: expect CodeNotFound, got %v", serviceMethod, rerr)
+		}
+	}
+}
+
+type wildcardPlugin struct {
+	n int32
+}
+
+func (p *wildcardPlugin) Name() string {
+	return "wildcard"
+}
+
+func (p *wildcardPlugin) PostReadCallBody(tp.ReadCtx) *tp.Rerror {
+	atomic.AddInt32(&p.n, 1)
+	return nil
+}
+
+func TestWildcardRoute(t *testing.T) {
+	plugin := new(wildcardPlugin)
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		files := srv.SubRoute("/files", plugin)
+		if path := files.RouteCallWildcard("*path", func(ctx tp.UnknownCallCtx) (interface{}, *tp.Rerror) {
+			return ctx.Param("path"), nil
+		}); path != "/files/*path" {
+			t.Fatalf("expect /files/*path, got %s", path)
+		}
+		files.RouteCallFunc(orders)
+	})
+	defer p.Close()
+	for serviceMethod, expect := range map[string]string{
+		"/files/a":         "a",
+		"/files/a/b.txt":   "a/b.txt",
+		"/files/orders":    ":/files/orders", // the static route first
+		"/files/orders/42": "orders/42",
+	} {
+		var result string
+		if rerr := p.sess.Call(serviceMethod, new(int), &result).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if result != expect {
+			t.Fatalf("%s: expect %q, got %q", serviceMethod, expect, result)
+		}
+	}
+	if n := atomic.LoadInt32(&plugin.n); n != 4 {
+		t.Fatalf("expect the plugin of the subrouter runs 4 times, got %d", n)
+	}
+	for _, serviceMethod := range []string{"/files", "/files/", "/other/a"} {
+		if rerr := p.sess.Call(serviceMethod, new(int), nil).Rerror(); rerr == nil || rerr.Code != tp.CodeNotFound {
+			t.Fatalf("%s: expect CodeNotFound, got %v", serviceMethod, rerr)
+		}
+	}
+}
+
+func TestRouteMatch(t *testing.T) {
+	if os.Getenv("TP_TEST_ROUTE_CONFLICT") == "1" {
+		// the child process registering the patterns of the same shape
+		srv := tp.NewPeer(tp.PeerConfig{})
+		srv.SubRoute("/conflict/:id").RouteCallFunc(hotloaded)
+		srv.SubRoute("/conflict/:uid").RouteCallFunc(hotloaded)
+		return
+	}
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		// the precedence does not depend on the order of the registration
+		srv.SubRoute("/:x/b").RouteCallFunc(hotloaded)
+		srv.Router().RouteCallWildcard("/m/*rest", func(tp.UnknownCallCtx) (interface{}, *tp.Rerror) {
+			return "wildcard", nil
+		})
+		srv.SubRoute("/m/:x").RouteCallFunc(hotloaded)
+		srv.SubRoute("/m/b").RouteCallFunc(hotloaded)
+	})
+	defer p.Close()
+	for serviceMethod, expect := range map[string]string{
+		"/m/b/hotloaded": "/m/b/hotloaded", // the exact route first
+		"/m/c/hotloaded": "/m/:x/hotloaded",
+		"/n/b/hotloaded": "/:x/b/hotloaded",
+		"/m/c/d":         "/m/*rest",
+	} {
+		h, ok := p.srv.Router().Match(serviceMethod)
+		if !ok || h.Name() != expect {
+			t.Fatalf("%s: expect %s, got %v", serviceMethod, expect, h)
+		}
+	}
+	p.srv.Unroute("/m/b/hotloaded")
+	if h, ok := p.srv.Router().Match("/m/b/hotloaded"); !ok || h.Name() != "/m/:x/hotloaded" {
+		t.Fatalf("expect /m/:x/hotloaded before /:x/b/hotloaded, got %v", h)
+	}
+	if _, ok := p.srv.Router().Match("/m/b/hotloaded", tp.TypePush); ok {
+		t.Fatal("expect no PUSH handler")
+	}
+	for serviceMethod, expect := range map[string]string{
+		"/m/b/hotloaded": "hello",
+		"/m/c/d":         "wildcard",
+	} {
+		var result string
+		if rerr := p.sess.Call(serviceMethod, "hello", &result).Rerror(); rerr != nil || result != expect {
+			t.Fatalf("%s: expect %q, got %q, %v", serviceMethod, expect, result, rerr)
+		}
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRouteMatch$")
+	cmd.Env = append(os.Environ(), "TP_TEST_ROUTE_CONFLICT=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "there is a handler conflict: /conflict/:uid/hotloaded and /conflict/:id/hotloaded") {
+		t.Fatalf("expect the conflict, got %v: %s", err, out)
+	}
+}
@@ -17,9 +17,9 @@ package tp
 import (
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
-	"unsafe"
 
 	"github.com/henrylee2cn/goutil"
 	"github.com/henrylee2cn/goutil/errors"
@@ -148,8 +148,15 @@ type (
 		pushHandlers map[string]*Handler
 		unknownCall  **Handler
 		unknownPush  **Handler
+		resolvers    *[]RouteResolver
+		callPatterns *[]*routePattern
+		pushPatterns *[]*routePattern
+		callVersions map[string][]*Handler // the versioned handlers, in the descending order of the versions
+		pushVersions map[string][]*Handler
+		mu           *sync.RWMutex // guards the routes shared by the sub-routers, which can be changed at runtime
 		// only for register router
 		prefix          string
+		version         string
 		pluginContainer *PluginContainer
 	}
 	// Handler call or push handler type info
@@ -162,6 +169,17 @@ type (
 		unknownHandleFunc func(*handlerCtx)
 		pluginContainer   *PluginContainer
 		routerTypeName    string
+		isBulk            bool
+		bulk              *Handler // selected when the message size exceeds the threshold
+		bulkThreshold     uint32
+		concurrency       *concurrencyLimiter // if nil, no limit
+		replyProcessors   []ReplyProcessor    // applied in order to the reply body of the call handler
+		pattern           *routePattern       // nil if the service method has no path parameters
+		sizeLimit         uint32              // if 0, only the global message size limit
+		requiredMeta      []*requiredMeta     // checked in order before the body is unmarshalled
+		newArg            func() interface{}  // if not nil, makes the arg without the reflection, see RouteCallG
+		handleArg         func(*handlerCtx, interface{})
+		version           string
 	}
 	// HandlersMaker makes []*Handler
 	HandlersMaker func(string, interface{}, *PluginContainer) ([]*Handler, error)
@@ -174,6 +192,9 @@ const (
 	pnCall        = "CALL"
 	pnUnknownPush = "UNKNOWN_PUSH"
 	pnUnknownCall = "UNKNOWN_CALL"
+	pnBulkPush    = "BULK_PUSH"
+	pnBulkCall    = "BULK_CALL"
+	pnStream      = "STREAM"
 )
 
 // newRouter creates root router.
@@ -185,6 +206,12 @@ func newRouter(rootGroup string, pluginContainer *PluginContainer) *Router {
 			pushHandlers:    make(map[string]*Handler),
 			unknownCall:     new(*Handler),
 			unknownPush:     new(*Handler),
+			resolvers:       new([]RouteResolver),
+			callPatterns:    new([]*routePattern),
+			pushPatterns:    new([]*routePattern),
+			callVersions:    make(map[string][]*Handler),
+			pushVersions:    make(map[string][]*Handler),
+			mu:              new(sync.RWMutex),
 			prefix:          rootGroup,
 			pluginContainer: pluginContainer,
 		},
@@ -218,7 +245,14 @@ func (r *SubRouter) SubRoute(prefix string, plugin ...Plugin) *SubRouter {
 		pushHandlers:    r.pushHandlers,
 		unknownCall:     r.unknownCall,
 		unknownPush:     r.unknownPush,
+		resolvers:       r.resolvers,
+		callPatterns:    r.callPatterns,
+		pushPatterns:    r.pushPatterns,
+		callVersions:    r.callVersions,
+		pushVersions:    r.pushVersions,
+		mu:              r.mu,
 		prefix:          globalServiceMethodMapper(r.prefix, prefix),
+		version:         r.version,
 		pluginContainer: pluginContainer,
 	}
 }
@@ -281,35 +315,159 @@ func (r *SubRouter) reg(
 	}
 	var names []string
 	var hadHandlers map[string]*Handler
-	if routerTypeName == pnCall {
-		hadHandlers = r.callHandlers
+	var versions map[string][]*Handler
+	if routerTypeName == pnCall || routerTypeName == pnStream {
+		hadHandlers, versions = r.callHandlers, r.callVersions
 	} else {
-		hadHandlers = r.pushHandlers
+		hadHandlers, versions = r.pushHandlers, r.pushVersions
 	}
+	r.mu.Lock()
 	for _, h := range handlers {
-		if _, ok := hadHandlers[h.name]; ok {
-			Fatalf("there is a handler conflict: %s", h.name)
+		if r.version != "" {
+			if isRoutePattern(h.name) {
+				r.mu.Unlock()
+				Fatalf("the route with the path parameters can not be versioned: %s(%s)", h.name, r.version)
+			}
+			if hasVersion(versions, h.name, r.version) {
+				r.mu.Unlock()
+				Fatalf("there is a handler conflict: %s(%s)", h.name, r.version)
+			}
+		} else {
+			if _, ok := hadHandlers[h.name]; ok {
+				r.mu.Unlock()
+				Fatalf("there is a handler conflict: %s", h.name)
+			}
+			if isRoutePattern(h.name) {
+				if had, ok := r.conflictPattern(routerTypeName, h.name); ok {
+					r.mu.Unlock()
+					Fatalf("there is a handler conflict: %s and %s", h.name, had)
+				}
+			}
 		}
+		// the handler is set up before it is visible to the running sessions
 		h.routerTypeName = routerTypeName
+		h.version = r.version
+		h.pluginContainer.postReg(h)
+		if h.version != "" {
+			addVersion(versions, h)
+			continue
+		}
 		hadHandlers[h.name] = h
-		pluginContainer.postReg(h)
-		Printf("register %s handler: %s", routerTypeName, h.name)
+		if isRoutePattern(h.name) {
+			r.addPattern(routerTypeName, h)
+		}
+	}
+	r.mu.Unlock()
+	for _, h := range handlers {
+		if h.version != "" {
+			Printf("register %s handler: %s(%s)", routerTypeName, h.name, h.version)
+		} else {
+			Printf("register %s handler: %s", routerTypeName, h.name)
+		}
 		names = append(names, h.name)
 	}
 	return names
 }
 
+// Handlers returns the routed CALL, PUSH and STREAM handlers, including the ones of all the versions,
+// in the order of the names, e.g. for the documents or the discovery.
+// NOTE: The unknown handlers and the bulk handlers are not included.
+func (r *Router) Handlers() []*Handler {
+	sr := r.subRouter
+	sr.mu.RLock()
+	handlers := make([]*Handler, 0, len(sr.callHandlers)+len(sr.pushHandlers))
+	for _, m := range []map[string]*Handler{sr.callHandlers, sr.pushHandlers} {
+		for _, h := range m {
+			handlers = append(handlers, h)
+		}
+	}
+	for _, m := range []map[string][]*Handler{sr.callVersions, sr.pushVersions} {
+		for _, hs := range m {
+			handlers = append(handlers, hs...)
+		}
+	}
+	sr.mu.RUnlock()
+	sort.Slice(handlers, func(i, j int) bool {
+		a, b := handlers[i], handlers[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.IsCall() != b.IsCall() {
+			return a.IsCall()
+		}
+		return compareVersion(a.version, b.version) < 0
+	})
+	return handlers
+}
+
+// Unroute removes the CALL and PUSH handlers of the service method, and reports whether any is removed.
+func (r *Router) Unroute(serviceMethod string) bool {
+	return r.subRouter.Unroute(serviceMethod)
+}
+
+// Unroute removes the CALL and PUSH handlers of the service method, and reports whether any is removed.
+// NOTE:
+//  The service method is the full path, e.g. the one returned by RouteCallFunc;
+//  The handlers of all the versions are removed too;
+//  The messages being handled are not affected, and the following ones fall through to the other routes.
+func (r *SubRouter) Unroute(serviceMethod string) bool {
+	r.mu.Lock()
+	var removed []*Handler
+	if h, ok := r.callHandlers[serviceMethod]; ok {
+		delete(r.callHandlers, serviceMethod)
+		if h.pattern != nil {
+			removePattern(r.callPatterns, h)
+		}
+		removed = append(removed, h)
+	}
+	if h, ok := r.pushHandlers[serviceMethod]; ok {
+		delete(r.pushHandlers, serviceMethod)
+		if h.pattern != nil {
+			removePattern(r.pushPatterns, h)
+		}
+		removed = append(removed, h)
+	}
+	removed = append(removed, r.callVersions[serviceMethod]...)
+	removed = append(removed, r.pushVersions[serviceMethod]...)
+	delete(r.callVersions, serviceMethod)
+	delete(r.pushVersions, serviceMethod)
+	r.mu.Unlock()
+	for _, h := range removed {
+		if h.version != "" {
+			Printf("unregister %s handler: %s(%s)", h.routerTypeName, h.name, h.version)
+		} else {
+			Printf("unregister %s handler: %s", h.routerTypeName, h.name)
+		}
+	}
+	return len(removed) > 0
+}
+
 // SetUnknownCall sets the default handler,
 // which is called when no handler for CALL is found.
 func (r *Router) SetUnknownCall(fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin) {
 	pluginContainer := r.subRouter.pluginContainer.cloneAndAppendMiddle(plugin...)
 	warnInvaildHandlerHooks(plugin)
 
-	var h = &Handler{
+	var h = newUnknownCallHandler(fn, pluginContainer)
+
+	r.subRouter.mu.Lock()
+	covered := *r.subRouter.unknownCall != nil
+	*r.subRouter.unknownCall = h
+	r.subRouter.mu.Unlock()
+	if covered {
+		Warnf("covered %s handler", h.name)
+	} else {
+		Printf("set %s handler", h.name)
+	}
+}
+
+func newUnknownCallHandler(fn func(UnknownCallCtx) (interface{}, *Rerror), pluginContainer *PluginContainer) *Handler {
+	return &Handler{
 		name:            pnUnknownCall,
 		isUnknown:       true,
 		argElem:         reflect.TypeOf([]byte{}),
 		pluginContainer: pluginContainer,
+		routerTypeName:  pnUnknownCall,
 		unknownHandleFunc: func(ctx *handlerCtx) {
 			body, rerr := fn(ctx)
 			if rerr != nil {
@@ -320,13 +478,6 @@ func (r *Router) SetUnknownCall(fn func(UnknownCallCtx) (interface{}, *Rerror),
 			}
 		},
 	}
-
-	if *r.subRouter.unknownCall == nil {
-		Printf("set %s handler", h.name)
-	} else {
-		Warnf("covered %s handler", h.name)
-	}
-	r.subRouter.unknownCall = &h
 }
 
 // SetUnknownPush sets the default handler,
@@ -335,46 +486,188 @@ func (r *Router) SetUnknownPush(fn func(UnknownPushCtx) *Rerror, plugin ...Plugi
 	pluginContainer := r.subRouter.pluginContainer.cloneAndAppendMiddle(plugin...)
 	warnInvaildHandlerHooks(plugin)
 
-	var h = &Handler{
+	var h = newUnknownPushHandler(fn, pluginContainer)
+
+	r.subRouter.mu.Lock()
+	covered := *r.subRouter.unknownPush != nil
+	*r.subRouter.unknownPush = h
+	r.subRouter.mu.Unlock()
+	if covered {
+		Warnf("covered %s handler", h.name)
+	} else {
+		Printf("set %s handler", h.name)
+	}
+}
+
+func newUnknownPushHandler(fn func(UnknownPushCtx) *Rerror, pluginContainer *PluginContainer) *Handler {
+	return &Handler{
 		name:            pnUnknownPush,
 		isUnknown:       true,
 		argElem:         reflect.TypeOf([]byte{}),
 		pluginContainer: pluginContainer,
+		routerTypeName:  pnUnknownPush,
 		unknownHandleFunc: func(ctx *handlerCtx) {
 			ctx.handleErr = fn(ctx)
 		},
 	}
+}
 
-	if *r.subRouter.unknownPush == nil {
-		Printf("set %s handler", h.name)
-	} else {
-		Warnf("covered %s handler", h.name)
+// RouteCallBulk registers the bulk CALL handler of the service method,
+// which is selected instead of the routed handler when the message size exceeds the threshold.
+func (r *Router) RouteCallBulk(serviceMethod string, threshold uint32, fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin) {
+	r.subRouter.RouteCallBulk(serviceMethod, threshold, fn, plugin...)
+}
+
+// RouteCallBulk registers the bulk CALL handler of the service method,
+// which is selected instead of the routed handler when the message size exceeds the threshold.
+// NOTE:
+//  The service method should be routed already;
+//  The bulk handler gets the raw body bytes, which are not decoded, e.g. for uploading.
+func (r *SubRouter) RouteCallBulk(serviceMethod string, threshold uint32, fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin) {
+	r.regBulk(pnBulkCall, r.callHandlers, serviceMethod, threshold, func(ctx *handlerCtx) {
+		body, rerr := fn(ctx)
+		if rerr != nil {
+			ctx.handleErr = rerr
+			rerr.SetToMeta(ctx.output.Meta())
+		} else {
+			ctx.output.SetBody(body)
+		}
+	}, plugin)
+}
+
+// RoutePushBulk registers the bulk PUSH handler of the service method,
+// which is selected instead of the routed handler when the message size exceeds the threshold.
+func (r *Router) RoutePushBulk(serviceMethod string, threshold uint32, fn func(UnknownPushCtx) *Rerror, plugin ...Plugin) {
+	r.subRouter.RoutePushBulk(serviceMethod, threshold, fn, plugin...)
+}
+
+// RoutePushBulk registers the bulk PUSH handler of the service method,
+// which is selected instead of the routed handler when the message size exceeds the threshold.
+// NOTE:
+//  The service method should be routed already;
+//  The bulk handler gets the raw body bytes, which are not decoded, e.g. for uploading.
+func (r *SubRouter) RoutePushBulk(serviceMethod string, threshold uint32, fn func(UnknownPushCtx) *Rerror, plugin ...Plugin) {
+	r.regBulk(pnBulkPush, r.pushHandlers, serviceMethod, threshold, func(ctx *handlerCtx) {
+		ctx.handleErr = fn(ctx)
+	}, plugin)
+}
+
+func (r *SubRouter) regBulk(
+	routerTypeName string,
+	hadHandlers map[string]*Handler,
+	serviceMethod string,
+	threshold uint32,
+	handleFunc func(*handlerCtx),
+	plugins []Plugin,
+) {
+	pluginContainer := r.pluginContainer.cloneAndAppendMiddle(plugins...)
+	warnInvaildHandlerHooks(plugins)
+	bulk := &Handler{
+		name:              serviceMethod,
+		isBulk:            true,
+		argElem:           reflect.TypeOf([]byte{}),
+		pluginContainer:   pluginContainer,
+		unknownHandleFunc: handleFunc,
+		routerTypeName:    routerTypeName,
+	}
+	pluginContainer.postReg(bulk)
+	r.mu.Lock()
+	old, ok := hadHandlers[serviceMethod]
+	if !ok {
+		r.mu.Unlock()
+		Fatalf("no handler to add the %s handler: %s", routerTypeName, serviceMethod)
+	}
+	if old.bulk != nil {
+		r.mu.Unlock()
+		Fatalf("there is a handler conflict: %s(%s)", serviceMethod, routerTypeName)
 	}
-	r.subRouter.unknownPush = &h
+	// replaces the copy, since the handler may be in use
+	h := new(Handler)
+	*h = *old
+	hadHandlers[serviceMethod] = h
+	if h.pattern != nil {
+		h.pattern.handler = h
+	}
+	h.bulk = bulk
+	h.bulkThreshold = threshold
+	r.mu.Unlock()
+	Printf("register %s handler: %s (size>%d)", routerTypeName, serviceMethod, threshold)
+}
+
+// selectBulk returns the bulk handler if the message size exceeds the threshold, else returns itself.
+func (h *Handler) selectBulk(size uint32) *Handler {
+	if h.bulk != nil && size > h.bulkThreshold {
+		return h.bulk
+	}
+	return h
 }
 
-func (r *SubRouter) getCall(uriPath string) (*Handler, bool) {
+func (r *SubRouter) getCall(uriPath, version string) (*Handler, bool) {
+	r.mu.RLock()
 	t, ok := r.callHandlers[uriPath]
+	if (!ok || version != "") && len(r.callVersions) > 0 {
+		if h := selectVersion(r.callVersions[uriPath], version); h != nil {
+			t, ok = h, true
+		}
+	}
+	if !ok && len(*r.callPatterns) > 0 {
+		t, ok = matchPattern(*r.callPatterns, uriPath)
+	}
+	resolvers, unknown := *r.resolvers, *r.unknownCall
+	r.mu.RUnlock()
 	if ok {
 		return t, true
 	}
-	if unknown := *r.unknownCall; unknown != nil {
+	if len(resolvers) > 0 {
+		if t, ok = resolve(resolvers, TypeCall, uriPath); ok {
+			return t, true
+		}
+	}
+	if unknown != nil {
 		return unknown, true
 	}
 	return nil, false
 }
 
-func (r *SubRouter) getPush(uriPath string) (*Handler, bool) {
+func (r *SubRouter) getPush(uriPath, version string) (*Handler, bool) {
+	r.mu.RLock()
 	t, ok := r.pushHandlers[uriPath]
+	if (!ok || version != "") && len(r.pushVersions) > 0 {
+		if h := selectVersion(r.pushVersions[uriPath], version); h != nil {
+			t, ok = h, true
+		}
+	}
+	if !ok && len(*r.pushPatterns) > 0 {
+		t, ok = matchPattern(*r.pushPatterns, uriPath)
+	}
+	resolvers, unknown := *r.resolvers, *r.unknownPush
+	r.mu.RUnlock()
 	if ok {
 		return t, true
 	}
-	if unknown := *r.unknownPush; unknown != nil {
+	if len(resolvers) > 0 {
+		if t, ok = resolve(resolvers, TypePush, uriPath); ok {
+			return t, true
+		}
+	}
+	if unknown != nil {
 		return unknown, true
 	}
 	return nil, false
 }
 
+// Match returns the handler which the service method is resolved to, e.g. for testing the precedence of the routes;
+// the mtype is TypeCall by default, or TypePush.
+// NOTE:
+//  The precedence is the exact route, the route patterns, the resolvers, and then the unknown handler;
+//  The message is assumed to have no version, see SubRouteVersion.
+func (r *Router) Match(serviceMethod string, mtype ...byte) (*Handler, bool) {
+	if len(mtype) > 0 && mtype[0] == TypePush {
+		return r.subRouter.getPush(serviceMethod, "")
+	}
+	return r.subRouter.getCall(serviceMethod, "")
+}
+
 // NOTE: callCtrlStruct needs to implement CallCtx interface.
 func makeCallHandlersFromStruct(prefix string, callCtrlStruct interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
 	var (
@@ -396,12 +689,17 @@ func makeCallHandlersFromStruct(prefix string, callCtrlStruct interface{}, plugi
 		return nil, errors.Errorf("call-handler: the struct do not have anonymous field tp.CallCtx: %s", ctype.String())
 	}
 
-	var callCtxOffset = iType.Offset
+	var callCtxIndex = iType.Index
 
 	if pluginContainer == nil {
 		pluginContainer = newPluginContainer()
 	}
 
+	tags, err := readRouteTags(ctype, iType)
+	if err != nil {
+		return nil, errors.Errorf("call-handler: %s: %v", ctype.String(), err)
+	}
+
 	type CallCtrlValue struct {
 		ctrl   reflect.Value
 		ctxPtr *CallCtx
@@ -409,8 +707,7 @@ func makeCallHandlersFromStruct(prefix string, callCtrlStruct interface{}, plugi
 	var pool = &sync.Pool{
 		New: func() interface{} {
 			ctrl := reflect.New(ctypeElem)
-			callCtxPtr := ctrl.Pointer() + callCtxOffset
-			ctxPtr := (*CallCtx)(unsafe.Pointer(callCtxPtr))
+			ctxPtr := ctrl.Elem().FieldByIndex(callCtxIndex).Addr().Interface().(*CallCtx)
 			return &CallCtrlValue{
 				ctrl:   ctrl,
 				ctxPtr: ctxPtr,
@@ -423,7 +720,7 @@ func makeCallHandlersFromStruct(prefix string, callCtrlStruct interface{}, plugi
 		mtype := method.Type
 		mname := method.Name
 		// Method must be exported.
-		if method.PkgPath != "" {
+		if method.PkgPath != "" || tags.ignored(mname) {
 			continue
 		}
 		// Method needs two ins: receiver, *<T>.
@@ -484,7 +781,7 @@ func makeCallHandlersFromStruct(prefix string, callCtrlStruct interface{}, plugi
 			obj := pool.Get().(*CallCtrlValue)
 			*obj.ctxPtr = ctx
 			rets := methodFunc.Call([]reflect.Value{obj.ctrl, argValue})
-			rerr := (*Rerror)(unsafe.Pointer(rets[1].Pointer()))
+			rerr := rets[1].Interface().(*Rerror)
 			if rerr != nil {
 				ctx.handleErr = rerr
 				rerr.SetToMeta(ctx.output.Meta())
@@ -494,14 +791,15 @@ func makeCallHandlersFromStruct(prefix string, callCtrlStruct interface{}, plugi
 			pool.Put(obj)
 		}
 
+		name, methodPluginContainer := tags.method(mname, pluginContainer)
 		handlers = append(handlers, &Handler{
 			handleFunc:      handleFunc,
 			argElem:         argType.Elem(),
 			reply:           replyType,
-			pluginContainer: pluginContainer,
+			pluginContainer: methodPluginContainer,
 			name: globalServiceMethodMapper(
-				globalServiceMethodMapper(prefix, ctrlStructName(ctype)),
-				mname,
+				globalServiceMethodMapper(prefix, tags.ctrlName(ctype)),
+				name,
 			),
 		})
 	}
@@ -567,7 +865,7 @@ func makeCallHandlersFromFunc(prefix string, callHandleFunc interface{}, pluginC
 
 		handleFunc = func(ctx *handlerCtx, argValue reflect.Value) {
 			rets := cValue.Call([]reflect.Value{reflect.ValueOf(ctx), argValue})
-			rerr := (*Rerror)(unsafe.Pointer(rets[1].Pointer()))
+			rerr := rets[1].Interface().(*Rerror)
 			if rerr != nil {
 				ctx.handleErr = rerr
 				rerr.SetToMeta(ctx.output.Meta())
@@ -591,12 +889,11 @@ func makeCallHandlersFromFunc(prefix string, callHandleFunc interface{}, pluginC
 			ctrl   reflect.Value
 			ctxPtr *CallCtx
 		}
-		var callCtxOffset = iType.Offset
+		var callCtxIndex = iType.Index
 		var pool = &sync.Pool{
 			New: func() interface{} {
 				ctrl := reflect.New(ctxTypeElem)
-				callCtxPtr := ctrl.Pointer() + callCtxOffset
-				ctxPtr := (*CallCtx)(unsafe.Pointer(callCtxPtr))
+				ctxPtr := ctrl.Elem().FieldByIndex(callCtxIndex).Addr().Interface().(*CallCtx)
 				return &CallCtrlValue{
 					ctrl:   ctrl,
 					ctxPtr: ctxPtr,
@@ -608,7 +905,7 @@ func makeCallHandlersFromFunc(prefix string, callHandleFunc interface{}, pluginC
 			obj := pool.Get().(*CallCtrlValue)
 			*obj.ctxPtr = ctx
 			rets := cValue.Call([]reflect.Value{obj.ctrl, argValue})
-			rerr := (*Rerror)(unsafe.Pointer(rets[1].Pointer()))
+			rerr := rets[1].Interface().(*Rerror)
 			if rerr != nil {
 				ctx.handleErr = rerr
 				rerr.SetToMeta(ctx.output.Meta())
@@ -652,11 +949,16 @@ func makePushHandlersFromStruct(prefix string, pushCtrlStruct interface{}, plugi
 		return nil, errors.Errorf("push-handler: the struct do not have anonymous field tp.PushCtx: %s", ctype.String())
 	}
 
-	var pushCtxOffset = iType.Offset
+	var pushCtxIndex = iType.Index
 
 	if pluginContainer == nil {
 		pluginContainer = newPluginContainer()
 	}
+
+	tags, err := readRouteTags(ctype, iType)
+	if err != nil {
+		return nil, errors.Errorf("push-handler: %s: %v", ctype.String(), err)
+	}
 	type PushCtrlValue struct {
 		ctrl   reflect.Value
 		ctxPtr *PushCtx
@@ -664,8 +966,7 @@ func makePushHandlersFromStruct(prefix string, pushCtrlStruct interface{}, plugi
 	var pool = &sync.Pool{
 		New: func() interface{} {
 			ctrl := reflect.New(ctypeElem)
-			pushCtxPtr := ctrl.Pointer() + pushCtxOffset
-			ctxPtr := (*PushCtx)(unsafe.Pointer(pushCtxPtr))
+			ctxPtr := ctrl.Elem().FieldByIndex(pushCtxIndex).Addr().Interface().(*PushCtx)
 			return &PushCtrlValue{
 				ctrl:   ctrl,
 				ctxPtr: ctxPtr,
@@ -678,7 +979,7 @@ func makePushHandlersFromStruct(prefix string, pushCtrlStruct interface{}, plugi
 		mtype := method.Type
 		mname := method.Name
 		// Method must be exported.
-		if method.PkgPath != "" {
+		if method.PkgPath != "" || tags.ignored(mname) {
 			continue
 		}
 		// Method needs two ins: receiver, *<T>.
@@ -732,16 +1033,17 @@ func makePushHandlersFromStruct(prefix string, pushCtrlStruct interface{}, plugi
 			obj := pool.Get().(*PushCtrlValue)
 			*obj.ctxPtr = ctx
 			rets := methodFunc.Call([]reflect.Value{obj.ctrl, argValue})
-			ctx.handleErr = (*Rerror)(unsafe.Pointer(rets[0].Pointer()))
+			ctx.handleErr = rets[0].Interface().(*Rerror)
 			pool.Put(obj)
 		}
+		name, methodPluginContainer := tags.method(mname, pluginContainer)
 		handlers = append(handlers, &Handler{
 			handleFunc:      handleFunc,
 			argElem:         argType.Elem(),
-			pluginContainer: pluginContainer,
+			pluginContainer: methodPluginContainer,
 			name: globalServiceMethodMapper(
-				globalServiceMethodMapper(prefix, ctrlStructName(ctype)),
-				mname,
+				globalServiceMethodMapper(prefix, tags.ctrlName(ctype)),
+				name,
 			),
 		})
 	}
@@ -801,7 +1103,7 @@ func makePushHandlersFromFunc(prefix string, pushHandleFunc interface{}, pluginC
 
 		handleFunc = func(ctx *handlerCtx, argValue reflect.Value) {
 			rets := cValue.Call([]reflect.Value{reflect.ValueOf(ctx), argValue})
-			ctx.handleErr = (*Rerror)(unsafe.Pointer(rets[0].Pointer()))
+			ctx.handleErr = rets[0].Interface().(*Rerror)
 		}
 
 	case reflect.Ptr:
@@ -819,12 +1121,11 @@ func makePushHandlersFromFunc(prefix string, pushHandleFunc interface{}, pluginC
 			ctrl   reflect.Value
 			ctxPtr *PushCtx
 		}
-		var pushCtxOffset = iType.Offset
+		var pushCtxIndex = iType.Index
 		var pool = &sync.Pool{
 			New: func() interface{} {
 				ctrl := reflect.New(ctxTypeElem)
-				pushCtxPtr := ctrl.Pointer() + pushCtxOffset
-				ctxPtr := (*PushCtx)(unsafe.Pointer(pushCtxPtr))
+				ctxPtr := ctrl.Elem().FieldByIndex(pushCtxIndex).Addr().Interface().(*PushCtx)
 				return &PushCtrlValue{
 					ctrl:   ctrl,
 					ctxPtr: ctxPtr,
@@ -836,7 +1137,7 @@ func makePushHandlersFromFunc(prefix string, pushHandleFunc interface{}, pluginC
 			obj := pool.Get().(*PushCtrlValue)
 			*obj.ctxPtr = ctx
 			rets := cValue.Call([]reflect.Value{obj.ctrl, argValue})
-			ctx.handleErr = (*Rerror)(unsafe.Pointer(rets[0].Pointer()))
+			ctx.handleErr = rets[0].Interface().(*Rerror)
 			pool.Put(obj)
 		}
 	}
@@ -907,6 +1208,9 @@ func (h *Handler) ArgElemType() reflect.Type {
 
 // NewArgValue creates a new arg elem value.
 func (h *Handler) NewArgValue() reflect.Value {
+	if h.newArg != nil {
+		return reflect.ValueOf(h.newArg())
+	}
 	return reflect.New(h.argElem)
 }
 
@@ -917,12 +1221,17 @@ func (h *Handler) ReplyType() reflect.Type {
 
 // IsCall checks if it is call handler or not.
 func (h *Handler) IsCall() bool {
-	return h.routerTypeName == pnCall || h.routerTypeName == pnUnknownCall
+	return h.routerTypeName == pnCall || h.routerTypeName == pnUnknownCall || h.routerTypeName == pnBulkCall
 }
 
 // IsPush checks if it is push handler or not.
 func (h *Handler) IsPush() bool {
-	return h.routerTypeName == pnPush || h.routerTypeName == pnUnknownPush
+	return h.routerTypeName == pnPush || h.routerTypeName == pnUnknownPush || h.routerTypeName == pnBulkPush
+}
+
+// IsStream checks if it is stream handler or not, see RouteStream.
+func (h *Handler) IsStream() bool {
+	return h.routerTypeName == pnStream
 }
 
 // IsUnknown checks if it is unknown handler(call/push) or not.
@@ -930,6 +1239,11 @@ func (h *Handler) IsUnknown() bool {
 	return h.isUnknown
 }
 
+// IsBulk checks if it is bulk handler(call/push) or not.
+func (h *Handler) IsBulk() bool {
+	return h.isBulk
+}
+
 // RouterTypeName returns the router type name.
 func (h *Handler) RouterTypeName() string {
 	return h.routerTypeName
@@ -0,0 +1,98 @@
+package tp_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func bulk_upload(ctx tp.CallCtx, arg *string) (string, *tp.Rerror) {
+	return "small", nil
+}
+
+func TestBulkRoute(t *testing.T) {
+	var serviceMethod string
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		serviceMethod = srv.RouteCallFunc(bulk_upload)
+		srv.RouteCallBulk(serviceMethod, 1024, func(ctx tp.UnknownCallCtx) (interface{}, *tp.Rerror) {
+			var arg string
+			if _, err := ctx.Bind(&arg); err != nil {
+				return nil, tp.NewRerror(tp.CodeBadMessage, tp.CodeText(tp.CodeBadMessage), err.Error())
+			}
+			return "bulk:" + strconv.Itoa(len(arg)), nil
+		})
+	})
+	defer p.Close()
+	for _, c := range []struct {
+		arg    string
+		result string
+	}{
+		{"abc", "small"},
+		{strings.Repeat("a", 4096), "bulk:4096"},
+	} {
+		var result string
+		if rerr := p.sess.Call(serviceMethod, c.arg, &result).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if result != c.result {
+			t.Fatalf("expect %q, got %q", c.result, result)
+		}
+	}
+}
+
+func hotloaded(ctx tp.CallCtx, arg *string) (string, *tp.Rerror) {
+	return *arg, nil
+}
+
+func TestRuntimeRoute(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, nil)
+	defer p.Close()
+	call := func(expectCode int32) {
+		var result string
+		rerr := p.sess.Call("/module/hotloaded", "x", &result).Rerror()
+		if expectCode == 0 && (rerr != nil || result != "x") {
+			t.Fatalf("expect x, got %q, %v", result, rerr)
+		}
+		if expectCode != 0 && (rerr == nil || rerr.Code != expectCode) {
+			t.Fatalf("expect code %d, got %v", expectCode, rerr)
+		}
+	}
+	call(tp.CodeNotFound)
+
+	// the concurrent CALLs while the module is loaded and unloaded
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				p.sess.Call("/module/hotloaded", "x", nil)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		path := p.srv.SubRoute("/module").RouteCallFunc(hotloaded)
+		if !p.srv.Unroute(path) {
+			t.Fatalf("expect %s is unrouted", path)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	p.srv.SubRoute("/module").RouteCallFunc(hotloaded)
+	call(0)
+	if !p.srv.Router().Unroute("/module/hotloaded") {
+		t.Fatal("expect /module/hotloaded is unrouted")
+	}
+	call(tp.CodeNotFound)
+	if p.srv.Unroute("/module/hotloaded") {
+		t.Fatal("expect no route to remove")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+// RouteResolver resolves the handler of the service method which is not routed,
+// e.g. from a scripting engine, the routes defined in the database, or the remote delegation;
+// the mtype is TypeCall or TypePush.
+// NOTE: It is called for each message, so the resolved handlers should be cached if costly.
+type RouteResolver func(mtype byte, serviceMethod string) (*Handler, bool)
+
+// SetResolver sets the chain of the resolvers, which are tried in order
+// when no handler is routed for the service method, before falling through to SetUnknownCall or SetUnknownPush.
+func (r *Router) SetResolver(resolvers ...RouteResolver) {
+	r.subRouter.mu.Lock()
+	*r.subRouter.resolvers = resolvers
+	r.subRouter.mu.Unlock()
+	Printf("set %d route resolvers", len(resolvers))
+}
+
+// resolve returns the handler of the service method resolved by the chain of the resolvers.
+func resolve(resolvers []RouteResolver, mtype byte, serviceMethod string) (*Handler, bool) {
+	for _, resolver := range resolvers {
+		h, ok := resolver(mtype, serviceMethod)
+		if !ok || h == nil {
+			continue
+		}
+		if h.IsCall() != (mtype == TypeCall) {
+			Warnf("route resolver: mismatched %s handler of %s message: %s", h.routerTypeName, TypeText(mtype), serviceMethod)
+			continue
+		}
+		return h, true
+	}
+	return nil, false
+}
+
+// NewCallHandler makes the CALL handler of the service method from the function, e.g. for a RouteResolver;
+// the function is in the form of the one of RouteCallFunc.
+func (r *Router) NewCallHandler(serviceMethod string, callHandleFunc interface{}, plugin ...Plugin) (*Handler, error) {
+	pluginContainer := r.subRouter.pluginContainer.cloneAndAppendMiddle(plugin...)
+	handlers, err := makeCallHandlersFromFunc("", callHandleFunc, pluginContainer)
+	if err != nil {
+		return nil, err
+	}
+	h := handlers[0]
+	h.name = serviceMethod
+	h.routerTypeName = pnCall
+	return h, nil
+}
+
+// NewPushHandler makes the PUSH handler of the service method from the function, e.g. for a RouteResolver;
+// the function is in the form of the one of RoutePushFunc.
+func (r *Router) NewPushHandler(serviceMethod string, pushHandleFunc interface{}, plugin ...Plugin) (*Handler, error) {
+	pluginContainer := r.subRouter.pluginContainer.cloneAndAppendMiddle(plugin...)
+	handlers, err := makePushHandlersFromFunc("", pushHandleFunc, pluginContainer)
+	if err != nil {
+		return nil, err
+	}
+	h := handlers[0]
+	h.name = serviceMethod
+	h.routerTypeName = pnPush
+	return h, nil
+}
+
+// NewUnknownCallHandler makes the CALL handler of the service method which handles the raw body,
+// e.g. for a RouteResolver which delegates to the remote peer.
+func (r *Router) NewUnknownCallHandler(serviceMethod string, fn func(UnknownCallCtx) (interface{}, *Rerror), plugin ...Plugin) *Handler {
+	pluginContainer := r.subRouter.pluginContainer.cloneAndAppendMiddle(plugin...)
+	h := newUnknownCallHandler(fn, pluginContainer)
+	h.name = serviceMethod
+	return h
+}
+
+// NewUnknownPushHandler makes the PUSH handler of the service method which handles the raw body,
+// e.g. for a RouteResolver which delegates to the remote peer.
+func (r *Router) NewUnknownPushHandler(serviceMethod string, fn func(UnknownPushCtx) *Rerror, plugin ...Plugin) *Handler {
+	pluginContainer := r.subRouter.pluginContainer.cloneAndAppendMiddle(plugin...)
+	h := newUnknownPushHandler(fn, pluginContainer)
+	h.name = serviceMethod
+	return h
+}
@@ -0,0 +1,46 @@
+package tp_test
+
+import (
+	"strings"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestRouteResolver(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		router := srv.Router()
+		script := router.NewUnknownCallHandler("/script", func(ctx tp.UnknownCallCtx) (interface{}, *tp.Rerror) {
+			return "script " + ctx.ServiceMethod(), nil
+		})
+		add, err := router.NewCallHandler("/db/add", func(ctx tp.CallCtx, arg *[]int) (int, *tp.Rerror) {
+			return (*arg)[0] + (*arg)[1], nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.SetResolver(
+			func(mtype byte, serviceMethod string) (*tp.Handler, bool) {
+				return script, mtype == tp.TypeCall && strings.HasPrefix(serviceMethod, "/script/")
+			},
+			func(mtype byte, serviceMethod string) (*tp.Handler, bool) {
+				return add, serviceMethod == "/db/add"
+			},
+		)
+		router.SetUnknownCall(func(ctx tp.UnknownCallCtx) (interface{}, *tp.Rerror) {
+			return "unknown", nil
+		})
+	})
+	defer p.Close()
+	var s string
+	if rerr := p.sess.Call("/script/hello", nil, &s).Rerror(); rerr != nil || s != "script /script/hello" {
+		t.Fatalf("unexpected reply: %q, %v", s, rerr)
+	}
+	var n int
+	if rerr := p.sess.Call("/db/add", []int{1, 2}, &n).Rerror(); rerr != nil || n != 3 {
+		t.Fatalf("unexpected reply: %d, %v", n, rerr)
+	}
+	if rerr := p.sess.Call("/other", nil, &s).Rerror(); rerr != nil || s != "unknown" {
+		t.Fatalf("unexpected reply: %q, %v", s, rerr)
+	}
+}
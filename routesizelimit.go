@@ -0,0 +1,56 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"fmt"
+)
+
+// WithRouteSizeLimit returns the plugin which limits the size of the messages of each route
+// it is registered with, e.g. the small limit for the RPC routes under the large global one for the uploads;
+// the oversize messages are refused with CodeMessageTooLarge before the body is unmarshalled.
+// NOTE:
+// The size is of the whole message, like the one of SetMessageSizeLimit;
+// The bulk handler is selected before the limit is checked, so it can have its own limit.
+func WithRouteSizeLimit(maxSize uint32) Plugin {
+	if maxSize == 0 {
+		Fatalf("invalid route size limit: %d", maxSize)
+	}
+	return &routeSizeLimit{maxSize: maxSize}
+}
+
+type routeSizeLimit struct {
+	maxSize uint32
+}
+
+var _ PostRegPlugin = new(routeSizeLimit)
+
+func (r *routeSizeLimit) Name() string {
+	return "route-size-limit"
+}
+
+// PostReg sets the size limit of the handler.
+func (r *routeSizeLimit) PostReg(h *Handler) error {
+	h.sizeLimit = r.maxSize
+	return nil
+}
+
+// checkSize refuses the message which exceeds the size limit of the route.
+func (h *Handler) checkSize(size uint32) *Rerror {
+	if h.sizeLimit > 0 && size > h.sizeLimit {
+		return rerrMessageTooLarge.Copy().SetReason(fmt.Sprintf("message size %d exceeds the route limit %d", size, h.sizeLimit))
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package tp_test
+
+import (
+	"strings"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestRouteSizeLimit(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.SubRoute("/limited", tp.WithRouteSizeLimit(256)).RouteCallFunc(hotloaded)
+		srv.SubRoute("/unlimited").RouteCallFunc(hotloaded)
+	})
+	defer p.Close()
+	large := strings.Repeat("x", 1024)
+	var result string
+	if rerr := p.sess.Call("/limited/hotloaded", "small", &result).Rerror(); rerr != nil || result != "small" {
+		t.Fatalf("expect small, got %q, %v", result, rerr)
+	}
+	if rerr := p.sess.Call("/limited/hotloaded", large, &result).Rerror(); rerr == nil || rerr.Code != tp.CodeMessageTooLarge {
+		t.Fatalf("expect CodeMessageTooLarge, got %v", rerr)
+	}
+	if rerr := p.sess.Call("/unlimited/hotloaded", large, &result).Rerror(); rerr != nil || result != large {
+		t.Fatalf("expect the large reply, got %v", rerr)
+	}
+	// the session is still usable
+	if rerr := p.sess.Call("/limited/hotloaded", "small", &result).Rerror(); rerr != nil || result != "small" {
+		t.Fatalf("expect small, got %q, %v", result, rerr)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/henrylee2cn/goutil/errors"
+)
+
+// routeTagKey the key of the tags of the controller struct, which control the routes of its methods, e.g.
+//
+//  type User struct {
+//      tp.CallCtx `tp:"name=account"`
+//      _ struct{} `tp:"method=GetUser,name=get,plugin=auth"`
+//      _ struct{} `tp:"method=Helper,ignore"`
+//  }
+//
+//  // the routes: /account/get, and the ones of the other methods except Helper
+//
+// - The tag of the anonymous tp.CallCtx or tp.PushCtx field sets the name of the controller instead of the struct name
+// - The tag of the blank field sets the name of the method instead of the method name, or ignores it
+// - The names are mapped by the service method mapper, like the struct and method names
+// - The plugins are the ones registered by RegPlugin, separated by '|', and appended to the ones of the route
+const routeTagKey = "tp"
+
+var regPlugins = struct {
+	m  map[string]Plugin
+	mu sync.RWMutex
+}{m: make(map[string]Plugin)}
+
+// RegPlugin registers the plugins by their names, which can be set to the methods by the route tags.
+func RegPlugin(plugin ...Plugin) {
+	regPlugins.mu.Lock()
+	defer regPlugins.mu.Unlock()
+	for _, p := range plugin {
+		if _, ok := regPlugins.m[p.Name()]; ok {
+			Fatalf("repeat register plugin: %s", p.Name())
+		}
+		regPlugins.m[p.Name()] = p
+	}
+}
+
+// routeTags the route tags of the controller struct.
+type routeTags struct {
+	name    string               // the name of the controller, instead of the struct name
+	methods map[string]*routeTag // by the method name
+}
+
+type routeTag struct {
+	name    string
+	ignore  bool
+	plugins []Plugin
+}
+
+// readRouteTags reads the route tags of the controller struct, whose anonymous context field is ctxField.
+func readRouteTags(ctype reflect.Type, ctxField reflect.StructField) (*routeTags, error) {
+	t := &routeTags{methods: make(map[string]*routeTag)}
+	if tag, ok := ctxField.Tag.Lookup(routeTagKey); ok {
+		method, rt, err := parseRouteTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if method != "" || rt.ignore || len(rt.plugins) > 0 {
+			return nil, errors.Errorf("only the name can be set to the controller: %s", tag)
+		}
+		t.name = rt.name
+	}
+	ctypeElem := ctype.Elem()
+	for i := 0; i < ctypeElem.NumField(); i++ {
+		field := ctypeElem.Field(i)
+		tag, ok := field.Tag.Lookup(routeTagKey)
+		if !ok || field.Name != "_" {
+			continue
+		}
+		method, rt, err := parseRouteTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if method == "" {
+			return nil, errors.Errorf("no method in the route tag: %s", tag)
+		}
+		if _, ok := ctype.MethodByName(method); !ok {
+			return nil, errors.Errorf("no method of the route tag: %s", tag)
+		}
+		if _, ok := t.methods[method]; ok {
+			return nil, errors.Errorf("repeat route tag of the method: %s", method)
+		}
+		t.methods[method] = rt
+	}
+	return t, nil
+}
+
+// parseRouteTag parses the tag in the form of `key=value,ignore`.
+func parseRouteTag(tag string) (method string, rt *routeTag, err error) {
+	rt = new(routeTag)
+	for _, s := range strings.Split(tag, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if s == "ignore" {
+			rt.ignore = true
+			continue
+		}
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return "", nil, errors.Errorf("invalid route tag: %s", tag)
+		}
+		switch kv[0] {
+		case "method":
+			method = kv[1]
+		case "name":
+			rt.name = kv[1]
+		case "plugin":
+			regPlugins.mu.RLock()
+			for _, name := range strings.Split(kv[1], "|") {
+				p, ok := regPlugins.m[name]
+				if !ok {
+					regPlugins.mu.RUnlock()
+					return "", nil, errors.Errorf("unregistered plugin of the route tag: %s", name)
+				}
+				rt.plugins = append(rt.plugins, p)
+			}
+			regPlugins.mu.RUnlock()
+		default:
+			return "", nil, errors.Errorf("invalid route tag: %s", tag)
+		}
+	}
+	return method, rt, nil
+}
+
+// ctrlName returns the name of the controller.
+func (t *routeTags) ctrlName(ctype reflect.Type) string {
+	if t.name != "" {
+		return t.name
+	}
+	return ctrlStructName(ctype)
+}
+
+// ignored reports whether the method is not routed.
+func (t *routeTags) ignored(mname string) bool {
+	rt, ok := t.methods[mname]
+	return ok && rt.ignore
+}
+
+// method returns the name and the plugin container of the method.
+func (t *routeTags) method(mname string, pluginContainer *PluginContainer) (string, *PluginContainer) {
+	rt, ok := t.methods[mname]
+	if !ok {
+		return mname, pluginContainer
+	}
+	if rt.name != "" {
+		mname = rt.name
+	}
+	if len(rt.plugins) > 0 {
+		warnInvaildHandlerHooks(rt.plugins)
+		pluginContainer = pluginContainer.cloneAndAppendMiddle(rt.plugins...)
+	}
+	return mname, pluginContainer
+}
@@ -0,0 +1,73 @@
+package tp_test
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type tagCounterPlugin struct {
+	n int32
+}
+
+func (p *tagCounterPlugin) Name() string {
+	return "tag-counter"
+}
+
+func (p *tagCounterPlugin) PostReadCallBody(tp.ReadCtx) *tp.Rerror {
+	atomic.AddInt32(&p.n, 1)
+	return nil
+}
+
+var (
+	tagCounter        = new(tagCounterPlugin)
+	regTagCounterOnce sync.Once
+)
+
+type TaggedUser struct {
+	tp.CallCtx `tp:"name=account"`
+	_          struct{} `tp:"method=GetUser,name=get,plugin=tag-counter"`
+	_          struct{} `tp:"method=Helper,ignore"`
+}
+
+func (u *TaggedUser) GetUser(id *int) (int, *tp.Rerror) {
+	return *id, nil
+}
+
+func (u *TaggedUser) Other(id *int) (int, *tp.Rerror) {
+	return -*id, nil
+}
+
+func (u *TaggedUser) Helper(a, b int) int {
+	return a + b
+}
+
+func TestRouteTag(t *testing.T) {
+	regTagCounterOnce.Do(func() { tp.RegPlugin(tagCounter) })
+	var paths []string
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		paths = srv.RouteCall(new(TaggedUser))
+	})
+	defer p.Close()
+	sort.Strings(paths)
+	if len(paths) != 2 || paths[0] != "/account/get" || paths[1] != "/account/other" {
+		t.Fatalf("expect [/account/get /account/other], got %v", paths)
+	}
+	n := atomic.LoadInt32(&tagCounter.n)
+	var result int
+	if rerr := p.sess.Call("/account/get", 7, &result).Rerror(); rerr != nil || result != 7 {
+		t.Fatalf("expect 7, got %d, %v", result, rerr)
+	}
+	if rerr := p.sess.Call("/account/other", 7, &result).Rerror(); rerr != nil || result != -7 {
+		t.Fatalf("expect -7, got %d, %v", result, rerr)
+	}
+	if got := atomic.LoadInt32(&tagCounter.n) - n; got != 1 {
+		t.Fatalf("expect the plugin of the method runs once, got %d", got)
+	}
+	if rerr := p.sess.Call("/account/helper", 7, nil).Rerror(); rerr == nil || rerr.Code != tp.CodeNotFound {
+		t.Fatalf("expect CodeNotFound, got %v", rerr)
+	}
+}
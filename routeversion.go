@@ -0,0 +1,143 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/henrylee2cn/goutil"
+	"github.com/mylonly/teleport/utils"
+)
+
+// SubRouteVersion adds the handler group of the version, see SubRouter.SubRouteVersion.
+func (r *Router) SubRouteVersion(version string, plugin ...Plugin) *SubRouter {
+	return r.subRouter.SubRouteVersion(version, plugin...)
+}
+
+// SubRouteVersion adds the handler group of the version, e.g. v2,
+// whose handlers are selected by the version of the message, see WithVersion.
+// NOTE:
+//  The message of the version is handled by the handler of the highest version not greater than it,
+//  or the one without the version if there is none;
+//  The message without the version is handled by the handler without the version,
+//  or the one of the highest version if there is none;
+//  The versions are compared by the dot-separated numbers, e.g. v1.10 > v1.9, the leading 'v' is ignored;
+//  The routes with the path parameters can not be versioned.
+func (r *SubRouter) SubRouteVersion(version string, plugin ...Plugin) *SubRouter {
+	if version == "" {
+		Fatalf("invalid route version: empty")
+	}
+	sub := r.SubRoute("", plugin...)
+	sub.prefix = r.prefix
+	sub.version = version
+	return sub
+}
+
+// WithVersion sets the version of the handler which the CALL or PUSH is sent to, see SubRouteVersion.
+func WithVersion(version string) MessageSetting {
+	return WithSetMeta(MetaVersion, version)
+}
+
+// GetVersion gets the version of the handler which the message is sent to.
+func GetVersion(meta *utils.Args) string {
+	if meta == nil {
+		return ""
+	}
+	return goutil.BytesToString(meta.Peek(MetaVersion))
+}
+
+// Version returns the version of the handler, it is empty if not versioned.
+func (h *Handler) Version() string {
+	return h.version
+}
+
+// addVersion registers the handler of the version, in the descending order of the versions.
+func addVersion(versions map[string][]*Handler, h *Handler) bool {
+	if hasVersion(versions, h.name, h.version) {
+		return false
+	}
+	handlers := versions[h.name]
+	i := len(handlers)
+	for j, had := range handlers {
+		if compareVersion(had.version, h.version) < 0 {
+			i = j
+			break
+		}
+	}
+	handlers = append(handlers, nil)
+	copy(handlers[i+1:], handlers[i:])
+	handlers[i] = h
+	versions[h.name] = handlers
+	return true
+}
+
+// hasVersion returns whether the handler of the name and the version has been registered.
+func hasVersion(versions map[string][]*Handler, name, version string) bool {
+	for _, had := range versions[name] {
+		if compareVersion(had.version, version) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// selectVersion returns the handler of the highest version not greater than the version,
+// or the one of the highest version if the version is empty.
+func selectVersion(handlers []*Handler, version string) *Handler {
+	if len(handlers) == 0 {
+		return nil
+	}
+	if version == "" {
+		return handlers[0]
+	}
+	for _, h := range handlers {
+		if compareVersion(h.version, version) <= 0 {
+			return h
+		}
+	}
+	return nil
+}
+
+// compareVersion compares the versions by the dot-separated parts,
+// numerically if both are numbers, and returns -1, 0 or 1.
+func compareVersion(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(strings.ToLower(a), "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(strings.ToLower(b), "v"), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+		if aerr == nil && berr == nil {
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+		if as[i] < bs[i] {
+			return -1
+		}
+		return 1
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
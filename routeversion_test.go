@@ -0,0 +1,73 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+type VersionNone struct {
+	tp.CallCtx
+}
+
+func (v *VersionNone) Whoami(*int) (string, *tp.Rerror) {
+	return "none", nil
+}
+
+type VersionV1 struct {
+	tp.CallCtx
+}
+
+func (v *VersionV1) Whoami(*int) (string, *tp.Rerror) {
+	return "v1", nil
+}
+
+type VersionV2 struct {
+	tp.CallCtx
+}
+
+func (v *VersionV2) Whoami(*int) (string, *tp.Rerror) {
+	return "v2", nil
+}
+
+func (v *VersionV2) Latest(*int) (string, *tp.Rerror) {
+	return "v2", nil
+}
+
+func TestRouteVersion(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc((*VersionNone).Whoami)
+		srv.SubRouteVersion("v2").RouteCallFunc((*VersionV2).Whoami)
+		srv.SubRouteVersion("v1").RouteCallFunc((*VersionV1).Whoami)
+		srv.SubRouteVersion("v2").RouteCallFunc((*VersionV2).Latest)
+	})
+	defer p.Close()
+	for _, c := range []struct {
+		serviceMethod, version, expect string
+	}{
+		{"/whoami", "", "none"},
+		{"/whoami", "v0", "none"},
+		{"/whoami", "v1", "v1"},
+		{"/whoami", "v1.5", "v1"},
+		{"/whoami", "v2", "v2"},
+		{"/whoami", "v10", "v2"},
+		{"/latest", "", "v2"},
+	} {
+		var result string
+		if rerr := p.sess.Call(c.serviceMethod, new(int), &result, tp.WithVersion(c.version)).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if result != c.expect {
+			t.Fatalf("%s(%s): expect %q, got %q", c.serviceMethod, c.version, c.expect, result)
+		}
+	}
+	if rerr := p.sess.Call("/latest", new(int), nil, tp.WithVersion("v1")).Rerror(); rerr == nil || rerr.Code != tp.CodeNotFound {
+		t.Fatalf("expect CodeNotFound, got %v", rerr)
+	}
+	if !p.srv.Unroute("/whoami") {
+		t.Fatal("expect /whoami is unrouted")
+	}
+	if rerr := p.sess.Call("/whoami", new(int), nil, tp.WithVersion("v2")).Rerror(); rerr == nil || rerr.Code != tp.CodeNotFound {
+		t.Fatalf("expect CodeNotFound, got %v", rerr)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// The scheduling policies of the waiting messages when the handlers are saturated.
+const (
+	// ScheduleFIFO handles the waiting messages in the order of arrival.
+	ScheduleFIFO = "fifo"
+	// ScheduleFair handles the waiting messages round-robin between the sessions,
+	// so that one firehose session can not starve the others.
+	ScheduleFair = "fair"
+)
+
+// scheduler limits the number of the running handlers, and queues the others.
+type scheduler struct {
+	workers  int
+	queueCap int // per session if fair, in total if fifo
+	fair     bool
+	running  int
+	pending  int
+	fifo     list.List                  // *handlerCtx
+	ring     list.List                  // *sessionQueue, the sessions with waiting messages
+	sessions map[*session]*list.Element // the elements of ring
+	mu       sync.Mutex
+	notFull  *sync.Cond
+}
+
+type sessionQueue struct {
+	sess *session
+	msgs list.List // *handlerCtx
+}
+
+func newScheduler(workers, queueCap int, policy string) (*scheduler, error) {
+	if workers <= 0 {
+		return nil, nil
+	}
+	if queueCap <= 0 {
+		queueCap = 1024
+	}
+	s := &scheduler{
+		workers:  workers,
+		queueCap: queueCap,
+		sessions: make(map[*session]*list.Element),
+	}
+	switch policy {
+	case "", ScheduleFIFO:
+	case ScheduleFair:
+		s.fair = true
+	default:
+		return nil, fmt.Errorf("invalid handler schedule config: %s, refer to the following: fifo or fair", policy)
+	}
+	s.notFull = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// submit runs the handler if any worker is idle, otherwise queues it.
+// NOTE: It blocks the read goroutine of the session while its queue is full.
+func (s *scheduler) submit(ctx *handlerCtx) {
+	s.mu.Lock()
+	if s.running < s.workers {
+		s.running++
+		s.mu.Unlock()
+		s.run(ctx)
+		return
+	}
+	if s.fair {
+		elem, ok := s.sessions[ctx.sess]
+		for ok && elem.Value.(*sessionQueue).msgs.Len() >= s.queueCap {
+			s.notFull.Wait()
+			elem, ok = s.sessions[ctx.sess]
+		}
+		if !ok {
+			elem = s.ring.PushBack(&sessionQueue{sess: ctx.sess})
+			s.sessions[ctx.sess] = elem
+		}
+		elem.Value.(*sessionQueue).msgs.PushBack(ctx)
+	} else {
+		for s.fifo.Len() >= s.queueCap {
+			s.notFull.Wait()
+		}
+		s.fifo.PushBack(ctx)
+	}
+	s.pending++
+	s.mu.Unlock()
+}
+
+// run handles the context, and then the waiting ones in the same goroutine.
+func (s *scheduler) run(ctx *handlerCtx) {
+	peer := ctx.sess.peer
+	if !Go(func() {
+		for ctx != nil {
+			ctx.handle()
+			peer.putContext(ctx, true)
+			ctx = s.next()
+		}
+	}) {
+		peer.putContext(ctx, true)
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+	}
+}
+
+// next returns the next waiting context, or releases the worker if there is none.
+func (s *scheduler) next() *handlerCtx {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ctx *handlerCtx
+	if s.fair {
+		if front := s.ring.Front(); front != nil {
+			q := front.Value.(*sessionQueue)
+			ctx = q.msgs.Remove(q.msgs.Front()).(*handlerCtx)
+			if q.msgs.Len() == 0 {
+				s.ring.Remove(front)
+				delete(s.sessions, q.sess)
+			} else {
+				// the session takes its turn again after the others
+				s.ring.MoveToBack(front)
+			}
+		}
+	} else if front := s.fifo.Front(); front != nil {
+		ctx = s.fifo.Remove(front).(*handlerCtx)
+	}
+	if ctx == nil {
+		s.running--
+		return nil
+	}
+	s.pending--
+	s.notFull.Broadcast()
+	return ctx
+}
+
+func (s *scheduler) stats(stats *StageStats) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	stats.HandlerWorkers = s.workers
+	stats.HandlerRunning = s.running
+	stats.HandlerQueueLen = s.pending
+	s.mu.Unlock()
+}
+
+// dispatch runs the handler of the context in the goroutine pool,
+// through the scheduler if the number of the running handlers is limited.
+// NOTE:
+//  The replies are never queued, since the waiting handlers may be calling;
+//  The control messages are never queued either, e.g. PING should be answered in time.
+func (p *peer) dispatch(ctx *handlerCtx) {
+	ctx.inspectInbound()
+	if ctx.input.Mtype() == TypeCall && ctx.handleErr == nil && !ctx.admitCall() {
+		// refused in the read goroutine, so that the excess CALLs spawn no goroutine
+		ctx.handleErr = rerrSessionOverloaded
+		ctx.handle()
+		p.putContext(ctx, true)
+		return
+	}
+	if p.dispatchStream(ctx) {
+		return
+	}
+	if p.dispatchChannel(ctx) {
+		return
+	}
+	if p.scheduler != nil && ctx.input.Mtype() != TypeReply && !IsControlType(ctx.input.Mtype()) {
+		p.scheduler.submit(ctx)
+		return
+	}
+	if !Go(func() {
+		defer p.putContext(ctx, true)
+		ctx.handle()
+	}) {
+		p.putContext(ctx, true)
+	}
+}
@@ -0,0 +1,73 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// inboundCounter reports the inbound messages, which are submitted to the scheduler right after.
+type inboundCounter chan struct{}
+
+func (c inboundCounter) InspectInbound(tp.Session, tp.Message) *tp.Rerror {
+	c <- struct{}{}
+	return nil
+}
+
+func (inboundCounter) InspectOutbound(tp.Session, tp.Message) {}
+
+func TestFairSchedule(t *testing.T) {
+	inbound := make(inboundCounter, 64)
+	entered, release := make(chan int, 64), make(chan struct{})
+	var path string
+	p := newMemPeers(t, tp.PeerConfig{
+		HandlerWorkers:  1,
+		HandlerSchedule: tp.ScheduleFair,
+	}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.SetMessageInspector(inbound)
+		path = srv.RouteCallFunc(blockingCall(entered, release))
+	})
+	defer p.Close()
+	defer close(release)
+	firehose := p.sess
+	sess, rerr := p.cli.Dial(p.addr)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+
+	// the only worker is busy, and the backlog of the firehose is queued
+	const backlog = 30
+	callCmdChan := make(chan tp.CallCmd, backlog+1)
+	for i := 0; i < backlog; i++ {
+		firehose.AsyncCall(path, 0, new(int), callCmdChan)
+	}
+	for i := 0; i < backlog; i++ {
+		<-inbound
+	}
+	<-entered
+	if stats := p.srv.StageStats(); stats.HandlerWorkers != 1 || stats.HandlerQueueLen == 0 {
+		t.Fatalf("expect the queued messages, got %+v", stats)
+	}
+	sess.AsyncCall(path, 1, new(int), callCmdChan)
+	<-inbound
+
+	// FIFO would handle the call after the whole backlog of the firehose
+	var order []int
+	for i := 0; i < 3; i++ {
+		release <- struct{}{}
+		order = append(order, <-entered)
+	}
+	if order[1] != 1 && order[2] != 1 {
+		t.Fatalf("expect the call not starved by the firehose, got the handling order %v", order)
+	}
+	for i := len(order); i < backlog; i++ {
+		release <- struct{}{}
+		<-entered
+	}
+	release <- struct{}{}
+	for i := 0; i < backlog+1; i++ {
+		if rerr = (<-callCmdChan).Rerror(); rerr != nil {
+			t.Fatalf("%s: %v", path, rerr)
+		}
+	}
+}
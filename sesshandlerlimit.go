@@ -0,0 +1,103 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync/atomic"
+)
+
+// The states of the session handler slot of the CALL.
+const (
+	sessSlotNone int8 = iota
+	sessSlotQueued
+	sessSlotHeld
+)
+
+// SetHandlerLimit sets the maximum number of the CALL handlers running at the same time for the session,
+// and queues at most queue CALLs for them; the excess CALLs are refused with CodeTooManyRequests.
+// It overrides PeerConfig.SessionHandlerLimit and PeerConfig.SessionHandlerQueue.
+// NOTE:
+// If limit<=0, no limit;
+// The PUSH handlers are not limited;
+// The CALLs being handled are counted by the previous limit.
+func (s *session) SetHandlerLimit(limit, queue int) {
+	s.handlerLimiter.Store(newSessionHandlerLimiter(limit, queue))
+}
+
+func newSessionHandlerLimiter(limit, queue int) *concurrencyLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &concurrencyLimiter{
+		slots: make(chan struct{}, limit),
+		queue: int32(queue),
+	}
+}
+
+// admitCall reserves a handler slot or a place in the queue of the session for the CALL without blocking,
+// and returns false if both are full.
+func (c *handlerCtx) admitCall() bool {
+	l, _ := c.sess.handlerLimiter.Load().(*concurrencyLimiter)
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		c.sessLimiter, c.sessSlot = l, sessSlotHeld
+		return true
+	default:
+	}
+	if atomic.AddInt32(&l.queued, 1) > l.queue {
+		atomic.AddInt32(&l.queued, -1)
+		atomic.AddUint64(&l.shed, 1)
+		return false
+	}
+	c.sessLimiter, c.sessSlot = l, sessSlotQueued
+	return true
+}
+
+// waitSessionSlot waits for the handler slot of the session, if the CALL is queued.
+func (c *handlerCtx) waitSessionSlot() *Rerror {
+	if c.sessSlot != sessSlotQueued {
+		return nil
+	}
+	l := c.sessLimiter
+	select {
+	case l.slots <- struct{}{}:
+		atomic.AddInt32(&l.queued, -1)
+		c.sessSlot = sessSlotHeld
+		return nil
+	case <-c.Context().Done():
+		return rerrHandleTimeout.Copy().SetReason("waiting for the session handler limit: " + c.Context().Err().Error())
+	case <-c.sess.closeNotifyCh:
+		return rerrConnClosed
+	}
+}
+
+// releaseSessionSlot releases the handler slot or the place in the queue of the session.
+func (c *handlerCtx) releaseSessionSlot() {
+	switch c.sessSlot {
+	case sessSlotHeld:
+		<-c.sessLimiter.slots
+	case sessSlotQueued:
+		atomic.AddInt32(&c.sessLimiter.queued, -1)
+	default:
+		return
+	}
+	c.sessLimiter, c.sessSlot = nil, sessSlotNone
+}
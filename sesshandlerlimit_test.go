@@ -0,0 +1,54 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestSessionHandlerLimit(t *testing.T) {
+	var path string
+	// each CALL is released by one send
+	entered, release := make(chan int, 4), make(chan struct{})
+	p := newMemPeers(t, tp.PeerConfig{
+		SessionHandlerLimit: 1,
+		SessionHandlerQueue: 1,
+	}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(blockingCall(entered, release))
+	})
+	defer p.Close()
+	// sends n CALLs, expects the refused ones replied while the running ones are held,
+	// then releases the running ones one by one
+	expect := func(n, running, refused int) {
+		t.Helper()
+		done := make(chan tp.CallCmd, n)
+		for i := 0; i < n; i++ {
+			arg := i
+			p.sess.AsyncCall(path, &arg, new(int), done)
+		}
+		for i := 0; i < running; i++ {
+			<-entered
+		}
+		for i := 0; i < refused; i++ {
+			if rerr := (<-done).Rerror(); rerr == nil || rerr.Code != tp.CodeTooManyRequests {
+				t.Fatalf("expect the call refused, got %v", rerr)
+			}
+		}
+		for i := refused; i < n; i++ {
+			if i >= refused+running {
+				// the queued one gets the slot released
+				<-entered
+			}
+			release <- struct{}{}
+			if rerr := (<-done).Rerror(); rerr != nil {
+				t.Fatal(rerr)
+			}
+		}
+	}
+	expect(4, 1, 2)
+	p.srv.RangeSession(func(s tp.Session) bool {
+		s.SetHandlerLimit(2, 0)
+		return true
+	})
+	expect(3, 2, 1)
+}
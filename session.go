@@ -16,6 +16,8 @@ package tp
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -30,6 +32,7 @@ import (
 	"github.com/mylonly/teleport/codec"
 	"github.com/mylonly/teleport/socket"
 	"github.com/mylonly/teleport/utils"
+	"github.com/mylonly/teleport/xfer"
 )
 
 type (
@@ -37,12 +40,31 @@ type (
 	PreSession interface {
 		// Peer returns the peer.
 		Peer() Peer
+		// Context returns the context of the session, which is canceled when the session is closed
+		// or the peer is going away, e.g. for the I/O of the handshake plugins.
+		Context() context.Context
 		// LocalAddr returns the local network address.
 		LocalAddr() net.Addr
 		// RemoteAddr returns the remote network address.
 		RemoteAddr() net.Addr
+		// PeerCertificates returns the certificate chain presented by the remote peer over TLS,
+		// which has been verified if required by the TLS config, e.g. ClientAuth of the server.
+		// NOTE: It is nil if the connection is not TLS, or the remote peer presents no certificate.
+		PeerCertificates() []*x509.Certificate
+		// PeerCred returns the credentials of the process on the other end of the unix domain socket,
+		// which are captured when the connection is accepted.
+		// NOTE: It is nil if the network is not unix or unixpacket, or the platform does not support it.
+		PeerCred() *PeerCred
 		// Swap returns custom data swap of the session(socket).
 		Swap() goutil.Map
+		// Store returns the typed key-value store of the session, whose values expire after the TTL.
+		// NOTE: It lives in memory with the session, unlike the persistent Peer.Store.
+		Store() *SessionStore
+		// SetLabel sets the label of the session, which is indexed for Peer.FindSessions,
+		// e.g. SetLabel("user", "42").
+		SetLabel(key, value string)
+		// Label returns the label value of the session.
+		Label(key string) (value string, ok bool)
 		// SetID sets the session id.
 		SetID(newID string)
 		// ControlFD invokes f on the underlying connection's file
@@ -71,10 +93,45 @@ type (
 		SessionAge() time.Duration
 		// ContextAge returns CALL or PUSH context max age.
 		ContextAge() time.Duration
+		// IdleTimeout returns the duration after which the session is closed if no message is exchanged.
+		IdleTimeout() time.Duration
+		// SetIdleTimeout sets the duration after which the session is closed if no message is exchanged,
+		// which is reset by every message read or written, except the control messages.
+		// NOTE: If d<=0, never closed for idleness.
+		SetIdleTimeout(d time.Duration)
 		// SetSessionAge sets the session max age.
 		SetSessionAge(duration time.Duration)
 		// SetContextAge sets CALL or PUSH context max age.
 		SetContextAge(duration time.Duration)
+		// WriteLimit returns the message size upper limit of writing.
+		WriteLimit() uint32
+		// SetWriteLimit sets the message size upper limit of writing,
+		// usually it is the read limit negotiated with the remote peer.
+		// NOTE: If limit=0, only the global message size limit is checked.
+		SetWriteLimit(limit uint32)
+		// SetWriteRateLimiter sets the limiters of the messages and bytes written to the session,
+		// which override the ones of PeerConfig.WriteMsgRate and PeerConfig.WriteByteRate.
+		// NOTE: If the limiter is nil, no limit; the control messages are never limited.
+		SetWriteRateLimiter(msgLimiter, byteLimiter RateLimiter)
+		// SetHandlerLimit sets the maximum number of the CALL handlers running at the same time for the session,
+		// and queues at most queue CALLs for them; the excess CALLs are refused with CodeTooManyRequests.
+		// NOTE: If limit<=0, no limit.
+		SetHandlerLimit(limit, queue int)
+		// SetInflightLimit sets the maximum number of the in-flight CALLs launched by the session,
+		// the excess CALLs wait or fail with CodeTooManyCalls, see PeerConfig.InflightCallsWait.
+		// NOTE: If limit<=0, no limit.
+		SetInflightLimit(limit int)
+		// RerrorCodec returns the codec id of Rerror sent to the remote peer.
+		RerrorCodec() byte
+		// SetRerrorCodec sets the codec id of Rerror sent to the remote peer,
+		// usually it is negotiated with the remote peer.
+		SetRerrorCodec(codecID byte)
+		// Downgrade marks the feature as unsupported by the remote peer,
+		// and the session falls back to the common feature subset.
+		// NOTE: It is usually called by the handshake plugins, instead of failing the connection.
+		Downgrade(feature string, reason *Rerror)
+		// IsDowngraded returns whether the feature has been downgraded.
+		IsDowngraded(feature string) bool
 		// Logger logger interface
 		Logger
 	}
@@ -84,12 +141,38 @@ type (
 		ID() string
 		// Peer returns the peer.
 		Peer() Peer
+		// Context returns the context of the session, which is canceled when the session is closed
+		// or the peer is going away.
+		Context() context.Context
 		// LocalAddr returns the local network address.
 		LocalAddr() net.Addr
 		// RemoteAddr returns the remote network address.
 		RemoteAddr() net.Addr
+		// PeerCertificates returns the certificate chain presented by the remote peer over TLS,
+		// which has been verified if required by the TLS config, e.g. ClientAuth of the server.
+		// NOTE: It is nil if the connection is not TLS, or the remote peer presents no certificate.
+		PeerCertificates() []*x509.Certificate
+		// PeerCred returns the credentials of the process on the other end of the unix domain socket,
+		// which are captured when the connection is accepted.
+		// NOTE: It is nil if the network is not unix or unixpacket, or the platform does not support it.
+		PeerCred() *PeerCred
+		// RemoteBuildInfo returns the build info of the remote peer.
+		// NOTE: It is nil until the HELLO control message is read, or if the remote peer sends none.
+		RemoteBuildInfo() *BuildInfo
+		// Label returns the label value of the session.
+		Label(key string) (value string, ok bool)
+		// Labels returns a copy of the labels of the session.
+		Labels() map[string]string
+		// Stats returns the statistics of the session, e.g. the bytes and messages read and written.
+		Stats() SessionStats
+		// CloseReason returns the reason why the session is closed last time, and the last error.
+		// NOTE: Its Kind is CloseNone if the session has never been closed.
+		CloseReason() CloseReason
 		// Swap returns custom data swap of the session(socket).
 		Swap() goutil.Map
+		// Store returns the typed key-value store of the session, whose values expire after the TTL.
+		// NOTE: It lives in memory with the session, unlike the persistent Peer.Store.
+		Store() *SessionStore
 		// Logger logger interface
 		Logger
 	}
@@ -98,8 +181,19 @@ type (
 		BaseSession
 		// SetID sets the session id.
 		SetID(newID string)
+		// SetLabel sets the label of the session, which is indexed for Peer.FindSessions,
+		// e.g. SetLabel("user", "42").
+		SetLabel(key, value string)
+		// DeleteLabel deletes the label of the session.
+		DeleteLabel(key string)
 		// Close closes the session.
 		Close() error
+		// Drain sends a GOAWAY control message with the reason, refuses new inbound CALLs
+		// with the retryable CodeServiceUnavailable, waits for the in-flight work, and closes the session.
+		// NOTE:
+		// If timeout>0, the session is closed after the timeout even if the work is not finished;
+		// The default reason is "draining".
+		Drain(timeout time.Duration, reason ...string) error
 		// CloseNotify returns a channel that closes when the connection has gone away.
 		CloseNotify() <-chan struct{}
 		// Health checks if the session is usable.
@@ -116,17 +210,86 @@ type (
 		// Call sends a message and receives reply.
 		// NOTE:
 		// If the arg is []byte or *[]byte type, it can automatically fill in the body codec name;
-		// If the session is a client role and PeerConfig.RedialTimes>0, it is automatically re-called once after a failure.
+		// If the session is a client role and PeerConfig.RedialTimes>0, it is automatically re-called once after a failure;
+		// If PeerConfig.OverloadRetries>0, it is retried after the retry-after hint of the rejection.
 		Call(serviceMethod string, arg interface{}, result interface{}, setting ...MessageSetting) CallCmd
+		// CallMulti sends a message and receives the parts of the reply by the iterator,
+		// which are sent by CallCtx.ReplyPart and ended by the reply returned by the handler.
+		// NOTE: newResult creates the result which each part is decoded into.
+		CallMulti(serviceMethod string, arg interface{}, newResult func() interface{}, setting ...MessageSetting) *ReplyIter
+		// CallStream sends a message and receives the reply as a stream of the chunks by the iterator,
+		// which are sent by CallCtx.SendStream and ended by the termination frame after the handler returns.
+		// NOTE: newResult creates the result which each chunk is decoded into.
+		CallStream(serviceMethod string, arg interface{}, newResult func() interface{}, setting ...MessageSetting) *ReplyIter
+		// OpenStream opens the stream to the STREAM handler of the service method,
+		// over which both sides exchange a sequence of messages until the handler returns.
+		// NOTE: newResult creates the result which each message sent by the handler is decoded into.
+		OpenStream(serviceMethod string, newResult func() interface{}, setting ...MessageSetting) *ClientStream
+		// RouteCallTemp registers the CALL handler function on the session only, and returns the path,
+		// which is sent to the remote peer to call back, e.g. in the argument of a CALL.
+		// NOTE:
+		// If the path is empty, a random one is generated;
+		// The temporary route takes precedence over the routes of the peer;
+		// If ttl>0, it is removed after the ttl, otherwise it lives until removed or the session is closed.
+		RouteCallTemp(path string, callHandleFunc interface{}, ttl time.Duration) string
+		// RemoveTempRoute removes the temporary route of the session,
+		// e.g. called by a one-shot handler itself.
+		RemoveTempRoute(path string)
 		// Push sends a message, but do not receives reply.
 		// NOTE:
 		// If the arg is []byte or *[]byte type, it can automatically fill in the body codec name;
 		// If the session is a client role and PeerConfig.RedialTimes>0, it is automatically re-called once after a failure.
 		Push(serviceMethod string, arg interface{}, setting ...MessageSetting) *Rerror
+		// PushUnreliable sends a message as an unreliable datagram, which may be lost, duplicated or reordered,
+		// e.g. for the telemetry and the game-state updates.
+		// NOTE:
+		// It falls back to Push if the connection does not support the datagrams, see DatagramConn;
+		// The message larger than the datagram limit of the connection fails.
+		PushUnreliable(serviceMethod string, arg interface{}, setting ...MessageSetting) *Rerror
+		// Control sends a framework-internal control message, it is not routed to user handlers.
+		// NOTE:
+		// The mtype must be in the control range, see IsControlType;
+		// The payload is carried by the metadata or the optional []byte body;
+		// It fails with CodeMtypeNotAllowed until the remote peer is negotiated to accept it, see FeatureControl.
+		Control(mtype byte, setting ...MessageSetting) *Rerror
 		// SessionAge returns the session max age.
 		SessionAge() time.Duration
 		// ContextAge returns CALL or PUSH context max age.
 		ContextAge() time.Duration
+		// IdleTimeout returns the duration after which the session is closed if no message is exchanged.
+		IdleTimeout() time.Duration
+		// SetIdleTimeout sets the duration after which the session is closed if no message is exchanged,
+		// which is reset by every message read or written, except the control messages.
+		// NOTE: If d<=0, never closed for idleness.
+		SetIdleTimeout(d time.Duration)
+		// WriteLimit returns the message size upper limit of writing.
+		WriteLimit() uint32
+		// SetWriteLimit sets the message size upper limit of writing,
+		// usually it is the read limit negotiated with the remote peer.
+		// NOTE: If limit=0, only the global message size limit is checked.
+		SetWriteLimit(limit uint32)
+		// SetWriteRateLimiter sets the limiters of the messages and bytes written to the session,
+		// which override the ones of PeerConfig.WriteMsgRate and PeerConfig.WriteByteRate.
+		// NOTE: If the limiter is nil, no limit; the control messages are never limited.
+		SetWriteRateLimiter(msgLimiter, byteLimiter RateLimiter)
+		// SetHandlerLimit sets the maximum number of the CALL handlers running at the same time for the session,
+		// and queues at most queue CALLs for them; the excess CALLs are refused with CodeTooManyRequests.
+		// NOTE: If limit<=0, no limit.
+		SetHandlerLimit(limit, queue int)
+		// SetInflightLimit sets the maximum number of the in-flight CALLs launched by the session,
+		// the excess CALLs wait or fail with CodeTooManyCalls, see PeerConfig.InflightCallsWait.
+		// NOTE: If limit<=0, no limit.
+		SetInflightLimit(limit int)
+		// OpenChannel returns the logical channel of the name multiplexed over the session,
+		// whose CALLs and PUSHes are handled in order by the remote peer, independently of the other channels.
+		OpenChannel(name string) Channel
+		// IsDowngraded returns whether the feature has been downgraded.
+		IsDowngraded(feature string) bool
+		// UpgradeProto switches the protocol of the live session, negotiated with the remote peer.
+		// NOTE:
+		// The remote peer must register the protocol by RegUpgradeProto;
+		// Only one side of the session should initiate the upgrade at a time.
+		UpgradeProto(protoFunc ProtoFunc) *Rerror
 	}
 )
 
@@ -138,25 +301,62 @@ var (
 
 type session struct {
 	peer                           *peer
-	getCallHandler, getPushHandler func(serviceMethodPath string) (*Handler, bool)
+	getCallHandler, getPushHandler func(serviceMethodPath, version string) (*Handler, bool)
 	timeSince                      func(time.Time) time.Duration
 	timeNow                        func() time.Time
 	seq                            int32
+	writeLimit                     uint32
+	rerrorCodec                    uint32
+	controlAccepted                int32 // 1 if the remote peer accepts the control messages, see FeatureControl
+	featuresSent                   int32 // 1 if the features are announced to the connection
 	callCmdMap                     goutil.Map
+	callCancels                    goutil.Map // seq -> context.CancelFunc, the CALL handlers cancelable by the caller
+	downgraded                     goutil.Map
+	tempRoutes                     goutil.Map
+	channels                       goutil.Map // name -> *channel
+	streams                        goutil.Map // seq -> *serverStream, the streams opened by the remote peer
+	streamCredits                  goutil.Map // seq -> *streamCredit, the streaming replies to the remote peer
+	chanQueues                     channelQueues
 	protoFuncs                     []ProtoFunc
 	socket                         socket.Socket
+	peerCred                       *PeerCred     // captured at accept time, only for unix domain sockets
+	remoteBuildInfo                atomic.Value  // *BuildInfo
 	status                         int32         // 0:ok, 1:active closed, 2:disconnect
+	closeReason                    CloseReason   // guarded by statusLock
+	brokenWrite                    atomic.Value  // brokenWrite, the connection closed by the writing
 	closeNotifyCh                  chan struct{} // closeNotifyCh is the channel returned by CloseNotify.
 	didCloseNotify                 int32
+	ctx                            context.Context
+	cancelCtx                      context.CancelFunc
+	draining                       int32
+	upgrading                      int32
+	unpackedBytes                  int64 // the size of the unpacked messages being handled
+	lastRead                       int64 // the unix nano time of the last read, for the heartbeat
+	lastActive                     int64 // the unix nano time of the last message exchanged, for the idle timeout
+	unchargedBytes                 int64 // the size of the last written message, charged before the next writing
+	counters                       *sessionCounters
+	store                          *SessionStore
+	msgLimiter                     RateLimiter
+	byteLimiter                    RateLimiter
+	handlerLimiter                 atomic.Value // *concurrencyLimiter, nil means no limit
+	inflightLimiter                atomic.Value // *concurrencyLimiter of the CALLs launched, nil means no limit
+	pendingUpgrade                 *pendingUpgrade
+	handshake                      *handshakeGuard // nil after the first message is read, or for the client role
+	upgradeLock                    sync.Mutex
 	statusLock                     sync.Mutex
-	writeLock                      sync.Mutex
-	graceCtxWaitGroup              sync.WaitGroup
-	graceCallCmdWaitGroup          sync.WaitGroup
+	writeLock                      priorityMutex
+	graceCtxWaitGroup              graceCounter
+	graceCallCmdWaitGroup          graceCounter
 	sessionAge                     time.Duration
 	contextAge                     time.Duration
 	sessionAgeLock                 sync.RWMutex
+	idleTimeout                    time.Duration
+	idleTimer                      *time.Timer
+	idleLock                       sync.Mutex
 	contextAgeLock                 sync.RWMutex
 	lock                           sync.RWMutex
+	labels                         map[string]string // guarded by the session hub
+	indexed                        bool              // whether the labels are indexed by the session hub
 	// only for client role
 	redialForClientLocked func(oldConn net.Conn) bool
 }
@@ -172,12 +372,50 @@ func newSession(peer *peer, conn net.Conn, protoFuncs []ProtoFunc) *session {
 		socket:         socket.NewSocket(conn, protoFuncs...),
 		closeNotifyCh:  make(chan struct{}),
 		callCmdMap:     goutil.AtomicMap(),
+		callCancels:    goutil.AtomicMap(),
+		downgraded:     goutil.AtomicMap(),
+		tempRoutes:     goutil.AtomicMap(),
+		channels:       goutil.AtomicMap(),
+		streams:        goutil.AtomicMap(),
+		streamCredits:  goutil.AtomicMap(),
+		counters:       new(sessionCounters),
+		store:          newSessionStore(),
+		rerrorCodec:    uint32(RerrorCodecJSON),
 		sessionAge:     peer.defaultSessionAge,
 		contextAge:     peer.defaultContextAge,
+		lastActive:     time.Now().UnixNano(),
+	}
+	if peer.idGenerator != nil {
+		s.socket.SetID(peer.idGenerator.NewID())
 	}
+	s.initWriteRate()
+	s.handlerLimiter.Store(newSessionHandlerLimiter(peer.sessHandlerLimit, peer.sessHandlerQueue))
+	s.inflightLimiter.Store(newInflightLimiter(peer.sessInflight))
+	s.SetIdleTimeout(peer.idleTimeout)
+	s.initWriteQueue()
+	s.ctx, s.cancelCtx = context.WithCancel(peer.ctx)
 	return s
 }
 
+// nextSeq returns the sequence of the next message launched by the session.
+// NOTE:
+// The sequences of the client role are odd, and the server role are even,
+// so the CALLs launched by both sides never share a sequence;
+// It is only a convention, the replies are matched with the CALLs launched by the same side,
+// so it is wire compatible with the older peers which step the sequences by 1.
+func (s *session) nextSeq() int32 {
+	return atomic.AddInt32(&s.seq, 2)
+}
+
+// setMessageID attaches a generated message ID to the metadata, if enabled and not set.
+func (s *session) setMessageID(output Message) {
+	g := s.peer.msgIDGenerator
+	if g == nil || len(output.Meta().Peek(MetaMessageID)) > 0 {
+		return
+	}
+	output.Meta().Set(MetaMessageID, g.NewID())
+}
+
 // Peer returns the peer.
 func (s *session) Peer() Peer {
 	return s.peer
@@ -271,6 +509,21 @@ func (s *session) RemoteAddr() net.Addr {
 	return s.socket.RemoteAddr()
 }
 
+// PeerCertificates returns the certificate chain presented by the remote peer over TLS,
+// which has been verified if required by the TLS config, e.g. ClientAuth of the server.
+// NOTE: It is nil if the connection is not TLS, or the remote peer presents no certificate.
+func (s *session) PeerCertificates() []*x509.Certificate {
+	switch conn := s.getConn().(type) {
+	case *tls.Conn:
+		return conn.ConnectionState().PeerCertificates
+	case interface {
+		PeerCertificates() []*x509.Certificate
+	}:
+		return conn.PeerCertificates()
+	}
+	return nil
+}
+
 // SessionAge returns the session max age.
 func (s *session) SessionAge() time.Duration {
 	s.sessionAgeLock.RLock()
@@ -306,6 +559,62 @@ func (s *session) SetContextAge(duration time.Duration) {
 	s.contextAgeLock.Unlock()
 }
 
+// WriteLimit returns the message size upper limit of writing.
+func (s *session) WriteLimit() uint32 {
+	return atomic.LoadUint32(&s.writeLimit)
+}
+
+// SetWriteLimit sets the message size upper limit of writing,
+// usually it is the read limit negotiated with the remote peer.
+// NOTE: If limit=0, only the global message size limit is checked.
+func (s *session) SetWriteLimit(limit uint32) {
+	atomic.StoreUint32(&s.writeLimit, limit)
+}
+
+// RerrorCodec returns the codec id of Rerror sent to the remote peer.
+func (s *session) RerrorCodec() byte {
+	return byte(atomic.LoadUint32(&s.rerrorCodec))
+}
+
+// SetRerrorCodec sets the codec id of Rerror sent to the remote peer,
+// usually it is negotiated with the remote peer.
+func (s *session) SetRerrorCodec(codecID byte) {
+	atomic.StoreUint32(&s.rerrorCodec, uint32(codecID))
+}
+
+// negotiateRerrorCodec switches the Rerror codec to the one that the remote peer wishes to accept.
+func (s *session) negotiateRerrorCodec(meta *utils.Args) {
+	b := meta.Peek(MetaAcceptRerrorCodec)
+	if len(b) == 0 {
+		return
+	}
+	id, err := strconv.ParseUint(goutil.BytesToString(b), 10, 8)
+	if err != nil || byte(id) == s.RerrorCodec() {
+		return
+	}
+	if _, err = GetRerrorCodec(byte(id)); err == nil {
+		s.SetRerrorCodec(byte(id))
+	}
+}
+
+// Downgrade marks the feature as unsupported by the remote peer,
+// and the session falls back to the common feature subset.
+// NOTE: It is usually called by the handshake plugins, instead of failing the connection.
+func (s *session) Downgrade(feature string, reason *Rerror) {
+	if _, loaded := s.downgraded.LoadOrStore(feature, reason); loaded {
+		return
+	}
+	Warnf("downgrade feature %q: remote peer does not support it, addr:%s, reason:%s",
+		feature, s.RemoteAddr().String(), reason.String())
+	s.peer.pluginContainer.postDowngrade(s, feature, reason)
+}
+
+// IsDowngraded returns whether the feature has been downgraded.
+func (s *session) IsDowngraded(feature string) bool {
+	_, ok := s.downgraded.Load(feature)
+	return ok
+}
+
 // Send sends message to peer, before the formal connection.
 // NOTE:
 // the external setting seq is invalid, the internal will be forced to set;
@@ -319,7 +628,7 @@ func (s *session) Send(serviceMethod string, body interface{}, rerr *Rerror, set
 	}()
 
 	output := socket.GetMessage(setting...)
-	output.SetSeq(atomic.AddInt32(&s.seq, 1))
+	output.SetSeq(s.nextSeq())
 
 	if output.BodyCodec() == codec.NilCodecID {
 		output.SetBodyCodec(s.peer.defaultBodyCodec)
@@ -370,12 +679,18 @@ func (s *session) Receive(newBodyFunc NewBodyFunc, setting ...MessageSetting) (i
 		socket.PutMessage(input)
 		return nil, rerr
 	}
+	if err := decompressMeta(input.Meta()); err != nil {
+		return input, rerrBadMessage.Copy().SetReason(err.Error())
+	}
+	s.readFeatures(input)
 	rerr = NewRerrorFromMeta(input.Meta())
 	return input, rerr
 }
 
 // AsyncCall sends a message and receives reply asynchronously.
 // NOTE:
+// If the context set by WithContext is done before the reply, the CALL fails with CodeCanceled
+// (or CodeHandleTimeout if the deadline is exceeded) at once, and the remote handler is canceled by CANCEL;
 // If the arg is []byte or *[]byte type, it can automatically fill in the body codec name;
 // If the session is a client role and PeerConfig.RedialTimes>0, it is automatically re-called once after a failure.
 func (s *session) AsyncCall(
@@ -385,6 +700,18 @@ func (s *session) AsyncCall(
 	callCmdChan chan<- CallCmd,
 	setting ...MessageSetting,
 ) CallCmd {
+	return s.asyncCall(serviceMethod, arg, result, callCmdChan, nil, setting...)
+}
+
+// asyncCall sends a message, and receives the reply, or the parts of the reply by the iterator if not nil.
+func (s *session) asyncCall(
+	serviceMethod string,
+	arg interface{},
+	result interface{},
+	callCmdChan chan<- CallCmd,
+	iter *ReplyIter,
+	setting ...MessageSetting,
+) *callCmd {
 	if callCmdChan == nil {
 		callCmdChan = make(chan CallCmd, 10) // buffered.
 	} else {
@@ -406,26 +733,48 @@ func (s *session) AsyncCall(
 			fn(output)
 		}
 	}
+	// the context set by WithContext, which aborts the CALL when done
+	userCtx := output.Context()
 
-	seq := atomic.AddInt32(&s.seq, 1)
+	seq := s.nextSeq()
 	output.SetSeq(seq)
+	s.setMessageID(output)
 
 	if output.BodyCodec() == codec.NilCodecID {
 		output.SetBodyCodec(s.peer.defaultBodyCodec)
 	}
+	if s.peer.rerrorCodec != RerrorCodecJSON {
+		output.Meta().Set(MetaAcceptRerrorCodec, strconv.FormatUint(uint64(s.peer.rerrorCodec), 10))
+	}
+	if iter != nil {
+		output.Meta().Set(MetaAcceptMultiReply, "1")
+	}
+	var (
+		callTimeout   time.Duration
+		timeoutCancel context.CancelFunc
+	)
+	if s.peer.callTimeout > 0 && output.Context() == context.Background() {
+		// no context is set by WithContext
+		callTimeout = s.peer.callTimeout
+		ctxTimout, cancel := context.WithTimeout(output.Context(), callTimeout)
+		socket.WithContext(ctxTimout)(output)
+		timeoutCancel = cancel
+	}
 	if age := s.ContextAge(); age > 0 {
 		ctxTimout, _ := context.WithTimeout(output.Context(), age)
 		socket.WithContext(ctxTimout)(output)
 	}
 
 	cmd := &callCmd{
-		sess:        s,
-		output:      output,
-		result:      result,
-		callCmdChan: callCmdChan,
-		doneChan:    make(chan struct{}),
-		start:       s.peer.timeNow(),
-		swap:        goutil.RwMap(),
+		sess:          s,
+		output:        output,
+		result:        result,
+		iter:          iter,
+		timeoutCancel: timeoutCancel,
+		callCmdChan:   callCmdChan,
+		doneChan:      make(chan struct{}),
+		start:         s.peer.timeNow(),
+		swap:          goutil.RwMap(),
 	}
 
 	// count call-launch
@@ -438,6 +787,12 @@ func (s *session) AsyncCall(
 		})
 	}
 
+	// NOTE: It may wait for the slots, so the call is not locked yet, e.g. by the disconnection.
+	if cmd.rerr = cmd.acquireInflight(); cmd.rerr != nil {
+		cmd.done()
+		return cmd
+	}
+
 	cmd.mu.Lock()
 	defer cmd.mu.Unlock()
 
@@ -464,6 +819,12 @@ W:
 		return cmd
 	}
 
+	if callTimeout > 0 {
+		cmd.timer = time.AfterFunc(callTimeout, cmd.expire)
+	}
+	if userCtx.Done() != nil {
+		go cmd.watchContext(userCtx)
+	}
 	s.peer.pluginContainer.postWriteCall(cmd)
 	return cmd
 }
@@ -471,10 +832,15 @@ W:
 // Call sends a message and receives reply.
 // NOTE:
 // If the arg is []byte or *[]byte type, it can automatically fill in the body codec name;
-// If the session is a client role and PeerConfig.RedialTimes>0, it is automatically re-called once after a failure.
+// If the session is a client role and PeerConfig.RedialTimes>0, it is automatically re-called once after a failure;
+// If PeerConfig.OverloadRetries>0, it is retried after the retry-after hint of the rejection.
 func (s *session) Call(serviceMethod string, arg interface{}, result interface{}, setting ...MessageSetting) CallCmd {
 	callCmd := s.AsyncCall(serviceMethod, arg, result, make(chan CallCmd, 1), setting...)
 	<-callCmd.Done()
+	for i := 0; i < s.peer.overloadRetries && waitRetryAfter(callCmd); i++ {
+		callCmd = s.AsyncCall(serviceMethod, arg, result, make(chan CallCmd, 1), setting...)
+		<-callCmd.Done()
+	}
 	return callCmd
 }
 
@@ -483,6 +849,10 @@ func (s *session) Call(serviceMethod string, arg interface{}, result interface{}
 // If the arg is []byte or *[]byte type, it can automatically fill in the body codec name;
 // If the session is a client role and PeerConfig.RedialTimes>0, it is automatically re-called once after a failure.
 func (s *session) Push(serviceMethod string, arg interface{}, setting ...MessageSetting) *Rerror {
+	return s.push(serviceMethod, arg, false, setting)
+}
+
+func (s *session) push(serviceMethod string, arg interface{}, unreliable bool, setting []MessageSetting) *Rerror {
 	ctx := s.peer.getContext(s, true)
 	ctx.start = s.peer.timeNow()
 	output := ctx.output
@@ -495,7 +865,8 @@ func (s *session) Push(serviceMethod string, arg interface{}, setting ...Message
 			fn(output)
 		}
 	}
-	output.SetSeq(atomic.AddInt32(&s.seq, 1))
+	output.SetSeq(s.nextSeq())
+	s.setMessageID(output)
 
 	if output.BodyCodec() == codec.NilCodecID {
 		output.SetBodyCodec(s.peer.defaultBodyCodec)
@@ -516,20 +887,77 @@ func (s *session) Push(serviceMethod string, arg interface{}, setting ...Message
 		return rerr
 	}
 
+	// NOTE: The write timeout starts after the plugins, and is not renewed by the redialing.
+	if timeout := s.peer.pushWriteTimeout; timeout > 0 {
+		ctxTimout, cancel := context.WithTimeout(output.Context(), timeout)
+		defer cancel()
+		socket.WithContext(ctxTimout)(output)
+	}
+
+	if unreliable {
+		if rerr = s.writeDatagram(output); rerr != nil {
+			return rerr
+		}
+		s.printAccessLog("", s.peer.timeSince(ctx.start), nil, output, typePushLaunch, false, nil)
+		s.peer.pluginContainer.postWritePush(ctx)
+		return nil
+	}
+
 	var usedConn net.Conn
 W:
 	if usedConn, rerr = s.write(output); rerr != nil {
 		if rerr == rerrConnClosed && s.redialForClient(usedConn) {
 			goto W
 		}
+		if deadline, ok := output.Context().Deadline(); ok && s.peer.pushWriteTimeout > 0 && !time.Now().Before(deadline) {
+			// the remote peer is not reading, e.g. black-holed, so evict the session without waiting,
+			// the in-flight replies can not be delivered either
+			Warnf("push write timeout, close the session: %s, serviceMethod: %s", s.ID(), serviceMethod)
+			s.close(CloseWriteError, rerr.ToError(), false)
+		}
 		return rerr
 	}
 
-	s.printAccessLog("", s.peer.timeSince(ctx.start), nil, output, typePushLaunch)
+	s.printAccessLog("", s.peer.timeSince(ctx.start), nil, output, typePushLaunch, false, nil)
 	s.peer.pluginContainer.postWritePush(ctx)
 	return nil
 }
 
+// Control sends a framework-internal control message, it is not routed to user handlers.
+// NOTE:
+// The mtype must be in the control range, see IsControlType;
+// The payload is carried by the metadata or the optional []byte body;
+// It fails with CodeMtypeNotAllowed until the remote peer is negotiated to accept it, see FeatureControl.
+func (s *session) Control(mtype byte, setting ...MessageSetting) *Rerror {
+	if !IsControlType(mtype) {
+		return rerrCodeMtypeNotAllowed.Copy().SetReason("not a control message type: " + TypeText(mtype))
+	}
+	if !s.acceptsControl() {
+		return rerrControlUnsupported
+	}
+	ctx := s.peer.getContext(s, true)
+	output := ctx.output
+	for _, fn := range setting {
+		if fn != nil {
+			fn(output)
+		}
+	}
+	output.SetMtype(mtype)
+	output.SetSeq(s.nextSeq())
+
+	defer func() {
+		if p := recover(); p != nil {
+			Errorf("panic:%v\n%s", p, goutil.PanicTrace(2))
+		}
+		s.peer.putContext(ctx, true)
+	}()
+	if _, rerr := s.write(output); rerr != nil {
+		return rerr
+	}
+	s.peer.pluginContainer.postWriteControl(ctx)
+	return nil
+}
+
 // Swap returns custom data swap of the session(socket).
 func (s *session) Swap() goutil.Map {
 	return s.socket.Swap()
@@ -601,11 +1029,115 @@ func (s *session) CloseNotify() <-chan struct{} {
 func (s *session) notifyClosed() {
 	if atomic.CompareAndSwapInt32(&s.didCloseNotify, 0, 1) {
 		close(s.closeNotifyCh)
+		s.cancelCtx()
+	}
+}
+
+// Context returns the context of the session, which is canceled when the session is closed
+// or the peer is going away.
+func (s *session) Context() context.Context {
+	return s.ctx
+}
+
+// hookContext returns the context of the session for the plugins, canceled after the timeout if >0.
+func (s *session) hookContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(s.ctx, timeout)
+	}
+	return context.WithCancel(s.ctx)
+}
+
+// Drain sends a GOAWAY control message with the reason, refuses new inbound CALLs
+// with the retryable CodeServiceUnavailable, waits for the in-flight work, and closes the session.
+// NOTE:
+// If timeout>0, the session is closed after the timeout even if the work is not finished;
+// The default reason is "draining".
+func (s *session) Drain(timeout time.Duration, reason ...string) error {
+	if !atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		return nil
+	}
+	why := defaultDrainReason
+	if len(reason) > 0 && reason[0] != "" {
+		why = reason[0]
+	}
+	Infof("draining session: %s, reason: %s", s.RemoteAddr().String(), why)
+	if rerr := s.Control(TypeGoaway, WithSetMeta(MetaGoawayReason, why)); rerr != nil {
+		Debugf("drain: send GOAWAY to %s: %s", s.RemoteAddr().String(), rerr.String())
+	}
+	done := make(chan struct{})
+	go func() {
+		s.graceCtxWaitGroup.Wait()
+		s.graceCallCmdWaitGroup.Wait()
+		close(done)
+	}()
+	if timeout <= 0 {
+		<-done
+		return s.close(CloseDrained, nil, true)
 	}
+	select {
+	case <-done:
+		return s.close(CloseDrained, nil, true)
+	case <-time.After(timeout):
+		Warnf("drain timeout: %s, closing with in-flight work", s.RemoteAddr().String())
+		return s.close(CloseDrained, errDrainTimeout, false)
+	}
+}
+
+// defaultDrainReason the reason carried by GOAWAY if not specified.
+const defaultDrainReason = "draining"
+
+func (s *session) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// graceCounter counts the in-flight work of the session, by an atomic counter and a condition.
+// NOTE: Unlike sync.WaitGroup, the work can be added while waiting, e.g. the inbound messages
+// read when draining, and Wait returns as soon as the counter reaches zero.
+type graceCounter struct {
+	n    int64
+	mu   sync.Mutex
+	zero *sync.Cond // created by the first Wait
+}
+
+// Add adds delta, which may be negative, to the counter.
+func (g *graceCounter) Add(delta int) {
+	n := atomic.AddInt64(&g.n, int64(delta))
+	if n < 0 {
+		panic("tp: negative graceCounter")
+	}
+	if n == 0 {
+		// the waiter is either before checking the counter, or waiting for the condition
+		g.mu.Lock()
+		if g.zero != nil {
+			g.zero.Broadcast()
+		}
+		g.mu.Unlock()
+	}
+}
+
+// Done decrements the counter by one.
+func (g *graceCounter) Done() {
+	g.Add(-1)
+}
+
+// Wait blocks until the counter is zero.
+func (g *graceCounter) Wait() {
+	g.mu.Lock()
+	for atomic.LoadInt64(&g.n) > 0 {
+		if g.zero == nil {
+			g.zero = sync.NewCond(&g.mu)
+		}
+		g.zero.Wait()
+	}
+	g.mu.Unlock()
 }
 
 // Close closes the session.
 func (s *session) Close() error {
+	return s.close(CloseActive, nil, true)
+}
+
+func (s *session) close(kind CloseKind, cause error, graceful bool) error {
 	s.lock.Lock()
 
 	s.statusLock.Lock()
@@ -616,12 +1148,16 @@ func (s *session) Close() error {
 		return nil
 	}
 	s.activelyClosing()
+	s.setCloseReasonLocked(kind, cause)
+	reason := s.closeReason
 	s.statusLock.Unlock()
 
 	s.peer.sessHub.Delete(s.ID())
 	s.notifyClosed()
-	s.graceCtxWaitGroup.Wait()
-	s.graceCallCmdWaitGroup.Wait()
+	if graceful {
+		s.graceCtxWaitGroup.Wait()
+		s.graceCallCmdWaitGroup.Wait()
+	}
 
 	s.statusLock.Lock()
 	// Notice actively closed
@@ -633,6 +1169,7 @@ func (s *session) Close() error {
 	err := s.socket.Close()
 	s.lock.Unlock()
 
+	s.peer.pluginContainer.sessionClosed(s, reason)
 	s.peer.pluginContainer.postDisconnect(s)
 	return err
 }
@@ -646,6 +1183,15 @@ func (s *session) readDisconnected(oldConn net.Conn, err error) {
 	}
 	// Notice passively closed
 	s.passivelyClosed()
+	if status != statusActiveClosing {
+		if bw, ok := s.brokenWrite.Load().(brokenWrite); ok && bw.conn == oldConn {
+			// the read error is caused by closing the connection after the writing failed
+			s.setCloseReasonLocked(CloseWriteError, bw.err)
+		} else {
+			s.setCloseReasonLocked(s.readCloseKind(err), err)
+		}
+	}
+	reason := s.closeReason
 	s.statusLock.Unlock()
 
 	s.peer.sessHub.Delete(s.ID())
@@ -666,11 +1212,13 @@ func (s *session) readDisconnected(oldConn net.Conn, err error) {
 		return true
 	})
 
+	// the SessionClosedPlugin plugins are executed by close
 	if status == statusActiveClosing {
 		return
 	}
 
 	s.socket.Close()
+	s.peer.pluginContainer.sessionClosed(s, reason)
 
 	if !s.redialForClient(oldConn) {
 		s.notifyClosed()
@@ -705,11 +1253,18 @@ func (s *session) startReadAndHandle() {
 	var (
 		err      error
 		usedConn = s.getConn()
+		hb       = s.startHeartbeat(usedConn)
 	)
+	if conn, ok := usedConn.(DatagramConn); ok {
+		go s.readDatagrams(conn)
+	}
 	defer func() {
 		if p := recover(); p != nil {
 			err = fmt.Errorf("panic:%v\n%s", p, goutil.PanicTrace(2))
 		}
+		if hb.stop() {
+			err = ErrHeartbeatTimeout
+		}
 		s.readDisconnected(usedConn, err)
 	}()
 
@@ -721,21 +1276,99 @@ func (s *session) startReadAndHandle() {
 			s.peer.putContext(ctx, false)
 			return
 		}
+		ctx.input.XferPipe().SetUnpackLimit(s.unpackLimit())
 		err = s.socket.ReadMessage(ctx.input)
+		if err == xfer.ErrUnpackTooLarge && s.goonRead() && s.rejectUnpackTooLarge(ctx.input) {
+			// the message is dropped, the connection is still usable
+			s.peer.putContext(ctx, false)
+			continue
+		}
 		if (err != nil && ctx.GetBodyCodec() == codec.NilCodecID) || !s.goonRead() {
 			s.peer.putContext(ctx, false)
 			return
 		}
+		if err == nil {
+			if s.handshake != nil {
+				s.handshake.done()
+				s.handshake = nil
+			}
+			s.touchRead()
+			s.readFeatures(ctx.input)
+			s.touchActive(ctx.input)
+			s.counters.countIn(ctx.input)
+			s.countUnpacked(ctx)
+			ctx.markPhase(&ctx.timeline.Unpacked)
+			if !ctx.isStaged() {
+				ctx.timeline.Decoded = ctx.timeline.Unpacked
+			}
+		}
 		if err != nil {
 			ctx.handleErr = rerrBadMessage.Copy().SetReason(err.Error())
+		} else if s.handleUpgradeMessage(ctx.input) {
+			s.peer.putContext(ctx, false)
+			continue
 		}
 		s.graceCtxWaitGroup.Add(1)
-		if !Go(func() {
-			defer s.peer.putContext(ctx, true)
-			ctx.handle()
-		}) {
-			s.peer.putContext(ctx, true)
+		if ctx.isStaged() {
+			if s.peer.preprocessor != nil {
+				s.peer.preprocessor.submit(ctx)
+				continue
+			}
+			ctx.decodeStagedBody()
 		}
+		s.peer.dispatch(ctx)
+	}
+}
+
+// unpackLimit returns the size upper limit of unpacking the next message,
+// that is the smaller of the message limit and the rest of the session budget.
+func (s *session) unpackLimit() int {
+	limit := s.peer.msgUnpackLimit
+	if s.peer.sessUnpackLimit > 0 {
+		rest := s.peer.sessUnpackLimit - int(atomic.LoadInt64(&s.unpackedBytes))
+		if rest < 1 {
+			// the budget is used up, only the empty bodies are allowed
+			rest = 1
+		}
+		if limit <= 0 || rest < limit {
+			limit = rest
+		}
+	}
+	return limit
+}
+
+// countUnpacked counts the unpacked message in the session budget until the context is put back.
+func (s *session) countUnpacked(ctx *handlerCtx) {
+	if s.peer.sessUnpackLimit <= 0 {
+		return
+	}
+	if n := ctx.input.XferPipe().UnpackedLen(); n > 0 {
+		ctx.unpackedLen = n
+		atomic.AddInt64(&s.unpackedBytes, int64(n))
+	}
+}
+
+// rejectUnpackTooLarge drops the message whose unpacked data exceeds the limit,
+// and replies CodeMessageTooLarge if it is a CALL.
+// It returns false if the header is unknown, e.g. the transfer filters wrap the header too,
+// then the session should be closed.
+func (s *session) rejectUnpackTooLarge(input Message) bool {
+	Warnf("unpack limit exceeded: %s, mtype: %s, serviceMethod: %s, seq: %d, limit: %d",
+		s.RemoteAddr().String(), TypeText(input.Mtype()), input.ServiceMethod(), input.Seq(), input.XferPipe().UnpackLimit())
+	switch input.Mtype() {
+	case TypeCall:
+		output := socket.GetMessage(socket.WithMtype(TypeReply))
+		defer socket.PutMessage(output)
+		output.SetSeq(input.Seq())
+		rerrMessageTooLarge.Copy().
+			SetReason(xfer.ErrUnpackTooLarge.Error()).
+			SetToMetaWithCodec(output.Meta(), s.RerrorCodec())
+		s.write(output)
+		return true
+	case TypePush:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -743,13 +1376,14 @@ func (s *session) write(message Message) (net.Conn, *Rerror) {
 	usedConn := s.getConn()
 	status := s.getStatus()
 	if status != statusOk &&
-		!(status == statusActiveClosing && message.Mtype() == TypeReply) {
+		!(status == statusActiveClosing && (message.Mtype() == TypeReply || IsControlType(message.Mtype()))) {
 		return usedConn, rerrConnClosed
 	}
 
 	var (
 		rerr        *Rerror
 		err         error
+		announced   bool
 		ctx         = message.Context()
 		deadline, _ = ctx.Deadline()
 	)
@@ -760,9 +1394,36 @@ func (s *session) write(message Message) (net.Conn, *Rerror) {
 	default:
 	}
 
-	s.writeLock.Lock()
+	if limit := s.WriteLimit(); limit > 0 {
+		socket.WithSizeLimit(limit)(message)
+	}
+
+	if err = s.writeLock.lockPriority(messagePriority(message)); err != nil {
+		goto ERR
+	}
 	defer s.writeLock.Unlock()
 
+	if !IsControlType(message.Mtype()) {
+		if err = s.waitWriteRate(ctx); err != nil {
+			goto ERR
+		}
+	}
+
+	// NOTE: The control messages are never compressed, since they are exchanged before the feature negotiation.
+	if !IsControlType(message.Mtype()) {
+		if orig := compressMeta(message.Meta(), s.peer.metaGzipThreshold); orig != nil {
+			defer restoreMeta(message.Meta(), orig)
+		}
+		if pipe := message.XferPipe(); pipe.Len() > 0 {
+			s.prepareCompress(pipe)
+			defer pipe.SetPackObserver(nil)
+		}
+	}
+
+	if announced = s.announceFeatures(message); announced {
+		defer message.Meta().Del(MetaFeatures)
+	}
+
 	select {
 	case <-ctx.Done():
 		err = ctx.Err()
@@ -773,6 +1434,17 @@ func (s *session) write(message Message) (net.Conn, *Rerror) {
 	}
 
 	if err == nil {
+		if announced {
+			atomic.StoreInt32(&s.featuresSent, 1)
+		}
+		s.counters.countOut(message)
+		s.touchActive(message)
+		if inspector := s.peer.inspector; inspector != nil {
+			inspector.InspectOutbound(s, message)
+		}
+		if s.byteLimiter != nil && !IsControlType(message.Mtype()) {
+			s.unchargedBytes = int64(message.Size())
+		}
 		return usedConn, nil
 	}
 
@@ -780,8 +1452,24 @@ func (s *session) write(message Message) (net.Conn, *Rerror) {
 		return usedConn, rerrConnClosed
 	}
 
+	if err == socket.ErrExceedMessageSizeLimit {
+		return usedConn, rerrMessageTooLarge.Copy().SetReason(err.Error())
+	}
+
 	Debugf("write error: %s", err.Error())
 
+	if werr, ok := err.(*socket.WriteError); ok && !werr.Temporary() {
+		if werr.Partial() {
+			// the frame is cut off, so the rest of the stream is corrupted
+			s.brokenWrite.Store(brokenWrite{conn: usedConn, err: err})
+			usedConn.Close()
+		}
+		if werr.Retryable() && s.redialForClientLocked != nil {
+			// the remote peer can not have handled the message, so it is written again after redialing
+			return usedConn, rerrConnClosed
+		}
+	}
+
 ERR:
 	rerr = rerrWriteFailed.Copy().SetReason(err.Error())
 	return usedConn, rerr
@@ -791,26 +1479,34 @@ ERR:
 type SessionHub struct {
 	// key: session id (ip, name and so on)
 	// value: *session
-	sessions goutil.Map
+	sessions  goutil.Map
+	labels    labelIndex
+	labelLock sync.RWMutex  // also guards the labels of the sessions
+	syncer    SessionSyncer // if nil, no synchronization
+	node      string
 }
 
 // newSessionHub creates a new sessions hub.
 func newSessionHub() *SessionHub {
 	chub := &SessionHub{
 		sessions: goutil.AtomicMap(),
+		labels:   make(labelIndex),
 	}
 	return chub
 }
 
 // Set sets a *session.
 func (sh *SessionHub) Set(sess *session) {
+	sh.indexLabels(sess)
+	sh.syncSession(sess)
 	_sess, loaded := sh.sessions.LoadOrStore(sess.ID(), sess)
 	if !loaded {
 		return
 	}
 	sh.sessions.Store(sess.ID(), sess)
 	if oldSess := _sess.(*session); sess != oldSess {
-		oldSess.Close()
+		sh.unindexLabels(oldSess)
+		oldSess.close(CloseReplaced, nil, true)
 	}
 }
 
@@ -850,7 +1546,17 @@ func (sh *SessionHub) Len() int {
 
 // Delete deletes the *session for a id.
 func (sh *SessionHub) Delete(id string) {
+	_sess, ok := sh.sessions.Load(id)
 	sh.sessions.Delete(id)
+	if !ok {
+		return
+	}
+	sh.removeSession(id)
+	// the session may be still there by the new id, see SetID
+	sess := _sess.(*session)
+	if cur, ok := sh.sessions.Load(sess.ID()); !ok || cur != sess {
+		sh.unindexLabels(sess)
+	}
 }
 
 const (
@@ -867,48 +1573,73 @@ const (
 	logFormatCallHandle = "CALL<- %s %s %q RECV(%s) SEND(%s)"
 )
 
-func (s *session) printAccessLog(realIP string, costTime time.Duration, input, output Message, logType int8) {
+func (s *session) printAccessLog(realIP string, costTime time.Duration, input, output Message, logType int8, failed bool, timeline *Timeline) {
 	if !EnableLoggerLevel(WARNING) {
 		return
 	}
-	var addr = s.RemoteAddr().String()
-	if realIP != "" && realIP == addr {
-		realIP = "same"
-	}
-	if realIP == "" {
-		realIP = "-"
+	var serviceMethod string
+	if logType == typePushLaunch || logType == typeCallLaunch {
+		serviceMethod = output.ServiceMethod()
+	} else {
+		serviceMethod = input.ServiceMethod()
 	}
-	addr += "(real:" + realIP + ")"
+	policy, ok := s.peer.logPolicies.get(serviceMethod)
 	var (
 		costTimeStr string
-		printFunc   = Infof
+		printFunc   = Warnf
+		tier        = logTierSampled
 	)
+	if ok {
+		tier = policy.tier(costTime, s.peer.slowCometDuration, s.peer.countTime, failed)
+	} else if s.peer.countTime && costTime >= s.peer.slowCometDuration {
+		tier = logTierSlow
+	}
 	if s.peer.countTime {
-		if costTime >= s.peer.slowCometDuration {
-			costTimeStr = costTime.String() + "(slow)"
-			printFunc = Warnf
-		} else {
-			if GetLoggerLevel() < INFO {
-				return
-			}
-			costTimeStr = costTime.String() + "(fast)"
-		}
-	} else {
+		costTimeStr = costTime.String()
+	}
+	switch tier {
+	case logTierNone:
+		return
+	case logTierFailed:
+		costTimeStr += "(fail)"
+	case logTierSlow:
+		costTimeStr += "(slow)"
+	case logTierSampled:
 		if GetLoggerLevel() < INFO {
 			return
 		}
-		costTimeStr = "(-)"
+		printFunc = Infof
+		if s.peer.countTime {
+			costTimeStr += "(fast)"
+		} else {
+			costTimeStr = "(-)"
+		}
+	}
+
+	if timeline != nil && s.peer.countTime {
+		if phases := timeline.String(); phases != "" {
+			costTimeStr += "[" + phases + "]"
+		}
 	}
 
+	var addr = s.RemoteAddr().String()
+	if realIP != "" && realIP == addr {
+		realIP = "same"
+	}
+	if realIP == "" {
+		realIP = "-"
+	}
+	addr += "(real:" + realIP + ")"
+
 	switch logType {
 	case typePushLaunch:
-		printFunc(logFormatPushLaunch, addr, costTimeStr, output.ServiceMethod(), messageLogBytes(output, s.peer.printDetail))
+		printFunc(logFormatPushLaunch, addr, costTimeStr, serviceMethod, messageLogBytes(output, policy.PrintDetail))
 	case typePushHandle:
-		printFunc(logFormatPushHandle, addr, costTimeStr, input.ServiceMethod(), messageLogBytes(input, s.peer.printDetail))
+		printFunc(logFormatPushHandle, addr, costTimeStr, serviceMethod, messageLogBytes(input, policy.PrintDetail))
 	case typeCallLaunch:
-		printFunc(logFormatCallLaunch, addr, costTimeStr, output.ServiceMethod(), messageLogBytes(output, s.peer.printDetail), messageLogBytes(input, s.peer.printDetail))
+		printFunc(logFormatCallLaunch, addr, costTimeStr, serviceMethod, messageLogBytes(output, policy.PrintDetail), messageLogBytes(input, policy.PrintDetail))
 	case typeCallHandle:
-		printFunc(logFormatCallHandle, addr, costTimeStr, input.ServiceMethod(), messageLogBytes(input, s.peer.printDetail), messageLogBytes(output, s.peer.printDetail))
+		printFunc(logFormatCallHandle, addr, costTimeStr, serviceMethod, messageLogBytes(input, policy.PrintDetail), messageLogBytes(output, policy.PrintDetail))
 	}
 }
 
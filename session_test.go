@@ -0,0 +1,381 @@
+package tp_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/codec"
+	"github.com/mylonly/teleport/socket"
+)
+
+type reverseCall struct {
+	tp.CallCtx
+}
+
+func (r *reverseCall) Echo(arg *string) (string, *tp.Rerror) {
+	if r.Seq()%2 != 0 {
+		return "", tp.NewRerror(400, "expect an even sequence from the server", "")
+	}
+	return *arg, nil
+}
+
+func (r *reverseCall) Reverse(arg *string) (string, *tp.Rerror) {
+	if r.Seq()%2 == 0 {
+		return "", tp.NewRerror(400, "expect an odd sequence from the client", "")
+	}
+	var result string
+	rerr := r.Session().Call("/reverse_call/echo", *arg, &result).Rerror()
+	return result, rerr
+}
+
+func TestReverseCall(t *testing.T) {
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.RouteCall(new(reverseCall))
+		cli.RouteCall(new(reverseCall))
+	})
+	defer peers.Close()
+
+	for i := 0; i < 3; i++ {
+		var result string
+		rerr := peers.sess.Call("/reverse_call/reverse", "hello", &result).Rerror()
+		if rerr != nil {
+			t.Fatalf("/reverse_call/reverse: %v", rerr)
+		}
+		if result != "hello" {
+			t.Fatalf("/reverse_call/reverse: expect hello, got %s", result)
+		}
+	}
+}
+
+func newIntBody(socket.Header) interface{} {
+	return new(int)
+}
+
+// TestSeqCompat the remote peer of an older version steps the sequences by 1,
+// so its CALLs may share the sequences with the CALLs launched by the server.
+func TestSeqCompat(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{})
+	defer srv.Close()
+	path := srv.RouteCallFunc(echo_call)
+	srvConn, oldConn := net.Pipe()
+	defer oldConn.Close()
+	sess, err := srv.ServeConn(srvConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := socket.NewSocket(oldConn)
+
+	// the server launches a CALL
+	called := make(chan int, 1)
+	go func() {
+		var result int
+		if rerr := sess.Call("/old/echo", 7, &result).Rerror(); rerr != nil {
+			t.Error(rerr)
+		}
+		called <- result
+	}()
+	call := socket.GetMessage(socket.WithNewBody(newIntBody))
+	if err = old.ReadMessage(call); err != nil {
+		t.Fatal(err)
+	}
+	if call.Mtype() != tp.TypeCall || call.Seq() != 2 {
+		t.Fatalf("expect the CALL of seq 2, got %s of seq %d", tp.TypeText(call.Mtype()), call.Seq())
+	}
+
+	// the old peer launches the CALLs of seq 1 and 2
+	for seq := int32(1); seq <= 2; seq++ {
+		m := socket.GetMessage(
+			socket.WithServiceMethod(path),
+			socket.WithBodyCodec(codec.ID_JSON),
+			socket.WithBody(int(seq)),
+		)
+		m.SetMtype(tp.TypeCall)
+		m.SetSeq(seq)
+		if err = old.WriteMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// and replies the CALL of the server with the same seq 2
+	reply := socket.GetMessage(
+		socket.WithServiceMethod(call.ServiceMethod()),
+		socket.WithBodyCodec(codec.ID_JSON),
+		socket.WithBody(*call.Body().(*int)),
+	)
+	reply.SetMtype(tp.TypeReply)
+	reply.SetSeq(call.Seq())
+	if err = old.WriteMessage(reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// the replies are matched with the CALLs launched by the same side only
+	for i := 0; i < 2; i++ {
+		m := socket.GetMessage(socket.WithNewBody(newIntBody))
+		if err = old.ReadMessage(m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Mtype() != tp.TypeReply || int32(*m.Body().(*int)) != m.Seq() {
+			t.Fatalf("expect the REPLY echoing seq %d, got %s: %v", m.Seq(), tp.TypeText(m.Mtype()), *m.Body().(*int))
+		}
+	}
+	if result := <-called; result != 7 {
+		t.Fatalf("expect 7, got %d", result)
+	}
+}
+
+// held returns the CALL handler which replies after the release is closed,
+// arg 0 is replied at once.
+func held(started chan<- struct{}, release <-chan struct{}) func(tp.CallCtx, *int) (int, *tp.Rerror) {
+	return func(_ tp.CallCtx, arg *int) (int, *tp.Rerror) {
+		if *arg == 0 {
+			return 0, nil
+		}
+		started <- struct{}{}
+		<-release
+		return *arg, nil
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var (
+		path    string
+		started = make(chan struct{}, 1)
+		release = make(chan struct{})
+		cliCtl  = make(controlRecorder, 16)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		cli.PluginContainer().AppendRight(cliCtl)
+		path = srv.RouteCallFunc(held(started, release))
+	})
+	defer peers.Close()
+	sess := peers.sess
+
+	// negotiate the control messages by the first messages
+	var arg = 0
+	if rerr := sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	var result int
+	callCmd := sess.AsyncCall(path, 500, &result, make(chan tp.CallCmd, 1))
+	<-started
+
+	s, ok := peers.srv.GetSession(sess.ID())
+	if !ok {
+		t.Fatal("session not found")
+	}
+	drained := make(chan error, 1)
+	go func() { drained <- s.Drain(0) }()
+	if c := cliCtl.wait(t, tp.TypeGoaway); c.reason != "draining" {
+		t.Fatalf("expect the default reason draining, got %q", c.reason)
+	}
+	rerr := sess.Call(path, &arg, new(int)).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeServiceUnavailable {
+		t.Fatalf("call while draining: expect CodeServiceUnavailable, got %v", rerr)
+	}
+
+	// waits for the in-flight CALL
+	close(release)
+	<-callCmd.Done()
+	if rerr = callCmd.Rerror(); rerr != nil || result != 500 {
+		t.Fatalf("in-flight call: expect 500, got %d, %v", result, rerr)
+	}
+	if err := <-drained; err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if reason := s.CloseReason(); reason.Kind != tp.CloseDrained || reason.Err != nil {
+		t.Fatalf("expect %q, got %q", tp.CloseDrained, reason.String())
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	var (
+		path    string
+		started = make(chan struct{}, 1)
+		release = make(chan struct{})
+		cliCtl  = make(controlRecorder, 16)
+	)
+	defer close(release)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		cli.PluginContainer().AppendRight(cliCtl)
+		path = srv.RouteCallFunc(held(started, release))
+	})
+	defer peers.Close()
+	sess := peers.sess
+
+	var arg = 0
+	if rerr := sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	callCmd := sess.AsyncCall(path, 1, new(int), make(chan tp.CallCmd, 1))
+	<-started
+
+	s, ok := peers.srv.GetSession(sess.ID())
+	if !ok {
+		t.Fatal("session not found")
+	}
+	drained := make(chan error, 1)
+	go func() { drained <- s.Drain(50*time.Millisecond, "maintenance") }()
+	if c := cliCtl.wait(t, tp.TypeGoaway); c.reason != "maintenance" {
+		t.Fatalf("expect the reason maintenance, got %q", c.reason)
+	}
+	// closed with the in-flight CALL
+	<-drained
+	<-callCmd.Done()
+	if rerr := callCmd.Rerror(); rerr == nil {
+		t.Fatal("expect the in-flight call failed")
+	}
+	if reason := s.CloseReason(); reason.Kind != tp.CloseDrained || reason.Err == nil {
+		t.Fatalf("expect %q with the drain timeout, got %q", tp.CloseDrained, reason.String())
+	}
+}
+
+func TestDrainWhileReading(t *testing.T) {
+	var (
+		path   string
+		pushed = make(chan struct{}, 1)
+	)
+	peers := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.RoutePushFunc(func(ctx tp.PushCtx, arg *int) *tp.Rerror {
+			select {
+			case pushed <- struct{}{}:
+			default:
+			}
+			return nil
+		})
+	})
+	defer peers.Close()
+	sess := peers.sess
+
+	// the inbound messages keep being read while draining
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if sess.Push(path, &i) != nil {
+				return
+			}
+		}
+	}()
+	<-pushed
+	s, ok := peers.srv.GetSession(sess.ID())
+	if !ok {
+		t.Fatal("session not found")
+	}
+	drained := make(chan error, 1)
+	go func() { drained <- s.Drain(0) }()
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("drain: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the drain")
+	}
+}
+
+// acceptedPlugin reports the sessions accepted by the server.
+type acceptedPlugin chan tp.Session
+
+func (acceptedPlugin) Name() string {
+	return "accepted"
+}
+
+func (a acceptedPlugin) PostAccept(sess tp.PreSession) *tp.Rerror {
+	a <- sess.(tp.Session)
+	return nil
+}
+
+func TestPushWriteTimeout(t *testing.T) {
+	accepted, closed := make(acceptedPlugin, 1), make(closeReasonPlugin, 1)
+	srv := tp.NewPeer(tp.PeerConfig{
+		LocalIP:                 "127.0.0.1",
+		DefaultPushWriteTimeout: 200 * time.Millisecond,
+		SocketWriteBuffer:       4096,
+	}, accepted, closed)
+	defer srv.Close()
+	addr := listenAndServe(t, srv)
+
+	// the client never reads
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.(*net.TCPConn).SetReadBuffer(4096)
+	sess := <-accepted
+
+	arg := make([]byte, 64<<10)
+	var rerr *tp.Rerror
+	start := time.Now()
+	for i := 0; i < 1000 && rerr == nil; i++ {
+		rerr = sess.Push("/black/hole", arg)
+	}
+	if rerr == nil {
+		t.Fatal("expect the push write timeout")
+	}
+	if cost := time.Since(start); cost > 3*time.Second {
+		t.Fatalf("expect failing fast, cost %v", cost)
+	}
+	if reason := closed.wait(t); reason.Kind != tp.CloseWriteError {
+		t.Fatalf("expect %q, got %q", tp.CloseWriteError, reason.String())
+	}
+	if n := srv.CountSession(); n != 0 {
+		t.Fatalf("expect the session evicted, got %d sessions", n)
+	}
+}
+
+type clientCertPlugin struct {
+	certs chan []byte
+}
+
+func (clientCertPlugin) Name() string {
+	return "client_cert"
+}
+
+func (c clientCertPlugin) PostAccept(sess tp.PreSession) *tp.Rerror {
+	certs := sess.PeerCertificates()
+	if len(certs) == 0 {
+		return tp.NewRerror(tp.CodeUnauthorized, tp.CodeText(tp.CodeUnauthorized), "missing client certificate")
+	}
+	c.certs <- certs[0].Raw
+	return nil
+}
+
+func TestPeerCertificates(t *testing.T) {
+	plugin := clientCertPlugin{certs: make(chan []byte, 1)}
+	srvTLSConfig := tp.GenerateTLSConfigForServer()
+	srvTLSConfig.ClientAuth = tls.RequireAnyClientCert
+	cliTLSConfig := tp.GenerateTLSConfigForServer()
+	cliTLSConfig.InsecureSkipVerify = true
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		srv.PluginContainer().AppendRight(plugin)
+		srv.SetTLSConfig(srvTLSConfig)
+		srv.RouteCallFunc(echo_call)
+		cli.SetTLSConfig(cliTLSConfig)
+	})
+	defer p.Close()
+	var arg, result = 10, 0
+	if rerr := p.sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	select {
+	case raw := <-plugin.certs:
+		if !bytes.Equal(raw, cliTLSConfig.Certificates[0].Certificate[0]) {
+			t.Fatal("expect the client certificate on the server session")
+		}
+	default:
+		t.Fatal("expect the client certificate in PostAccept")
+	}
+	certs := p.sess.PeerCertificates()
+	if len(certs) == 0 || !bytes.Equal(certs[0].Raw, srvTLSConfig.Certificates[0].Certificate[0]) {
+		t.Fatal("expect the server certificate on the client session")
+	}
+}
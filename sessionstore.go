@@ -0,0 +1,155 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync"
+	"time"
+)
+
+// SessionStore the typed in-memory key-value store of a session,
+// whose values are deleted automatically after the TTL, e.g. the auth tokens.
+type SessionStore struct {
+	m  map[string]*storeEntry
+	mu sync.RWMutex
+}
+
+type storeEntry struct {
+	value interface{}
+	timer *time.Timer
+}
+
+func newSessionStore() *SessionStore {
+	return new(SessionStore)
+}
+
+// Set sets the value of the key.
+// NOTE: If ttl<=0, the key never expires.
+func (s *SessionStore) Set(key string, value interface{}, ttl time.Duration) {
+	e := &storeEntry{value: value}
+	s.mu.Lock()
+	if s.m == nil {
+		s.m = make(map[string]*storeEntry)
+	} else if old, ok := s.m[key]; ok && old.timer != nil {
+		old.timer.Stop()
+	}
+	s.m[key] = e
+	if ttl > 0 {
+		e.timer = time.AfterFunc(ttl, func() {
+			s.mu.Lock()
+			if s.m[key] == e {
+				delete(s.m, key)
+			}
+			s.mu.Unlock()
+		})
+	}
+	s.mu.Unlock()
+}
+
+// Get returns the value of the key.
+func (s *SessionStore) Get(key string) (value interface{}, ok bool) {
+	s.mu.RLock()
+	e, ok := s.m[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// GetString returns the string value of the key.
+// NOTE: If the key does not exist or the value is not string, ok=false.
+func (s *SessionStore) GetString(key string) (value string, ok bool) {
+	v, _ := s.Get(key)
+	value, ok = v.(string)
+	return
+}
+
+// GetInt returns the int value of the key, converted from any integer type.
+// NOTE: If the key does not exist or the value is not integer, ok=false.
+func (s *SessionStore) GetInt(key string) (int, bool) {
+	i, ok := s.GetInt64(key)
+	return int(i), ok
+}
+
+// GetInt64 returns the int64 value of the key, converted from any integer type.
+// NOTE: If the key does not exist or the value is not integer, ok=false.
+func (s *SessionStore) GetInt64(key string) (int64, bool) {
+	v, _ := s.Get(key)
+	switch i := v.(type) {
+	case int:
+		return int64(i), true
+	case int8:
+		return int64(i), true
+	case int16:
+		return int64(i), true
+	case int32:
+		return int64(i), true
+	case int64:
+		return i, true
+	case uint:
+		return int64(i), true
+	case uint8:
+		return int64(i), true
+	case uint16:
+		return int64(i), true
+	case uint32:
+		return int64(i), true
+	case uint64:
+		return int64(i), true
+	}
+	return 0, false
+}
+
+// GetBool returns the bool value of the key.
+// NOTE: If the key does not exist or the value is not bool, ok=false.
+func (s *SessionStore) GetBool(key string) (value bool, ok bool) {
+	v, _ := s.Get(key)
+	value, ok = v.(bool)
+	return
+}
+
+// GetBytes returns the []byte value of the key.
+// NOTE: If the key does not exist or the value is not []byte, ok=false.
+func (s *SessionStore) GetBytes(key string) (value []byte, ok bool) {
+	v, _ := s.Get(key)
+	value, ok = v.([]byte)
+	return
+}
+
+// Delete deletes the key.
+func (s *SessionStore) Delete(key string) {
+	s.mu.Lock()
+	if e, ok := s.m[key]; ok {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		delete(s.m, key)
+	}
+	s.mu.Unlock()
+}
+
+// Len returns the number of the keys.
+func (s *SessionStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.m)
+}
+
+// Store returns the typed key-value store of the session, whose values expire after the TTL.
+// NOTE: It lives in memory with the session, unlike the persistent Peer.Store.
+func (s *session) Store() *SessionStore {
+	return s.store
+}
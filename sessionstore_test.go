@@ -0,0 +1,34 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestSessionStore(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, nil)
+	defer p.Close()
+	store := p.sess.Store()
+	store.Set("token", "abc", ttl)
+	store.Set("uid", int64(42), 0)
+	if v, ok := store.GetString("token"); !ok || v != "abc" {
+		t.Fatalf("expect token abc, got %q, %v", v, ok)
+	}
+	if v, ok := store.GetInt("uid"); !ok || v != 42 {
+		t.Fatalf("expect uid 42, got %d, %v", v, ok)
+	}
+	if _, ok := store.GetString("uid"); ok {
+		t.Fatal("expect the mismatched type")
+	}
+	time.Sleep(2 * ttl)
+	if _, ok := store.Get("token"); ok {
+		t.Fatal("expect the token expired")
+	}
+	store.Delete("uid")
+	if n := store.Len(); n != 0 {
+		t.Fatalf("expect empty store, got %d keys", n)
+	}
+}
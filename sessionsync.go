@@ -0,0 +1,143 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mylonly/teleport/store"
+)
+
+// SessionRecord the exported state of a session, e.g. for the external store shared by the cluster.
+type SessionRecord struct {
+	// Node the name of the peer which holds the session, see Peer.SetSessionSyncer
+	Node string `json:"node"`
+	// ID the session ID, usually set to the identity of the remote user by Session.SetID
+	ID string `json:"id"`
+	// RemoteAddr the remote address of the session
+	RemoteAddr string `json:"remote_addr"`
+	// Labels the labels of the session, see Session.SetLabel
+	Labels map[string]string `json:"labels,omitempty"`
+	// Swap the string values of the swap of the session
+	Swap map[string]string `json:"swap,omitempty"`
+	// Updated the time when the record is exported
+	Updated time.Time `json:"updated"`
+}
+
+// SessionSyncer receives the changes of the state of the sessions,
+// e.g. to synchronize them to Redis.
+// NOTE: The methods are called synchronously, in the order of the changes of each session.
+type SessionSyncer interface {
+	// SyncSession is called when the session is connected, or its ID or labels change.
+	// NOTE: The swap is exported along with them.
+	SyncSession(rec *SessionRecord) error
+	// RemoveSession is called when the session is closed, or after its ID changes;
+	// only the Node and ID of the record are set.
+	RemoveSession(rec *SessionRecord) error
+}
+
+// SetSessionSyncer sets the syncer of the state of the sessions,
+// and the node name of the peer which is exported with the sessions.
+// NOTE: It should be called before dialing or serving.
+func (p *peer) SetSessionSyncer(node string, syncer SessionSyncer) {
+	p.sessHub.node = node
+	p.sessHub.syncer = syncer
+}
+
+// syncSession exports the session to the syncer, if any.
+func (sh *SessionHub) syncSession(sess *session) {
+	if sh.syncer == nil {
+		return
+	}
+	rec := &SessionRecord{
+		Node:       sh.node,
+		ID:         sess.ID(),
+		RemoteAddr: sess.RemoteAddr().String(),
+		Labels:     sess.Labels(),
+		Updated:    time.Now(),
+	}
+	sess.Swap().Range(func(key, value interface{}) bool {
+		k, ok1 := key.(string)
+		v, ok2 := value.(string)
+		if ok1 && ok2 {
+			if rec.Swap == nil {
+				rec.Swap = make(map[string]string)
+			}
+			rec.Swap[k] = v
+		}
+		return true
+	})
+	if err := sh.syncer.SyncSession(rec); err != nil {
+		Warnf("sync session %s: %s", rec.ID, err.Error())
+	}
+}
+
+// removeSession removes the session from the syncer, if any.
+func (sh *SessionHub) removeSession(id string) {
+	if sh.syncer == nil {
+		return
+	}
+	if err := sh.syncer.RemoveSession(&SessionRecord{Node: sh.node, ID: id}); err != nil {
+		Warnf("remove session %s: %s", id, err.Error())
+	}
+}
+
+// SessionRegistry the SessionSyncer which saves the records of the sessions in the store shared by the cluster,
+// e.g. a driver registered by store.Reg, keyed by the session ID, to find on which node the session is.
+type SessionRegistry struct {
+	store store.Store
+}
+
+var _ SessionSyncer = new(SessionRegistry)
+
+// NewSessionRegistry creates the registry of the sessions in the store.
+func NewSessionRegistry(st store.Store) *SessionRegistry {
+	return &SessionRegistry{store: st}
+}
+
+const sessionRecordKeyPrefix = "tp/session/"
+
+// SyncSession saves the record of the session.
+func (r *SessionRegistry) SyncSession(rec *SessionRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return r.store.Set(sessionRecordKeyPrefix+rec.ID, b, 0)
+}
+
+// RemoveSession deletes the record of the session, unless it is saved by another node.
+func (r *SessionRegistry) RemoveSession(rec *SessionRecord) error {
+	cur, ok, err := r.Lookup(rec.ID)
+	if err != nil || !ok || cur.Node != rec.Node {
+		return err
+	}
+	return r.store.Delete(sessionRecordKeyPrefix + rec.ID)
+}
+
+// Lookup returns the record of the session by the ID across the cluster,
+// e.g. whether the user is online anywhere and on which node.
+func (r *SessionRegistry) Lookup(id string) (rec *SessionRecord, ok bool, err error) {
+	b, ok, err := r.store.Get(sessionRecordKeyPrefix + id)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	rec = new(SessionRecord)
+	if err = json.Unmarshal(b, rec); err != nil {
+		return nil, false, err
+	}
+	return rec, true, nil
+}
@@ -0,0 +1,51 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/store"
+)
+
+func TestSessionSyncer(t *testing.T) {
+	registry := tp.NewSessionRegistry(store.NewMemoryStore())
+	p1 := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.SetSessionSyncer("node1", registry)
+	})
+	defer p1.Close()
+	closed := make(closeReasonPlugin, 1)
+	p2 := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.SetSessionSyncer("node2", registry)
+		srv.RouteCallFunc(echo_call)
+		srv.PluginContainer().AppendRight(closed)
+	})
+	defer p2.Close()
+	// the session is served after the round trip
+	var arg, result = 1, 0
+	if rerr := p2.sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	var srvSess tp.Session
+	p2.srv.RangeSession(func(s tp.Session) bool {
+		srvSess = s
+		return false
+	})
+	srvSess.Swap().Store("device", "ios")
+	srvSess.SetID("user-1")
+	srvSess.SetLabel("room", "lobby")
+
+	rec, ok, err := registry.Lookup("user-1")
+	if err != nil || !ok {
+		t.Fatalf("expect user-1 online, got %v, %v", ok, err)
+	}
+	if rec.Node != "node2" || rec.Labels["room"] != "lobby" || rec.Swap["device"] != "ios" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+
+	// the record is removed before the session closed plugins
+	p2.sess.Close()
+	closed.wait(t)
+	if _, ok, _ = registry.Lookup("user-1"); ok {
+		t.Fatal("expect user-1 offline")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SessionStats the statistics of a session.
+type SessionStats struct {
+	// BytesIn the total size of the messages read
+	BytesIn uint64
+	// BytesOut the total size of the messages written
+	BytesOut uint64
+	// MsgsIn the number of the messages read, by the message type text, e.g. CALL
+	MsgsIn map[string]uint64
+	// MsgsOut the number of the messages written, by the message type text, e.g. REPLY
+	MsgsOut map[string]uint64
+	// LastActivity the time of the last message read or written
+	LastActivity time.Time
+	// Handled the number of the CALLs and PUSHes handled
+	Handled uint64
+	// HandlerLatency the moving average of the cost of the handlers, including the waiting in the queue;
+	// only counted if PeerConfig.CountTime is true
+	HandlerLatency time.Duration
+	// WriteQueueLen the number of the messages waiting for writing, except the control messages
+	WriteQueueLen int
+	// WriteDropped the number of the messages refused by the policy of the full write queue
+	WriteDropped uint64
+	// CompressIn the total size of the data before the compression filters of the messages written
+	CompressIn uint64
+	// CompressOut the total size of the data after the compression filters of the messages written
+	CompressOut uint64
+	// CompressRatio CompressOut/CompressIn, 0 if nothing is compressed
+	CompressRatio float64
+	// CompressTime the total time spent by the compression filters of the messages written
+	CompressTime time.Duration
+	// CompressSkipped the number of the messages written uncompressed while the compression is disabled
+	CompressSkipped uint64
+	// CompressDisabledUntil the end of the cooldown during which the compression is disabled,
+	// zero if enabled; see PeerConfig.CompressCooldown
+	CompressDisabledUntil time.Time
+}
+
+// msgTypeSlots the counter slots of the message types:
+// [0,8) for the data types, [8,24) for the control types,
+// 24 for the other data types, and 25 for the other control types.
+const msgTypeSlots = 26
+
+func msgTypeSlot(mtype byte) int {
+	switch {
+	case mtype < 8:
+		return int(mtype)
+	case mtype < TypeControlMin:
+		return 24
+	case mtype < TypeControlMin+16:
+		return 8 + int(mtype-TypeControlMin)
+	default:
+		return 25
+	}
+}
+
+// slotMsgType returns a message type of the slot, whose TypeText is the label of the slot.
+func slotMsgType(slot int) byte {
+	switch {
+	case slot < 8:
+		return byte(slot)
+	case slot < 24:
+		return TypeControlMin + byte(slot-8)
+	case slot == 24:
+		return 8
+	default:
+		return TypeControlMin + 16
+	}
+}
+
+// sessionCounters the counters of a session, updated atomically in the read and write paths.
+// NOTE: It is allocated separately to keep the 64-bit words aligned.
+type sessionCounters struct {
+	bytesIn   uint64
+	bytesOut  uint64
+	msgsIn    [msgTypeSlots]uint64
+	msgsOut   [msgTypeSlots]uint64
+	handled   uint64
+	timed     uint64 // the number of the handled messages whose cost is counted
+	latency   int64  // nanoseconds
+	lastWrite int64  // the unix nano time
+
+	compressIn       uint64
+	compressOut      uint64
+	compressCost     int64 // nanoseconds
+	compressSkipped  uint64
+	compressPoor     int64 // the number of the consecutive incompressible messages
+	compressOffUntil int64 // the unix nano time, 0 if the compression is enabled
+}
+
+func (c *sessionCounters) countIn(msg Message) {
+	atomic.AddUint64(&c.bytesIn, uint64(msg.Size()))
+	atomic.AddUint64(&c.msgsIn[msgTypeSlot(msg.Mtype())], 1)
+}
+
+func (c *sessionCounters) countOut(msg Message) {
+	atomic.AddUint64(&c.bytesOut, uint64(msg.Size()))
+	atomic.AddUint64(&c.msgsOut[msgTypeSlot(msg.Mtype())], 1)
+	atomic.StoreInt64(&c.lastWrite, time.Now().UnixNano())
+}
+
+// countHandled counts the handled message, and updates the exponentially weighted moving average
+// of the handler cost with the weight 1/8, if the cost is counted.
+func (c *sessionCounters) countHandled(cost time.Duration, counted bool) {
+	atomic.AddUint64(&c.handled, 1)
+	if !counted {
+		return
+	}
+	if atomic.AddUint64(&c.timed, 1) == 1 {
+		atomic.StoreInt64(&c.latency, int64(cost))
+		return
+	}
+	for {
+		old := atomic.LoadInt64(&c.latency)
+		if atomic.CompareAndSwapInt64(&c.latency, old, old+(int64(cost)-old)/8) {
+			return
+		}
+	}
+}
+
+func loadMsgCounts(counts *[msgTypeSlots]uint64) map[string]uint64 {
+	m := make(map[string]uint64)
+	for slot := range counts {
+		if n := atomic.LoadUint64(&counts[slot]); n > 0 {
+			m[TypeText(slotMsgType(slot))] += n
+		}
+	}
+	return m
+}
+
+// Stats returns the statistics of the session.
+func (s *session) Stats() SessionStats {
+	c := s.counters
+	last := atomic.LoadInt64(&s.lastRead)
+	if w := atomic.LoadInt64(&c.lastWrite); w > last {
+		last = w
+	}
+	stats := SessionStats{
+		BytesIn:        atomic.LoadUint64(&c.bytesIn),
+		BytesOut:       atomic.LoadUint64(&c.bytesOut),
+		MsgsIn:         loadMsgCounts(&c.msgsIn),
+		MsgsOut:        loadMsgCounts(&c.msgsOut),
+		Handled:        atomic.LoadUint64(&c.handled),
+		HandlerLatency: time.Duration(atomic.LoadInt64(&c.latency)),
+		WriteQueueLen:  s.writeQueueLen(),
+		WriteDropped:   atomic.LoadUint64(&s.writeLock.queue.dropped),
+	}
+	if last > 0 {
+		stats.LastActivity = time.Unix(0, last)
+	}
+	s.compressStats(&stats)
+	return stats
+}
@@ -0,0 +1,43 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestSessionStats(t *testing.T) {
+	accepted, closed := make(acceptedPlugin, 1), make(closeReasonPlugin, 1)
+	p := newMemPeers(t, tp.PeerConfig{CountTime: true}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(echo_call)
+		srv.RoutePushFunc(notice_push)
+		srv.PluginContainer().AppendRight(accepted, closed)
+	})
+	defer p.Close()
+	var arg, result = 20, 0
+	for i := 0; i < 3; i++ {
+		if rerr := p.sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	if rerr := p.sess.Push("/notice/push", "stats"); rerr != nil {
+		t.Fatal(rerr)
+	}
+
+	stats := p.sess.Stats()
+	if stats.MsgsOut["CALL"] != 3 || stats.MsgsOut["PUSH"] != 1 || stats.MsgsIn["REPLY"] != 3 {
+		t.Fatalf("unexpected message counts: out %v, in %v", stats.MsgsOut, stats.MsgsIn)
+	}
+	if stats.BytesOut == 0 || stats.BytesIn == 0 || time.Since(stats.LastActivity) > time.Second {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	// the server session is closed after the PUSH is handled
+	srvSess := <-accepted
+	p.sess.Close()
+	closed.wait(t)
+	stats = srvSess.Stats()
+	if stats.Handled != 4 || stats.HandlerLatency <= 0 || stats.MsgsOut["REPLY"] != 3 {
+		t.Fatalf("unexpected server stats: %+v", stats)
+	}
+}
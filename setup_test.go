@@ -0,0 +1,105 @@
+package tp_test
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// memPort the last port of the in-process network used by the tests.
+var memPort int32 = 20000
+
+// memPeers a server peer and the session dialed to it by a client peer.
+type memPeers struct {
+	srv  tp.Peer
+	cli  tp.Peer
+	sess tp.Session
+	addr string // the address dialed by the client peer
+}
+
+// newMemPeers starts a server peer on the in-process network and dials it from a client peer.
+// The routes and the plugins are added by setup, if not nil, before serving and dialing.
+// NOTE:
+//  The network and the listen port of the configs are overridden;
+//  Dialing waits for the listener, so no sleep is needed;
+//  The caller should close the peers by Close.
+func newMemPeers(t testing.TB, srvCfg, cliCfg tp.PeerConfig, setup func(srv, cli tp.Peer), protoFunc ...tp.ProtoFunc) *memPeers {
+	port := atomic.AddInt32(&memPort, 1)
+	srvCfg.Network = "mem"
+	srvCfg.ListenPort = uint16(port)
+	cliCfg.Network = "mem"
+	p := &memPeers{
+		srv:  tp.NewPeer(srvCfg),
+		cli:  tp.NewPeer(cliCfg),
+		addr: ":" + strconv.Itoa(int(port)),
+	}
+	if setup != nil {
+		setup(p.srv, p.cli)
+	}
+	go p.srv.ListenAndServe(protoFunc...)
+	var rerr *tp.Rerror
+	p.sess, rerr = p.cli.Dial(p.addr, protoFunc...)
+	if rerr != nil {
+		p.Close()
+		t.Fatal(rerr)
+	}
+	return p
+}
+
+// Close closes the client and the server peers.
+func (p *memPeers) Close() {
+	p.cli.Close()
+	p.srv.Close()
+}
+
+// blockingCall returns the CALL handler which sends its arg to entered, blocks until release is closed,
+// and replies the arg, so that the tests control the in-flight CALLs without sleeping.
+func blockingCall(entered chan<- int, release <-chan struct{}) func(tp.CallCtx, *int) (int, *tp.Rerror) {
+	return func(_ tp.CallCtx, arg *int) (int, *tp.Rerror) {
+		entered <- *arg
+		<-release
+		return *arg, nil
+	}
+}
+
+// echo_call replies the arg.
+func echo_call(_ tp.CallCtx, arg *int) (int, *tp.Rerror) {
+	return *arg, nil
+}
+
+// notice_push drops the pushed arg.
+func notice_push(_ tp.PushCtx, _ *string) *tp.Rerror {
+	return nil
+}
+
+// listened the plugin which reports the addresses listened by the peer.
+type listened chan net.Addr
+
+func (listened) Name() string {
+	return "listened"
+}
+
+func (l listened) PostListen(addr net.Addr) error {
+	l <- addr
+	return nil
+}
+
+// listenAndServe serves the peer in the background for the tests of the real networks,
+// and returns the address of its first listener, e.g. listening on 127.0.0.1:0.
+// NOTE: It waits for the listener, so no sleep is needed.
+func listenAndServe(t testing.TB, peer tp.Peer, protoFunc ...tp.ProtoFunc) string {
+	l := make(listened, 8)
+	peer.PluginContainer().AppendRight(l)
+	go peer.ListenAndServe(protoFunc...)
+	select {
+	case addr := <-l:
+		return addr.String()
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the listener")
+		return ""
+	}
+}
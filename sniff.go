@@ -0,0 +1,87 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/mylonly/teleport/codec"
+)
+
+// sniffBodyCodec detects the codec of the staged raw body if the body codec is unknown,
+// e.g. 0 set by the older clients.
+func (c *handlerCtx) sniffBodyCodec() {
+	if len(c.rawBody) == 0 {
+		return
+	}
+	if _, err := codec.Get(c.input.BodyCodec()); err == nil {
+		return
+	}
+	if id, ok := sniffCodec(c.rawBody); ok {
+		c.Debugf("sniffed body codec %q of the unknown %d: %s", id, c.input.BodyCodec(), c.input.ServiceMethod())
+		c.input.SetBodyCodec(id)
+	}
+}
+
+// sniffCodec detects the registered codec of the encoded data, JSON first and then protobuf.
+func sniffCodec(data []byte) (byte, bool) {
+	if json.Valid(data) {
+		if _, err := codec.Get(codec.ID_JSON); err == nil {
+			return codec.ID_JSON, true
+		}
+	}
+	if isProtobufWire(data) {
+		if _, err := codec.Get(codec.ID_PROTOBUF); err == nil {
+			return codec.ID_PROTOBUF, true
+		}
+	}
+	return codec.NilCodecID, false
+}
+
+// isProtobufWire returns whether the data is a sequence of the well-formed protobuf fields.
+// NOTE: The deprecated groups are not supported.
+func isProtobufWire(data []byte) bool {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 == 0 {
+			return false
+		}
+		data = data[n:]
+		switch key & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return false
+			}
+		case 1: // fixed64
+			n = 8
+		case 2: // length-delimited
+			var size uint64
+			if size, n = binary.Uvarint(data); n <= 0 || size > uint64(len(data)-n) {
+				return false
+			}
+			n += int(size)
+		case 5: // fixed32
+			n = 4
+		default:
+			return false
+		}
+		if n > len(data) {
+			return false
+		}
+		data = data[n:]
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package tp_test
+
+import (
+	"net"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/codec"
+	"github.com/mylonly/teleport/socket"
+)
+
+func TestSniffBodyCodec(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{LocalIP: "127.0.0.1", SniffBodyCodec: true})
+	defer srv.Close()
+	srv.RouteCallFunc(echo_call)
+	addr := listenAndServe(t, srv)
+
+	// an older client which never sets the body codec
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := socket.NewSocket(conn)
+	defer sock.Close()
+	output := socket.NewMessage(
+		socket.WithMtype(tp.TypeCall),
+		socket.WithServiceMethod("/echo/call"),
+		socket.WithBody([]byte("12")),
+	)
+	output.SetSeq(1)
+	if err = sock.WriteMessage(output); err != nil {
+		t.Fatal(err)
+	}
+	var result int
+	input := socket.NewMessage(socket.WithNewBody(func(socket.Header) interface{} { return &result }))
+	for {
+		if err = sock.ReadMessage(input); err != nil {
+			t.Fatal(err)
+		}
+		if input.Mtype() == tp.TypeReply {
+			break
+		}
+	}
+	if rerr := tp.NewRerrorFromMeta(input.Meta()); rerr != nil || result != 12 {
+		t.Fatalf("expect 12, got %d, %v", result, rerr)
+	}
+	if input.BodyCodec() != codec.ID_JSON {
+		t.Fatalf("expect the reply in JSON, got %d", input.BodyCodec())
+	}
+}
@@ -153,6 +153,9 @@ type message struct {
 	xferPipe *xfer.XferPipe
 	// message size
 	size uint32
+	// sizeLimit the message size upper limit of writing, 0 means no limit.
+	// NOTE: only for writing message, such as the limit negotiated with the remote peer.
+	sizeLimit uint32
 	// ctx is the message handling context,
 	// carries a deadline, a cancelation signal,
 	// and other values across API boundaries.
@@ -208,6 +211,7 @@ func (m *message) Reset(settings ...MessageSetting) {
 	m.mtype = 0
 	m.serviceMethod = ""
 	m.size = 0
+	m.sizeLimit = 0
 	m.ctx = nil
 	m.bodyCodec = codec.NilCodecID
 	m.doSetting(settings...)
@@ -369,6 +373,9 @@ func (m *message) SetSize(size uint32) error {
 	if err != nil {
 		return err
 	}
+	if m.sizeLimit > 0 && size > m.sizeLimit {
+		return ErrExceedMessageSizeLimit
+	}
 	m.size = size
 	return nil
 }
@@ -479,6 +486,15 @@ func WithNewBody(newBodyFunc NewBodyFunc) MessageSetting {
 	}
 }
 
+// WithSizeLimit sets the message size upper limit of writing.
+// If the packed message is bigger than the limit, it fails before writing.
+// NOTE: If limit=0, only the global message size limit is checked.
+func WithSizeLimit(limit uint32) MessageSetting {
+	return func(m Message) {
+		m.(*message).sizeLimit = limit
+	}
+}
+
 // WithXferPipe sets transfer filter pipe.
 // NOTE: Panic if the filterID is not registered.
 // SUGGEST: The length can not be bigger than 255!
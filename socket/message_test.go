@@ -3,6 +3,7 @@ package socket
 import (
 	"testing"
 
+	"github.com/mylonly/teleport/utils"
 	"github.com/mylonly/teleport/xfer/gzip"
 )
 
@@ -25,3 +26,28 @@ func TestMessageString(t *testing.T) {
 	t.Logf("%%#v:%#v", m)
 	t.Logf("%%+v:%+v", m)
 }
+
+func TestMetaTLV(t *testing.T) {
+	meta := utils.AcquireArgs()
+	defer utils.ReleaseArgs(meta)
+	meta.Add("a", "1&b=2")
+	meta.Add("a", "%zz")
+	meta.Add("empty", "")
+	b, err := AppendMetaTLV(nil, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an unknown type is skipped
+	b = append(b, 9, 0, 0, 0, 1, 'x')
+	parsed := utils.AcquireArgs()
+	defer utils.ReleaseArgs(parsed)
+	if err = ParseMetaTLV(parsed, b); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != meta.String() {
+		t.Fatalf("expect %q, got %q", meta.String(), parsed.String())
+	}
+	if err = ParseMetaTLV(parsed, b[:len(b)-1]); err != ErrBadMetaTLV {
+		t.Fatalf("expect ErrBadMetaTLV, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/mylonly/teleport/utils"
+)
+
+/*
+# binary TLV metadata format(Big Endian), a sequence of:
+
+{1 byte type}
+{4 bytes value length}
+{value}
+
+# the value of the argument type(1):
+
+{2 bytes key length}
+{key}
+{argument value}
+
+NOTE: The unknown types are skipped.
+*/
+
+// MetaTLVTypeArg the TLV type of a metadata argument
+const MetaTLVTypeArg byte = 1
+
+// ErrBadMetaTLV error
+var ErrBadMetaTLV = errors.New("Bad TLV metadata")
+
+// AppendMetaTLV appends the metadata in the binary TLV format to dst,
+// the keys and values are kept as they are, without percent-encoding.
+func AppendMetaTLV(dst []byte, meta *utils.Args) ([]byte, error) {
+	var err error
+	var b [4]byte
+	meta.VisitAll(func(key, value []byte) {
+		if err != nil {
+			return
+		}
+		if len(key) > math.MaxUint16 {
+			err = errors.New("TLV metadata: not support key longer than 65535")
+			return
+		}
+		dst = append(dst, MetaTLVTypeArg)
+		binary.BigEndian.PutUint32(b[:], uint32(2+len(key)+len(value)))
+		dst = append(dst, b[:]...)
+		binary.BigEndian.PutUint16(b[:2], uint16(len(key)))
+		dst = append(dst, b[:2]...)
+		dst = append(dst, key...)
+		dst = append(dst, value...)
+	})
+	return dst, err
+}
+
+// ParseMetaTLV parses the metadata in the binary TLV format, and adds the arguments to meta.
+func ParseMetaTLV(meta *utils.Args, data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return ErrBadMetaTLV
+		}
+		typ := data[0]
+		n := binary.BigEndian.Uint32(data[1:])
+		data = data[5:]
+		if uint64(n) > uint64(len(data)) {
+			return ErrBadMetaTLV
+		}
+		value := data[:n]
+		data = data[n:]
+		if typ != MetaTLVTypeArg {
+			continue
+		}
+		if len(value) < 2 {
+			return ErrBadMetaTLV
+		}
+		keyLen := int(binary.BigEndian.Uint16(value))
+		value = value[2:]
+		if keyLen > len(value) {
+			return ErrBadMetaTLV
+		}
+		meta.AddBytesKV(value[:keyLen], value[keyLen:])
+	}
+	return nil
+}
@@ -21,9 +21,12 @@ import (
 	"math"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/henrylee2cn/goutil"
+	"github.com/mylonly/teleport/metrics"
 	"github.com/mylonly/teleport/utils"
+	"github.com/mylonly/teleport/xfer"
 )
 
 type (
@@ -79,25 +82,58 @@ func SetDefaultProtoFunc(protoFunc ProtoFunc) {
 {metadata(urlencoded)}
 {1 byte body codec id}
 {body}
+
+# the raw-tlv protocol replaces the metadata with:
+
+{4 bytes metadata length}
+{metadata(binary TLV)}
 */
 
 // rawProto fast socket communication protocol.
 type rawProto struct {
-	id   byte
-	name string
-	r    io.Reader
-	w    io.Writer
-	rMu  sync.Mutex
+	id      byte
+	name    string
+	r       io.Reader
+	w       io.Writer
+	rMu     sync.Mutex
+	metaTLV bool
+	metrics *metrics.ProtoMetrics
+}
+
+var (
+	rawMetrics    = metrics.Proto("raw")
+	rawTLVMetrics = metrics.Proto("raw-tlv")
+)
+
+func init() {
+	metrics.RegErrorKind(ErrExceedMessageSizeLimit, metrics.ErrKindTooLarge)
+	metrics.RegErrorKind(ErrProactivelyCloseSocket, metrics.ErrKindClosed)
+	metrics.RegErrorKind(xfer.ErrUnpackTooLarge, metrics.ErrKindUnpackLimit)
 }
 
 // RawProtoFunc is creation function of fast socket protocol.
 // NOTE: it is the default protocol.
 var RawProtoFunc = func(rw IOWithReadBuffer) Proto {
 	return &rawProto{
-		id:   'r',
-		name: "raw",
-		r:    rw,
-		w:    rw,
+		id:      'r',
+		name:    "raw",
+		r:       rw,
+		w:       rw,
+		metrics: rawMetrics,
+	}
+}
+
+// RawTLVProtoFunc is creation function of the raw protocol with the binary TLV metadata,
+// which has no percent-encoding and supports the metadata longer than 65535.
+// NOTE: It can be selected per session by Session.UpgradeProto.
+var RawTLVProtoFunc = func(rw IOWithReadBuffer) Proto {
+	return &rawProto{
+		id:      'R',
+		name:    "raw-tlv",
+		r:       rw,
+		w:       rw,
+		metaTLV: true,
+		metrics: rawTLVMetrics,
 	}
 }
 
@@ -108,12 +144,20 @@ func (r *rawProto) Version() (byte, string) {
 
 // Pack writes the Message into the connection.
 // NOTE: Make sure to write only once or there will be package contamination!
-func (r *rawProto) Pack(m Message) error {
+func (r *rawProto) Pack(m Message) (err error) {
+	var (
+		start              = time.Now()
+		headerLen, bodyLen int
+	)
+	defer func() {
+		r.metrics.ObservePack(start, headerLen, bodyLen, err)
+	}()
+
 	bb := utils.AcquireByteBuffer()
 	defer utils.ReleaseByteBuffer(bb)
 
 	// fake size
-	err := binary.Write(bb, binary.BigEndian, uint32(0))
+	err = binary.Write(bb, binary.BigEndian, uint32(0))
 
 	// transfer pipe
 	bb.WriteByte(byte(m.XferPipe().Len()))
@@ -126,12 +170,14 @@ func (r *rawProto) Pack(m Message) error {
 	if err != nil {
 		return err
 	}
+	headerLen = bb.Len() - prefixLen
 
 	// body
 	err = r.writeBody(bb, m)
 	if err != nil {
 		return err
 	}
+	bodyLen = bb.Len() - prefixLen - headerLen
 
 	// do transfer pipe
 	payload, err := m.XferPipe().OnPack(bb.B[prefixLen:])
@@ -173,7 +219,21 @@ func (r *rawProto) writeHeader(bb *utils.ByteBuffer, m Message) error {
 	bb.WriteByte(byte(serviceMethodLength))
 	bb.Write(serviceMethod)
 
+	if r.metaTLV {
+		lenPos := bb.Len()
+		bb.Write(make([]byte, 4))
+		var err error
+		bb.B, err = AppendMetaTLV(bb.B, m.Meta())
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint32(bb.B[lenPos:], uint32(bb.Len()-lenPos-4))
+		return nil
+	}
 	metaBytes := m.Meta().QueryString()
+	if len(metaBytes) > math.MaxUint16 {
+		return errors.New("raw proto: not support metadata longer than 65535, use the raw-tlv proto")
+	}
 	binary.Write(bb, binary.BigEndian, uint16(len(metaBytes)))
 	bb.Write(metaBytes)
 	return nil
@@ -191,25 +251,37 @@ func (r *rawProto) writeBody(bb *utils.ByteBuffer, m Message) error {
 
 // Unpack reads bytes from the connection to the Message.
 // NOTE: Concurrent unsafe!
-func (r *rawProto) Unpack(m Message) error {
+func (r *rawProto) Unpack(m Message) (err error) {
+	var (
+		start              time.Time
+		headerLen, bodyLen int
+	)
+	defer func() {
+		r.metrics.ObserveUnpack(start, headerLen, bodyLen, err)
+	}()
+
 	bb := utils.AcquireByteBuffer()
 	defer utils.ReleaseByteBuffer(bb)
 
 	// read message
-	err := r.readMessage(bb, m)
+	err = r.readMessage(bb, m)
 	if err != nil {
 		return err
 	}
+	start = time.Now()
 	// do transfer pipe
 	data, err := m.XferPipe().OnUnpack(bb.B)
 	if err != nil {
 		return err
 	}
 	// header
+	bodyLen = len(data)
 	data, err = r.readHeader(data, m)
 	if err != nil {
 		return err
 	}
+	headerLen = bodyLen - len(data)
+	bodyLen = len(data)
 	// body
 	return r.readBody(data, m)
 }
@@ -275,6 +347,17 @@ func (r *rawProto) readHeader(data []byte, m Message) ([]byte, error) {
 	data = data[serviceMethodLen:]
 
 	// meta
+	if r.metaTLV {
+		metaLen := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(metaLen) > uint64(len(data)) {
+			return nil, ErrBadMetaTLV
+		}
+		if err = ParseMetaTLV(m.Meta(), data[:metaLen]); err != nil {
+			return nil, err
+		}
+		return data[metaLen:], nil
+	}
 	metaLen := binary.BigEndian.Uint16(data)
 	data = data[2:]
 	m.Meta().ParseBytes(data[:metaLen])
@@ -96,13 +96,21 @@ type (
 		SetID(string)
 		// Reset reset net.Conn and ProtoFunc.
 		Reset(netConn net.Conn, protoFunc ...ProtoFunc)
+		// UpgradeReadProto switches the protocol of reading.
+		// NOTE: The caller ensures that it is called between reading two messages.
+		UpgradeReadProto(protoFunc ProtoFunc)
+		// UpgradeWriteProto switches the protocol of writing.
+		// NOTE: The caller ensures that it is called between writing two messages.
+		UpgradeWriteProto(protoFunc ProtoFunc)
 		// Raw returns the raw net.Conn
 		Raw() net.Conn
 	}
 	socket struct {
+		written uint64 // the bytes written, first for the 64-bit alignment of the atomic access
 		net.Conn
 		readerWithBuffer *bufio.Reader
 		protocol         Proto
+		readProtocol     Proto
 		id               string
 		idMutex          sync.RWMutex
 		swap             goutil.Map
@@ -150,6 +158,7 @@ func newSocket(c net.Conn, protoFuncs []ProtoFunc) *socket {
 		readerWithBuffer: bufio.NewReaderSize(c, readerSize),
 	}
 	s.protocol = getProto(protoFuncs, s)
+	s.readProtocol = s.protocol
 	s.initOptimize()
 	return s
 }
@@ -190,16 +199,29 @@ func (s *socket) ControlFD(f func(fd uintptr)) error {
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
 // NOTE:
 //  For the byte stream type of body, write directly, do not do any processing;
-//  Must be safe for concurrent use by multiple goroutines.
+//  Must be safe for concurrent use by multiple goroutines;
+//  The failure of the connection is returned as *WriteError,
+//  whose Written is exact only if the messages are not written concurrently.
 func (s *socket) WriteMessage(message Message) error {
 	s.mu.RLock()
 	protocol := s.protocol
 	s.mu.RUnlock()
+	written := atomic.LoadUint64(&s.written)
 	err := protocol.Pack(message)
-	if err != nil && s.isActiveClosed() {
-		err = ErrProactivelyCloseSocket
+	if err == nil {
+		return nil
 	}
-	return err
+	if s.isActiveClosed() {
+		return ErrProactivelyCloseSocket
+	}
+	return classifyWriteError(err, int(atomic.LoadUint64(&s.written)-written), protocol, message)
+}
+
+// Write writes data to the connection, and counts the written bytes.
+func (s *socket) Write(b []byte) (int, error) {
+	n, err := s.Conn.Write(b)
+	atomic.AddUint64(&s.written, uint64(n))
+	return n, err
 }
 
 // ReadMessage reads header and body from the connection.
@@ -208,7 +230,7 @@ func (s *socket) WriteMessage(message Message) error {
 //  Must be safe for concurrent use by multiple goroutines.
 func (s *socket) ReadMessage(message Message) error {
 	s.mu.RLock()
-	protocol := s.protocol
+	protocol := s.readProtocol
 	s.mu.RUnlock()
 	return protocol.Unpack(message)
 }
@@ -247,6 +269,22 @@ func (s *socket) SetID(id string) {
 	s.idMutex.Unlock()
 }
 
+// UpgradeReadProto switches the protocol of reading.
+// NOTE: The caller ensures that it is called between reading two messages.
+func (s *socket) UpgradeReadProto(protoFunc ProtoFunc) {
+	s.mu.Lock()
+	s.readProtocol = protoFunc(s)
+	s.mu.Unlock()
+}
+
+// UpgradeWriteProto switches the protocol of writing.
+// NOTE: The caller ensures that it is called between writing two messages.
+func (s *socket) UpgradeWriteProto(protoFunc ProtoFunc) {
+	s.mu.Lock()
+	s.protocol = protoFunc(s)
+	s.mu.Unlock()
+}
+
 // Reset reset net.Conn and ProtoFunc.
 func (s *socket) Reset(netConn net.Conn, protoFunc ...ProtoFunc) {
 	atomic.StoreInt32(&s.curState, activeClose)
@@ -255,6 +293,7 @@ func (s *socket) Reset(netConn net.Conn, protoFunc ...ProtoFunc) {
 	s.readerWithBuffer.Discard(s.readerWithBuffer.Buffered())
 	s.readerWithBuffer.Reset(netConn)
 	s.protocol = getProto(protoFunc, s)
+	s.readProtocol = s.protocol
 	s.SetID("")
 	atomic.StoreInt32(&s.curState, normal)
 	s.initOptimize()
@@ -283,6 +322,7 @@ func (s *socket) Close() error {
 		s.Conn = nil
 		s.swap = nil
 		s.protocol = nil
+		s.readProtocol = nil
 		socketPool.Put(s)
 	}
 	return err
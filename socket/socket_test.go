@@ -0,0 +1,78 @@
+package socket
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+// brokenConn writes at most limit bytes, and then fails with err.
+type brokenConn struct {
+	net.Conn
+	limit int
+	err   error
+}
+
+func (c *brokenConn) Write(b []byte) (int, error) {
+	if len(b) <= c.limit {
+		c.limit -= len(b)
+		return len(b), nil
+	}
+	n := c.limit
+	c.limit = 0
+	return n, c.err
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// resendProto the raw proto which supports re-sending the frames.
+type resendProto struct {
+	Proto
+}
+
+func (resendProto) Resendable(Message) bool {
+	return true
+}
+
+func TestWriteError(t *testing.T) {
+	broken := &net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE}
+	timeout := &net.OpError{Op: "write", Net: "tcp", Err: timeoutError{}}
+	resend := func(rw IOWithReadBuffer) Proto {
+		return resendProto{RawProtoFunc(rw)}
+	}
+	for _, c := range []struct {
+		limit     int
+		err       error
+		protoFunc ProtoFunc
+		temporary bool
+		partial   bool
+		retryable bool
+	}{
+		{0, timeout, nil, true, false, false},
+		{0, broken, nil, false, false, true},
+		{10, timeout, nil, false, true, false},
+		{10, broken, nil, false, true, false},
+		{10, broken, resend, false, true, true},
+	} {
+		conn := &brokenConn{limit: c.limit, err: c.err}
+		s := NewSocket(conn, c.protoFunc)
+		m := GetMessage(WithServiceMethod("/write/error"), WithBody([]byte("test")))
+		err := s.WriteMessage(m)
+		PutMessage(m)
+		werr, ok := err.(*WriteError)
+		if !ok {
+			t.Fatalf("expect *WriteError, got %T: %v", err, err)
+		}
+		if werr.Written != c.limit || werr.Temporary() != c.temporary || werr.Partial() != c.partial || werr.Retryable() != c.retryable {
+			t.Fatalf("limit %d, %v: unexpected classification: written=%d, temporary=%v, partial=%v, retryable=%v",
+				c.limit, c.err, werr.Written, werr.Temporary(), werr.Partial(), werr.Retryable())
+		}
+		if werr.Timeout() != (c.err == timeout) {
+			t.Fatalf("unexpected timeout: %v", werr)
+		}
+	}
+}
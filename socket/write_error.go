@@ -0,0 +1,89 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"net"
+)
+
+// ResendProto the optional interface of Proto, which supports re-sending the frames
+// cut off by the write failures after reconnecting, e.g. the remote peer de-duplicates them.
+type ResendProto interface {
+	Proto
+	// Resendable returns whether the partially written message can be re-sent idempotently.
+	Resendable(Message) bool
+}
+
+// WriteError the classified failure of writing a message to the connection.
+// NOTE: It implements net.Error.
+type WriteError struct {
+	// Err the error of the connection
+	Err error
+	// Written the number of the bytes of the frame written before the failure
+	Written int
+	// Resendable whether the proto re-sends the frame idempotently after reconnecting, see ResendProto
+	Resendable bool
+}
+
+var _ net.Error = new(WriteError)
+
+// Error implements error.
+func (e *WriteError) Error() string {
+	if e.Written > 0 {
+		return "partial write: " + e.Err.Error()
+	}
+	return e.Err.Error()
+}
+
+// Timeout returns whether the write timed out.
+func (e *WriteError) Timeout() bool {
+	ne, ok := e.Err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// Temporary returns whether nothing is written and the connection is still usable, e.g. the write timed out.
+func (e *WriteError) Temporary() bool {
+	if e.Written > 0 {
+		return false
+	}
+	ne, ok := e.Err.(net.Error)
+	return ok && (ne.Timeout() || ne.Temporary())
+}
+
+// Partial returns whether the frame is cut off, then the rest of the stream is corrupted.
+func (e *WriteError) Partial() bool {
+	return e.Written > 0
+}
+
+// Retryable returns whether the message can be written again after reconnecting:
+// the failure is fatal, and nothing is written or the frame is resendable.
+func (e *WriteError) Retryable() bool {
+	return !e.Temporary() && (e.Written == 0 || e.Resendable)
+}
+
+// classifyWriteError wraps the error of the connection with the written bytes of the frame.
+// NOTE: The errors before writing, e.g. encoding the body, are returned as they are.
+func classifyWriteError(err error, written int, proto Proto, message Message) error {
+	if written == 0 {
+		if _, ok := err.(net.Error); !ok {
+			return err
+		}
+	}
+	werr := &WriteError{Err: err, Written: written}
+	if p, ok := proto.(ResendProto); ok {
+		werr.Resendable = p.Resendable(message)
+	}
+	return werr
+}
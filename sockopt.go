@@ -0,0 +1,111 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// SocketOptions the options of the TCP sockets of the listeners and the dialed connections.
+type SocketOptions struct {
+	// ReusePort sets SO_REUSEPORT on the listening sockets,
+	// so that several processes can listen on the same port.
+	ReusePort bool
+	// Nagle enables Nagle's algorithm, i.e. clears TCP_NODELAY; default no delay.
+	Nagle bool
+	// KeepAlive the period of the keep-alive probes;
+	// if 0, the system default; if <0, disables keep-alive.
+	KeepAlive time.Duration
+	// ReadBuffer the size of the operating system's receive buffer; if <=0, the system default.
+	ReadBuffer int
+	// WriteBuffer the size of the operating system's transmit buffer; if <=0, the system default.
+	WriteBuffer int
+	// Control is called after creating the socket and before binding or connecting it,
+	// like net.ListenConfig.Control; e.g. setting other socket options.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+func (o *SocketOptions) listenControl(network, address string, c syscall.RawConn) error {
+	if o.ReusePort && isTCPNetwork(network) {
+		var err error
+		if e := c.Control(func(fd uintptr) {
+			err = setReusePort(fd)
+		}); e != nil {
+			return e
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if o.Control != nil {
+		return o.Control(network, address, c)
+	}
+	return nil
+}
+
+func (o *SocketOptions) needTune() bool {
+	return o.Nagle || o.KeepAlive != 0 || o.ReadBuffer > 0 || o.WriteBuffer > 0
+}
+
+// tune sets the options of the TCP connection.
+func (o *SocketOptions) tune(conn net.Conn) {
+	c, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if o.Nagle {
+		c.SetNoDelay(false)
+	}
+	if o.KeepAlive < 0 {
+		c.SetKeepAlive(false)
+	} else if o.KeepAlive > 0 {
+		c.SetKeepAlive(true)
+		c.SetKeepAlivePeriod(o.KeepAlive)
+	}
+	if o.ReadBuffer > 0 {
+		c.SetReadBuffer(o.ReadBuffer)
+	}
+	if o.WriteBuffer > 0 {
+		c.SetWriteBuffer(o.WriteBuffer)
+	}
+}
+
+func isTCPNetwork(network string) bool {
+	return network == "tcp" || network == "tcp4" || network == "tcp6"
+}
+
+// tunedListener tunes the accepted connections.
+type tunedListener struct {
+	net.Listener
+	opts *SocketOptions
+}
+
+func (l *tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.opts.tune(conn)
+	return conn, nil
+}
+
+// SetSocketControl sets the hook which is called after creating the TCP socket
+// and before binding or connecting it, like net.ListenConfig.Control;
+// e.g. setting other socket options.
+func (p *peer) SetSocketControl(control func(network, address string, c syscall.RawConn) error) {
+	p.sockOpts.Control = control
+}
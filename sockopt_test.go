@@ -0,0 +1,67 @@
+package tp_test
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestSocketOptions(t *testing.T) {
+	var (
+		listenControls, dialControls int32
+		addr                         string
+	)
+	for i := 0; i < 2; i++ {
+		// two peers share the port
+		var port uint16
+		if i > 0 {
+			_, p, _ := net.SplitHostPort(addr)
+			n, _ := strconv.Atoi(p)
+			port = uint16(n)
+		}
+		srv := tp.NewPeer(tp.PeerConfig{
+			LocalIP:          "127.0.0.1",
+			ListenPort:       port,
+			ReusePort:        true,
+			TCPNagle:         true,
+			TCPKeepAlive:     time.Minute,
+			SocketReadBuffer: 1 << 16,
+		})
+		defer srv.Close()
+		srv.SetSocketControl(func(network, address string, c syscall.RawConn) error {
+			atomic.AddInt32(&listenControls, 1)
+			return nil
+		})
+		srv.RouteCallFunc(echo_call)
+		addr = listenAndServe(t, srv)
+	}
+	if n := atomic.LoadInt32(&listenControls); n != 2 {
+		t.Fatalf("expect 2 listening sockets, got %d", n)
+	}
+
+	cli := tp.NewPeer(tp.PeerConfig{
+		TCPKeepAlive:      -1,
+		SocketWriteBuffer: 1 << 16,
+	})
+	defer cli.Close()
+	cli.SetSocketControl(func(network, address string, c syscall.RawConn) error {
+		atomic.AddInt32(&dialControls, 1)
+		return nil
+	})
+	sess, rerr := cli.Dial(addr)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	var arg, result = 10, 0
+	if rerr = sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if n := atomic.LoadInt32(&dialControls); n != 1 {
+		t.Fatalf("expect 1 dialing socket, got %d", n)
+	}
+}
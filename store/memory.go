@@ -0,0 +1,120 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryDriverName the name of in-memory store driver
+const MemoryDriverName = "memory"
+
+func init() {
+	Reg(MemoryDriverName, func(string) (Store, error) {
+		return NewMemoryStore(), nil
+	})
+}
+
+// DefaultSweepInterval the default interval of sweeping the expired keys of the in-memory store
+const DefaultSweepInterval = time.Minute
+
+// NewMemoryStore creates an in-memory store, it is not persistent.
+// NOTE: The expired keys are swept every sweepInterval, default DefaultSweepInterval,
+// even if nobody reads them again.
+func NewMemoryStore(sweepInterval ...time.Duration) Store {
+	interval := DefaultSweepInterval
+	if len(sweepInterval) > 0 && sweepInterval[0] > 0 {
+		interval = sweepInterval[0]
+	}
+	s := &memoryStore{
+		m:       make(map[string]memoryItem),
+		closeCh: make(chan struct{}),
+	}
+	go s.sweep(interval)
+	return s
+}
+
+type memoryStore struct {
+	m         map[string]memoryItem
+	mu        sync.RWMutex
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+type memoryItem struct {
+	value    []byte
+	expireAt time.Time
+}
+
+func (m memoryItem) expired(now time.Time) bool {
+	return !m.expireAt.IsZero() && !now.Before(m.expireAt)
+}
+
+// Get returns the value of the key.
+// NOTE: If the key does not exist or has expired, exist=false.
+func (s *memoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	item, ok := s.m[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	if item.expired(time.Now()) {
+		s.mu.Lock()
+		if item, ok = s.m[key]; ok && item.expired(time.Now()) {
+			delete(s.m, key)
+		}
+		s.mu.Unlock()
+		return nil, false, nil
+	}
+	return append([]byte(nil), item.value...), true, nil
+}
+
+// Set sets the value of the key.
+// NOTE: If ttl<=0, the key never expires.
+func (s *memoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	item := memoryItem{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		item.expireAt = time.Now().Add(ttl)
+	}
+	s.mu.Lock()
+	s.m[key] = item
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete deletes the key.
+func (s *memoryStore) Delete(key string) error {
+	s.mu.Lock()
+	delete(s.m, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// sweep deletes the expired keys periodically until the store is closed.
+func (s *memoryStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, item := range s.m {
+				if item.expired(now) {
+					delete(s.m, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close closes the store.
+func (s *memoryStore) Close() error {
+	s.closeOnce.Do(func() { close(s.closeCh) })
+	s.mu.Lock()
+	s.m = make(map[string]memoryItem)
+	s.mu.Unlock()
+	return nil
+}
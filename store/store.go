@@ -0,0 +1,82 @@
+// Package store is the key-value store for session-adjacent state,
+// such as resume tokens, reliable-push journals and dedup caches.
+//
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store key-value store for session-adjacent state
+type Store interface {
+	// Get returns the value of the key.
+	// NOTE: If the key does not exist or has expired, exist=false.
+	Get(key string) (value []byte, exist bool, err error)
+	// Set sets the value of the key.
+	// NOTE: If ttl<=0, the key never expires.
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete deletes the key.
+	Delete(key string) error
+	// Close closes the store.
+	Close() error
+}
+
+// Driver opens a store by the data source.
+// NOTE: The format of the source is defined by the driver.
+type Driver func(source string) (Store, error)
+
+var driverMap = struct {
+	m  map[string]Driver
+	mu sync.RWMutex
+}{
+	m: make(map[string]Driver),
+}
+
+// Reg registers store driver.
+// NOTE: Only the in-memory driver is built in, the persistent backends can be registered by the user.
+func Reg(name string, driver Driver) {
+	driverMap.mu.Lock()
+	defer driverMap.mu.Unlock()
+	if _, ok := driverMap.m[name]; ok {
+		panic("multi-register store driver: " + name)
+	}
+	driverMap.m[name] = driver
+}
+
+// Open opens a store by the driver name and the data source.
+func Open(name, source string) (Store, error) {
+	driverMap.mu.RLock()
+	driver, ok := driverMap.m[name]
+	driverMap.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported store driver: %s", name)
+	}
+	return driver(source)
+}
+
+// OpenURI opens a store by the URI, format: 'driver' or 'driver:source'.
+// e.g. memory, or mydriver:/var/lib/app/session.db if mydriver is registered
+func OpenURI(uri string) (Store, error) {
+	name, source := uri, ""
+	if i := strings.Index(uri, ":"); i >= 0 {
+		name, source = uri[:i], uri[i+1:]
+	}
+	return Open(name, source)
+}
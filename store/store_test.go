@@ -0,0 +1,56 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s, err := OpenURI("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.Set("a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set("b", []byte("2"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, _ := s.Get("a"); !ok || string(v) != "1" {
+		t.Fatalf("a: expect 1, got %q(%v)", v, ok)
+	}
+	if v, ok, _ := s.Get("b"); !ok || string(v) != "2" {
+		t.Fatalf("b: expect 2, got %q(%v)", v, ok)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok, _ := s.Get("b"); ok {
+		t.Fatal("b: expect expired")
+	}
+	s.Delete("a")
+	if _, ok, _ := s.Get("a"); ok {
+		t.Fatal("a: expect deleted")
+	}
+	if _, err = OpenURI("unknown:/tmp/x.db"); err == nil {
+		t.Fatal("unknown: expect unsupported driver")
+	}
+}
+
+func TestMemorySweep(t *testing.T) {
+	s := NewMemoryStore(20 * time.Millisecond)
+	defer s.Close()
+	s.Set("a", []byte("1"), 0)
+	s.Set("b", []byte("2"), 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	m := s.(*memoryStore)
+	m.mu.RLock()
+	_, okA := m.m["a"]
+	_, okB := m.m["b"]
+	m.mu.RUnlock()
+	if !okA {
+		t.Fatal("a: expect kept")
+	}
+	if okB {
+		t.Fatal("b: expect swept without reading")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"path"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/henrylee2cn/goutil/errors"
+	"github.com/mylonly/teleport/codec"
+)
+
+var (
+	rerrNotStream    = NewRerror(CodeBadMessage, CodeText(CodeBadMessage), "the CALL does not open a stream")
+	rerrStreamClosed = NewRerror(CodeBadMessage, CodeText(CodeBadMessage), "the stream is closed")
+)
+
+// RouteStream registers the STREAM handler of the service method, and returns the path.
+func (r *Router) RouteStream(serviceMethod string, fn func(StreamCtx) *Rerror, plugin ...Plugin) string {
+	return r.subRouter.RouteStream(serviceMethod, fn, plugin...)
+}
+
+// RouteStream registers the STREAM handler of the service method, and returns the path.
+// The stream is opened by Session.OpenStream, then both sides exchange a sequence of messages
+// tied to it, until the handler returns.
+// NOTE:
+//  The service method is under the prefix of the router, e.g. RouteStream("/chat") of SubRoute("/room") is /room/chat;
+//  The stream handler shares the service methods with the CALL handlers, but it can not have the path parameters.
+func (r *SubRouter) RouteStream(serviceMethod string, fn func(StreamCtx) *Rerror, plugin ...Plugin) string {
+	maker := func(prefix string, _ interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
+		name := path.Join("/", prefix, serviceMethod)
+		if isRoutePattern(name) {
+			return nil, errors.Errorf("the stream route can not have the path parameters: %s", name)
+		}
+		return []*Handler{{
+			name:    name,
+			argElem: reflect.TypeOf([]byte{}),
+			newArg: func() interface{} {
+				return new([]byte)
+			},
+			handleArg: func(ctx *handlerCtx, _ interface{}) {
+				if rerr := fn(ctx); rerr != nil {
+					ctx.handleErr = rerr
+					rerr.SetToMeta(ctx.output.Meta())
+				}
+			},
+			pluginContainer: pluginContainer,
+		}}, nil
+	}
+	return r.reg(pnStream, maker, nil, plugin)[0]
+}
+
+// serverStream the receiving side of the stream opened by the remote peer.
+type serverStream struct {
+	frames []streamFrame
+	ended  bool          // the remote peer has closed its sending side
+	notify chan struct{} // signaled after a frame is queued or the stream is ended
+	mu     sync.Mutex
+}
+
+type streamFrame struct {
+	bodyCodec byte
+	body      []byte
+}
+
+// openStream registers the stream opened by the CALL, to which the following PUSHes over it are delivered.
+func (c *handlerCtx) openStream() *Rerror {
+	if len(c.input.Meta().Peek(MetaStream)) == 0 {
+		return rerrNotStream
+	}
+	c.stream = &serverStream{notify: make(chan struct{}, 1)}
+	c.sess.streams.Store(c.input.Seq(), c.stream)
+	return nil
+}
+
+// closeStream unregisters the stream opened by the CALL and the credits of its reply, after the handler returns.
+func (c *handlerCtx) closeStream() {
+	if c.credit != nil {
+		c.sess.streamCredits.Delete(c.input.Seq())
+		c.credit = nil
+	}
+	if c.stream == nil {
+		return
+	}
+	if c.input.Mtype() == TypeCall {
+		c.sess.streams.Delete(c.input.Seq())
+	}
+	c.stream = nil
+}
+
+// bindStreamPush binds the raw body of the PUSH sent over the stream, which is not routed.
+// NOTE: If the stream is closed, the PUSH is dropped, see dispatchStream.
+func (c *handlerCtx) bindStreamPush(seq []byte) interface{} {
+	n, err := strconv.ParseInt(string(seq), 10, 32)
+	if err != nil {
+		c.handleErr = rerrBadMessage.Copy().SetReason("invalid stream sequence: " + string(seq))
+		return nil
+	}
+	v, ok := c.sess.streams.Load(int32(n))
+	if !ok {
+		return nil
+	}
+	c.stream = v.(*serverStream)
+	c.input.SetBody(new([]byte))
+	return c.input.Body()
+}
+
+// dispatchStream delivers the PUSH sent over the stream in the read goroutine, so that the order is kept,
+// and returns false if it is out of any stream.
+func (p *peer) dispatchStream(ctx *handlerCtx) bool {
+	if ctx.input.Mtype() != TypePush || len(ctx.input.Meta().Peek(MetaStreamSeq)) == 0 {
+		return false
+	}
+	switch {
+	case ctx.handleErr != nil:
+		Warnf("%s", ctx.handleErr.String())
+	case ctx.stream == nil:
+		Debugf("drop the message of the closed stream: %s %s, seq: %s",
+			ctx.IP(), ctx.input.ServiceMethod(), ctx.PeekMeta(MetaStreamSeq))
+	case len(ctx.input.Meta().Peek(MetaStreamEnd)) > 0:
+		ctx.stream.end()
+	default:
+		ctx.stream.push(streamFrame{
+			bodyCodec: ctx.input.BodyCodec(),
+			body:      *ctx.input.Body().(*[]byte),
+		})
+	}
+	p.putContext(ctx, true)
+	return true
+}
+
+func (st *serverStream) push(frame streamFrame) {
+	st.mu.Lock()
+	st.frames = append(st.frames, frame)
+	st.mu.Unlock()
+	st.signal()
+}
+
+func (st *serverStream) end() {
+	st.mu.Lock()
+	st.ended = true
+	st.mu.Unlock()
+	st.signal()
+}
+
+func (st *serverStream) signal() {
+	select {
+	case st.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Recv waits for the next message sent over the stream by the remote peer, and decodes it into arg;
+// returns false after the remote peer closes its sending side, or if the stream is aborted.
+func (c *handlerCtx) Recv(arg interface{}) (bool, *Rerror) {
+	st := c.stream
+	if st == nil {
+		return false, rerrNotStream
+	}
+	for {
+		st.mu.Lock()
+		if len(st.frames) > 0 {
+			frame := st.frames[0]
+			st.frames[0] = streamFrame{}
+			st.frames = st.frames[1:]
+			st.mu.Unlock()
+			if err := codec.Unmarshal(frame.bodyCodec, frame.body, arg); err != nil {
+				return false, rerrBadMessage.Copy().SetReason(err.Error())
+			}
+			return true, nil
+		}
+		ended := st.ended
+		st.mu.Unlock()
+		if ended {
+			return false, nil
+		}
+		select {
+		case <-st.notify:
+		case <-c.Context().Done():
+			return false, rerrCallContext(c.Context().Err())
+		case <-c.sess.closeNotifyCh:
+			return false, rerrConnClosed
+		}
+	}
+}
+
+// Send sends a message over the stream to the remote peer, which is received by ClientStream.Next.
+func (c *handlerCtx) Send(result interface{}) *Rerror {
+	if c.stream == nil {
+		return rerrNotStream
+	}
+	return c.SendStream(result)
+}
+
+// ClientStream the client side of the stream, see Session.OpenStream.
+// For example:
+//  stream := sess.OpenStream("/chat", func() interface{} { return new(string) })
+//  stream.Send("hello")
+//  stream.CloseSend()
+//  for stream.Next() {
+//  	msg := *stream.Result().(*string)
+//  }
+//  if rerr := stream.Rerror(); rerr != nil {
+//  	...
+//  }
+type ClientStream struct {
+	*ReplyIter
+	sess          *session
+	serviceMethod string
+	seq           string
+}
+
+// OpenStream opens the stream to the STREAM handler of the service method,
+// over which both sides exchange a sequence of messages until the handler returns.
+// NOTE:
+//  newResult creates the result which each message sent by the handler is decoded into;
+//  The handler waits while the unread messages fill the window, see WithStreamWindow;
+//  The stream is aborted by the CALL timeout, unless the context is set by WithContext.
+func (s *session) OpenStream(serviceMethod string, newResult func() interface{}, setting ...MessageSetting) *ClientStream {
+	setting = append(setting[:len(setting):len(setting)], WithSetMeta(MetaStream, "1"))
+	iter := s.callStream(serviceMethod, nil, newResult, setting)
+	return &ClientStream{
+		ReplyIter:     iter,
+		sess:          s,
+		serviceMethod: serviceMethod,
+		seq:           iter.flow.seq,
+	}
+}
+
+// Send sends a message over the stream to the handler, which is received by StreamCtx.Recv.
+// NOTE: It fails after the stream is ended.
+func (cs *ClientStream) Send(arg interface{}, setting ...MessageSetting) *Rerror {
+	select {
+	case <-cs.cmd.Done():
+		return rerrStreamClosed
+	default:
+	}
+	setting = append(setting[:len(setting):len(setting)], WithSetMeta(MetaStreamSeq, cs.seq))
+	return cs.sess.Push(cs.serviceMethod, arg, setting...)
+}
+
+// CloseSend closes the sending side of the stream,
+// then StreamCtx.Recv returns false after the messages sent before.
+func (cs *ClientStream) CloseSend() *Rerror {
+	return cs.Send(nil, WithSetMeta(MetaStreamEnd, "1"))
+}
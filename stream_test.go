@@ -0,0 +1,81 @@
+package tp_test
+
+import (
+	"strings"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestRouteStream(t *testing.T) {
+	var path string
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.SubRoute("/chat").RouteStream("/upper", func(ctx tp.StreamCtx) *tp.Rerror {
+			for {
+				var msg string
+				ok, rerr := ctx.Recv(&msg)
+				if rerr != nil {
+					return rerr
+				}
+				if !ok {
+					return nil
+				}
+				if msg == "bye" {
+					return tp.NewRerror(tp.CodeConflict, "bye", "")
+				}
+				if rerr = ctx.Send(strings.ToUpper(msg)); rerr != nil {
+					return rerr
+				}
+			}
+		})
+	})
+	defer p.Close()
+	if path != "/chat/upper" {
+		t.Fatalf("expect /chat/upper, got %s", path)
+	}
+	recv := func(stream *tp.ClientStream) []string {
+		var msgs []string
+		for stream.Next() {
+			msgs = append(msgs, *stream.Result().(*string))
+		}
+		return msgs
+	}
+	newResult := func() interface{} { return new(string) }
+
+	// ended by the client
+	var rerr *tp.Rerror
+	stream := p.sess.OpenStream(path, newResult)
+	for _, msg := range []string{"a", "b", "c"} {
+		if rerr = stream.Send(msg); rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+	if rerr = stream.CloseSend(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if msgs := recv(stream); strings.Join(msgs, ",") != "A,B,C" {
+		t.Fatalf("expect A,B,C, got %v", msgs)
+	}
+	if rerr = stream.Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if rerr = stream.Send("d"); rerr == nil {
+		t.Fatal("expect the error of sending over the ended stream")
+	}
+
+	// ended by the handler
+	stream = p.sess.OpenStream(path, newResult)
+	stream.Send("x")
+	stream.Send("bye")
+	if msgs := recv(stream); strings.Join(msgs, ",") != "X" {
+		t.Fatalf("expect X, got %v", msgs)
+	}
+	if rerr = stream.Rerror(); rerr == nil || rerr.Code != tp.CodeConflict {
+		t.Fatalf("expect code %d, got %v", tp.CodeConflict, rerr)
+	}
+
+	// not opened as a stream
+	if rerr = p.sess.Call(path, nil, nil).Rerror(); rerr == nil || rerr.Code != tp.CodeBadMessage {
+		t.Fatalf("expect code %d, got %v", tp.CodeBadMessage, rerr)
+	}
+}
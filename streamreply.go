@@ -0,0 +1,187 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/mylonly/teleport/socket"
+)
+
+// defaultStreamWindow the default number of the chunks sent without waiting for the caller to read them.
+const defaultStreamWindow = 16
+
+var rerrStreamRefused = NewRerror(CodeBadMessage, CodeText(CodeBadMessage), "the caller does not accept the stream")
+
+// WithStreamWindow sets the number of the chunks of the reply which the replier can send
+// before they are read, see Session.CallStream.
+func WithStreamWindow(window int) MessageSetting {
+	return socket.WithSetMeta(MetaStreamWindow, strconv.Itoa(window))
+}
+
+// CallStream sends a message and receives the reply as a stream of the chunks by the iterator,
+// which are sent by CallCtx.SendStream and ended by the termination frame after the handler returns.
+// NOTE:
+//  newResult creates the result which each chunk is decoded into;
+//  The reply returned by the handler is the last chunk, unless it is nil;
+//  The handler waits while the unread chunks fill the window, see WithStreamWindow.
+func (s *session) CallStream(serviceMethod string, arg interface{}, newResult func() interface{}, setting ...MessageSetting) *ReplyIter {
+	return s.callStream(serviceMethod, arg, newResult, setting)
+}
+
+// callStream launches the CALL whose reply is a stream of the chunks under the flow control.
+func (s *session) callStream(serviceMethod string, arg interface{}, newResult func() interface{}, setting []MessageSetting) *ReplyIter {
+	iter := &ReplyIter{newResult: newResult, lastEnds: true}
+	iter.cond = sync.NewCond(&iter.mu)
+	setting = append([]MessageSetting{WithStreamWindow(defaultStreamWindow)}, setting...)
+	iter.cmd = s.asyncCall(serviceMethod, arg, nil, make(chan CallCmd, 1), iter, setting...)
+	window, _ := strconv.Atoi(string(iter.cmd.output.Meta().Peek(MetaStreamWindow)))
+	iter.flow = &replyFlow{
+		sess:   s,
+		seq:    strconv.FormatInt(int64(iter.cmd.output.Seq()), 10),
+		window: window,
+	}
+	return iter
+}
+
+// replyFlow the flow control of the chunks of the reply on the caller side.
+type replyFlow struct {
+	sess   *session
+	seq    string
+	window int
+	read   int // the chunks read since the last grant
+}
+
+// onRead counts the chunk read, and returns the credits to grant after half of the window is read.
+func (f *replyFlow) onRead() int {
+	f.read++
+	if f.read < (f.window+1)/2 {
+		return 0
+	}
+	n := f.read
+	f.read = 0
+	return n
+}
+
+// grant sends the credits to the replier by the CREDIT control message.
+func (f *replyFlow) grant(n int) {
+	rerr := f.sess.Control(TypeCredit, WithSetMeta(MetaStreamSeq, f.seq), WithSetMeta(MetaCredit, strconv.Itoa(n)))
+	if rerr != nil {
+		Debugf("grant stream credits: %s, seq: %s, error: %s", f.sess.RemoteAddr().String(), f.seq, rerr.String())
+	}
+}
+
+// streamCredit the credits of the chunks which the replier can send.
+type streamCredit struct {
+	n      int
+	notify chan struct{} // signaled after the credits are granted
+	mu     sync.Mutex
+}
+
+// acquire waits for a credit to send a chunk.
+func (sc *streamCredit) acquire(ctx context.Context, closeCh <-chan struct{}) *Rerror {
+	for {
+		sc.mu.Lock()
+		if sc.n > 0 {
+			sc.n--
+			sc.mu.Unlock()
+			return nil
+		}
+		sc.mu.Unlock()
+		select {
+		case <-sc.notify:
+		case <-ctx.Done():
+			return rerrCallContext(ctx.Err())
+		case <-closeCh:
+			return rerrConnClosed
+		}
+	}
+}
+
+func (sc *streamCredit) grant(n int) {
+	sc.mu.Lock()
+	sc.n += n
+	sc.mu.Unlock()
+	select {
+	case sc.notify <- struct{}{}:
+	default:
+	}
+}
+
+// grantCredit adds the credits of the CREDIT control message to the stream of the CALL.
+func (s *session) grantCredit(seq, credit []byte) {
+	n, err := strconv.ParseInt(string(seq), 10, 32)
+	m, err2 := strconv.Atoi(string(credit))
+	if err != nil || err2 != nil || m <= 0 {
+		Debugf("ignore bad credit message: %s, seq: %q, credit: %q", s.RemoteAddr().String(), seq, credit)
+		return
+	}
+	if v, ok := s.streamCredits.Load(int32(n)); ok {
+		v.(*streamCredit).grant(m)
+	}
+}
+
+// isStreamCall returns whether the caller accepts the reply as a stream of the chunks.
+func (c *handlerCtx) isStreamCall() bool {
+	return len(c.input.Meta().Peek(MetaStreamWindow)) > 0
+}
+
+// SendStream sends a chunk of the reply before the handler returns, which is received by Session.CallStream.
+// NOTE:
+//  It waits while the unread chunks fill the window of the caller;
+//  If the caller is Session.CallMulti, it is the same as ReplyPart, without the flow control.
+func (c *handlerCtx) SendStream(chunk interface{}) *Rerror {
+	if !c.isStreamCall() {
+		return c.ReplyPart(chunk)
+	}
+	if c.credit == nil {
+		window, err := strconv.Atoi(string(c.input.Meta().Peek(MetaStreamWindow)))
+		if err != nil || window <= 0 {
+			return rerrStreamRefused
+		}
+		c.credit = &streamCredit{n: window, notify: make(chan struct{}, 1)}
+		c.sess.streamCredits.Store(c.input.Seq(), c.credit)
+	}
+	if rerr := c.credit.acquire(c.Context(), c.sess.closeNotifyCh); rerr != nil {
+		return rerr
+	}
+	return c.writePart(chunk)
+}
+
+// endStream sends the reply returned by the handler as the last chunk, unless it is nil,
+// then the reply without the body is the termination frame of the stream.
+func (c *handlerCtx) endStream() {
+	if body := c.output.Body(); c.handleErr == nil && !isNilBody(body) {
+		if rerr := c.SendStream(body); rerr != nil {
+			c.handleErr = rerr
+		}
+	}
+	c.output.SetBody(nil)
+	c.output.Meta().Set(MetaStreamEnd, "1")
+}
+
+func isNilBody(body interface{}) bool {
+	if body == nil {
+		return true
+	}
+	switch v := reflect.ValueOf(body); v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// chunksSent receives the chunks after they are sent by the handler.
+var chunksSent chan int
+
+func chunks(ctx tp.CallCtx, n *int) (int, *tp.Rerror) {
+	for i := 0; i < *n; i++ {
+		if rerr := ctx.SendStream(i); rerr != nil {
+			return 0, rerr
+		}
+		chunksSent <- i
+	}
+	return *n, nil
+}
+
+func TestCallStream(t *testing.T) {
+	chunksSent = make(chan int, 100)
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(chunks)
+		srv.RouteCallFunc(hotloaded)
+	})
+	defer p.Close()
+	newResult := func() interface{} { return new(int) }
+
+	// the handler waits for the chunks to be read
+	iter := p.sess.CallStream("/chunks", 100, newResult, tp.WithStreamWindow(4))
+	for i := 0; i < 4; i++ {
+		<-chunksSent
+	}
+	select {
+	case i := <-chunksSent:
+		t.Fatalf("expect 4 chunks sent before reading, got the chunk %d", i)
+	case <-time.After(50 * time.Millisecond):
+	}
+	var chunks []int
+	for iter.Next() {
+		chunks = append(chunks, *iter.Result().(*int))
+	}
+	if rerr := iter.Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if len(chunks) != 101 || chunks[0] != 0 || chunks[99] != 99 || chunks[100] != 100 {
+		t.Fatalf("expect 0..99 and the reply 100, got %d chunks: %v", len(chunks), chunks)
+	}
+	if meta := iter.CallCmd().InputMeta(); string(meta.Peek(tp.MetaStreamEnd)) != "1" {
+		t.Fatalf("expect the termination frame, got %s", meta.String())
+	}
+
+	// the handler without the chunks
+	iter = p.sess.CallStream("/hotloaded", "x", func() interface{} { return new(string) })
+	var results []string
+	for iter.Next() {
+		results = append(results, *iter.Result().(*string))
+	}
+	if rerr := iter.Rerror(); rerr != nil || len(results) != 1 || results[0] != "x" {
+		t.Fatalf("expect [x], got %v, %v", results, rerr)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !js
+
+package tp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/henrylee2cn/goutil"
+	"github.com/henrylee2cn/goutil/graceful"
+)
+
+// The environment variables of the socket activation by systemd, see sd_listen_fds(3).
+const (
+	listenPIDKey     = "LISTEN_PID"
+	listenFDNamesKey = "LISTEN_FDNAMES"
+	// systemdAddrsKey the addresses of the named sockets, which are inherited by the graceful reboot,
+	// since the names are not passed by it.
+	systemdAddrsKey = "LISTEN_SYSTEMD_ADDRS"
+	listenFDsStart  = 3
+)
+
+type systemdAddr struct {
+	Network string `json:"network"`
+	Addr    string `json:"addr"`
+}
+
+var systemdSockets = struct {
+	addrs    map[string]systemdAddr // name -> the address of the activated socket
+	initOnce sync.Once
+	mu       sync.Mutex
+}{
+	addrs: make(map[string]systemdAddr),
+}
+
+// initSystemdSockets reads the names and addresses of the sockets activated by systemd.
+// NOTE:
+//  The sockets passed to another process are ignored, e.g. LISTEN_PID is of the parent process;
+//  The sockets are adopted by matching the addresses, like the ones inherited by the graceful reboot.
+func initSystemdSockets() {
+	systemdSockets.initOnce.Do(func() {
+		systemdSockets.mu.Lock()
+		defer systemdSockets.mu.Unlock()
+		json.Unmarshal(goutil.StringToBytes(os.Getenv(systemdAddrsKey)), &systemdSockets.addrs)
+		pid := os.Getenv(listenPIDKey)
+		if len(pid) == 0 {
+			return
+		}
+		names := os.Getenv(listenFDNamesKey)
+		// the children, e.g. the graceful reboot, must not take them as their own sockets
+		os.Unsetenv(listenPIDKey)
+		os.Unsetenv(listenFDNamesKey)
+		if pid != strconv.Itoa(os.Getpid()) {
+			os.Unsetenv(inheritedFDsKey)
+			return
+		}
+		count, _ := strconv.Atoi(os.Getenv(inheritedFDsKey))
+		var nameList []string
+		if len(names) > 0 {
+			nameList = strings.Split(names, ":")
+		}
+		for i := 0; i < count; i++ {
+			f := os.NewFile(uintptr(listenFDsStart+i), "systemd")
+			lis, err := net.FileListener(f)
+			if err != nil {
+				Warnf("systemd socket fd %d: %v", listenFDsStart+i, err)
+				continue
+			}
+			addr := systemdAddr{Network: lis.Addr().Network(), Addr: lis.Addr().String()}
+			// the dup of the fd is closed, the fd itself is adopted later
+			lis.Close()
+			name := strconv.Itoa(i)
+			systemdSockets.addrs[name] = addr
+			if i < len(nameList) && len(nameList[i]) > 0 {
+				name = nameList[i]
+				systemdSockets.addrs[name] = addr
+			}
+			Infof("systemd socket activated (name:%s, network:%s, addr:%s)", name, addr.Network, addr.Addr)
+		}
+	})
+}
+
+// lookupSystemdSocket returns the address of the socket activated by systemd,
+// by the FileDescriptorName of the socket unit, or the index of the passed sockets.
+func lookupSystemdSocket(name string) (network, addr string, err error) {
+	initSystemdSockets()
+	systemdSockets.mu.Lock()
+	a, ok := systemdSockets.addrs[name]
+	systemdSockets.mu.Unlock()
+	if !ok {
+		return "", "", fmt.Errorf("systemd socket not found: %s", name)
+	}
+	return a.Network, a.Addr, nil
+}
+
+func setSystemdAddrs() {
+	systemdSockets.mu.Lock()
+	b, _ := json.Marshal(systemdSockets.addrs)
+	systemdSockets.mu.Unlock()
+	graceful.AddInherited(nil, []*graceful.Env{
+		{K: systemdAddrsKey, V: goutil.BytesToString(b)},
+	})
+}
@@ -0,0 +1,74 @@
+package tp_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestSystemdActivation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the socket activation is not supported on windows")
+	}
+	if os.Getenv("TP_TEST_SYSTEMD") == "1" {
+		// the child process activated with the socket, as systemd sets LISTEN_PID after fork
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		srv := tp.NewPeer(tp.PeerConfig{ListenAddrs: []string{"systemd://web"}})
+		srv.RouteCallFunc(echo_call)
+		go srv.ListenAndServe()
+		// serves until the parent closes the stdin
+		io.Copy(ioutil.Discard, os.Stdin)
+		return
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	f, err := lis.(*net.TCPListener).File()
+	lis.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdActivation$")
+	cmd.Env = append(os.Environ(), "TP_TEST_SYSTEMD=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=web")
+	cmd.ExtraFiles = []*os.File{f}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stdin.Close()
+		cmd.Wait()
+	}()
+
+	cli := tp.NewPeer(tp.PeerConfig{})
+	defer cli.Close()
+	sess, rerr := cli.Dial(addr)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var arg, result = 10, 0
+	if rerr = sess.Call("/echo/call", &arg, &result, tp.WithContext(ctx)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if result != arg {
+		t.Fatalf("expect %d, got %d", arg, result)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"time"
+
+	"github.com/henrylee2cn/goutil"
+)
+
+// tempRoute the temporary CALL route of a session.
+type tempRoute struct {
+	handler *Handler
+	timer   *time.Timer
+}
+
+func (r *tempRoute) stop() {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+}
+
+// RouteCallTemp registers the CALL handler function on the session only, and returns the path,
+// which is sent to the remote peer to call back, e.g. in the argument of a CALL.
+// NOTE:
+// If the path is empty, a random one is generated;
+// The temporary route takes precedence over the routes of the peer;
+// If ttl>0, it is removed after the ttl, otherwise it lives until removed or the session is closed.
+func (s *session) RouteCallTemp(path string, callHandleFunc interface{}, ttl time.Duration) string {
+	pluginContainer := s.peer.router.subRouter.pluginContainer.cloneAndAppendMiddle()
+	handlers, err := makeCallHandlersFromFunc("", callHandleFunc, pluginContainer)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	if path == "" {
+		path = "/_temp/" + goutil.URLRandomString(12)
+	}
+	h := handlers[0]
+	h.name = path
+	h.routerTypeName = pnCall
+	r := &tempRoute{handler: h}
+	if old, ok := s.tempRoutes.Load(path); ok {
+		old.(*tempRoute).stop()
+	}
+	s.tempRoutes.Store(path, r)
+	if ttl > 0 {
+		r.timer = time.AfterFunc(ttl, func() {
+			if v, ok := s.tempRoutes.Load(path); ok && v == r {
+				s.tempRoutes.Delete(path)
+			}
+		})
+	}
+	s.Debugf("register temporary %s handler: %s", pnCall, path)
+	return path
+}
+
+// RemoveTempRoute removes the temporary route of the session,
+// e.g. called by a one-shot handler itself.
+func (s *session) RemoveTempRoute(path string) {
+	if v, ok := s.tempRoutes.Load(path); ok {
+		v.(*tempRoute).stop()
+		s.tempRoutes.Delete(path)
+	}
+}
+
+// lookupCallHandler returns the CALL handler, the temporary routes of the session first.
+func (s *session) lookupCallHandler(serviceMethod, version string) (*Handler, bool) {
+	if s.tempRoutes.Len() > 0 {
+		if v, ok := s.tempRoutes.Load(serviceMethod); ok {
+			return v.(*tempRoute).handler, true
+		}
+	}
+	return s.getCallHandler(serviceMethod, version)
+}
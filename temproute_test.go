@@ -0,0 +1,46 @@
+package tp_test
+
+import (
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func callback_call(ctx tp.CallCtx, path *string) (string, *tp.Rerror) {
+	var result string
+	rerr := ctx.Session().Call(*path, "ping", &result).Rerror()
+	return result, rerr
+}
+
+func TestRouteCallTemp(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.RouteCallFunc(callback_call)
+	})
+	defer p.Close()
+	// one-shot callback
+	path := p.sess.RouteCallTemp("", func(ctx tp.CallCtx, arg *string) (string, *tp.Rerror) {
+		ctx.Session().RemoveTempRoute(ctx.ServiceMethod())
+		return *arg + " pong", nil
+	}, 0)
+	var result string
+	if rerr := p.sess.Call("/callback/call", path, &result).Rerror(); rerr != nil || result != "ping pong" {
+		t.Fatalf("expect %q, got %q, %v", "ping pong", result, rerr)
+	}
+	if rerr := p.sess.Call("/callback/call", path, &result).Rerror(); rerr == nil || rerr.Code != tp.CodeNotFound {
+		t.Fatalf("expect not found after the one-shot callback, got %v", rerr)
+	}
+
+	// expired by the ttl
+	path = p.sess.RouteCallTemp("/hook", func(ctx tp.CallCtx, arg *string) (string, *tp.Rerror) {
+		return "hooked", nil
+	}, ttl)
+	if rerr := p.sess.Call("/callback/call", path, &result).Rerror(); rerr != nil || result != "hooked" {
+		t.Fatalf("expect %q, got %q, %v", "hooked", result, rerr)
+	}
+	time.Sleep(2 * ttl)
+	if rerr := p.sess.Call("/callback/call", path, &result).Rerror(); rerr == nil || rerr.Code != tp.CodeNotFound {
+		t.Fatalf("expect not found after the ttl, got %v", rerr)
+	}
+}
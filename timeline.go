@@ -0,0 +1,74 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"strings"
+	"time"
+)
+
+// Timeline the timestamps of the processing phases of an inbound message,
+// which are recorded only if PeerConfig.CountTime is true; the unreached phases are zero.
+type Timeline struct {
+	// Read the header is read
+	Read time.Time
+	// Unpacked the whole message is read and unpacked
+	Unpacked time.Time
+	// Decoded the body is unmarshaled, the same as Unpacked if it is unmarshaled while unpacking
+	Decoded time.Time
+	// HandleStart the handler starts
+	HandleStart time.Time
+	// HandleEnd the handler returns
+	HandleEnd time.Time
+	// WriteStart the reply starts to be marshaled and written
+	WriteStart time.Time
+	// Written the reply is written
+	Written time.Time
+}
+
+// String returns the durations of the phases, e.g.
+// "unpack=1ms decode=20µs wait=5µs handle=3ms write=100µs".
+func (t Timeline) String() string {
+	var b strings.Builder
+	phase := func(name string, start, end time.Time) {
+		if start.IsZero() || end.IsZero() {
+			return
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(end.Sub(start).String())
+	}
+	phase("unpack", t.Read, t.Unpacked)
+	phase("decode", t.Unpacked, t.Decoded)
+	phase("wait", t.Decoded, t.HandleStart)
+	phase("handle", t.HandleStart, t.HandleEnd)
+	phase("write", t.WriteStart, t.Written)
+	return b.String()
+}
+
+// Timeline returns the timestamps of the processing phases of the input message so far.
+func (c *handlerCtx) Timeline() Timeline {
+	return c.timeline
+}
+
+// markPhase records the time of the phase, if the time is counted.
+func (c *handlerCtx) markPhase(t *time.Time) {
+	if c.sess.peer.countTime {
+		*t = time.Now()
+	}
+}
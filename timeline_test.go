@@ -0,0 +1,32 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestTimeline(t *testing.T) {
+	var path string
+	timelines := make(chan tp.Timeline, 1)
+	p := newMemPeers(t, tp.PeerConfig{CountTime: true}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		path = srv.RouteCallFunc(func(ctx tp.CallCtx, arg *int) (int, *tp.Rerror) {
+			timelines <- ctx.Timeline()
+			return *arg, nil
+		})
+	})
+	defer p.Close()
+	var arg = 1
+	if rerr := p.sess.Call(path, &arg, new(int)).Rerror(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	timeline := <-timelines
+	if timeline.Read.IsZero() || timeline.Unpacked.Before(timeline.Read) ||
+		timeline.Decoded.Before(timeline.Unpacked) || timeline.HandleStart.Before(timeline.Decoded) {
+		t.Fatalf("unexpected timeline: %+v", timeline)
+	}
+	if !timeline.HandleEnd.IsZero() || !timeline.Written.IsZero() {
+		t.Fatalf("expect the unreached phases zero: %+v", timeline)
+	}
+	t.Log(timeline)
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval the minimum interval of checking the certificate files for changes.
+const certReloadInterval = time.Second
+
+// certReloader loads the certificate from the files, and reloads it when the files change,
+// e.g. renewed by Let's Encrypt.
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	cert      *tls.Certificate
+	modTime   time.Time // the latest modification time of the files
+	checkedAt time.Time
+	mu        sync.Mutex
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) stat() (time.Time, error) {
+	var modTime time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTime, err
+		}
+		if t := info.ModTime(); t.After(modTime) {
+			modTime = t
+		}
+	}
+	return modTime, nil
+}
+
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// certificate returns the current certificate, which is reloaded if the files have changed.
+// NOTE: If reloading fails, e.g. the files are being written, keeps the previous one.
+func (r *certReloader) certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.checkedAt) < certReloadInterval {
+		return r.cert
+	}
+	r.checkedAt = now
+	modTime, err := r.stat()
+	if err != nil || modTime.Equal(r.modTime) {
+		return r.cert
+	}
+	if err = r.load(modTime); err != nil {
+		Warnf("reload TLS certificate %s: %s", r.certFile, err.Error())
+		return r.cert
+	}
+	Infof("reloaded TLS certificate: %s", r.certFile)
+	return r.cert
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+// SetTLSCertificateLoader sets the loader of the certificate called on each TLS handshake,
+// e.g. autocert.Manager.GetCertificate, so that the certificate can be renewed without restart.
+// NOTE: It should be called before listening.
+func (p *peer) SetTLSCertificateLoader(loader func(*tls.ClientHelloInfo) (*tls.Certificate, error)) {
+	if p.tlsConfig == nil {
+		p.tlsConfig = newTLSConfig(tls.Certificate{})
+	} else {
+		p.tlsConfig = p.tlsConfig.Clone()
+	}
+	p.tlsConfig.Certificates = nil
+	p.tlsConfig.GetCertificate = loader
+}
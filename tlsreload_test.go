@@ -0,0 +1,75 @@
+package tp_test
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tp "github.com/mylonly/teleport"
+)
+
+// writeTestCertFiles writes a new certificate modified at modTime, and returns it.
+func writeTestCertFiles(t *testing.T, certFile, keyFile string, modTime time.Time) []byte {
+	cert := tp.GenerateTLSConfigForServer().Certificates[0]
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(cert.PrivateKey.(*rsa.PrivateKey))})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+	return cert.Certificate[0]
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tp_cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+	writeTestCertFiles(t, certFile, keyFile, now)
+
+	var newCert []byte
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, cli tp.Peer) {
+		if err := srv.SetTLSConfigFromFile(certFile, keyFile); err != nil {
+			t.Fatal(err)
+		}
+		// renew the certificate before the first handshake
+		newCert = writeTestCertFiles(t, certFile, keyFile, now.Add(time.Minute))
+		srv.RouteCallFunc(echo_call)
+		cli.SetTLSConfig(tp.GenerateTLSConfigForClient())
+	})
+	defer p.Close()
+	expect := func(sess tp.Session) {
+		t.Helper()
+		// the handshake is done by the first message
+		var arg, result = 1, 0
+		if rerr := sess.Call("/echo/call", &arg, &result).Rerror(); rerr != nil {
+			t.Fatal(rerr)
+		}
+		certs := sess.PeerCertificates()
+		if len(certs) == 0 || !bytes.Equal(certs[0].Raw, newCert) {
+			t.Fatal("expect the renewed server certificate")
+		}
+	}
+	expect(p.sess)
+
+	// the files are checked at most once per second
+	writeTestCertFiles(t, certFile, keyFile, now.Add(2*time.Minute))
+	sess, rerr := p.cli.Dial(p.addr)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	expect(sess)
+}
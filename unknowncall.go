@@ -0,0 +1,98 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mylonly/teleport/codec"
+	"github.com/mylonly/teleport/socket"
+)
+
+var bodyTypes = struct {
+	m    map[string]func() interface{}
+	rwmu sync.RWMutex
+}{m: make(map[string]func() interface{})}
+
+// RegBodyType registers the function creating the body of the type name,
+// which is declared by the sender with WithBodyType, see UnknownCallCtx.BindRegistered.
+// NOTE: The protobuf messages registered by the generated code need not be registered again.
+func RegBodyType(typeName string, newBody func() interface{}) {
+	bodyTypes.rwmu.Lock()
+	bodyTypes.m[typeName] = newBody
+	bodyTypes.rwmu.Unlock()
+}
+
+// newBodyOfType creates the body of the registered type name.
+func newBodyOfType(typeName string) (interface{}, bool) {
+	bodyTypes.rwmu.RLock()
+	newBody, ok := bodyTypes.m[typeName]
+	bodyTypes.rwmu.RUnlock()
+	if ok {
+		return newBody(), true
+	}
+	return codec.NewProtoMessage(typeName)
+}
+
+// WithBodyType declares the type name of the body, e.g. the full name of the protobuf message,
+// so that the receiver can decode the body in the unknown call handler.
+func WithBodyType(typeName string) MessageSetting {
+	return socket.WithSetMeta(MetaBodyType, typeName)
+}
+
+// BodyType returns the type name of the input body declared by the sender, see WithBodyType.
+func (c *handlerCtx) BodyType() string {
+	return string(c.input.Meta().Peek(MetaBodyType))
+}
+
+// BindAny packs the raw protobuf body into PbAny of the declared type,
+// or unmarshals it if the declared type is google.protobuf.Any,
+// e.g. for the gateway to forward it downstream.
+func (c *handlerCtx) BindAny() (*codec.PbAny, error) {
+	b, ok := c.input.Body().(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("the body is not raw bytes")
+	}
+	if c.input.BodyCodec() != codec.ID_PROTOBUF {
+		return nil, fmt.Errorf("the body codec is not protobuf: %d", c.input.BodyCodec())
+	}
+	typeName := c.BodyType()
+	if typeName == "" {
+		return nil, fmt.Errorf("the body type is not declared")
+	}
+	pbAny := new(codec.PbAny)
+	if typeName == codec.AnyTypeName {
+		return pbAny, codec.ProtoUnmarshal(*b, pbAny)
+	}
+	pbAny.TypeUrl = codec.AnyTypeURLPrefix + typeName
+	pbAny.Value = *b
+	return pbAny, nil
+}
+
+// BindRegistered binds the raw body to a new value of the declared type,
+// which is registered by RegBodyType or the generated protobuf code.
+func (c *handlerCtx) BindRegistered() (interface{}, error) {
+	typeName := c.BodyType()
+	if typeName == "" {
+		return nil, fmt.Errorf("the body type is not declared")
+	}
+	v, ok := newBodyOfType(typeName)
+	if !ok {
+		return nil, fmt.Errorf("unregistered body type: %q", typeName)
+	}
+	_, err := c.Bind(v)
+	return v, err
+}
@@ -0,0 +1,58 @@
+package tp_test
+
+import (
+	"fmt"
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/codec"
+)
+
+type gatewayPoint struct {
+	X, Y int
+}
+
+func TestUnknownCallBodyType(t *testing.T) {
+	tp.RegBodyType("test.Point", func() interface{} { return new(gatewayPoint) })
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+		srv.SetUnknownCall(func(ctx tp.UnknownCallCtx) (interface{}, *tp.Rerror) {
+			if ctx.GetBodyCodec() == codec.ID_PROTOBUF {
+				pbAny, err := ctx.BindAny()
+				if err != nil {
+					return nil, tp.NewRerror(tp.CodeBadMessage, "bad any", err.Error())
+				}
+				msg, err := pbAny.Unpack()
+				if err != nil {
+					return nil, tp.NewRerror(tp.CodeBadMessage, "bad any", err.Error())
+				}
+				return fmt.Sprintf("%s %s %T", ctx.ServiceMethod(), pbAny.TypeUrl, msg), nil
+			}
+			v, err := ctx.BindRegistered()
+			if err != nil {
+				return nil, tp.NewRerror(tp.CodeBadMessage, "bad body", err.Error())
+			}
+			return fmt.Sprintf("%s %+v", ctx.ServiceMethod(), v), nil
+		})
+	})
+	defer p.Close()
+	var result string
+	rerr := p.sess.Call("/downstream/point", &gatewayPoint{X: 1, Y: 2}, &result,
+		tp.WithBodyType("test.Point"),
+	).Rerror()
+	if expect := "/downstream/point &{X:1 Y:2}"; rerr != nil || result != expect {
+		t.Fatalf("expect %q, got %q, %v", expect, result, rerr)
+	}
+	rerr = p.sess.Call("/downstream/empty", new(codec.PbEmpty), &result,
+		tp.WithBodyCodec(codec.ID_PROTOBUF),
+		tp.WithBodyType("codec.PbEmpty"),
+		tp.WithAcceptBodyCodec(codec.ID_JSON),
+	).Rerror()
+	if expect := "/downstream/empty type.googleapis.com/codec.PbEmpty *codec.PbEmpty"; rerr != nil || result != expect {
+		t.Fatalf("expect %q, got %q, %v", expect, result, rerr)
+	}
+	// the body type is not declared
+	rerr = p.sess.Call("/downstream/point", &gatewayPoint{}, &result).Rerror()
+	if rerr == nil || rerr.Code != tp.CodeBadMessage {
+		t.Fatalf("expect CodeBadMessage, got %v", rerr)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mylonly/teleport/socket"
+)
+
+var upgradeProtos = struct {
+	list []ProtoFunc
+	rwmu sync.RWMutex
+}{}
+
+// RegUpgradeProto registers the protocols which the remote peer is allowed to upgrade the session to.
+// NOTE: The protocol is identified by the name returned by Proto.Version().
+func RegUpgradeProto(protoFunc ...ProtoFunc) {
+	upgradeProtos.rwmu.Lock()
+	upgradeProtos.list = append(upgradeProtos.list, protoFunc...)
+	upgradeProtos.rwmu.Unlock()
+}
+
+func getUpgradeProto(name string, rw socket.IOWithReadBuffer) (ProtoFunc, bool) {
+	upgradeProtos.rwmu.RLock()
+	defer upgradeProtos.rwmu.RUnlock()
+	for _, protoFunc := range upgradeProtos.list {
+		if _, n := protoFunc(rw).Version(); n == name {
+			return protoFunc, true
+		}
+	}
+	return nil, false
+}
+
+// defaultUpgradeTimeout the default timeout of waiting for UPGRADE_ACK
+const defaultUpgradeTimeout = 5 * time.Second
+
+type pendingUpgrade struct {
+	protoFunc ProtoFunc
+	ackCh     chan *Rerror
+}
+
+// UpgradeProto switches the protocol of the live session, negotiated with the remote peer.
+// NOTE:
+//  The remote peer must register the protocol by RegUpgradeProto;
+//  Writing is paused during the exchange, and the messages being read or written are not affected;
+//  If the remote peer does not answer before the context age (default 5s), the session is closed,
+//  since the protocol of the remote peer is unknown.
+func (s *session) UpgradeProto(protoFunc ProtoFunc) *Rerror {
+	_, name := protoFunc(s.socket).Version()
+	if !atomic.CompareAndSwapInt32(&s.upgrading, 0, 1) {
+		return rerrConflict.Copy().SetReason("another protocol upgrade is in progress")
+	}
+	defer atomic.StoreInt32(&s.upgrading, 0)
+
+	pending := &pendingUpgrade{
+		protoFunc: protoFunc,
+		ackCh:     make(chan *Rerror, 1),
+	}
+	s.upgradeLock.Lock()
+	s.pendingUpgrade = pending
+	s.upgradeLock.Unlock()
+
+	// pause writing until the remote peer answers
+	s.writeLock.Lock()
+	rerr := s.writeUpgradeLocked(TypeUpgrade, name, nil, nil)
+	if rerr != nil {
+		s.writeLock.Unlock()
+		s.clearPendingUpgrade()
+		return rerr
+	}
+	timeout := s.ContextAge()
+	if timeout <= 0 {
+		timeout = defaultUpgradeTimeout
+	}
+	select {
+	case rerr = <-pending.ackCh:
+		if rerr == nil {
+			s.socket.UpgradeWriteProto(protoFunc)
+			Infof("upgrade protocol ok: %s, proto: %s", s.RemoteAddr().String(), name)
+		}
+		s.writeLock.Unlock()
+		return rerr
+	case <-time.After(timeout):
+		s.writeLock.Unlock()
+		s.clearPendingUpgrade()
+		Warnf("upgrade protocol timeout: %s, proto: %s, closing the session", s.RemoteAddr().String(), name)
+		rerr := rerrHandleTimeout.Copy().SetReason("upgrade protocol timeout")
+		go s.close(CloseProtocolError, rerr.ToError(), true)
+		return rerr
+	}
+}
+
+func (s *session) clearPendingUpgrade() *pendingUpgrade {
+	s.upgradeLock.Lock()
+	pending := s.pendingUpgrade
+	s.pendingUpgrade = nil
+	s.upgradeLock.Unlock()
+	return pending
+}
+
+// writeUpgradeLocked writes the UPGRADE or UPGRADE_ACK message, and then switches
+// the protocol of writing if upgradeTo!=nil.
+// NOTE: The caller holds s.writeLock.
+func (s *session) writeUpgradeLocked(mtype byte, name string, rerr *Rerror, upgradeTo ProtoFunc) *Rerror {
+	output := socket.GetMessage(
+		socket.WithMtype(mtype),
+		socket.WithSetMeta(MetaUpgradeProto, name),
+	)
+	defer socket.PutMessage(output)
+	output.SetSeq(s.nextSeq())
+	if rerr != nil {
+		rerr.SetToMeta(output.Meta())
+	}
+	if err := s.socket.WriteMessage(output); err != nil {
+		return rerrWriteFailed.Copy().SetReason(err.Error())
+	}
+	if upgradeTo != nil {
+		s.socket.UpgradeWriteProto(upgradeTo)
+	}
+	return nil
+}
+
+// handleUpgradeMessage handles the UPGRADE and UPGRADE_ACK messages synchronously in the read goroutine,
+// so that the protocol of reading is switched before reading the next message.
+// It returns false if the message is not an upgrade message.
+func (s *session) handleUpgradeMessage(input Message) bool {
+	switch input.Mtype() {
+	case TypeUpgrade:
+		name := string(input.Meta().Peek(MetaUpgradeProto))
+		protoFunc, ok := getUpgradeProto(name, s.socket)
+		var rerr *Rerror
+		if ok {
+			// the remote peer writes with the new protocol after the UPGRADE
+			s.socket.UpgradeReadProto(protoFunc)
+		} else {
+			rerr = rerrNotFound.Copy().SetReason("unsupported protocol: " + name)
+		}
+		s.writeLock.Lock()
+		writeErr := s.writeUpgradeLocked(TypeUpgradeAck, name, rerr, protoFunc)
+		s.writeLock.Unlock()
+		if writeErr != nil {
+			Warnf("upgrade protocol: %s, proto: %s, write UPGRADE_ACK: %s", s.RemoteAddr().String(), name, writeErr.String())
+		} else if ok {
+			Infof("upgrade protocol ok: %s, proto: %s", s.RemoteAddr().String(), name)
+		}
+		return true
+
+	case TypeUpgradeAck:
+		pending := s.clearPendingUpgrade()
+		if pending == nil {
+			Warnf("upgrade protocol: %s, unexpected UPGRADE_ACK", s.RemoteAddr().String())
+			return true
+		}
+		rerr := NewRerrorFromMeta(input.Meta())
+		if rerr == nil {
+			// the remote peer writes with the new protocol after the UPGRADE_ACK
+			s.socket.UpgradeReadProto(pending.protoFunc)
+		}
+		pending.ackCh <- rerr
+		return true
+
+	default:
+		return false
+	}
+}
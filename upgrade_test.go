@@ -0,0 +1,53 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/proto/jsonproto"
+	"github.com/mylonly/teleport/proto/pbproto"
+	"github.com/mylonly/teleport/socket"
+)
+
+func TestUpgradeProto(t *testing.T) {
+	tp.RegUpgradeProto(jsonproto.NewJSONProtoFunc(), socket.RawTLVProtoFunc)
+	cases := []struct {
+		name     string
+		from, to tp.ProtoFunc
+	}{
+		{"raw to json", socket.RawProtoFunc, jsonproto.NewJSONProtoFunc()},
+		// e.g. start on the simple JSON proto, then upgrade to the binary one after auth
+		{"json to raw-tlv", jsonproto.NewJSONProtoFunc(), socket.RawTLVProtoFunc},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var path string
+			p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{}, func(srv, _ tp.Peer) {
+				path = srv.RouteCallFunc(echo_call)
+			}, c.from)
+			defer p.Close()
+			call := func() {
+				t.Helper()
+				var arg, result = 10, 0
+				if rerr := p.sess.Call(path, &arg, &result).Rerror(); rerr != nil {
+					t.Fatal(rerr)
+				}
+				if result != arg {
+					t.Fatalf("expect %d, got %d", arg, result)
+				}
+			}
+			call()
+			// the server refuses the unregistered proto, and the session keeps the old one
+			rerr := p.sess.UpgradeProto(pbproto.NewPbProtoFunc())
+			if rerr == nil || rerr.Code != tp.CodeNotFound {
+				t.Fatalf("expect unsupported protocol, got %v", rerr)
+			}
+			call()
+			if rerr = p.sess.UpgradeProto(c.to); rerr != nil {
+				t.Fatalf("upgrade: %v", rerr)
+			}
+			// both sides read and write by the new proto
+			call()
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// The policies of the messages waiting for writing when the write queue of the session is full.
+const (
+	// WriteQueueBlock waits for writing, the default
+	WriteQueueBlock = "block"
+	// WriteQueueDropOldest refuses the oldest waiting message, and queues the new one
+	WriteQueueDropOldest = "drop-oldest"
+	// WriteQueueDropNew refuses the new message
+	WriteQueueDropNew = "drop-new"
+	// WriteQueueClose refuses the new message, and closes the session
+	WriteQueueClose = "close"
+)
+
+const (
+	writeQueueBlock int8 = iota
+	writeQueueDropOldest
+	writeQueueDropNew
+	writeQueueClose
+)
+
+var (
+	errWriteQueueFull = errors.New("write queue is full")
+	errWriteDropped   = errors.New("dropped from the full write queue")
+)
+
+func parseWriteQueuePolicy(policy string) (int8, error) {
+	switch policy {
+	case "", WriteQueueBlock:
+		return writeQueueBlock, nil
+	case WriteQueueDropOldest:
+		return writeQueueDropOldest, nil
+	case WriteQueueDropNew:
+		return writeQueueDropNew, nil
+	case WriteQueueClose:
+		return writeQueueClose, nil
+	default:
+		return 0, fmt.Errorf("invalid write queue policy: %s, refer to the following: block, drop-oldest, drop-new or close", policy)
+	}
+}
+
+// writeQueue the bound of the messages waiting for the write lock of a session, except the control messages.
+type writeQueue struct {
+	size    int // if <=0, no limit
+	policy  int8
+	waiting int
+	dropped uint64
+	onClose func()
+}
+
+// admit applies the policy if the queue is full, and returns the error if the new message is refused.
+// NOTE: The caller holds m.mu.
+func (q *writeQueue) admit(m *priorityMutex) error {
+	if q.size <= 0 || q.waiting < q.size || q.policy == writeQueueBlock {
+		q.waiting++
+		return nil
+	}
+	atomic.AddUint64(&q.dropped, 1)
+	switch q.policy {
+	case writeQueueDropOldest:
+		m.dropOldest()
+		q.waiting++
+		return nil
+	case writeQueueClose:
+		if q.onClose != nil {
+			go q.onClose()
+		}
+	}
+	return errWriteQueueFull
+}
+
+// initWriteQueue bounds the write queue of the session.
+func (s *session) initWriteQueue() {
+	q := &s.writeLock.queue
+	q.size = s.peer.writeQueueSize
+	q.policy = s.peer.writeQueuePolicy
+	q.onClose = func() {
+		Warnf("write queue is full, close the session: %s", s.RemoteAddr().String())
+		s.close(CloseWriteError, errWriteQueueFull, true)
+	}
+}
+
+// writeQueueLen returns the number of the messages waiting for writing, except the control messages.
+func (s *session) writeQueueLen() int {
+	s.writeLock.mu.Lock()
+	n := s.writeLock.queue.waiting
+	s.writeLock.mu.Unlock()
+	return n
+}
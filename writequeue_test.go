@@ -0,0 +1,38 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+)
+
+func TestWriteQueuePolicy(t *testing.T) {
+	p := newMemPeers(t, tp.PeerConfig{}, tp.PeerConfig{
+		WriteQueueSize:   1,
+		WriteQueuePolicy: tp.WriteQueueDropNew,
+	}, func(srv, _ tp.Peer) {
+		srv.RoutePushFunc(notice_push)
+	})
+	defer p.Close()
+	gate := newGateLimiter()
+	p.sess.SetWriteRateLimiter(gate, nil)
+	done := make(chan *tp.Rerror, 2)
+	var arg = "hello"
+	go func() { done <- p.sess.Push("/notice/push", &arg) }() // writing
+	<-gate.waiting
+	go func() { done <- p.sess.Push("/notice/push", &arg) }() // waiting
+	waitWriteQueue(t, p.sess, 1)
+	rerr := p.sess.Push("/notice/push", &arg)
+	if rerr == nil || rerr.Code != tp.CodeWriteFailed {
+		t.Fatalf("expect the new push refused, got %v", rerr)
+	}
+	if stats := p.sess.Stats(); stats.WriteQueueLen != 1 || stats.WriteDropped != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	close(gate.open)
+	for i := 0; i < 2; i++ {
+		if rerr = <-done; rerr != nil {
+			t.Fatal(rerr)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	ws "github.com/mylonly/teleport/mixer/websocket/websocket"
+)
+
+// isWebsocketNetwork returns whether the network is the WebSocket transport.
+func isWebsocketNetwork(network string) bool {
+	return network == "ws" || network == "wss"
+}
+
+// wsConn wraps the WebSocket connection as the net.Conn carrying the binary frames,
+// so that any ProtoFunc can be used on it.
+// NOTE: The addresses are the ones of the underlying TCP connection.
+type wsConn struct {
+	*ws.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	tlsState   *tls.ConnectionState // nil if not wss
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+func newWsConn(conn *ws.Conn, localAddr, remoteAddr net.Addr) *wsConn {
+	conn.PayloadType = ws.BinaryFrame
+	return &wsConn{
+		Conn:       conn,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+		closed:     make(chan struct{}),
+	}
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// PeerCertificates returns the certificate chain presented by the remote peer over wss.
+func (c *wsConn) PeerCertificates() []*x509.Certificate {
+	if c.tlsState == nil {
+		return nil
+	}
+	return c.tlsState.PeerCertificates
+}
+
+func (c *wsConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return err
+}
+
+var errWsListenerClosed = errors.New("websocket listener closed")
+
+// wsListener accepts the WebSocket connections upgraded by an HTTP server on the path.
+type wsListener struct {
+	lis       net.Listener
+	network   string // ws or wss
+	server    *http.Server
+	connCh    chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newWsListener(lis net.Listener, network, path string) *wsListener {
+	l := &wsListener{
+		lis:     lis,
+		network: network,
+		connCh:  make(chan net.Conn),
+		closed:  make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, ws.Server{Handler: l.handle})
+	l.server = &http.Server{Handler: mux}
+	go l.server.Serve(lis)
+	return l
+}
+
+// handle hands the connection over to Accept, and blocks until it is closed,
+// since the connection is closed when the handler returns.
+func (l *wsListener) handle(conn *ws.Conn) {
+	req := conn.Request()
+	localAddr, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if localAddr == nil {
+		localAddr = l.lis.Addr()
+	}
+	remoteAddr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	if err != nil {
+		return
+	}
+	c := newWsConn(conn, localAddr, remoteAddr)
+	c.tlsState = req.TLS
+	select {
+	case l.connCh <- c:
+	case <-l.closed:
+		return
+	}
+	<-c.closed
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closed:
+		return nil, errWsListenerClosed
+	}
+}
+
+// Close stops accepting, the accepted connections are not affected.
+func (l *wsListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.lis.Close()
+	})
+	return err
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.lis.Addr()
+}
+
+// wsLocation returns the URL of the WebSocket endpoint.
+// NOTE: The addr is a URL, or host:port which is joined with the path.
+func wsLocation(network, addr, path string) (*url.URL, error) {
+	rawurl := addr
+	if !strings.Contains(addr, "://") {
+		rawurl = network + "://" + addr + path
+	}
+	return url.Parse(rawurl)
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !js
+
+package tp
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+
+	ws "github.com/mylonly/teleport/mixer/websocket/websocket"
+)
+
+// dialWebsocket connects with the WebSocket endpoint.
+func dialWebsocket(dial func(network, addr string, tlsConfig *tls.Config) (net.Conn, error),
+	network, addr, path string, tlsConfig *tls.Config) (net.Conn, error) {
+	location, err := wsLocation(network, addr, path)
+	if err != nil {
+		return nil, err
+	}
+	origin := &url.URL{Scheme: "http", Host: location.Host}
+	var conn net.Conn
+	if location.Scheme == "wss" {
+		origin.Scheme = "https"
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if len(tlsConfig.ServerName) == 0 {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = location.Hostname()
+		}
+		conn, err = dial("tcp", location.Host, tlsConfig)
+	} else {
+		conn, err = dial("tcp", location.Host, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	config := &ws.Config{
+		Location: location,
+		Origin:   origin,
+		Version:  ws.ProtocolVersionHybi13,
+	}
+	wsc, err := ws.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := newWsConn(wsc, conn.LocalAddr(), conn.RemoteAddr())
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		c.tlsState = &state
+	}
+	return c, nil
+}
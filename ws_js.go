@@ -0,0 +1,221 @@
+// Copyright 2018 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tp
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+var (
+	errWsConnClosed = errors.New("use of closed websocket connection")
+	errWsTimeout    = wsTimeoutError{}
+)
+
+type wsTimeoutError struct{}
+
+func (wsTimeoutError) Error() string   { return "i/o timeout" }
+func (wsTimeoutError) Timeout() bool   { return true }
+func (wsTimeoutError) Temporary() bool { return true }
+
+type wsAddr struct {
+	network string
+	addr    string
+}
+
+func (a wsAddr) Network() string {
+	return a.network
+}
+
+func (a wsAddr) String() string {
+	return a.addr
+}
+
+// jsWsConn wraps the WebSocket of the browser as the net.Conn carrying the binary frames.
+// NOTE: The write deadline is ignored, since the browser buffers the outgoing frames.
+type jsWsConn struct {
+	ws           js.Value
+	localAddr    net.Addr
+	remoteAddr   net.Addr
+	funcs        []js.Func
+	buf          []byte // received but not read yet
+	err          error  // set when closed
+	readDeadline time.Time
+	notify       chan struct{}
+	mu           sync.Mutex
+}
+
+// dialWebsocket connects with the WebSocket endpoint by the browser.
+// NOTE: The dial function and the TLS config are ignored,
+// since the browser manages the underlying connection.
+func dialWebsocket(dial func(network, addr string, tlsConfig *tls.Config) (net.Conn, error),
+	network, addr, path string, tlsConfig *tls.Config) (net.Conn, error) {
+	location, err := wsLocation(network, addr, path)
+	if err != nil {
+		return nil, err
+	}
+	c := &jsWsConn{
+		localAddr:  wsAddr{network: location.Scheme, addr: jsLocalHost()},
+		remoteAddr: wsAddr{network: location.Scheme, addr: location.Host},
+		notify:     make(chan struct{}, 1),
+	}
+	opened := make(chan error, 1)
+	// NOTE: The callbacks must not block, or the event loop of the browser is stuck.
+	onOpen := js.FuncOf(func(js.Value, []js.Value) interface{} {
+		select {
+		case opened <- nil:
+		default:
+		}
+		return nil
+	})
+	onMessage := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		data := js.Global().Get("Uint8Array").New(args[0].Get("data"))
+		b := make([]byte, data.Get("length").Int())
+		js.CopyBytesToGo(b, data)
+		c.mu.Lock()
+		c.buf = append(c.buf, b...)
+		c.mu.Unlock()
+		c.wake()
+		return nil
+	})
+	onClose := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		select {
+		case opened <- errors.New("websocket: connection failed: " + location.String()):
+		default:
+		}
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = io.EOF
+		}
+		c.mu.Unlock()
+		c.wake()
+		for _, fn := range c.funcs {
+			fn.Release()
+		}
+		return nil
+	})
+	c.funcs = []js.Func{onOpen, onMessage, onClose}
+	c.ws = js.Global().Get("WebSocket").New(location.String())
+	c.ws.Set("binaryType", "arraybuffer")
+	c.ws.Call("addEventListener", "open", onOpen)
+	c.ws.Call("addEventListener", "message", onMessage)
+	c.ws.Call("addEventListener", "close", onClose)
+	if err = <-opened; err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: c.remoteAddr, Err: err}
+	}
+	return c, nil
+}
+
+// jsLocalHost returns the host of the page, or empty if not in the browser.
+func jsLocalHost() string {
+	if loc := js.Global().Get("location"); loc.Truthy() {
+		return loc.Get("host").String()
+	}
+	return ""
+}
+
+// wake notifies the waiting Read without blocking.
+func (c *jsWsConn) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *jsWsConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.buf) > 0 {
+			n := copy(b, c.buf)
+			c.buf = c.buf[n:]
+			c.mu.Unlock()
+			return n, nil
+		}
+		err, deadline := c.err, c.readDeadline
+		c.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		if deadline.IsZero() {
+			<-c.notify
+			continue
+		}
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, errWsTimeout
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-c.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (c *jsWsConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	data := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(data, b)
+	c.ws.Call("send", data)
+	return len(b), nil
+}
+
+func (c *jsWsConn) Close() error {
+	c.mu.Lock()
+	if c.err == errWsConnClosed {
+		c.mu.Unlock()
+		return errWsConnClosed
+	}
+	c.err = errWsConnClosed
+	c.mu.Unlock()
+	c.wake()
+	c.ws.Call("close")
+	return nil
+}
+
+func (c *jsWsConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *jsWsConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *jsWsConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *jsWsConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	c.wake()
+	return nil
+}
+
+func (c *jsWsConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
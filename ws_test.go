@@ -0,0 +1,46 @@
+package tp_test
+
+import (
+	"testing"
+
+	tp "github.com/mylonly/teleport"
+	"github.com/mylonly/teleport/proto/jsonproto"
+)
+
+func TestWebsocketNetwork(t *testing.T) {
+	srv := tp.NewPeer(tp.PeerConfig{
+		Network:       "ws",
+		LocalIP:       "127.0.0.1",
+		WebsocketPath: "/rpc",
+	})
+	defer srv.Close()
+	srv.RouteCallFunc(echo_call)
+	addr := listenAndServe(t, srv, jsonproto.NewJSONProtoFunc())
+
+	cli := tp.NewPeer(tp.PeerConfig{
+		Network:       "ws",
+		WebsocketPath: "/rpc",
+	})
+	defer cli.Close()
+	for i := 0; i < 2; i++ {
+		sess, rerr := cli.Dial(addr, jsonproto.NewJSONProtoFunc())
+		if rerr != nil {
+			t.Fatalf("dial: %v", rerr)
+		}
+		var arg, result = 10, 0
+		rerr = sess.Call("/echo/call", &arg, &result).Rerror()
+		if rerr != nil {
+			t.Fatalf("/echo/call: %v", rerr)
+		}
+		if result != arg {
+			t.Fatalf("expect %d, got %d", arg, result)
+		}
+	}
+	// the sessions are identified by the TCP addresses
+	if n := srv.CountSession(); n != 2 {
+		t.Fatalf("expect 2 sessions, got %d", n)
+	}
+	if _, rerr := cli.Dial("ws://" + addr + "/none"); rerr == nil {
+		t.Fatal("expect dial failed on the wrong path")
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"sync"
 
@@ -80,6 +81,11 @@ func (g *Gzip) Name() string {
 	return g.name
 }
 
+// IsCompress returns true, since gzip compresses the data.
+func (g *Gzip) IsCompress() bool {
+	return true
+}
+
 // OnPack performs filtering on packing.
 func (g *Gzip) OnPack(src []byte) ([]byte, error) {
 	gw := g.wPool.Get().(*gzip.Writer)
@@ -113,3 +119,21 @@ func (g *Gzip) OnUnpack(src []byte) ([]byte, error) {
 	dest, _ := ioutil.ReadAll(gr)
 	return dest, nil
 }
+
+// OnUnpackLimit performs filtering on unpacking, and stops as soon as the output is bigger than limit.
+func (g *Gzip) OnUnpackLimit(src []byte, limit int) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	gr := g.rPool.Get().(*gzip.Reader)
+	defer g.rPool.Put(gr)
+	err := gr.Reset(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	dest, _ := ioutil.ReadAll(io.LimitReader(gr, int64(limit)+1))
+	if len(dest) > limit {
+		return nil, xfer.ErrUnpackTooLarge
+	}
+	return dest, nil
+}
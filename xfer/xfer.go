@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"time"
 )
 
 // XferFilter handles byte stream of message when transfer.
@@ -34,6 +35,32 @@ type XferFilter interface {
 	OnUnpack([]byte) ([]byte, error)
 }
 
+// LimitedXferFilter is the transfer filter that can stop unpacking as soon as the output exceeds the limit,
+// e.g. the decompression filter, so that the memory is bounded before the whole output is produced.
+type LimitedXferFilter interface {
+	XferFilter
+	// OnUnpackLimit performs filtering on unpacking, returns ErrUnpackTooLarge if the output is bigger than limit.
+	OnUnpackLimit(src []byte, limit int) ([]byte, error)
+}
+
+// CompressXferFilter is the transfer filter that compresses the data, e.g. gzip,
+// which may be skipped when the data is incompressible.
+type CompressXferFilter interface {
+	XferFilter
+	// IsCompress returns true if the filter compresses the data.
+	IsCompress() bool
+}
+
+// IsCompress determines if the transfer filter compresses the data.
+func IsCompress(filter XferFilter) bool {
+	cf, ok := filter.(CompressXferFilter)
+	return ok && cf.IsCompress()
+}
+
+// PackObserver is called after each transfer filter packs the data,
+// with the sizes of the data before and after, and the time spent.
+type PackObserver func(filter XferFilter, srcLen, dstLen int, cost time.Duration)
+
 var xferFilterMap = struct {
 	idMap   map[byte]XferFilter
 	nameMap map[string]XferFilter
@@ -45,6 +72,9 @@ var xferFilterMap = struct {
 // ErrXferPipeTooLong error
 var ErrXferPipeTooLong = errors.New("The length of transfer pipe cannot be bigger than 255")
 
+// ErrUnpackTooLarge error
+var ErrUnpackTooLarge = errors.New("Size of unpacked data exceeds limit")
+
 // Reg registers transfer filter.
 func Reg(xferFilter XferFilter) {
 	id := xferFilter.ID()
@@ -80,7 +110,10 @@ func GetByName(name string) (XferFilter, error) {
 // XferPipe transfer filter pipe, handlers from outer-most to inner-most.
 // NOTE: the length can not be bigger than 255!
 type XferPipe struct {
-	filters []XferFilter
+	filters      []XferFilter
+	unpackLimit  int
+	unpackedLen  int
+	packObserver PackObserver
 }
 
 // NewXferPipe creates a new transfer filter pipe.
@@ -91,6 +124,32 @@ func NewXferPipe() *XferPipe {
 // Reset resets transfer filter pipe.
 func (x *XferPipe) Reset() {
 	x.filters = x.filters[:0]
+	x.unpackLimit = 0
+	x.unpackedLen = 0
+	x.packObserver = nil
+}
+
+// SetUnpackLimit sets the size upper limit of the data unpacked by each filter.
+// NOTE: If limit<=0, there is no limit.
+func (x *XferPipe) SetUnpackLimit(limit int) {
+	x.unpackLimit = limit
+}
+
+// UnpackLimit returns the size upper limit of the data unpacked by each filter.
+func (x *XferPipe) UnpackLimit() int {
+	return x.unpackLimit
+}
+
+// UnpackedLen returns the size of the data unpacked by the last OnUnpack,
+// 0 means the pipe is empty or OnUnpack has not been called.
+func (x *XferPipe) UnpackedLen() int {
+	return x.unpackedLen
+}
+
+// SetPackObserver sets the function called after each filter packs the data.
+// NOTE: If observer is nil, nothing is observed.
+func (x *XferPipe) SetPackObserver(observer PackObserver) {
+	x.packObserver = observer
 }
 
 // Append appends transfer filter by id.
@@ -112,6 +171,20 @@ func (x *XferPipe) AppendFrom(src *XferPipe) {
 	}
 }
 
+// Remove removes the transfer filters for which the callback returns true.
+func (x *XferPipe) Remove(callback func(filter XferFilter) bool) {
+	filters := x.filters[:0]
+	for _, filter := range x.filters {
+		if !callback(filter) {
+			filters = append(filters, filter)
+		}
+	}
+	for i := len(filters); i < len(x.filters); i++ {
+		x.filters[i] = nil
+	}
+	x.filters = filters
+}
+
 func (x *XferPipe) check() error {
 	if x.Len() > math.MaxUint8 {
 		return ErrXferPipeTooLong
@@ -165,7 +238,15 @@ func (x *XferPipe) Range(callback func(idx int, filter XferFilter) bool) {
 func (x *XferPipe) OnPack(data []byte) ([]byte, error) {
 	var err error
 	for i := x.Len() - 1; i >= 0; i-- {
-		if data, err = x.filters[i].OnPack(data); err != nil {
+		if x.packObserver == nil {
+			data, err = x.filters[i].OnPack(data)
+		} else {
+			srcLen, start := len(data), time.Now()
+			if data, err = x.filters[i].OnPack(data); err == nil {
+				x.packObserver(x.filters[i], srcLen, len(data), time.Since(start))
+			}
+		}
+		if err != nil {
 			return data, err
 		}
 	}
@@ -173,13 +254,25 @@ func (x *XferPipe) OnPack(data []byte) ([]byte, error) {
 }
 
 // OnUnpack unpacks transfer byte stream, from outer-most to inner-most.
+// NOTE: If the unpack limit is set, returns ErrUnpackTooLarge when the output of any filter exceeds it.
 func (x *XferPipe) OnUnpack(data []byte) ([]byte, error) {
 	var err error
 	var count = x.Len()
 	for i := 0; i < count; i++ {
-		if data, err = x.filters[i].OnUnpack(data); err != nil {
+		filter := x.filters[i]
+		if x.unpackLimit <= 0 {
+			data, err = filter.OnUnpack(data)
+		} else if lf, ok := filter.(LimitedXferFilter); ok {
+			data, err = lf.OnUnpackLimit(data, x.unpackLimit)
+		} else if data, err = filter.OnUnpack(data); err == nil && len(data) > x.unpackLimit {
+			err = ErrUnpackTooLarge
+		}
+		if err != nil {
 			return data, err
 		}
 	}
+	if count > 0 {
+		x.unpackedLen = len(data)
+	}
 	return data, err
 }